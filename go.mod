@@ -0,0 +1,15 @@
+module github.com/disposedtrolley/ptv-graph
+
+go 1.16
+
+require (
+	github.com/dsnet/compress v0.0.1 // indirect
+	github.com/frankban/quicktest v1.14.6 // indirect
+	github.com/golang/snappy v1.0.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/mholt/archiver v3.1.1+incompatible
+	github.com/nwaples/rardecode v1.1.3 // indirect
+	github.com/parquet-go/parquet-go v0.20.0
+	github.com/pierrec/lz4 v2.6.1+incompatible // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+)
@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// graphCacheVersion identifies the layout written by Save, so that a cache saved by a
+// build with a different Graph is rejected rather than misread.
+const graphCacheVersion = 2
+
+// graphCache is the layout of a saved Graph.
+type graphCache struct {
+	Version int
+	Graph   Graph
+}
+
+// Save writes the graph to w in a binary form which LoadGraph reads back, so that a
+// graph needn't be rebuilt from its feed every time it's used.
+func (g *Graph) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(graphCache{Version: graphCacheVersion, Graph: *g})
+}
+
+// LoadGraph reads a graph written by Graph.Save from r. The stops and adjacency lists
+// are exactly those of the graph saved. Returns an error if r doesn't hold a graph, or
+// holds one saved by an incompatible version of the package.
+func LoadGraph(r io.Reader) (*Graph, error) {
+	var c graphCache
+	if err := gob.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("unable to read graph: %w", err)
+	}
+	if c.Version != graphCacheVersion {
+		return nil, fmt.Errorf("graph was saved in version %d of the format, not %d", c.Version, graphCacheVersion)
+	}
+
+	g := &c.Graph
+	// Empty maps aren't saved, but a graph's maps are always ready to add to.
+	if g.Stops == nil {
+		g.Stops = make(map[string]gtfs.Stop)
+	}
+	if g.Edges == nil {
+		g.Edges = make(map[string][]Edge)
+	}
+	return g, nil
+}
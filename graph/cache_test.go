@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+func TestSaveLoadGraph(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:10:00"},
+		"T2": {"A", "08:10:00", "B", "08:14:00"},
+		"T3": {"D", "09:00:00", "A", "09:20:00"},
+	})
+	feed.Stops[0].Name, feed.Stops[0].Lat, feed.Stops[0].Lon = "Flinders St, Stop 1", -37.8183, 144.9671
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.MergeParallelEdges()
+	g.AddTransfers([]gtfs.Transfer{{FromStopID: "C", ToStopID: "D", Distance: 140}}, 0)
+
+	var buf bytes.Buffer
+	if err := g.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGraph(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, g) {
+		t.Errorf("got %+v\nwant %+v", loaded, g)
+	}
+}
+
+func TestLoadEmptyGraph(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Graph{}).Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	g, err := LoadGraph(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The graph can still be added to.
+	g.Stops["A"] = gtfs.Stop{ID: "A"}
+	g.AddTransfers([]gtfs.Transfer{{FromStopID: "A", ToStopID: "B", Distance: 14}}, 0)
+	if len(g.Edges["A"]) != 1 {
+		t.Errorf("got edges %+v, want the transfer from A", g.Edges)
+	}
+}
+
+func TestLoadGraphErrors(t *testing.T) {
+	if _, err := LoadGraph(strings.NewReader("stop_id,stop_name\n")); err == nil {
+		t.Error("loaded a graph from CSV")
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(graphCache{Version: graphCacheVersion - 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGraph(&buf); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("got error %v, want one of the cache's version", err)
+	}
+}
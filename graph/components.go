@@ -0,0 +1,54 @@
+package graph
+
+import "sort"
+
+// ConnectedComponents groups the stops of the graph into its connected components,
+// treating every edge as undirected. Stops without any edges form components of their
+// own. Components are ordered from largest to smallest, so the first is the main
+// network and any after it are stops which can't be reached from it. The stop_ids of
+// each component are sorted.
+func (g *Graph) ConnectedComponents() [][]string {
+	adjacent := make(map[string][]string, len(g.Stops))
+	for id := range g.Stops {
+		adjacent[id] = nil
+	}
+	for _, edges := range g.Edges {
+		for _, e := range edges {
+			adjacent[e.From] = append(adjacent[e.From], e.To)
+			adjacent[e.To] = append(adjacent[e.To], e.From)
+		}
+	}
+
+	ids := make([]string, 0, len(adjacent))
+	for id := range adjacent {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	seen := make(map[string]bool, len(ids))
+	var components [][]string
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		component := []string{id}
+		for i := 0; i < len(component); i++ {
+			for _, next := range adjacent[component[i]] {
+				if !seen[next] {
+					seen[next] = true
+					component = append(component, next)
+				}
+			}
+		}
+		sort.Strings(component)
+		components = append(components, component)
+	}
+
+	// Components were found in order of their smallest stop_id, which breaks ties in size.
+	sort.SliceStable(components, func(i, j int) bool {
+		return len(components[i]) > len(components[j])
+	})
+	return components
+}
@@ -0,0 +1,49 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+func TestConnectedComponents(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		// A, B, C and D form the main network, B only reachable against the
+		// direction of D's trip.
+		"T1": {"A", "08:00:00", "C", "08:05:00"},
+		"T2": {"C", "08:10:00", "D", "08:15:00"},
+		"T3": {"B", "08:00:00", "D", "08:05:00"},
+		// X and Y are only linked to each other.
+		"T4": {"X", "08:00:00", "Y", "08:05:00"},
+	})
+	feed.Stops = append(feed.Stops, gtfs.Stop{ID: "Z"})
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"A", "B", "C", "D"}, {"X", "Y"}, {"Z"}}
+	if got := g.ConnectedComponents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestConnectedComponentsJoinedByTransfer(t *testing.T) {
+	g, err := BuildGraph(testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00"},
+		"T2": {"X", "08:00:00", "Y", "08:05:00"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.ConnectedComponents(); len(got) != 2 {
+		t.Fatalf("got %v, want two components", got)
+	}
+
+	g.AddTransfers([]gtfs.Transfer{{FromStopID: "B", ToStopID: "X", Distance: 100}}, 0)
+	want := [][]string{{"A", "B", "X", "Y"}}
+	if got := g.ConnectedComponents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// dotEdge is a hop between two stops in a given direction of a route.
+type dotEdge struct {
+	from, to  string
+	direction int
+}
+
+// WriteRouteDOT writes the stops of a route to w as a Graphviz DOT digraph, with an
+// edge for each hop made by any of the route's trips. Hops made by several trips
+// appear once. Nodes are labelled with their stop names, and hops in direction 1 are
+// drawn dashed to tell them apart from those in direction 0.
+func (g *Graph) WriteRouteDOT(routeID string, w io.Writer) error {
+	seen := make(map[dotEdge]bool)
+	var edges []dotEdge
+	stops := make(map[string]bool)
+	for _, adjacent := range g.Edges {
+		for _, e := range adjacent {
+			if e.TripID == "" || e.RouteID != routeID {
+				continue
+			}
+			de := dotEdge{from: e.From, to: e.To, direction: e.DirectionID}
+			if seen[de] {
+				continue
+			}
+			seen[de] = true
+			edges = append(edges, de)
+			stops[e.From] = true
+			stops[e.To] = true
+		}
+	}
+	if len(edges) == 0 {
+		return fmt.Errorf("graph: no trips of route %q", routeID)
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return a.to < b.to
+	})
+	ids := make([]string, 0, len(stops))
+	for id := range stops {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", strconv.Quote(routeID))
+	for _, id := range ids {
+		label := id
+		if stop, ok := g.Stops[id]; ok && stop.Name != "" {
+			label = stop.Name
+		}
+		fmt.Fprintf(bw, "  %s [label=%s];\n", strconv.Quote(id), strconv.Quote(label))
+	}
+	for _, e := range edges {
+		style := "solid"
+		if e.direction == 1 {
+			style = "dashed"
+		}
+		fmt.Fprintf(bw, "  %s -> %s [style=%s];\n", strconv.Quote(e.from), strconv.Quote(e.to), style)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteRouteDOT(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:12:00"},
+		"T2": {"A", "09:00:00", "B", "09:05:00", "C", "09:12:00"},
+		"T3": {"C", "10:00:00", "B", "10:07:00", "A", "10:12:00"},
+		"T4": {"A", "11:00:00", "D", "11:10:00"},
+	})
+	for i, trip := range feed.Trips {
+		switch trip.ID {
+		case "T3":
+			feed.Trips[i].DirectionID = 1
+		case "T4":
+			feed.Trips[i].RouteID = "R2"
+		}
+	}
+	for i := range feed.Stops {
+		if feed.Stops[i].ID == "A" {
+			feed.Stops[i].Name = "Flinders Street"
+		}
+	}
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteRouteDOT("R1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, `digraph "R1" {`) {
+		t.Errorf("got %q, want a digraph named R1", dot)
+	}
+	for _, want := range []string{
+		`"A" [label="Flinders Street"];`,
+		`"B" [label="B"];`,
+		`"A" -> "B" [style=solid];`,
+		`"B" -> "C" [style=solid];`,
+		`"C" -> "B" [style=dashed];`,
+		`"B" -> "A" [style=dashed];`,
+	} {
+		if strings.Count(dot, want) != 1 {
+			t.Errorf("got %d of %s, want 1:\n%s", strings.Count(dot, want), want, dot)
+		}
+	}
+	// Stops which only other routes call at are left out.
+	if strings.Contains(dot, `"D"`) {
+		t.Errorf("got stop D of route R2:\n%s", dot)
+	}
+}
+
+func TestWriteRouteDOTUnknownRoute(t *testing.T) {
+	g, err := BuildGraph(testFeed(map[string][]string{"T1": {"A", "08:00:00", "B", "08:05:00"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.WriteRouteDOT("R9", &bytes.Buffer{}); err == nil {
+		t.Error("WriteRouteDOT didn't return an error for a route without trips")
+	}
+}
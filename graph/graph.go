@@ -0,0 +1,133 @@
+// Package graph models a consolidated GTFS feed as a directed graph of stops, where
+// each edge is a hop made by a trip between two consecutive stops.
+package graph
+
+import (
+	"sort"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// Edge is a directed hop between two stops made by a trip, or a walking transfer if
+// TripID is empty. Weight is the time taken from departing From to arriving at To.
+// RouteID and DirectionID are those of the trip, and are left empty for transfers.
+// Trips is the number of trips making the hop, which is 1 unless the edge stands for
+// several merged by MergeParallelEdges, and 0 for transfers.
+type Edge struct {
+	From        string
+	To          string
+	TripID      string
+	RouteID     string
+	DirectionID int
+	Weight      time.Duration
+	Trips       int
+}
+
+// Graph is a directed graph of the stops in a feed. Edges holds the adjacency list of
+// each stop, keyed by the stop_id the edges depart from.
+type Graph struct {
+	Stops map[string]gtfs.Stop
+	Edges map[string][]Edge
+}
+
+// BuildGraph constructs a Graph with a node for every stop in the feed, and an edge
+// between each pair of consecutive stops (ordered by stop_sequence) of every trip.
+// Hops whose times are missing or run backwards are left out of the graph.
+func BuildGraph(feed *gtfs.Feed) (*Graph, error) {
+	g := &Graph{
+		Stops: make(map[string]gtfs.Stop, len(feed.Stops)),
+		Edges: make(map[string][]Edge),
+	}
+
+	for _, stop := range feed.Stops {
+		g.Stops[stop.ID] = stop
+	}
+
+	tripsByID := make(map[string]gtfs.Trip, len(feed.Trips))
+	for _, trip := range feed.Trips {
+		tripsByID[trip.ID] = trip
+	}
+
+	trips := stopTimesByTrip(feed.StopTimes)
+	// Visit trips in a fixed order so that each adjacency list is built reproducibly.
+	tripIDs := make([]string, 0, len(trips))
+	for id := range trips {
+		tripIDs = append(tripIDs, id)
+	}
+	sort.Strings(tripIDs)
+
+	for _, tripID := range tripIDs {
+		stopTimes := trips[tripID]
+		trip := tripsByID[tripID]
+		for i := 1; i < len(stopTimes); i++ {
+			from, to := stopTimes[i-1], stopTimes[i]
+
+			departure, err := gtfs.ParseGTFSTime(from.DepartureTime)
+			if err != nil {
+				continue
+			}
+			arrival, err := gtfs.ParseGTFSTime(to.ArrivalTime)
+			if err != nil || arrival < departure {
+				continue
+			}
+
+			g.Edges[from.StopID] = append(g.Edges[from.StopID], Edge{
+				From:        from.StopID,
+				To:          to.StopID,
+				TripID:      tripID,
+				RouteID:     trip.RouteID,
+				DirectionID: trip.DirectionID,
+				Weight:      arrival - departure,
+				Trips:       1,
+			})
+		}
+	}
+	return g, nil
+}
+
+// MergeParallelEdges replaces the edges made by trips between each pair of stops with a
+// single edge, as a graph built from a full timetable has an edge for every trip making
+// each hop, most of which a shortest path never takes. The merged edge is the fastest
+// of them, keeping its trip, route and direction, with Trips counting every trip merged
+// into it. Walking transfers are left as they are. Each adjacency list keeps the order
+// in which its stops are first reached.
+func (g *Graph) MergeParallelEdges() {
+	for from, edges := range g.Edges {
+		merged := edges[:0:0]
+		byStop := make(map[string]int)
+		for _, e := range edges {
+			if e.TripID == "" {
+				merged = append(merged, e)
+				continue
+			}
+			i, ok := byStop[e.To]
+			if !ok {
+				byStop[e.To] = len(merged)
+				merged = append(merged, e)
+				continue
+			}
+			trips := merged[i].Trips + e.Trips
+			if e.Weight < merged[i].Weight {
+				merged[i] = e
+			}
+			merged[i].Trips = trips
+		}
+		g.Edges[from] = merged
+	}
+}
+
+// Groups stop times by their trip_id, with each trip's stop times ordered by stop_sequence.
+func stopTimesByTrip(stopTimes []gtfs.StopTime) map[string][]gtfs.StopTime {
+	trips := make(map[string][]gtfs.StopTime)
+	for _, st := range stopTimes {
+		trips[st.TripID] = append(trips[st.TripID], st)
+	}
+
+	for _, sts := range trips {
+		sort.Slice(sts, func(i, j int) bool {
+			return sts[i].StopSequence < sts[j].StopSequence
+		})
+	}
+	return trips
+}
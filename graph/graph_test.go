@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// Returns a feed of the given trips on route R1, each described by the stops it calls
+// at in turn and the time it calls at each, e.g. "A", "08:00:00", "B", "08:05:00".
+// Every stop called at is added to the feed.
+func testFeed(trips map[string][]string) *gtfs.Feed {
+	feed := &gtfs.Feed{}
+	seen := make(map[string]bool)
+	for tripID, calls := range trips {
+		feed.Trips = append(feed.Trips, gtfs.Trip{ID: tripID, RouteID: "R1"})
+		for i := 0; i+1 < len(calls); i += 2 {
+			stopID, at := calls[i], calls[i+1]
+			feed.StopTimes = append(feed.StopTimes, gtfs.StopTime{
+				TripID:        tripID,
+				StopID:        stopID,
+				StopSequence:  i/2 + 1,
+				ArrivalTime:   at,
+				DepartureTime: at,
+			})
+			if !seen[stopID] {
+				seen[stopID] = true
+				feed.Stops = append(feed.Stops, gtfs.Stop{ID: stopID})
+			}
+		}
+	}
+	return feed
+}
+
+func TestBuildGraph(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:12:00"},
+	})
+	// Stop times needn't be given in order of their stop_sequence.
+	feed.StopTimes[0], feed.StopTimes[2] = feed.StopTimes[2], feed.StopTimes[0]
+	feed.Stops = append(feed.Stops, gtfs.Stop{ID: "D"})
+
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Stops) != 4 {
+		t.Errorf("got %d stops, want 4", len(g.Stops))
+	}
+	want := []Edge{{From: "A", To: "B", TripID: "T1", RouteID: "R1", Weight: 5 * time.Minute, Trips: 1}}
+	if !reflect.DeepEqual(g.Edges["A"], want) {
+		t.Errorf("got edges from A %+v, want %+v", g.Edges["A"], want)
+	}
+	want = []Edge{{From: "B", To: "C", TripID: "T1", RouteID: "R1", Weight: 7 * time.Minute, Trips: 1}}
+	if !reflect.DeepEqual(g.Edges["B"], want) {
+		t.Errorf("got edges from B %+v, want %+v", g.Edges["B"], want)
+	}
+	if len(g.Edges["C"]) != 0 || len(g.Edges["D"]) != 0 {
+		t.Errorf("got edges from the last stop or an unserved one: %+v %+v", g.Edges["C"], g.Edges["D"])
+	}
+}
+
+func TestBuildGraphSkipsBadTimes(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "", "C", "08:10:00"},
+		"T2": {"C", "09:00:00", "D", "08:55:00"},
+	})
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stopID := range []string{"A", "B", "C"} {
+		if len(g.Edges[stopID]) != 0 {
+			t.Errorf("got edges from %s: %+v", stopID, g.Edges[stopID])
+		}
+	}
+}
+
+func TestBuildGraphPastMidnight(t *testing.T) {
+	g, err := BuildGraph(testFeed(map[string][]string{"T1": {"A", "23:55:00", "B", "24:10:00"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edges := g.Edges["A"]; len(edges) != 1 || edges[0].Weight != 15*time.Minute {
+		t.Errorf("got edges from A %+v, want one of 15m", edges)
+	}
+}
+
+func TestMergeParallelEdges(t *testing.T) {
+	walk := Edge{From: "A", To: "B", Weight: 10 * time.Minute}
+	g := &Graph{Edges: map[string][]Edge{
+		"A": {
+			{From: "A", To: "B", TripID: "T1", RouteID: "R1", Weight: 5 * time.Minute, Trips: 1},
+			{From: "A", To: "C", TripID: "T1", RouteID: "R1", Weight: 9 * time.Minute, Trips: 1},
+			walk,
+			{From: "A", To: "B", TripID: "T2", RouteID: "R2", DirectionID: 1, Weight: 4 * time.Minute, Trips: 1},
+			{From: "A", To: "B", TripID: "T3", RouteID: "R1", Weight: 6 * time.Minute, Trips: 1},
+		},
+		"B": {{From: "B", To: "C", TripID: "T1", RouteID: "R1", Weight: 7 * time.Minute, Trips: 1}},
+	}}
+	g.MergeParallelEdges()
+
+	// The fastest trip from A to B stands for all three, in the place of the first.
+	want := []Edge{
+		{From: "A", To: "B", TripID: "T2", RouteID: "R2", DirectionID: 1, Weight: 4 * time.Minute, Trips: 3},
+		{From: "A", To: "C", TripID: "T1", RouteID: "R1", Weight: 9 * time.Minute, Trips: 1},
+		walk,
+	}
+	if !reflect.DeepEqual(g.Edges["A"], want) {
+		t.Errorf("got edges from A %+v, want %+v", g.Edges["A"], want)
+	}
+	if len(g.Edges["B"]) != 1 || g.Edges["B"][0].Trips != 1 {
+		t.Errorf("got edges from B %+v, want the one left alone", g.Edges["B"])
+	}
+
+	// Merging again changes nothing, keeping the count of trips merged before.
+	g.MergeParallelEdges()
+	if !reflect.DeepEqual(g.Edges["A"], want) {
+		t.Errorf("merging twice gives edges from A %+v, want %+v", g.Edges["A"], want)
+	}
+}
+
+func TestBuildGraphMergeParallelEdges(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:10:00"},
+		"T2": {"A", "08:10:00", "B", "08:13:00"},
+	})
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Edges["A"]) != 2 {
+		t.Fatalf("got edges from A %+v, want one for each trip", g.Edges["A"])
+	}
+	g.MergeParallelEdges()
+	want := []Edge{{From: "A", To: "B", TripID: "T2", RouteID: "R1", Weight: 3 * time.Minute, Trips: 2}}
+	if !reflect.DeepEqual(g.Edges["A"], want) {
+		t.Errorf("got edges from A %+v, want %+v", g.Edges["A"], want)
+	}
+
+	// The shortest path is as fast as before merging.
+	path, d, err := g.ShortestPath("A", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A", "B", "C"}; !reflect.DeepEqual(path, want) || d != 8*time.Minute {
+		t.Errorf("got path %v taking %s, want %v taking 8m0s", path, d, want)
+	}
+}
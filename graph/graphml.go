@@ -0,0 +1,113 @@
+package graph
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+// graphMLKeys declares the attributes carried by the nodes and edges of the GraphML output.
+var graphMLKeys = []graphMLKey{
+	{ID: "stop_id", For: "node", Name: "stop_id", Type: "string"},
+	{ID: "stop_name", For: "node", Name: "stop_name", Type: "string"},
+	{ID: "lat", For: "node", Name: "lat", Type: "double"},
+	{ID: "lon", For: "node", Name: "lon", Type: "double"},
+	{ID: "weight", For: "edge", Name: "weight", Type: "double"},
+	{ID: "route_id", For: "edge", Name: "route_id", Type: "string"},
+	{ID: "trip_id", For: "edge", Name: "trip_id", Type: "string"},
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML writes the graph to w as a directed GraphML document, for viewing in
+// tools such as Gephi or yEd. Nodes carry the stop_id, stop_name, lat and lon of
+// their stop. Edges carry their weight in seconds, along with the route_id and
+// trip_id of the trip making the hop; walking transfers have neither.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: graphMLNamespace,
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "G", EdgeDefault: "directed"},
+	}
+
+	ids := make([]string, 0, len(g.Stops))
+	for id := range g.Stops {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		stop := g.Stops[id]
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: id,
+			Data: []graphMLData{
+				{Key: "stop_id", Value: stop.ID},
+				{Key: "stop_name", Value: stop.Name},
+				{Key: "lat", Value: strconv.FormatFloat(stop.Lat, 'f', -1, 64)},
+				{Key: "lon", Value: strconv.FormatFloat(stop.Lon, 'f', -1, 64)},
+			},
+		})
+	}
+
+	for _, id := range ids {
+		for _, e := range g.Edges[id] {
+			data := []graphMLData{{Key: "weight", Value: strconv.FormatFloat(e.Weight.Seconds(), 'f', -1, 64)}}
+			if e.TripID != "" {
+				data = append(data,
+					graphMLData{Key: "route_id", Value: e.RouteID},
+					graphMLData{Key: "trip_id", Value: e.TripID},
+				)
+			}
+			doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: e.From, Target: e.To, Data: data})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
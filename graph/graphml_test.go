@@ -0,0 +1,99 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+func TestWriteGraphML(t *testing.T) {
+	feed := testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:12:00"},
+		"T2": {"C", "09:00:00", "A", "09:20:00"},
+	})
+	for i := range feed.Stops {
+		feed.Stops[i].Name = "Stop " + feed.Stops[i].ID
+		feed.Stops[i].Lat, feed.Stops[i].Lon = -37.8, 144.9
+	}
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.XMLNS != graphMLNamespace || doc.Graph.EdgeDefault != "directed" {
+		t.Errorf("got namespace %q and edgedefault %q", doc.XMLNS, doc.Graph.EdgeDefault)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 3 {
+		t.Fatalf("got %d nodes and %d edges, want 3 of each", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	// Every attribute used is declared in the header, for the right kind of element.
+	declared := make(map[string]string)
+	for _, k := range doc.Keys {
+		declared[k.ID] = k.For
+	}
+	for _, n := range doc.Graph.Nodes {
+		for _, d := range n.Data {
+			if declared[d.Key] != "node" {
+				t.Errorf("node attribute %s isn't declared for nodes", d.Key)
+			}
+		}
+	}
+	for _, e := range doc.Graph.Edges {
+		for _, d := range e.Data {
+			if declared[d.Key] != "edge" {
+				t.Errorf("edge attribute %s isn't declared for edges", d.Key)
+			}
+		}
+	}
+
+	want := graphMLNode{ID: "A", Data: []graphMLData{
+		{Key: "stop_id", Value: "A"},
+		{Key: "stop_name", Value: "Stop A"},
+		{Key: "lat", Value: "-37.8"},
+		{Key: "lon", Value: "144.9"},
+	}}
+	if got := doc.Graph.Nodes[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got node %+v, want %+v", got, want)
+	}
+	edge := doc.Graph.Edges[0]
+	if edge.Source != "A" || edge.Target != "B" || len(edge.Data) != 3 || edge.Data[0].Value != "300" || edge.Data[1].Value != "R1" {
+		t.Errorf("got edge %+v, want A to B on R1 weighing 300s", edge)
+	}
+}
+
+func TestWriteGraphMLTransfers(t *testing.T) {
+	feed := testFeed(map[string][]string{"T1": {"A", "08:00:00", "B", "08:05:00"}})
+	g, err := BuildGraph(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.AddTransfers([]gtfs.Transfer{{FromStopID: "B", ToStopID: "A", Distance: 140}}, 0)
+
+	var buf bytes.Buffer
+	if err := g.WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	// Walking transfers carry their weight alone.
+	for _, e := range doc.Graph.Edges {
+		if e.Source == "B" && len(e.Data) != 1 {
+			t.Errorf("got transfer %+v, want its weight alone", e)
+		}
+	}
+}
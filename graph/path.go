@@ -0,0 +1,84 @@
+package graph
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoPath is returned by ShortestPath when the destination can't be reached from
+// the origin, e.g. as they lie in disconnected parts of the network.
+var ErrNoPath = errors.New("graph: no path between stops")
+
+// ShortestPath finds the quickest path between two stops using Dijkstra's algorithm
+// over the travel time of each edge. It returns the stop_ids visited along the path,
+// including both ends, and the total travel time.
+func (g *Graph) ShortestPath(fromStopID, toStopID string) ([]string, time.Duration, error) {
+	for _, id := range []string{fromStopID, toStopID} {
+		if _, ok := g.Stops[id]; !ok {
+			return nil, 0, fmt.Errorf("graph: unknown stop %q", id)
+		}
+	}
+
+	dist := map[string]time.Duration{fromStopID: 0}
+	prev := make(map[string]string)
+	visited := make(map[string]bool)
+
+	q := &pathQueue{{stopID: fromStopID}}
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(pathItem)
+		if visited[cur.stopID] {
+			continue
+		}
+		visited[cur.stopID] = true
+
+		if cur.stopID == toStopID {
+			break
+		}
+
+		for _, e := range g.Edges[cur.stopID] {
+			d := cur.dist + e.Weight
+			if best, ok := dist[e.To]; ok && best <= d {
+				continue
+			}
+			dist[e.To] = d
+			prev[e.To] = cur.stopID
+			heap.Push(q, pathItem{stopID: e.To, dist: d})
+		}
+	}
+
+	if !visited[toStopID] {
+		return nil, 0, ErrNoPath
+	}
+
+	path := []string{toStopID}
+	for id := toStopID; id != fromStopID; {
+		id = prev[id]
+		path = append(path, id)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, dist[toStopID], nil
+}
+
+// pathItem is a stop waiting to be visited, along with the best known time to reach it.
+type pathItem struct {
+	stopID string
+	dist   time.Duration
+}
+
+// pathQueue is a min-heap of pathItems ordered by their distance, for use with container/heap.
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
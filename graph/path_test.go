@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShortestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		trips    map[string][]string
+		from, to string
+		path     []string
+		duration time.Duration
+	}{
+		{
+			name:     "linear",
+			trips:    map[string][]string{"T1": {"A", "08:00:00", "B", "08:05:00", "C", "08:12:00"}},
+			from:     "A",
+			to:       "C",
+			path:     []string{"A", "B", "C"},
+			duration: 12 * time.Minute,
+		},
+		{
+			// The branch through D has fewer stops, but takes longer than the one through B and C.
+			name: "branching",
+			trips: map[string][]string{
+				"T1": {"A", "08:00:00", "B", "08:04:00", "C", "08:08:00", "E", "08:12:00"},
+				"T2": {"A", "08:00:00", "D", "08:10:00", "E", "08:20:00"},
+			},
+			from:     "A",
+			to:       "E",
+			path:     []string{"A", "B", "C", "E"},
+			duration: 12 * time.Minute,
+		},
+		{
+			name:     "same stop",
+			trips:    map[string][]string{"T1": {"A", "08:00:00", "B", "08:05:00"}},
+			from:     "A",
+			to:       "A",
+			path:     []string{"A"},
+			duration: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := BuildGraph(testFeed(tt.trips))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, d, err := g.ShortestPath(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(path, tt.path) || d != tt.duration {
+				t.Errorf("got %v in %v, want %v in %v", path, d, tt.path, tt.duration)
+			}
+		})
+	}
+}
+
+func TestShortestPathUnreachable(t *testing.T) {
+	g, err := BuildGraph(testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00"},
+		"T2": {"C", "09:00:00", "D", "09:05:00"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// B is reachable from A, but not the other way around, as edges are directed.
+	for _, pair := range [][2]string{{"A", "D"}, {"B", "A"}} {
+		if _, _, err := g.ShortestPath(pair[0], pair[1]); !errors.Is(err, ErrNoPath) {
+			t.Errorf("ShortestPath(%s, %s) returned %v, want ErrNoPath", pair[0], pair[1], err)
+		}
+	}
+	if _, _, err := g.ShortestPath("A", "Z"); err == nil || errors.Is(err, ErrNoPath) {
+		t.Errorf("ShortestPath to an unknown stop returned %v", err)
+	}
+}
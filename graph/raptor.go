@@ -0,0 +1,314 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// unreached stands in for the arrival time at a stop which hasn't been reached.
+const unreached = time.Duration(1<<63 - 1)
+
+// Timetable holds the trips of a feed grouped for journey planning with
+// EarliestArrival. Unlike a Graph it keeps the time of every departure, so that a
+// journey can only board a trip which hasn't left yet.
+type Timetable struct {
+	stops     map[string]bool
+	patterns  []*pattern
+	byStop    map[string][]patternStop
+	transfers map[string][]footpath
+
+	calendars     []gtfs.Calendar
+	calendarDates []gtfs.CalendarDate
+}
+
+// pattern is a set of trips of the same route calling at the same stops in the same
+// order. arrivals[i] and departures[i] are the times of the trip at stops[i].
+type pattern struct {
+	routeID string
+	stops   []string
+	trips   []patternTrip
+}
+
+type patternTrip struct {
+	id         string
+	serviceID  string
+	arrivals   []time.Duration
+	departures []time.Duration
+}
+
+// patternStop is the position of a stop within a pattern.
+type patternStop struct {
+	pattern *pattern
+	index   int
+}
+
+// footpath is a walking transfer to another stop.
+type footpath struct {
+	to   string
+	walk time.Duration
+}
+
+// Leg is one part of a Journey: either a ride on a trip between two of its stops, or
+// a walking transfer between two nearby stops if TripID is empty. Departure and
+// Arrival are offsets from the start of the service day.
+type Leg struct {
+	TripID    string
+	RouteID   string
+	From      string
+	To        string
+	Departure time.Duration
+	Arrival   time.Duration
+}
+
+// Journey is the quickest way found to reach a stop. Arrival is the time of arrival at
+// the destination, as an offset from the start of the service day.
+type Journey struct {
+	Arrival time.Duration
+	Legs    []Leg
+}
+
+// NewTimetable groups the trips of a feed into patterns for journey planning, along
+// with walking transfers between stops such as those found by gtfs.ComputeTransfers.
+// Walking transfers take the time needed to cover their distance at WalkingSpeed, plus
+// penalty, which as with AddTransfers favours journeys making fewer transfers.
+// Stop times whose times are missing or can't be parsed are left out, as a trip
+// can't be boarded or left at a stop without a known time.
+func NewTimetable(feed *gtfs.Feed, transfers []gtfs.Transfer, penalty time.Duration) *Timetable {
+	t := &Timetable{
+		stops:         make(map[string]bool, len(feed.Stops)),
+		byStop:        make(map[string][]patternStop),
+		transfers:     make(map[string][]footpath),
+		calendars:     feed.Calendars,
+		calendarDates: feed.CalendarDates,
+	}
+	for _, s := range feed.Stops {
+		t.stops[s.ID] = true
+	}
+
+	stopTimes := stopTimesByTrip(feed.StopTimes)
+	patterns := make(map[string]*pattern)
+	var keys []string
+	for _, trip := range feed.Trips {
+		var stops []string
+		pt := patternTrip{id: trip.ID, serviceID: trip.ServiceID}
+		for _, st := range stopTimes[trip.ID] {
+			arrival, err := gtfs.ParseGTFSTime(st.ArrivalTime)
+			if err != nil {
+				continue
+			}
+			departure, err := gtfs.ParseGTFSTime(st.DepartureTime)
+			if err != nil {
+				continue
+			}
+			stops = append(stops, st.StopID)
+			pt.arrivals = append(pt.arrivals, arrival)
+			pt.departures = append(pt.departures, departure)
+			t.stops[st.StopID] = true
+		}
+		if len(stops) < 2 {
+			continue
+		}
+
+		key := trip.RouteID + "\x1f" + strings.Join(stops, "\x1f")
+		p, ok := patterns[key]
+		if !ok {
+			p = &pattern{routeID: trip.RouteID, stops: stops}
+			patterns[key] = p
+			keys = append(keys, key)
+		}
+		p.trips = append(p.trips, pt)
+	}
+
+	// Order the patterns so that ties between equally good journeys are broken the
+	// same way every time.
+	sort.Strings(keys)
+	for _, key := range keys {
+		p := patterns[key]
+		t.patterns = append(t.patterns, p)
+		for i, stop := range p.stops {
+			t.byStop[stop] = append(t.byStop[stop], patternStop{pattern: p, index: i})
+		}
+	}
+
+	for _, tr := range transfers {
+		walk := walkingTime(tr.Distance) + penalty
+		t.transfers[tr.FromStopID] = append(t.transfers[tr.FromStopID], footpath{to: tr.ToStopID, walk: walk})
+	}
+	return t
+}
+
+// label records the leg by which a stop was reached in a round of EarliestArrival.
+// The leg begins at a stop reached in the previous round for a ride, or in the same
+// round for a walk.
+type label struct {
+	leg  Leg
+	walk bool
+}
+
+// EarliestArrival finds the journey arriving soonest at toStopID when leaving
+// fromStopID no earlier than departure on the given day, using the round-based RAPTOR
+// algorithm. Each round boards one more trip than the last, so at most maxTrips trips
+// are taken; walking transfers may be made between them. Of the journeys arriving
+// earliest, the one taking the fewest trips is returned.
+//
+// Only trips whose service runs on day are considered, and only those of that service
+// day, so a journey can't continue onto the trips of the following day.
+func (t *Timetable) EarliestArrival(fromStopID, toStopID string, day time.Time, departure time.Duration, maxTrips int) (Journey, error) {
+	for _, id := range []string{fromStopID, toStopID} {
+		if !t.stops[id] {
+			return Journey{}, fmt.Errorf("graph: unknown stop %q", id)
+		}
+	}
+	active := gtfs.ActiveServices(t.calendars, t.calendarDates, day)
+
+	// arrivals[k] holds the earliest arrival at each stop using at most k trips, and
+	// labels[k] how each stop was first reached that quickly in round k.
+	arrivals := []map[string]time.Duration{{fromStopID: departure}}
+	labels := []map[string]label{{}}
+	best := map[string]time.Duration{fromStopID: departure}
+	arrival := func(k int, stop string) time.Duration {
+		if a, ok := arrivals[k][stop]; ok {
+			return a
+		}
+		return unreached
+	}
+	bestAt := func(stop string) time.Duration {
+		if a, ok := best[stop]; ok {
+			return a
+		}
+		return unreached
+	}
+
+	marked := map[string]bool{fromStopID: true}
+	t.walk(marked, arrivals[0], labels[0], best)
+
+	for k := 1; k <= maxTrips && len(marked) > 0; k++ {
+		arrivals = append(arrivals, make(map[string]time.Duration, len(arrivals[k-1])))
+		for stop, a := range arrivals[k-1] {
+			arrivals[k][stop] = a
+		}
+		labels = append(labels, make(map[string]label))
+
+		// Each pattern is scanned from the earliest of its stops reached last round.
+		queue := make(map[*pattern]int)
+		for stop := range marked {
+			for _, ps := range t.byStop[stop] {
+				if i, ok := queue[ps.pattern]; !ok || ps.index < i {
+					queue[ps.pattern] = ps.index
+				}
+			}
+		}
+		marked = make(map[string]bool)
+
+		for _, p := range t.patterns {
+			start, ok := queue[p]
+			if !ok {
+				continue
+			}
+
+			var trip *patternTrip
+			var boarded int
+			for i := start; i < len(p.stops); i++ {
+				stop := p.stops[i]
+				if trip != nil {
+					a := trip.arrivals[i]
+					if a < bestAt(stop) && a < bestAt(toStopID) {
+						arrivals[k][stop] = a
+						best[stop] = a
+						labels[k][stop] = label{leg: Leg{
+							TripID:    trip.id,
+							RouteID:   p.routeID,
+							From:      p.stops[boarded],
+							To:        stop,
+							Departure: trip.departures[boarded],
+							Arrival:   a,
+						}}
+						marked[stop] = true
+					}
+				}
+
+				// Catch an earlier trip here if the stop was reached in time last round.
+				ready := arrival(k-1, stop)
+				if trip == nil || ready <= trip.departures[i] {
+					if next := p.earliestTrip(i, ready, active); next != nil && next != trip {
+						trip = next
+						boarded = i
+					}
+				}
+			}
+		}
+
+		t.walk(marked, arrivals[k], labels[k], best)
+	}
+
+	// Find the fewest trips needed to arrive at the earliest possible time.
+	final := bestAt(toStopID)
+	if final == unreached {
+		return Journey{}, ErrNoPath
+	}
+	k := len(arrivals) - 1
+	for k > 0 && arrival(k-1, toStopID) == final {
+		k--
+	}
+
+	var legs []Leg
+	for stop := toStopID; stop != fromStopID; {
+		l, ok := labels[k][stop]
+		if !ok {
+			// The stop was reached as quickly in an earlier round.
+			k--
+			continue
+		}
+		legs = append(legs, l.leg)
+		stop = l.leg.From
+		if !l.walk {
+			k--
+		}
+	}
+	for i, j := 0, len(legs)-1; i < j; i, j = i+1, j-1 {
+		legs[i], legs[j] = legs[j], legs[i]
+	}
+	return Journey{Arrival: final, Legs: legs}, nil
+}
+
+// Returns the trip of the pattern departing the stop at index soonest at or after
+// ready, considering only trips whose service is active, or nil if there isn't one.
+func (p *pattern) earliestTrip(index int, ready time.Duration, active map[string]bool) *patternTrip {
+	var earliest *patternTrip
+	for i := range p.trips {
+		trip := &p.trips[i]
+		if !active[trip.serviceID] || trip.departures[index] < ready {
+			continue
+		}
+		if earliest == nil || trip.departures[index] < earliest.departures[index] {
+			earliest = trip
+		}
+	}
+	return earliest
+}
+
+// Relaxes the walking transfers from each stop marked in the current round, marking
+// the stops whose arrival times improve.
+func (t *Timetable) walk(marked map[string]bool, arrivals map[string]time.Duration, labels map[string]label, best map[string]time.Duration) {
+	var walked []string
+	for stop := range marked {
+		from := arrivals[stop]
+		for _, fp := range t.transfers[stop] {
+			a := from + fp.walk
+			if b, ok := best[fp.to]; ok && b <= a {
+				continue
+			}
+			arrivals[fp.to] = a
+			best[fp.to] = a
+			labels[fp.to] = label{leg: Leg{From: stop, To: fp.to, Departure: from, Arrival: a}, walk: true}
+			walked = append(walked, fp.to)
+		}
+	}
+	for _, stop := range walked {
+		marked[stop] = true
+	}
+}
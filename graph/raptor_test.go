@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// Returns testFeed(trips), with every trip run to service S1 on weekdays during 2024.
+func weekdayFeed(trips map[string][]string) *gtfs.Feed {
+	feed := testFeed(trips)
+	for i := range feed.Trips {
+		feed.Trips[i].ServiceID = "S1"
+	}
+	feed.Calendars = []gtfs.Calendar{{
+		ServiceID: "S1", Monday: true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true,
+		StartDate: "20240101", EndDate: "20241231",
+	}}
+	return feed
+}
+
+// Returns the time of day given as hours and minutes.
+func clock(h, m int) time.Duration {
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+}
+
+var tuesday = time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)
+
+func TestEarliestArrival(t *testing.T) {
+	tt := NewTimetable(weekdayFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:10:00"},
+		// T2 leaves B before T1 arrives, so T3 is the connection.
+		"T2": {"B", "08:05:00", "C", "08:20:00"},
+		"T3": {"B", "08:15:00", "C", "08:30:00"},
+		// T4 goes straight to C, but arrives later.
+		"T4": {"A", "08:00:00", "X", "08:30:00", "C", "08:45:00"},
+	}), nil, 0)
+
+	j, err := tt.EarliestArrival("A", "C", tuesday, clock(7, 55), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Journey{Arrival: clock(8, 30), Legs: []Leg{
+		{TripID: "T1", RouteID: "R1", From: "A", To: "B", Departure: clock(8, 0), Arrival: clock(8, 10)},
+		{TripID: "T3", RouteID: "R1", From: "B", To: "C", Departure: clock(8, 15), Arrival: clock(8, 30)},
+	}}
+	if !reflect.DeepEqual(j, want) {
+		t.Errorf("got %+v\nwant %+v", j, want)
+	}
+
+	// Limited to a single trip, the transfer can't be made.
+	j, err = tt.EarliestArrival("A", "C", tuesday, clock(7, 55), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Arrival != clock(8, 45) || len(j.Legs) != 1 || j.Legs[0].TripID != "T4" {
+		t.Errorf("got %+v, want T4 alone arriving at 08:45", j)
+	}
+}
+
+func TestEarliestArrivalWalkingTransfer(t *testing.T) {
+	tt := NewTimetable(weekdayFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:10:00"},
+		"T2": {"D", "08:12:00", "E", "08:20:00"},
+	}), []gtfs.Transfer{{FromStopID: "B", ToStopID: "D", Distance: 140}}, 0)
+
+	j, err := tt.EarliestArrival("A", "E", tuesday, clock(8, 0), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 140m takes 100s to walk, leaving time to catch T2.
+	walked := clock(8, 10) + 100*time.Second
+	want := Journey{Arrival: clock(8, 20), Legs: []Leg{
+		{TripID: "T1", RouteID: "R1", From: "A", To: "B", Departure: clock(8, 0), Arrival: clock(8, 10)},
+		{From: "B", To: "D", Departure: clock(8, 10), Arrival: walked},
+		{TripID: "T2", RouteID: "R1", From: "D", To: "E", Departure: clock(8, 12), Arrival: clock(8, 20)},
+	}}
+	if !reflect.DeepEqual(j, want) {
+		t.Errorf("got %+v\nwant %+v", j, want)
+	}
+}
+
+func TestEarliestArrivalTransferPenalty(t *testing.T) {
+	feed := weekdayFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:10:00"},
+		"T2": {"D", "08:12:00", "E", "08:20:00"},
+		"T3": {"A", "08:00:00", "E", "08:25:00"},
+	})
+	transfers := []gtfs.Transfer{{FromStopID: "B", ToStopID: "D", Distance: 140}}
+
+	j, err := NewTimetable(feed, transfers, 0).EarliestArrival("A", "E", tuesday, clock(8, 0), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Arrival != clock(8, 20) || len(j.Legs) != 3 {
+		t.Errorf("got %+v without a penalty, want T1 and T2 arriving at 08:20", j)
+	}
+
+	// Walking to D now takes until 08:16:40, after T2 has left.
+	j, err = NewTimetable(feed, transfers, 5*time.Minute).EarliestArrival("A", "E", tuesday, clock(8, 0), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Journey{Arrival: clock(8, 25), Legs: []Leg{
+		{TripID: "T3", RouteID: "R1", From: "A", To: "E", Departure: clock(8, 0), Arrival: clock(8, 25)},
+	}}
+	if !reflect.DeepEqual(j, want) {
+		t.Errorf("got %+v\nwant %+v", j, want)
+	}
+}
+
+func TestEarliestArrivalInactiveService(t *testing.T) {
+	tt := NewTimetable(weekdayFeed(map[string][]string{"T1": {"A", "08:00:00", "B", "08:10:00"}}), nil, 0)
+	saturday := time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)
+	if _, err := tt.EarliestArrival("A", "B", saturday, clock(7, 0), 3); err != ErrNoPath {
+		t.Errorf("got error %v on a day T1 doesn't run, want ErrNoPath", err)
+	}
+	// Nor can a trip which has already left be boarded.
+	if _, err := tt.EarliestArrival("A", "B", tuesday, clock(8, 1), 3); err != ErrNoPath {
+		t.Errorf("got error %v after T1 has left, want ErrNoPath", err)
+	}
+	if _, err := tt.EarliestArrival("A", "Z", tuesday, clock(7, 0), 3); err == nil || err == ErrNoPath {
+		t.Errorf("got error %v for an unknown stop", err)
+	}
+}
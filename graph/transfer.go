@@ -0,0 +1,34 @@
+package graph
+
+import (
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// WalkingSpeed is the speed in meters per second assumed when weighting walking
+// transfers, roughly that of an average adult.
+var WalkingSpeed = 1.4
+
+// AddTransfers adds a walking edge to the graph for each transfer, such as those
+// returned by gtfs.ComputeTransfers. Walking edges have no TripID, and are weighted
+// by the time taken to walk the transfer's distance at WalkingSpeed plus penalty.
+//
+// The penalty accounts for the inconvenience of changing services, so that paths
+// prefer staying aboard when the travel times are close. A penalty of 0 weights
+// transfers by their walking time alone.
+func (g *Graph) AddTransfers(transfers []gtfs.Transfer, penalty time.Duration) {
+	for _, t := range transfers {
+		walk := walkingTime(t.Distance) + penalty
+		g.Edges[t.FromStopID] = append(g.Edges[t.FromStopID], Edge{
+			From:   t.FromStopID,
+			To:     t.ToStopID,
+			Weight: walk,
+		})
+	}
+}
+
+// Returns the time taken to walk distance meters at WalkingSpeed.
+func walkingTime(distance float64) time.Duration {
+	return time.Duration(distance / WalkingSpeed * float64(time.Second))
+}
@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+func TestAddTransfers(t *testing.T) {
+	g, err := BuildGraph(testFeed(map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00"},
+		"T2": {"C", "08:00:00", "D", "08:05:00"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.AddTransfers([]gtfs.Transfer{
+		{FromStopID: "B", ToStopID: "C", Distance: 140},
+		{FromStopID: "C", ToStopID: "B", Distance: 140},
+	}, 0)
+
+	// 140m takes 100s to walk at 1.4m/s.
+	want := []Edge{{From: "B", To: "C", Weight: 100 * time.Second}}
+	if !reflect.DeepEqual(g.Edges["B"], want) {
+		t.Errorf("got edges from B %+v, want %+v", g.Edges["B"], want)
+	}
+
+	path, d, err := g.ShortestPath("A", "D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A", "B", "C", "D"}; !reflect.DeepEqual(path, want) || d != 10*time.Minute+100*time.Second {
+		t.Errorf("got %v in %v, want %v in 11m40s", path, d, want)
+	}
+}
+
+func TestAddTransfersPenalty(t *testing.T) {
+	trips := map[string][]string{
+		"T1": {"A", "08:00:00", "B", "08:05:00"},
+		"T2": {"C", "08:00:00", "D", "08:05:00"},
+		// T3 is a little slower than changing from T1 to T2.
+		"T3": {"A", "08:00:00", "D", "08:13:00"},
+	}
+	transfers := []gtfs.Transfer{{FromStopID: "B", ToStopID: "C", Distance: 140}}
+
+	tests := []struct {
+		penalty time.Duration
+		path    []string
+		d       time.Duration
+	}{
+		{0, []string{"A", "B", "C", "D"}, 10*time.Minute + 100*time.Second},
+		// The penalty tips the balance towards staying aboard T3.
+		{2 * time.Minute, []string{"A", "D"}, 13 * time.Minute},
+	}
+	for _, tt := range tests {
+		g, err := BuildGraph(testFeed(trips))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.AddTransfers(transfers, tt.penalty)
+		if want := 100*time.Second + tt.penalty; g.Edges["B"][0].Weight != want {
+			t.Errorf("penalty %v: got transfer %+v, want a weight of %v", tt.penalty, g.Edges["B"][0], want)
+		}
+
+		path, d, err := g.ShortestPath("A", "D")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(path, tt.path) || d != tt.d {
+			t.Errorf("penalty %v: got %v in %v, want %v in %v", tt.penalty, path, d, tt.path, tt.d)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package gtfs
+
+import "github.com/mholt/archiver"
+
+// Archiver extracts and creates the zips read and written by Consolidate.
+type Archiver interface {
+	// Unarchive extracts the archive at source into the directory destination.
+	Unarchive(source, destination string) error
+	// Archive writes the files and directories in sources to a new archive at
+	// destination, which mustn't already exist.
+	Archive(sources []string, destination string) error
+}
+
+// DefaultArchiver is the Archiver used when Options.Archiver isn't set, backed by
+// github.com/mholt/archiver.
+var DefaultArchiver Archiver = mholtArchiver{}
+
+// mholtArchiver is an Archiver which defers to github.com/mholt/archiver, choosing the
+// format of each archive from its file extension.
+type mholtArchiver struct{}
+
+func (mholtArchiver) Unarchive(source, destination string) error {
+	return archiver.Unarchive(source, destination)
+}
+
+func (mholtArchiver) Archive(sources []string, destination string) error {
+	return archiver.Archive(sources, destination)
+}
+
+// Returns a, or DefaultArchiver if a is nil.
+func archiverOrDefault(a Archiver) Archiver {
+	if a == nil {
+		return DefaultArchiver
+	}
+	return a
+}
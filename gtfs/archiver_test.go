@@ -0,0 +1,111 @@
+package gtfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// memArchiver is an Archiver which extracts archives held in memory rather than zips on
+// disk. Unarchive writes the files of archives[source] into the destination, where a
+// file of an archive may itself be the source of another, and Archive records what it
+// was asked to archive.
+type memArchiver struct {
+	archives map[string]map[string]string
+	archived map[string][]string
+}
+
+func (a *memArchiver) Unarchive(source, destination string) error {
+	files, ok := a.archives[source]
+	if !ok {
+		return &os.PathError{Op: "open", Path: source, Err: os.ErrNotExist}
+	}
+	for name, contents := range files {
+		path := filepath.Join(destination, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *memArchiver) Archive(sources []string, destination string) error {
+	a.archived[destination] = sources
+	return nil
+}
+
+func TestExtractPTVDataWithArchiver(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "in")
+	a := &memArchiver{archives: map[string]map[string]string{
+		"gtfs.zip": {
+			"1/" + innerZipFileName:        "",
+			"2/" + innerZipFileName:        "",
+			"2/readme.txt":                 "not a feed",
+			"3/nested/" + innerZipFileName: "",
+		},
+		filepath.Join(dest, "1", innerZipFileName):           {"stops.txt": testFeed["stops.txt"]},
+		filepath.Join(dest, "2", innerZipFileName):           {"routes.txt": testFeed["routes.txt"]},
+		filepath.Join(dest, "3", "nested", innerZipFileName): {"trips.txt": testFeed["trips.txt"]},
+	}}
+
+	if err := extractPTVData(context.Background(), "gtfs.zip", dest, extractOptions{archiver: a, log: NewLogger(ioutil.Discard, LevelError)}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		filepath.Join("1", "google_transit", "stops.txt"):           testFeed["stops.txt"],
+		filepath.Join("2", "google_transit", "routes.txt"):          testFeed["routes.txt"],
+		filepath.Join("3", "nested", "google_transit", "trips.txt"): testFeed["trips.txt"],
+	} {
+		if got := readFile(t, filepath.Join(dest, path)); got != want {
+			t.Errorf("extracted %s as %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestConsolidateWithArchiver(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	looseInputFiles := filepath.Join(dir, looseInputDirName)
+	a := &memArchiver{
+		archives: map[string]map[string]string{
+			"gtfs.zip": {"1/" + innerZipFileName: ""},
+			filepath.Join(looseInputFiles, "1", innerZipFileName): testFeed,
+		},
+		archived: make(map[string][]string),
+	}
+
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.KeepIntermediate = true
+	opts.Archiver = a
+	if err := Consolidate("gtfs.zip", out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(readRows(t, filepath.Join(out, "stops.txt"))); got != 4 {
+		t.Errorf("stops.txt has %d rows, want 4", got)
+	}
+	sources, ok := a.archived[out+".zip"]
+	if !ok {
+		t.Fatalf("archived %v, want %s.zip", a.archived, out)
+	}
+	entries, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, e := range entries {
+		want = append(want, filepath.Join(out, e.Name()))
+	}
+	sort.Strings(sources)
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("archived %v, want %v", sources, want)
+	}
+}
@@ -0,0 +1,405 @@
+package gtfs
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointState is the progress of a consolidation saved to its checkpoint file: the
+// source files written in full, and for each kind of GTFS file what had been written
+// of it by then.
+type checkpointState struct {
+	// Inputs and Format identify the run, so that a checkpoint isn't resumed by another.
+	Inputs []string
+	Format string
+
+	// Done lists the source files written in full, relative to the extracted input.
+	Done   []string
+	Tables map[string]checkpointTable
+}
+
+// checkpointTable is what had been written of a kind of GTFS file when a checkpoint was
+// saved.
+type checkpointTable struct {
+	// Size is the length of the output file, which is truncated back to it on resuming.
+	Size int64
+	// Keys lists the keys of the rows written, so that their duplicates are still found.
+	Keys []string
+	// Kept holds the rows kept for each key of a table checked for conflicts.
+	Kept map[string]checkpointRow
+
+	Rows       int
+	Duplicates int
+	Conflicts  int
+}
+
+// checkpointRow is a keptRow as saved in a checkpoint.
+type checkpointRow struct {
+	Path string
+	Row  []string
+}
+
+// checkpointFileSuffix is appended to the output directory to give the path of its
+// checkpoint.
+const checkpointFileSuffix = ".checkpoint"
+
+// Returns the checkpointer for a run writing format to outputDir, if opts asks for
+// checkpoints to be saved or resumed, along with what was written of each table by the
+// checkpoint resumed from.
+func setupCheckpoint(format outputFormat, outputDir, looseInputFiles string, inputZips []string, opts Options, log Logger) (*checkpointer, map[string]checkpointTable, error) {
+	if opts.CheckpointInterval <= 0 && !opts.Resume {
+		return nil, nil, nil
+	}
+	f, ok := format.(*csvFormat)
+	if ok {
+		_, ok = f.sink.(dirSink)
+	}
+	if !ok || f.gzip || opts.Sort {
+		return nil, nil, errors.New("only the csv format written to a directory can be checkpointed, and not when sorting")
+	}
+
+	inputs := make([]string, len(inputZips))
+	for i, input := range inputZips {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		inputs[i] = abs
+	}
+	c := newCheckpointer(outputSibling(outputDir, opts.FilePrefix, checkpointFileSuffix), looseInputFiles, opts.CheckpointInterval, inputs, opts.Format, log)
+	if !opts.Resume {
+		// A checkpoint left by an earlier run no longer matches the output.
+		c.remove()
+		return c, nil, nil
+	}
+
+	state, err := c.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == nil {
+		log.Infof("No checkpoint found at %s, starting afresh", c.path)
+		return c, nil, nil
+	}
+	log.Infof("Resuming from %s, skipping %d files already written", c.path, len(state.Done))
+	f.resume = make(map[string]int64, len(state.Tables))
+	for kind, ct := range state.Tables {
+		f.resume[fmt.Sprintf("%s.%s", kind, f.ext)] = ct.Size
+	}
+	return c, state.Tables, nil
+}
+
+// checkpointer saves the progress of a consolidation at intervals, so that it can be
+// resumed if interrupted. A checkpoint is only ever saved between source files: once
+// it's due, no more files are started until those being read have been written in
+// full, so that the output holds exactly the rows of the files listed as done.
+//
+// Its methods may be called on a nil checkpointer, which does nothing.
+type checkpointer struct {
+	path     string
+	root     string
+	interval time.Duration
+	inputs   []string
+	format   string
+	log      Logger
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// save snapshots the tables being written, and is set once writing begins.
+	save    func() (map[string]checkpointTable, error)
+	last    time.Time
+	pending bool
+	failed  bool
+
+	skip     map[string]bool
+	done     []string
+	inFlight map[string]bool
+	sent     map[string]int
+	written  map[string]int
+}
+
+// Returns a checkpointer saving to path every interval, or never if interval isn't
+// positive, on behalf of a run consolidating the feed extracted to root.
+func newCheckpointer(path, root string, interval time.Duration, inputs []string, format string, log Logger) *checkpointer {
+	c := &checkpointer{
+		path:     path,
+		root:     root,
+		interval: interval,
+		inputs:   inputs,
+		format:   format,
+		log:      log,
+		last:     time.Now(),
+		skip:     make(map[string]bool),
+		inFlight: make(map[string]bool),
+		sent:     make(map[string]int),
+		written:  make(map[string]int),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Reads the checkpoint saved to c's path, returning nil if there isn't one. The files
+// it lists as done are skipped from then on. Returns an error if the checkpoint was
+// saved by a run with different inputs or output format.
+func (c *checkpointer) load() (*checkpointState, error) {
+	file, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var state checkpointState
+	if err := gob.NewDecoder(file).Decode(&state); err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint %s: %w", c.path, err)
+	}
+	if state.Format != c.format || !equalRows(state.Inputs, c.inputs) {
+		return nil, fmt.Errorf("checkpoint %s was saved consolidating %v as %s, not %v as %s", c.path, state.Inputs, state.Format, c.inputs, c.format)
+	}
+
+	c.done = state.Done
+	for _, rel := range state.Done {
+		c.skip[filepath.Join(c.root, rel)] = true
+	}
+	return &state, nil
+}
+
+// Sets the function snapshotting the tables being written, allowing checkpoints to be
+// saved.
+func (c *checkpointer) start(save func() (map[string]checkpointTable, error)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.save = save
+}
+
+// Returns whether the source file at path was written before the checkpoint resumed from.
+func (c *checkpointer) skips(path string) bool {
+	return c != nil && c.skip[path]
+}
+
+// Records that the source file at path is being read, first waiting for any checkpoint
+// which is due to be saved.
+func (c *checkpointer) begin(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.interval > 0 && !c.pending && !c.failed && time.Since(c.last) >= c.interval {
+		c.pending = true
+		c.trySave()
+	}
+	for c.pending {
+		c.cond.Wait()
+	}
+	c.inFlight[path] = true
+}
+
+// Records that rows records of the source file at path were read, or that reading it
+// failed with err.
+func (c *checkpointer) finish(path string, rows int, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		// The file's rows may be partly written, so nothing more can be checkpointed.
+		c.failed = true
+		delete(c.inFlight, path)
+		c.trySave()
+		return
+	}
+	c.sent[path] = rows
+	c.complete(path)
+}
+
+// Records that a record of the source file at path has been written, or skipped.
+func (c *checkpointer) wrote(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.written[path]++
+	c.complete(path)
+}
+
+// Marks the source file at path as done if it has been read and every one of its
+// records written.
+func (c *checkpointer) complete(path string) {
+	sent, read := c.sent[path]
+	if !read || c.written[path] < sent {
+		return
+	}
+	delete(c.inFlight, path)
+	delete(c.sent, path)
+	delete(c.written, path)
+	if rel, err := filepath.Rel(c.root, path); err == nil {
+		c.done = append(c.done, rel)
+	}
+	c.trySave()
+}
+
+// Saves the checkpoint if one is due and no files are in flight, then lets the walk
+// carry on. Must be called with c.mu held.
+func (c *checkpointer) trySave() {
+	if !c.pending || len(c.inFlight) > 0 {
+		return
+	}
+	if !c.failed && c.save != nil {
+		if err := c.write(); err != nil {
+			c.log.Warnf("Unable to save checkpoint: %s", err.Error())
+		} else {
+			c.log.Infof("Saved checkpoint of %d files to %s", len(c.done), c.path)
+		}
+	}
+	c.last = time.Now()
+	c.pending = false
+	c.cond.Broadcast()
+}
+
+// Writes the checkpoint, replacing the last one only once the new one is complete.
+func (c *checkpointer) write() error {
+	tables, err := c.save()
+	if err != nil {
+		return err
+	}
+	state := checkpointState{Inputs: c.inputs, Format: c.format, Done: c.done, Tables: tables}
+
+	tmp := c.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(state); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Removes the checkpoint once consolidation has finished.
+func (c *checkpointer) remove() {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		c.log.Warnf("Error when deleting checkpoint: %s", err.Error())
+	}
+}
+
+// checkpointedTable is implemented by the tableWriters whose output can be resumed
+// from a checkpoint.
+type checkpointedTable interface {
+	// Flushes the rows written so far, returning the size of the output.
+	flushSize() (int64, error)
+}
+
+func (t *csvTable) flushSize() (int64, error) {
+	t.writer.Flush()
+	if err := t.writer.Error(); err != nil {
+		return 0, err
+	}
+	seeker, ok := t.file.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("unable to checkpoint %s", t.name)
+	}
+	return seeker.Seek(0, io.SeekCurrent)
+}
+
+// Snapshots what has been written of each table, for a checkpoint.
+func snapshotTables(data map[string]*gtfsTable) (map[string]checkpointTable, error) {
+	tables := make(map[string]checkpointTable, len(data))
+	for kind, t := range data {
+		ct, err := t.snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kind, err)
+		}
+		tables[kind] = ct
+	}
+	return tables, nil
+}
+
+func (t *gtfsTable) snapshot() (checkpointTable, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.w.(checkpointedTable)
+	if !ok {
+		return checkpointTable{}, errors.New("output can't be checkpointed")
+	}
+	size, err := w.flushSize()
+	if err != nil {
+		return checkpointTable{}, err
+	}
+
+	ct := checkpointTable{
+		Size:       size,
+		Keys:       make([]string, 0, len(t.keys)),
+		Rows:       t.stats.rows,
+		Duplicates: t.stats.duplicates,
+		Conflicts:  t.stats.conflicts,
+	}
+	for key := range t.keys {
+		ct.Keys = append(ct.Keys, key)
+	}
+	if t.kept != nil {
+		ct.Kept = make(map[string]checkpointRow, len(t.kept))
+		for key, kept := range t.kept {
+			ct.Kept[key] = checkpointRow{Path: kept.path, Row: kept.row}
+		}
+	}
+	return ct, nil
+}
+
+// Restores what had been written of the table when a checkpoint was saved.
+func (t *gtfsTable) restore(ct checkpointTable) {
+	for _, key := range ct.Keys {
+		t.keys[key] = struct{}{}
+	}
+	if t.kept != nil {
+		for key, kept := range ct.Kept {
+			t.kept[key] = keptRow{path: kept.Path, row: kept.Row}
+		}
+	}
+	t.stats.rows = ct.Rows
+	t.stats.duplicates = ct.Duplicates
+	t.stats.conflicts = ct.Conflicts
+}
+
+// Reopens the file with the given name in the sink's directory to carry on writing it
+// from size, discarding anything written after the checkpoint.
+func (s dirSink) reopen(name string, size int64) (io.WriteCloser, error) {
+	path := filepath.Join(s.path, s.prefix+name)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reopen output file %s: %w", path, err)
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,192 @@
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// interruptingLogger cancels a consolidation once it has saved a checkpoint of files
+// source files, as though the process had been killed.
+type interruptingLogger struct {
+	Logger
+	files  int
+	cancel context.CancelFunc
+	once   sync.Once
+	saved  bool
+}
+
+func (l *interruptingLogger) Infof(format string, args ...interface{}) {
+	if strings.HasPrefix(fmt.Sprintf(format, args...), fmt.Sprintf("Saved checkpoint of %d files", l.files)) {
+		l.once.Do(func() {
+			l.saved = true
+			l.cancel()
+		})
+	}
+	l.Logger.Infof(format, args...)
+}
+
+// Writes feeds to in which repeat one another's records, so that resuming only finds
+// the duplicates among them if the keys already written were saved.
+func writeCheckpointFeeds(t *testing.T, in string) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		var stopTimes strings.Builder
+		stopTimes.WriteString(strings.SplitN(testFeed["stop_times.txt"], "\n", 2)[0] + "\n")
+		for j := 0; j < 200; j++ {
+			fmt.Fprintf(&stopTimes, "T1,08:%02d:00,08:%02d:00,A,%d,,0,0,\n", j%60, j%60, i*100+j)
+		}
+		writeFiles(t, filepath.Join(in, fmt.Sprint(i)), withFiles(testFeed, map[string]string{
+			"stops.txt":      testFeed["stops.txt"] + fmt.Sprintf("E%d,Stop %d,-37.8,144.9,1\n", i, i),
+			"stop_times.txt": stopTimes.String(),
+		}))
+	}
+}
+
+// Returns the sorted rows of each file written to out, and the rows the manifest
+// counts for each.
+func outputRows(t *testing.T, out string) (map[string][]string, map[string]int) {
+	t.Helper()
+	infos, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]string)
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".txt") {
+			continue
+		}
+		lines := strings.Split(readFile(t, filepath.Join(out, info.Name())), "\n")
+		sort.Strings(lines[1:])
+		files[info.Name()] = lines
+	}
+	counts := make(map[string]int)
+	for _, f := range readManifest(t, filepath.Join(out, ManifestFileName)).Files {
+		counts[f.Name] = f.Rows
+	}
+	return files, counts
+}
+
+func TestConsolidateResume(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeCheckpointFeeds(t, in)
+
+	want := filepath.Join(dir, "want")
+	if err := Consolidate(in, want, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	wantFiles, wantCounts := outputRows(t, want)
+
+	for _, files := range []int{1, 10, 20} {
+		t.Run(fmt.Sprintf("files=%d", files), func(t *testing.T) {
+			out := filepath.Join(dir, fmt.Sprint("out", files))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			log := &interruptingLogger{Logger: NewLogger(ioutil.Discard, LevelError), files: files, cancel: cancel}
+			opts := testOptions(dir)
+			opts.Logger = log
+			opts.Concurrency = 1
+			opts.CheckpointInterval = time.Nanosecond
+			err := ConsolidateContext(ctx, in, out, opts)
+			if !errors.Is(err, context.Canceled) || !log.saved {
+				t.Fatalf("got error %v, saved checkpoint %t; want the run interrupted after a checkpoint", err, log.saved)
+			}
+			if _, err := os.Stat(out + checkpointFileSuffix); err != nil {
+				t.Fatalf("no checkpoint was left behind: %v", err)
+			}
+
+			opts = testOptions(dir)
+			opts.Resume = true
+			if err := Consolidate(in, out, opts); err != nil {
+				t.Fatal(err)
+			}
+			gotFiles, gotCounts := outputRows(t, out)
+			if !reflect.DeepEqual(gotFiles, wantFiles) {
+				for name := range wantFiles {
+					if !reflect.DeepEqual(gotFiles[name], wantFiles[name]) {
+						t.Errorf("resumed %s differs from an uninterrupted run:\ngot  %q\nwant %q", name, gotFiles[name], wantFiles[name])
+					}
+				}
+			}
+			if !reflect.DeepEqual(gotCounts, wantCounts) {
+				t.Errorf("resumed manifest counts %v, want %v", gotCounts, wantCounts)
+			}
+			if _, err := os.Stat(out + checkpointFileSuffix); !os.IsNotExist(err) {
+				t.Errorf("the checkpoint wasn't removed once finished: %v", err)
+			}
+		})
+	}
+}
+
+func TestConsolidateResumeWithoutCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Resume = true
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readRows(t, filepath.Join(out, "stops.txt"))); got != 4 {
+		t.Errorf("stops.txt has %d rows, want 4", got)
+	}
+}
+
+func TestConsolidateResumeMismatchedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeCheckpointFeeds(t, in)
+
+	out := filepath.Join(dir, "out")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := testOptions(dir)
+	opts.Logger = &interruptingLogger{Logger: NewLogger(ioutil.Discard, LevelError), files: 1, cancel: cancel}
+	opts.Concurrency = 1
+	opts.CheckpointInterval = time.Nanosecond
+	if err := ConsolidateContext(ctx, in, out, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	// The checkpoint was saved consolidating other inputs.
+	other := filepath.Join(dir, "other")
+	if err := os.Rename(in, other); err != nil {
+		t.Fatal(err)
+	}
+	opts = testOptions(dir)
+	opts.Resume = true
+	if err := Consolidate(other, out, opts); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Errorf("got error %v, want one of the mismatched checkpoint", err)
+	}
+}
+
+func TestConsolidateCheckpointUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	for name, opts := range map[string]func(*Options){
+		"sorted": func(o *Options) { o.Sort = true },
+		"ndjson": func(o *Options) { o.Format = FormatNDJSON },
+		"gzip":   func(o *Options) { o.Format = FormatCSVGzip },
+	} {
+		o := testOptions(dir)
+		o.CheckpointInterval = time.Minute
+		opts(&o)
+		if err := Consolidate(in, filepath.Join(dir, name), o); err == nil {
+			t.Errorf("checkpointed %s output", name)
+		}
+	}
+}
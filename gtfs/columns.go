@@ -0,0 +1,126 @@
+package gtfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reads the header row of every GTFS file of the given kinds beneath path, whose fields
+// are separated by comma, and returns for each of those kinds the columns to write to
+// the output, along with the kinds of file found.
+//
+// The columns of a kind are those found across all of its source files, in the order
+// they were first seen, so that no column present in any feed is lost. Kinds listed
+// in only are restricted to the named columns which are present in the source, in
+// the order given. Kinds without any source files fall back to outputColumns.
+func sourceColumns(path string, only map[string][]string, kinds []string, comma rune) (map[string][]string, map[string]bool, error) {
+	seen := make(map[string]map[string]bool)
+	columns := make(map[string][]string)
+
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("access %s: %w", path, err)
+		}
+		if info.IsDir() || !fileIsGTFSFile(info.Name(), kinds) {
+			return nil
+		}
+
+		header, err := readHeader(path, comma)
+		if err != nil {
+			return err
+		}
+		kind := strings.Split(info.Name(), ".")[0]
+		if seen[kind] == nil {
+			seen[kind] = make(map[string]bool)
+		}
+		for _, column := range header {
+			if !seen[kind][column] {
+				seen[kind][column] = true
+				columns[kind] = append(columns[kind], column)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[string]bool, len(seen))
+	for kind := range seen {
+		found[kind] = true
+	}
+
+	for _, kind := range kinds {
+		if _, ok := seen[kind]; !ok {
+			columns[kind] = outputColumns[kind]
+			seen[kind] = make(map[string]bool)
+			for _, column := range columns[kind] {
+				seen[kind][column] = true
+			}
+		}
+
+		if names, ok := only[kind]; ok {
+			var kept []string
+			for _, column := range names {
+				if seen[kind][column] {
+					kept = append(kept, column)
+				}
+			}
+			columns[kind] = kept
+		}
+	}
+	return columns, found, nil
+}
+
+// Returns the names under which the columns of each kind of GTFS file are written,
+// renaming those given in renames for their kind. Columns which aren't renamed keep
+// their own names. Returns an error if renaming would give two columns of a kind the
+// same name.
+func renameColumns(columns map[string][]string, renames map[string]map[string]string) (map[string][]string, error) {
+	headers := make(map[string][]string, len(columns))
+	for kind, names := range columns {
+		header := make([]string, len(names))
+		used := make(map[string]bool, len(names))
+		for i, column := range names {
+			name := column
+			if to, ok := renames[kind][column]; ok {
+				name = to
+			}
+			if used[name] {
+				return nil, fmt.Errorf("%s.txt would have more than one column named %s", kind, name)
+			}
+			used[name] = true
+			header[i] = name
+		}
+		headers[kind] = header
+	}
+	return headers, nil
+}
+
+// Returns the header row of the CSV file at path, whose fields are separated by comma,
+// or nil if the file is empty. The file is decompressed if it's gzipped.
+func readHeader(path string, comma rune) ([]string, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	gr, err := decompressGTFSFile(path, file)
+	if err != nil {
+		return nil, err
+	}
+	r := newCSVReader(gr)
+	r.Comma = comma
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header of %s: %w", path, err)
+	}
+	return header, nil
+}
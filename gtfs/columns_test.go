@@ -0,0 +1,107 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConsolidateKeepsSourceColumns(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), testFeed)
+	// The second feed has a column the first doesn't, and lacks wheelchair_boarding.
+	writeFiles(t, filepath.Join(in, "2"), map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,zone_id\nE,Box Hill,-37.8190,145.1220,2\n",
+	})
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(out, "stops.txt"))), "\n")
+	if want := "stop_id,stop_name,stop_lat,stop_lon,wheelchair_boarding,zone_id"; lines[0] != want {
+		t.Errorf("got header %q, want %q", lines[0], want)
+	}
+	rows := lines[1:]
+	for _, want := range []string{"A,\"Flinders St, Stop 1\",-37.8183,144.9671,1,", "E,Box Hill,-37.8190,145.1220,,2"} {
+		found := false
+		for _, row := range rows {
+			found = found || row == want
+		}
+		if !found {
+			t.Errorf("stops.txt is missing the row %q: %q", want, rows)
+		}
+	}
+}
+
+func TestConsolidateRestrictedColumns(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	// Columns the source doesn't have are left out.
+	opts.Columns = map[string][]string{"stops": {"stop_name", "stop_id", "platform_code", "wheelchair_boarding"}}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(out, "stops.txt"))), "\n")
+	want := []string{"stop_name,stop_id,wheelchair_boarding", "\"Flinders St, Stop 1\",A,1"}
+	if !reflect.DeepEqual(lines[:2], want) {
+		t.Errorf("got %q, want %q to start stops.txt", lines[:2], want)
+	}
+	// Other kinds keep every column.
+	if got := len(strings.Split(strings.SplitN(readFile(t, filepath.Join(out, "trips.txt")), "\n", 2)[0], ",")); got != 7 {
+		t.Errorf("trips.txt has %d columns, want 7", got)
+	}
+}
+
+func TestConsolidateRenameColumns(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.RenameColumns = map[string]map[string]string{"stops": {"stop_lat": "lat", "stop_lon": "lon", "platform_code": "platform"}}
+	// Columns and Filter still name the columns as the source does.
+	opts.Columns = map[string][]string{"stops": {"stop_id", "stop_lon", "stop_lat"}}
+	opts.Filter.BBox = &BoundingBox{MinLat: -38, MinLon: 144, MaxLat: -37, MaxLon: 145}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(out, "stops.txt"))), "\n")
+	want := []string{"stop_id,lon,lat", "A,144.9671,-37.8183", "B,144.9525,-37.8184", "C,144.9900,-37.8240"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got stops.txt %q, want %q", lines, want)
+	}
+	// Other kinds keep their names.
+	if got := strings.SplitN(readFile(t, filepath.Join(out, "shapes.txt")), "\n", 2)[0]; !strings.Contains(got, "shape_pt_lat") {
+		t.Errorf("got shapes.txt header %q, want shape_pt_lat left alone", got)
+	}
+}
+
+func TestConsolidateRenameColumnsErrors(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	opts := testOptions(dir)
+	opts.RenameColumns = map[string]map[string]string{"stops": {"stop_lat": "stop_lon"}}
+	err := Consolidate(in, filepath.Join(dir, "out"), opts)
+	if err == nil || !strings.Contains(err.Error(), "more than one column named stop_lon") {
+		t.Errorf("got error %v, want one of two columns named stop_lon", err)
+	}
+
+	opts.RenameColumns = map[string]map[string]string{"stops": {"stop_lat": "lat"}}
+	opts.Format = FormatNDJSON
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err == nil {
+		t.Error("renamed the columns of a format with fixed fields")
+	}
+}
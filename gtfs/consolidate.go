@@ -0,0 +1,793 @@
+// Package gtfs consolidates the GTFS data published by PTV into a single feed.
+//
+// PTV publishes its timetable as a zip containing numbered subdirectories (1, 2, 3 etc.),
+// each holding a google_transit.zip with a full GTFS feed for one mode of transport. The
+// functions in this package extract those nested feeds, merge their records and write
+// them back out as one set of GTFS files.
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+var looseInputDirName = "gtfs_in"
+var innerZipFileName = "google_transit.zip"
+var validGTFSFileNames = []string{"agency", "calendar_dates", "calendar", "routes", "stop_times", "stops", "trips", "shapes", "frequencies", "transfers"}
+
+// Options controls how Consolidate goes about producing its output.
+type Options struct {
+	// TmpDir is the directory the input zip is extracted into. Defaults to the
+	// current working directory.
+	TmpDir string
+
+	// KeepIntermediate preserves the extracted input and the consolidated output
+	// directory once the archive has been written, logging where they were left. The
+	// extracted input is always replaced at the start of the next run.
+	KeepIntermediate bool
+
+	// ExtractRetries is the number of times to retry extracting an inner feed which
+	// fails in a way which may be transient, such as a read error on a network
+	// filesystem, waiting twice as long before each retry as the last. Feeds which
+	// aren't valid zips are never retried.
+	ExtractRetries int
+
+	// MaxMalformedRows is the fraction of the rows of any one GTFS file which may be
+	// malformed, such as having the wrong number of fields, before consolidation fails.
+	// Malformed rows below the limit are logged and skipped. Defaults to
+	// DefaultMaxMalformedRows; a negative value fails on the first malformed row.
+	MaxMalformedRows float64
+
+	// Delimiter separates the fields of the source GTFS files, such as ';' for feeds
+	// exported with a European locale. Defaults to a comma.
+	Delimiter rune
+
+	// OutputDelimiter separates the fields written by FormatCSV, FormatCSVGzip and
+	// FormatFlat. Defaults to a comma, whatever the Delimiter of the source files.
+	OutputDelimiter rune
+
+	// Only restricts consolidation to the given kinds of GTFS file, such as "stops" and
+	// "routes". Source files of other kinds are neither read nor written, unless Filter
+	// or NormalizeTimezone needs them to decide what to write, and are no longer required
+	// to be present.
+	Only []string
+
+	// Exclude leaves the given kinds of GTFS file, such as "shapes", out of the output,
+	// while consolidating the rest as usual. It's applied after Only, and as with Only
+	// the excluded files are no longer required, but are still read where Filter or
+	// NormalizeTimezone needs them to decide what to write.
+	Exclude []string
+
+	// Keys overrides the columns used to identify duplicate records for the given
+	// kinds of GTFS file. Kinds which aren't present use DefaultKeys.
+	Keys map[string][]string
+
+	// Columns restricts the columns written for the given kinds of GTFS file to those
+	// named, in the order given. Kinds which aren't present keep every column found in
+	// the source feed.
+	Columns map[string][]string
+
+	// RenameColumns renames columns of the given kinds of GTFS file in the output,
+	// mapping the name of each column in the source feed to the name it's written under,
+	// e.g. "stop_lat" to "lat". Columns which aren't renamed keep their names. Keys,
+	// Columns and Filter still refer to columns by their names in the source. Only
+	// FormatCSV, FormatCSVGzip and FormatSQLite support renaming columns.
+	RenameColumns map[string]map[string]string
+
+	// Concurrency limits how many inner zips are extracted, how many GTFS files are
+	// read, and how many tables are written, at once. Defaults to GOMAXPROCS.
+	Concurrency int
+
+	// Format is the output format to produce, one of FormatCSV, FormatCSVGzip,
+	// FormatSQLite, FormatGeoJSON, FormatNDJSON, FormatProtobuf, FormatParquet or
+	// FormatFlat. Defaults to FormatCSV.
+	Format string
+
+	// Sink receives the consolidated files in place of outputDir, such as
+	// NewNDJSONSink(os.Stdout) to stream them. As the files never reach outputDir, no
+	// manifest or archive is written. FormatSQLite can't be written to a sink.
+	Sink Sink
+
+	// FilePrefix is added to the start of the name of every file written to outputDir,
+	// e.g. metro_ gives metro_stops.txt and metro_manifest.json, and of the archive,
+	// database or checkpoint written alongside it, e.g. metro_gtfs_out.zip. Only this
+	// output's own files are archived and removed, not those of another whose prefix
+	// starts with this one, so several outputs with different prefixes may share
+	// outputDir. It may only hold letters, digits, '-', '_' and '.', and mustn't start
+	// with a '.'.
+	FilePrefix string
+
+	// SkipArchive leaves the consolidated files in outputDir rather than archiving
+	// them, e.g. as they're already compressed with FormatCSVGzip.
+	SkipArchive bool
+
+	// Filter restricts the output to a subset of the feed.
+	Filter Filter
+
+	// Sample writes only the first Sample rows of each kind of GTFS file, and stops
+	// reading a kind once it has them, for quickly testing a pipeline against a large
+	// feed. As rows are taken in whatever order they're read, the sample needn't hold the
+	// records its rows refer to; Filter.SampleTrips gives a coherent sample instead.
+	// Zero writes every row.
+	Sample int
+
+	// DryRun walks and deduplicates the feed without writing any output, instead
+	// reporting the number of rows, duplicates and conflicts found for each kind of
+	// GTFS file.
+	DryRun bool
+
+	// Report is where the dry run report is written. Defaults to os.Stdout.
+	Report io.Writer
+
+	// ContinueOnWriteError carries on writing the other kinds of GTFS file when one
+	// of them fails to be written. The partial output is still archived, and every
+	// write error is returned once it has been.
+	ContinueOnWriteError bool
+
+	// AddRouteTypeNames adds a route_type_name column to routes, naming the route_type
+	// of each route using RouteTypeName.
+	AddRouteTypeNames bool
+
+	// NormalizeTimezone converts the arrival and departure times of every trip from the
+	// agency_timezone of its agency to Timezone, and rewrites agency_timezone to match,
+	// so that feeds published in different zones share one. The offsets between zones
+	// are taken on the first date of the feed's service. Every agency_timezone must be a
+	// known timezone.
+	NormalizeTimezone bool
+
+	// Timezone is the IANA timezone NormalizeTimezone converts times to, such as
+	// "Australia/Melbourne". Defaults to the agency_timezone of the agency whose
+	// agency_id sorts first.
+	Timezone string
+
+	// ExpandFrequencies replaces each trip run to frequencies.txt with a concrete trip
+	// for every one of its departures, along with its stop times, for consumers which
+	// don't understand frequencies. Each is given the trip_id of the trip it was
+	// expanded from followed by its departure, e.g. T1_063000, and frequencies.txt is
+	// written without any rows.
+	ExpandFrequencies bool
+
+	// WalkingTransfers, if positive, adds a transfer to transfers.txt in each direction
+	// between every pair of stops within this many meters of one another, as given by
+	// ComputeTransfers, so that other planners can change between them on foot. Each has
+	// a transfer_type of 2 and a min_transfer_time of the seconds taken to walk it at
+	// TransferWalkingSpeed. Transfers given by the feed itself are kept in their place.
+	WalkingTransfers float64
+
+	// FillRouteColors fills a blank route_color in routes.txt with the colour of the
+	// route's route_type, as given by RouteColor, and a blank route_text_color with
+	// whichever of black and white is legible against it. The columns are added if the
+	// feed doesn't have them.
+	FillRouteColors bool
+
+	// Sort writes the rows of each file in order of their keys, so that consolidating the
+	// same feed twice produces identical files. Of the records sharing a key, the one
+	// kept comes from the source file whose path sorts first. Every row is held in
+	// memory until its file is written.
+	Sort bool
+
+	// CheckpointInterval, if positive, saves the progress of consolidation to
+	// <outputDir>.checkpoint at most this often, so that a run which is interrupted can
+	// be carried on with Resume. Checkpoints are only saved between source files: once
+	// one is due, no more files are started until those being read have been written.
+	// Only FormatCSV written to outputDir can be checkpointed, and not along with Sort.
+	CheckpointInterval time.Duration
+
+	// Resume carries on from the checkpoint saved by an interrupted run with the same
+	// inputs and format, keeping what it had written and skipping the source files it had
+	// finished. Without a checkpoint the run starts afresh. The checkpoint is removed
+	// once consolidation has finished.
+	Resume bool
+
+	// MinRows gives the fewest rows expected to be written for the given kinds of GTFS
+	// file. Consolidation fails with ErrTooFewRows, before anything is archived, if any
+	// of them has fewer, e.g. as their source files were truncated when extracted.
+	MinRows map[string]int
+
+	// Archiver extracts the input zip and the feeds within it, and archives the output.
+	// Defaults to DefaultArchiver.
+	Archiver Archiver
+
+	// Build identifies the program producing the feed, and is recorded in its manifest.
+	Build *BuildInfo
+
+	// Strict fails consolidation with ErrStrict if there are any problems with the feed
+	// which are otherwise only warned about: inner zips which couldn't be extracted,
+	// optional files missing from the feed, every service having expired, conflicting
+	// definitions of a record, trips defined by several feeds with different stop
+	// times, and stop times FormatFlat couldn't join. Malformed rows fail reading their
+	// file, as with a negative MaxMalformedRows. When writing FormatCSV to outputDir
+	// without a FilePrefix, the consolidated feed is also checked with Validate, e.g.
+	// for stops with bad coordinates or references to records which don't exist. Every
+	// problem is still logged, and the output written in full, before failing.
+	Strict bool
+
+	// Logger receives progress messages. Defaults to logging milestones to stderr.
+	Logger Logger
+
+	// Progress, if set, is called as the feed is consolidated with the number of bytes
+	// of GTFS files read so far and the total size of those files. Calls are never
+	// made concurrently, and done never decreases from one call to the next.
+	Progress func(done, total int64)
+}
+
+// requiredGTFSFileNames are the kinds of GTFS file without which a feed can't be
+// consolidated. The other kinds in validGTFSFileNames are optional.
+var requiredGTFSFileNames = []string{"stops", "routes", "trips", "stop_times"}
+
+// ErrMissingFile is returned by Consolidate when the feed lacks any of the required
+// GTFS files: stops.txt, routes.txt, trips.txt or stop_times.txt.
+var ErrMissingFile = errors.New("missing required GTFS file")
+
+// ErrTooFewRows is returned by Consolidate when fewer rows are written for a kind of
+// GTFS file than were required by Options.MinRows.
+var ErrTooFewRows = errors.New("too few rows written")
+
+// ErrStrict is returned by Consolidate with Options.Strict set when there were any
+// problems with the feed, which have each been logged as a warning.
+var ErrStrict = errors.New("problems with the feed in strict mode")
+
+// feedWarnings reports the problems with a feed which don't stop it being consolidated,
+// logging each as a warning. With strict set they're also counted, so that
+// consolidation fails once they've all been reported.
+type feedWarnings struct {
+	log    Logger
+	strict bool
+	n      int
+}
+
+// Logs a problem with the feed as a warning.
+func (w *feedWarnings) warnf(format string, args ...interface{}) {
+	w.log.Warnf(format, args...)
+	w.n++
+}
+
+// Returns ErrStrict if any problems were reported in strict mode, or nil.
+func (w *feedWarnings) err() error {
+	if !w.strict || w.n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d warnings", ErrStrict, w.n)
+}
+
+// Consolidate extracts the PTV GTFS zip at inputZip, merges the records of every feed
+// it contains and writes one file per kind of GTFS record to outputDir, along with a
+// manifest of those files. The directory is then archived to outputDir.zip, unless
+// SkipArchive is set. In FormatSQLite the records are instead written to
+// a database at outputDir.db, and no archive is produced. See Options.FilePrefix for
+// how these are named when several outputs share outputDir.
+//
+// Inner feeds which fail to extract are logged and skipped; any other failure is
+// returned, leaving the intermediate files in place for inspection. Optional GTFS
+// files missing from every feed are warned about once consolidation has finished, and
+// a feed whose services have all expired is warned about before it starts.
+//
+// If inputZip is a directory it's taken to hold a feed which has already been
+// extracted, and its GTFS files are consolidated where they are. Such a directory is
+// never modified or removed.
+func Consolidate(inputZip, outputDir string, opts Options) error {
+	return ConsolidateContext(context.Background(), inputZip, outputDir, opts)
+}
+
+// ConsolidateContext is like Consolidate, but stops extracting and walking the feed
+// once ctx is cancelled, returning ctx.Err(). Nothing is archived or cleaned up after
+// a cancellation.
+func ConsolidateContext(ctx context.Context, inputZip, outputDir string, opts Options) error {
+	return ConsolidateFeeds(ctx, []string{inputZip}, outputDir, opts)
+}
+
+// ConsolidateFeeds is like ConsolidateContext, but merges the records of several PTV
+// GTFS zips into one output, such as separately published metro and regional feeds.
+// Each zip is extracted into its own subdirectory of the extracted input, and records
+// duplicated across the feeds are collapsed just as those duplicated within a feed.
+//
+// Only a single input may be a directory holding an already extracted feed.
+func ConsolidateFeeds(ctx context.Context, inputZips []string, outputDir string, opts Options) error {
+	if len(inputZips) == 0 {
+		return errors.New("no input zips to consolidate")
+	}
+	looseInputFiles := filepath.Join(opts.TmpDir, looseInputDirName)
+	log := loggerOrDefault(opts.Logger)
+
+	extract := true
+	for _, input := range inputZips {
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if len(inputZips) > 1 {
+			return fmt.Errorf("%s is a directory, which can't be consolidated along with other inputs", input)
+		}
+		looseInputFiles = input
+		extract = false
+	}
+	if opts.Sink != nil {
+		// Nothing is written to the output directory, so there's nothing there to clean up.
+		outputDir = ""
+	}
+
+	err := consolidate(ctx, inputZips, outputDir, looseInputFiles, extract, opts, log)
+	if err != nil {
+		if !extract {
+			looseInputFiles = ""
+		}
+		logIntermediateFiles(looseInputFiles, outputDir, "Leaving", log)
+	}
+	return err
+}
+
+// Does the work of ConsolidateFeeds, consolidating the feeds in looseInputFiles after
+// first extracting inputZips there if extract is set.
+func consolidate(ctx context.Context, inputZips []string, outputDir, looseInputFiles string, extract bool, opts Options, log Logger) error {
+	delimiter, err := csvDelimiter(opts.Delimiter)
+	if err != nil {
+		return err
+	}
+	outputDelimiter, err := csvDelimiter(opts.OutputDelimiter)
+	if err != nil {
+		return err
+	}
+	if err := checkFilePrefix(opts.FilePrefix); err != nil {
+		return err
+	}
+
+	warnings := &feedWarnings{log: log, strict: opts.Strict}
+
+	var format outputFormat = discardFormat{}
+	if !opts.DryRun {
+		format, err = newOutputFormat(opts.Format, outputDir, opts.FilePrefix, opts.Sink, outputDelimiter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.RenameColumns) > 0 && !formatNamesColumns(opts.Format) {
+		return fmt.Errorf("the %s format doesn't support renaming columns", opts.Format)
+	}
+	kinds, err := consolidatedKinds(opts.Only, opts.Exclude)
+	if err != nil {
+		return err
+	}
+
+	if extract {
+		// Files kept from an earlier run would otherwise be consolidated along with this one.
+		if err := os.RemoveAll(looseInputFiles); err != nil {
+			return err
+		}
+		for i, inputZip := range inputZips {
+			dest := looseInputFiles
+			if len(inputZips) > 1 {
+				dest = filepath.Join(looseInputFiles, fmt.Sprintf("input%d", i+1))
+			}
+			err := extractPTVData(ctx, inputZip, dest, extractOptions{retries: opts.ExtractRetries, archiver: opts.Archiver, workers: opts.Concurrency, log: log})
+			var innerErrs innerZipErrors
+			if errors.As(err, &innerErrs) {
+				// The outer zip was extracted, so carry on with the feeds we do have.
+				for _, e := range innerErrs {
+					warnings.warnf("Skipping feed: %s", e.Error())
+				}
+			} else if err != nil {
+				return err
+			}
+		}
+	} else {
+		log.Infof("Reading extracted feed from %s", looseInputFiles)
+	}
+
+	columns, found, err := sourceColumns(looseInputFiles, opts.Columns, kinds, delimiter)
+	if err != nil {
+		return err
+	}
+	walkingTransfers := opts.WalkingTransfers > 0 && containsString(kinds, "transfers")
+	if walkingTransfers {
+		// transfers.txt is written whether or not the feed has one.
+		found["transfers"] = true
+	}
+	missing, err := missingFiles(found, kinds)
+	if err != nil {
+		return err
+	}
+	lastService, err := sourceServiceEnd(looseInputFiles, delimiter)
+	if err != nil {
+		return err
+	}
+	if lastService != "" && lastService < time.Now().Format(gtfsDateLayout) {
+		warnings.warnf("Every service in the feed has expired, the last on %s, so it can't plan any journeys", lastService)
+	}
+
+	derived := make(map[string]map[string]func(h Header, row []string) string)
+	if opts.AddRouteTypeNames {
+		mergeDerived(derived, map[string]map[string]func(h Header, row []string) string{
+			"routes": {"route_type_name": routeTypeNameColumn},
+		})
+		if !containsString(columns["routes"], "route_type_name") {
+			columns["routes"] = append(columns["routes"], "route_type_name")
+		}
+	}
+	if opts.FillRouteColors {
+		mergeDerived(derived, map[string]map[string]func(h Header, row []string) string{
+			"routes": {"route_color": routeColorColumn, "route_text_color": routeTextColorColumn},
+		})
+		for _, column := range []string{"route_color", "route_text_color"} {
+			if !containsString(columns["routes"], column) {
+				columns["routes"] = append(columns["routes"], column)
+			}
+		}
+	}
+
+	if walkingTransfers {
+		for _, column := range outputColumns["transfers"] {
+			if !containsString(columns["transfers"], column) {
+				columns["transfers"] = append(columns["transfers"], column)
+			}
+		}
+	}
+
+	headers, err := renameColumns(columns, opts.RenameColumns)
+	if err != nil {
+		return err
+	}
+
+	walkOpts := walkOptions{concurrency: opts.Concurrency, log: log, maxMalformed: opts.MaxMalformedRows, comma: delimiter}
+	if opts.Strict {
+		// Any malformed row is a problem with the feed.
+		walkOpts.maxMalformed = -1
+	}
+
+	var keep *keepSet
+	if opts.Filter.active() {
+		log.Infof("Resolving filters...")
+		keep, err = resolveFilter(ctx, looseInputFiles, opts.Filter, walkOpts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var normalizer *timezoneNormalizer
+	if opts.NormalizeTimezone {
+		log.Infof("Resolving timezones...")
+		normalizer, err = resolveTimezones(ctx, looseInputFiles, opts.Timezone, walkOpts)
+		if err != nil {
+			return err
+		}
+		mergeDerived(derived, normalizer.derived())
+	}
+
+	var expander *frequencyExpander
+	if opts.ExpandFrequencies {
+		log.Infof("Resolving frequencies...")
+		expander, err = resolveFrequencies(ctx, looseInputFiles, walkOpts)
+		if err != nil {
+			return err
+		}
+		if normalizer != nil {
+			normalizer.expand(expander)
+		}
+	}
+
+	var transfers []GTFSRecord
+	if walkingTransfers {
+		log.Infof("Computing walking transfers...")
+		transfers, err = resolveTransfers(ctx, looseInputFiles, keep, opts.WalkingTransfers, walkOpts)
+		if err != nil {
+			return err
+		}
+	}
+
+	cp, restored, err := setupCheckpoint(format, outputDir, looseInputFiles, inputZips, opts, log)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Consolidating %s...", looseInputFiles)
+	walkOpts.progress = opts.Progress
+	walkOpts.checkpoint = cp
+	walkOpts.kinds = kinds
+	sample := newSampler(opts.Sample)
+	walkOpts.sample = sample
+	var patterns *tripPatterns
+	if sample == nil {
+		// A sample only holds part of the stop times of each feed.
+		patterns = newTripPatterns()
+	}
+	records, errc := walkPTVData(ctx, looseInputFiles, walkOpts)
+	if len(transfers) > 0 {
+		records = appendRecords(records, transfers)
+	}
+	stats, writeErr := writeOutput(records, format, writeOptions{
+		kinds:           kinds,
+		columns:         columns,
+		headers:         headers,
+		keys:            opts.Keys,
+		keep:            keep,
+		expand:          expander,
+		continueOnError: opts.ContinueOnWriteError,
+		workers:         opts.Concurrency,
+		sorted:          opts.Sort,
+		derived:         derived,
+		checkpoint:      cp,
+		restored:        restored,
+		sample:          sample,
+		patterns:        patterns,
+	})
+	if err := <-errc; err != nil {
+		return err
+	}
+	if writeErr != nil && !opts.ContinueOnWriteError {
+		return writeErr
+	}
+	if normalizer != nil {
+		if err := normalizer.result(); err != nil {
+			return err
+		}
+	}
+	logConflicts(stats, warnings)
+	logTripConflicts(patterns.conflicts(), warnings)
+	if flat, ok := format.(*flatFormat); ok && flat.missing > 0 {
+		warnings.warnf("%d stop times in schedule.csv are missing their trip, route or stop, whose columns were left blank", flat.missing)
+	}
+
+	if opts.DryRun {
+		report := opts.Report
+		if report == nil {
+			report = os.Stdout
+		}
+		if err := writeDryRunReport(report, stats); err != nil {
+			return err
+		}
+	}
+	if err := checkMinRows(stats, opts.MinRows); err != nil {
+		return err
+	}
+	if opts.Strict && !opts.DryRun && (opts.Format == "" || opts.Format == FormatCSV) && opts.Sink == nil && opts.FilePrefix == "" {
+		for _, err := range Validate(outputDir) {
+			warnings.warnf("Invalid consolidated feed: %s", err.Error())
+		}
+	}
+	if !opts.DryRun && opts.Format != FormatSQLite && opts.Sink == nil {
+		if err := writeManifest(outputDir, opts.FilePrefix, stats, opts.Build); err != nil {
+			return err
+		}
+		if !opts.SkipArchive {
+			log.Infof("Archiving %s...", outputDir)
+			if err := archiveOutput(outputDir, opts.FilePrefix, archiverOrDefault(opts.Archiver)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+	cp.remove()
+
+	if !extract {
+		// The input directory isn't ours to remove.
+		looseInputFiles = ""
+	}
+	if opts.KeepIntermediate {
+		logIntermediateFiles(looseInputFiles, outputDir, "Keeping", log)
+	} else {
+		// Without an archive the consolidated files are the output itself.
+		keepOutput := opts.SkipArchive && !opts.DryRun && opts.Format != FormatSQLite && opts.Sink == nil
+		cleanup(looseInputFiles, outputDir, opts.FilePrefix, keepOutput, log)
+	}
+	if len(missing) > 0 {
+		warnings.warnf("Optional files missing from the feed: %s", strings.Join(missing, ", "))
+	}
+	if err := warnings.err(); err != nil {
+		return err
+	}
+	log.Infof("Finished consolidating %s", strings.Join(inputZips, ", "))
+	return nil
+}
+
+// Returns the kinds of GTFS file to consolidate: those in only, or every kind if it's
+// empty, less those in exclude. Returns an error if either names an unknown kind.
+func consolidatedKinds(only, exclude []string) ([]string, error) {
+	if len(only) == 0 && len(exclude) == 0 {
+		return validGTFSFileNames, nil
+	}
+	for _, kind := range append(append([]string(nil), only...), exclude...) {
+		if !containsString(validGTFSFileNames, kind) {
+			return nil, fmt.Errorf("unknown kind of GTFS file %q", kind)
+		}
+	}
+
+	var kinds []string
+	for _, kind := range validGTFSFileNames {
+		if (len(only) == 0 || containsString(only, kind)) && !containsString(exclude, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, nil
+}
+
+// Returns the names of the optional GTFS files among kinds which weren't found, or
+// ErrMissingFile naming the required files among kinds which weren't.
+func missingFiles(found map[string]bool, kinds []string) ([]string, error) {
+	required := make(map[string]bool, len(requiredGTFSFileNames))
+	var missingRequired []string
+	for _, kind := range requiredGTFSFileNames {
+		required[kind] = true
+		if !found[kind] && containsString(kinds, kind) {
+			missingRequired = append(missingRequired, fmt.Sprintf("%s.txt", kind))
+		}
+	}
+	if len(missingRequired) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingFile, strings.Join(missingRequired, ", "))
+	}
+
+	var missing []string
+	for _, kind := range kinds {
+		if !required[kind] && !found[kind] {
+			missing = append(missing, fmt.Sprintf("%s.txt", kind))
+		}
+	}
+	return missing, nil
+}
+
+// Returns ErrTooFewRows naming each kind of GTFS file for which fewer rows were written
+// than the minimum given in min.
+func checkMinRows(stats map[string]tableStats, min map[string]int) error {
+	var short []string
+	for _, kind := range validGTFSFileNames {
+		if _, written := stats[kind]; !written {
+			continue
+		}
+		if n, ok := min[kind]; ok && stats[kind].rows < n {
+			short = append(short, fmt.Sprintf("%s.txt has %d of %d", kind, stats[kind].rows, n))
+		}
+	}
+	if len(short) > 0 {
+		return fmt.Errorf("%w: %s", ErrTooFewRows, strings.Join(short, ", "))
+	}
+	return nil
+}
+
+// Warns of the records which were dropped as duplicates despite differing from the
+// record kept with the same key, such as two calendars sharing a service_id but
+// running on different days.
+func logConflicts(stats map[string]tableStats, warnings *feedWarnings) {
+	for _, kind := range validGTFSFileNames {
+		s := stats[kind]
+		if s.conflicts == 0 {
+			continue
+		}
+		warnings.warnf("%s.txt has %d conflicting definitions, keeping the first of each", kind, s.conflicts)
+		for _, c := range s.conflictExamples {
+			// Only the conflicts themselves are counted as problems.
+			warnings.log.Warnf("%s.txt %s in %s differs from %s", kind, c.key, c.paths[1], c.paths[0])
+		}
+	}
+}
+
+// Warns of the trips defined by more than one feed which call at different stops in
+// each, of which only one survives with a mix of the stop times of both.
+func logTripConflicts(conflicts []tripConflict, warnings *feedWarnings) {
+	if len(conflicts) == 0 {
+		return
+	}
+	warnings.warnf("trips.txt has %d trips defined by several feeds calling at different stops, keeping a mix of their stop times", len(conflicts))
+	for i, c := range conflicts {
+		if i == conflictExampleLimit {
+			break
+		}
+		warnings.log.Warnf("trips.txt trip_id %q calls at different stops in %s and %s", c.tripID, c.feeds[0], c.feeds[1])
+	}
+}
+
+// Writes the number of rows, duplicates and conflicting duplicates found for each kind
+// of GTFS file as a table.
+func writeDryRunReport(w io.Writer, stats map[string]tableStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "file\trows\tduplicates\tconflicts\t")
+	for _, kind := range validGTFSFileNames {
+		if _, written := stats[kind]; !written {
+			continue
+		}
+		fmt.Fprintf(tw, "%s.txt\t%d\t%d\t%d\t\n", kind, stats[kind].rows, stats[kind].duplicates, stats[kind].conflicts)
+	}
+	return tw.Flush()
+}
+
+// Logs the locations of the extracted input and consolidated output directories left
+// on disk, preceded by verb. An empty looseInputFiles means nothing was extracted.
+func logIntermediateFiles(looseInputFiles, consolidatedOutputFiles, verb string, log Logger) {
+	for _, dir := range []struct{ desc, path string }{
+		{"extracted input files", looseInputFiles},
+		{"consolidated output files", consolidatedOutputFiles},
+	} {
+		if info, err := os.Stat(dir.path); err == nil && info.IsDir() {
+			log.Infof("%s %s in %s", verb, dir.desc, dir.path)
+		}
+	}
+}
+
+// Removes the temporary directories created when the original files were extracted
+// and the consolidated output was produced, unless keepOutput is set. An empty
+// looseInputFiles means nothing was extracted. With a prefix, only the output files
+// named with it, as given by isOutputFile, are removed, as other outputs may share the
+// directory, which is itself only removed once empty.
+func cleanup(looseInputFiles, consolidatedOutputFiles, prefix string, keepOutput bool, log Logger) {
+	if looseInputFiles != "" {
+		err := os.RemoveAll(looseInputFiles)
+		if err != nil {
+			log.Warnf("Error when deleting extracted input files: %s", err.Error())
+		}
+	}
+
+	if keepOutput {
+		return
+	}
+	if prefix == "" {
+		err := os.RemoveAll(consolidatedOutputFiles)
+		if err != nil {
+			log.Warnf("Error when deleting consolidated output files: %s", err.Error())
+		}
+		return
+	}
+	entries, err := os.ReadDir(consolidatedOutputFiles)
+	if err != nil {
+		log.Warnf("Error when deleting consolidated output files: %s", err.Error())
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isOutputFile(e.Name(), prefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(consolidatedOutputFiles, e.Name())); err != nil {
+			log.Warnf("Error when deleting consolidated output files: %s", err.Error())
+		}
+	}
+	// Fails, leaving the directory in place, while other outputs remain within it.
+	os.Remove(consolidatedOutputFiles)
+}
+
+// Returns the CSV field delimiter d, or a comma if d is zero. Returns an error if d
+// can't separate fields, such as a quote or a line break.
+func csvDelimiter(d rune) (rune, error) {
+	if d == 0 {
+		return ',', nil
+	}
+	if d == '"' || d == '\r' || d == '\n' || d == utf8.RuneError || !utf8.ValidRune(d) {
+		return 0, fmt.Errorf("%q can't be used as a delimiter", d)
+	}
+	return d, nil
+}
+
+// Returns an error if prefix can't safely be added to the start of a file's name: it
+// may only hold ASCII letters, digits, '-', '_' and '.', and mustn't start with a '.'
+// so that the files aren't hidden, nor the prefix lead out of the directory.
+func checkFilePrefix(prefix string) error {
+	if strings.HasPrefix(prefix, ".") {
+		return fmt.Errorf("invalid file prefix %q: mustn't start with a '.'", prefix)
+	}
+	for _, r := range prefix {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid file prefix %q: may only hold letters, digits, '-', '_' and '.'", prefix)
+		}
+	}
+	return nil
+}
+
+// Adds the derived columns of each kind of GTFS file in more to derived, replacing any
+// of the same name.
+func mergeDerived(derived, more map[string]map[string]func(h Header, row []string) string) {
+	for kind, columns := range more {
+		if derived[kind] == nil {
+			derived[kind] = make(map[string]func(h Header, row []string) string)
+		}
+		for column, derive := range columns {
+			derived[kind][column] = derive
+		}
+	}
+}
@@ -0,0 +1,1088 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestConsolidate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{
+		"1": testFeed,
+		"2": withFiles(testFeed, map[string]string{
+			"stops.txt": testFeed["stops.txt"] + "E,Box Hill,-37.8190,145.1220,1\n",
+		}),
+	})
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.KeepIntermediate = true
+	if err := Consolidate(input, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records found in both feeds are only written once.
+	for kind, want := range map[string]int{"agency": 1, "routes": 2, "stops": 5, "trips": 3, "stop_times": 7, "shapes": 4} {
+		if got := len(readRows(t, filepath.Join(out, kind+".txt"))); got != want {
+			t.Errorf("%s.txt has %d rows, want %d", kind, got, want)
+		}
+	}
+
+	r, err := zip.OpenReader(out + ".zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, filepath.Base(f.Name))
+	}
+	sort.Strings(names)
+	for _, want := range []string{"stops.txt", "stop_times.txt", "trips.txt"} {
+		if i := sort.SearchStrings(names, want); i == len(names) || names[i] != want {
+			t.Errorf("archive is missing %s: %v", want, names)
+		}
+	}
+}
+
+func TestConsolidateRemovesIntermediateFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	if err := Consolidate(input, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out + ".zip"); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output directory left behind: %v", err)
+	}
+}
+
+func TestConsolidateMissingInput(t *testing.T) {
+	dir := t.TempDir()
+	if err := Consolidate(filepath.Join(dir, "missing.zip"), filepath.Join(dir, "out"), testOptions(dir)); err == nil {
+		t.Error("consolidating a missing zip didn't return an error")
+	}
+}
+
+func TestConsolidateCompositeKeys(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), map[string]string{
+		"stop_times.txt":     "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,A,1\nT1,08:05:00,08:05:00,B,2\n",
+		"shapes.txt":         "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\nSH1,-37.81,144.96,1\nSH1,-37.82,144.95,2\n",
+		"calendar_dates.txt": "service_id,date,exception_type\nS1,20240601,1\nS1,20240602,1\n",
+	})
+	writeFiles(t, filepath.Join(in, "2"), map[string]string{
+		"stop_times.txt":     "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:05:00,08:05:00,B,2\nT1,08:10:00,08:10:00,C,3\n",
+		"shapes.txt":         "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\nSH1,-37.82,144.95,2\n",
+		"calendar_dates.txt": "service_id,date,exception_type\nS1,20240602,1\n",
+	})
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Only = []string{"stop_times", "shapes", "calendar_dates"}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows sharing a trip_id, shape_id or service_id are distinct unless the rest of
+	// their key matches too.
+	for kind, want := range map[string]int{"stop_times": 3, "shapes": 2, "calendar_dates": 2} {
+		if got := len(readRows(t, filepath.Join(out, kind+".txt"))); got != want {
+			t.Errorf("%s.txt has %d rows, want %d", kind, got, want)
+		}
+	}
+}
+
+func TestConsolidateOverriddenKeys(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, map[string]string{
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,A,1\nT1,08:05:00,08:05:00,B,2\n",
+	})
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Only = []string{"stop_times"}
+	opts.Keys = map[string][]string{"stop_times": {"trip_id"}}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRows(t, filepath.Join(out, "stop_times.txt")); len(got) != 1 {
+		t.Errorf("got rows %q, want the first stop time of T1 alone", got)
+	}
+}
+
+func TestConsolidateQuotedCommas(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), testFeed)
+	// The second feed orders its routes' columns differently, with an empty
+	// route_long_name between quoted fields and the colours.
+	writeFiles(t, filepath.Join(in, "2"), map[string]string{
+		"routes.txt": "route_color,route_long_name,route_id,route_short_name,route_type,route_text_color\n" +
+			"00FF00,,R3,\"Stop 12, Smith St\",3,000000\n",
+		"stops.txt": "stop_name,stop_id,stop_lat,stop_lon\n\"Smith St, Stop 12\",E,-37.80,144.98\n",
+	})
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := LoadFeed(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stops := make(map[string]Stop)
+	for _, s := range feed.Stops {
+		stops[s.ID] = s
+	}
+	if got := stops["A"].Name; got != "Flinders St, Stop 1" {
+		t.Errorf("got stop A named %q", got)
+	}
+	if got := stops["E"]; got.Name != "Smith St, Stop 12" || got.Lat != -37.80 || got.Lon != 144.98 {
+		t.Errorf("got stop E %+v", got)
+	}
+
+	routes := make(map[string]Route)
+	for _, r := range feed.Routes {
+		routes[r.ID] = r
+	}
+	if got := routes["R1"]; got.LongName != "Sandringham, City" || got.Type != 2 || got.Color != "FF0000" {
+		t.Errorf("got route R1 %+v", got)
+	}
+	want := Route{ID: "R3", ShortName: "Stop 12, Smith St", Type: 3, Color: "00FF00", TextColor: "000000"}
+	if got := routes["R3"]; got != want {
+		t.Errorf("got route R3 %+v, want %+v", got, want)
+	}
+}
+
+func TestConsolidateDryRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed, "2": testFeed})
+
+	var report bytes.Buffer
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.DryRun = true
+	opts.Report = &report
+	if err := Consolidate(input, filepath.Join(dir, "out"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the output nor the extracted input are left behind.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		t.Errorf("got files %v, want gtfs.zip alone", names)
+	}
+
+	// Every row of the second feed duplicates one of the first.
+	fields := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(report.String()), "\n") {
+		f := strings.Fields(line)
+		fields[f[0]] = f[1:]
+	}
+	if got, want := fields["stop_times.txt"], []string{"7", "7", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stop_times.txt counts %v, want %v\n%s", got, want, report.String())
+	}
+	if got, want := fields["stops.txt"], []string{"4", "4", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stops.txt counts %v, want %v\n%s", got, want, report.String())
+	}
+}
+
+func TestConsolidateWriteError(t *testing.T) {
+	for _, continueOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("continue=%t", continueOnError), func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			writeFiles(t, in, testFeed)
+			// A directory in the way of stops.txt means it can't be created.
+			out := filepath.Join(dir, "out")
+			if err := os.MkdirAll(filepath.Join(out, "stops.txt"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := testOptions(dir)
+			opts.ContinueOnWriteError = continueOnError
+			if err := Consolidate(in, out, opts); err == nil || !strings.Contains(err.Error(), "stops.txt") {
+				t.Fatalf("got error %v, want one writing stops.txt", err)
+			}
+			// Carrying on writes the other files in full.
+			if continueOnError {
+				if got := len(readRows(t, filepath.Join(out, "trips.txt"))); got != 3 {
+					t.Errorf("trips.txt has %d rows, want 3", got)
+				}
+			}
+		})
+	}
+}
+
+func TestConsolidateWarnsOfMissingOptionalFiles(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"shapes.txt": ""}))
+
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged.String(), "WARN Optional files missing from the feed: shapes.txt") {
+		t.Errorf("didn't warn that shapes.txt is missing:\n%s", logged.String())
+	}
+}
+
+func TestConsolidateMissingRequiredFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"stops.txt": ""}))
+
+	err := Consolidate(in, filepath.Join(dir, "out"), testOptions(dir))
+	if !errors.Is(err, ErrMissingFile) || !strings.Contains(err.Error(), "stops.txt") {
+		t.Errorf("got error %v, want ErrMissingFile naming stops.txt", err)
+	}
+}
+
+func TestConsolidateKeysIncludingParentStation(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	// Both feeds list stop A, but beneath different stations.
+	writeFiles(t, filepath.Join(in, "1"), map[string]string{"stops.txt": "stop_id,stop_name,parent_station\nA,Platform 1,P1\n"})
+	writeFiles(t, filepath.Join(in, "2"), map[string]string{"stops.txt": "stop_id,stop_name,parent_station\nA,Platform 1,P2\nA,Platform 1,P1\n"})
+
+	for _, tt := range []struct {
+		key  []string
+		want int
+	}{{nil, 1}, {[]string{"stop_id", "parent_station"}, 2}} {
+		out := filepath.Join(dir, fmt.Sprint("out", len(tt.key)))
+		opts := testOptions(dir)
+		opts.Only = []string{"stops"}
+		if tt.key != nil {
+			opts.Keys = map[string][]string{"stops": tt.key}
+		}
+		if err := Consolidate(in, out, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := readRows(t, filepath.Join(out, "stops.txt")); len(got) != tt.want {
+			t.Errorf("keyed by %v, got rows %q, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestConsolidateKeepIntermediate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	var logged bytes.Buffer
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.KeepIntermediate = true
+	opts.Logger = NewLogger(&logged, LevelInfo)
+	if err := Consolidate(input, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	extracted := filepath.Join(dir, looseInputDirName)
+	for _, path := range []string{filepath.Join(extracted, "1", "google_transit", "stops.txt"), filepath.Join(out, "stops.txt")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, want := range []string{"Keeping extracted input files in " + extracted, "Keeping consolidated output files in " + out} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("didn't log %q:\n%s", want, logged.String())
+		}
+	}
+}
+
+func TestConsolidateLeavesIntermediateFilesOnError(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.MinRows = map[string]int{"stops": 100}
+	if err := Consolidate(input, out, opts); err == nil {
+		t.Fatal("Consolidate didn't return an error")
+	}
+	// What was extracted and written is left to be inspected.
+	for _, path := range []string{filepath.Join(dir, looseInputDirName, "1", "google_transit", "stops.txt"), filepath.Join(out, "stops.txt")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestConsolidateSortedIsReproducible(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	for i := 0; i < 20; i++ {
+		// Every feed holds a different version of stop A, and stops of its own.
+		writeFiles(t, filepath.Join(in, fmt.Sprintf("%02d", i)), withFiles(testFeed, map[string]string{
+			"stops.txt": fmt.Sprintf("stop_id,stop_name,stop_lat,stop_lon\nA,Version %d,-37.8183,144.9671\n%d,Stop %d,-37.8,144.9\n", i, i, i),
+		}))
+	}
+
+	consolidate := func(out string) map[string][]byte {
+		opts := testOptions(dir)
+		opts.SkipArchive = false
+		opts.Sort = true
+		opts.Concurrency = 8
+		if err := Consolidate(in, out, opts); err != nil {
+			t.Fatal(err)
+		}
+		r, err := zip.OpenReader(out + ".zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		files := make(map[string][]byte)
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[filepath.Base(f.Name)] = b
+		}
+		return files
+	}
+	first, second := consolidate(filepath.Join(dir, "first")), consolidate(filepath.Join(dir, "second"))
+	if len(first) == 0 || !reflect.DeepEqual(first, second) {
+		t.Errorf("consolidating twice gave different archives")
+	}
+
+	// Rows are in order of their keys, numbers compared as such, and of the versions of
+	// stop A the one kept is from the feed sorting first.
+	stops := strings.Split(strings.TrimSpace(string(first["stops.txt"])), "\n")[1:]
+	if stops[0] != "0,Stop 0,-37.8,144.9" || stops[2] != "2,Stop 2,-37.8,144.9" || stops[19] != "19,Stop 19,-37.8,144.9" || stops[20] != "A,Version 0,-37.8183,144.9671" {
+		t.Errorf("got stops %q", stops)
+	}
+	stopTimes := strings.Split(strings.TrimSpace(string(first["stop_times.txt"])), "\n")[1:]
+	for i := 1; i < len(stopTimes); i++ {
+		if stopTimes[i-1] > stopTimes[i] {
+			t.Errorf("stop times out of order: %q", stopTimes)
+			break
+		}
+	}
+}
+
+func TestConsolidateExtractedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// Laid out as extracting a PTV zip would leave it, inner zips and all.
+	in := filepath.Join(dir, "extracted")
+	writeFiles(t, filepath.Join(in, "1", "google_transit"), testFeed)
+	writeFiles(t, filepath.Join(in, "2", "google_transit"), withFiles(testFeed, map[string]string{
+		"stops.txt": testFeed["stops.txt"] + "E,Box Hill,-37.8190,145.1220,1\n",
+	}))
+	if err := ioutil.WriteFile(filepath.Join(in, "1", innerZipFileName), []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readRows(t, filepath.Join(out, "stops.txt"))); got != 5 {
+		t.Errorf("stops.txt has %d rows, want 5", got)
+	}
+	// Nothing is extracted, and the input directory is left as it was.
+	if _, err := os.Stat(filepath.Join(dir, looseInputDirName)); !os.IsNotExist(err) {
+		t.Errorf("input was extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(in, "1", "google_transit", "stops.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConsolidateMinRows(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	// The fixture has exactly 7 stop times and 4 stops.
+	opts := testOptions(dir)
+	opts.MinRows = map[string]int{"stop_times": 7, "stops": 4}
+	if err := Consolidate(in, filepath.Join(dir, "exact"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.MinRows = map[string]int{"stop_times": 8, "stops": 4}
+	err := Consolidate(in, filepath.Join(dir, "short"), opts)
+	if !errors.Is(err, ErrTooFewRows) || !strings.Contains(err.Error(), "stop_times.txt has 7 of 8") || strings.Contains(err.Error(), "stops.txt") {
+		t.Errorf("got error %v, want ErrTooFewRows for stop_times.txt alone", err)
+	}
+}
+
+func TestConsolidateFeeds(t *testing.T) {
+	dir := t.TempDir()
+	metro, regional := filepath.Join(dir, "metro.zip"), filepath.Join(dir, "regional.zip")
+	writePTVZip(t, metro, map[string]map[string]string{"2": testFeed})
+	// Both zips hold their feeds in a directory 2, and share the agency and stop B.
+	writePTVZip(t, regional, map[string]map[string]string{"2": {
+		"agency.txt": testFeed["agency.txt"],
+		"stops.txt":  "stop_id,stop_name,stop_lat,stop_lon\nB,Southern Cross,-37.8184,144.9525\nG,Geelong,-38.1445,144.3553\n",
+	}})
+
+	out := filepath.Join(dir, "out")
+	if err := ConsolidateFeeds(context.Background(), []string{metro, regional}, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	for kind, want := range map[string]int{"agency": 1, "stops": 5, "trips": 3} {
+		if got := len(readRows(t, filepath.Join(out, kind+".txt"))); got != want {
+			t.Errorf("%s.txt has %d rows, want %d", kind, got, want)
+		}
+	}
+}
+
+func TestConsolidateFeedsDirectoryAmongZips(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "metro.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+	if err := ConsolidateFeeds(context.Background(), []string{input, dir}, filepath.Join(dir, "out"), testOptions(dir)); err == nil {
+		t.Error("consolidating a directory along with a zip didn't return an error")
+	}
+	if err := ConsolidateFeeds(context.Background(), nil, filepath.Join(dir, "out"), testOptions(dir)); err == nil {
+		t.Error("consolidating no inputs didn't return an error")
+	}
+}
+
+func TestConsolidateArchivesFilesAtRoot(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	for _, prefix := range []string{"", "metro_"} {
+		t.Run(fmt.Sprintf("prefix=%q", prefix), func(t *testing.T) {
+			out := filepath.Join(dir, "gtfs_out")
+			opts := testOptions(dir)
+			opts.SkipArchive = false
+			opts.FilePrefix = prefix
+			if err := Consolidate(in, out, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := zip.OpenReader(filepath.Join(dir, prefix+"gtfs_out.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			names := make(map[string]bool)
+			for _, f := range r.File {
+				if strings.Contains(f.Name, "/") {
+					t.Errorf("archive has an entry %q beneath a directory", f.Name)
+				}
+				names[f.Name] = true
+			}
+			for _, kind := range []string{"agency", "stops", "stop_times", "trips"} {
+				if want := prefix + kind + ".txt"; !names[want] {
+					t.Errorf("archive is missing %s: %v", want, names)
+				}
+			}
+		})
+	}
+}
+
+func TestConsolidateSharedOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	metro := filepath.Join(dir, "metro")
+	writeFiles(t, metro, testFeed)
+	regional := filepath.Join(dir, "regional")
+	writeFiles(t, regional, withFiles(testFeed, map[string]string{
+		"stops.txt": testFeed["stops.txt"] + "E,Geelong,-38.1445,144.3553,1\n",
+	}))
+
+	// Both outputs are written to the same directory, each with its own prefix. Only
+	// the second is archived.
+	out := filepath.Join(dir, "gtfs_out")
+	opts := testOptions(dir)
+	opts.FilePrefix = "metro_"
+	if err := Consolidate(metro, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts = testOptions(dir)
+	opts.SkipArchive = false
+	opts.FilePrefix = "regional_"
+	if err := Consolidate(regional, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archiving the second output leaves the files of the first in place.
+	if got := len(readRows(t, filepath.Join(out, "metro_stops.txt"))); got != 4 {
+		t.Errorf("metro_stops.txt has %d rows, want 4", got)
+	}
+	m := readManifest(t, filepath.Join(out, "metro_"+ManifestFileName))
+	for _, f := range m.Files {
+		if !strings.HasPrefix(f.Name, "metro_") {
+			t.Errorf("metro_ manifest lists %s", f.Name)
+		}
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "regional_") {
+			t.Errorf("left %s behind once archived", e.Name())
+		}
+	}
+
+	r, err := zip.OpenReader(filepath.Join(dir, "regional_gtfs_out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	names := make(map[string]bool)
+	for _, f := range r.File {
+		names[f.Name] = true
+		if !strings.HasPrefix(f.Name, "regional_") {
+			t.Errorf("regional_gtfs_out.zip holds %s", f.Name)
+		}
+	}
+	if !names["regional_stops.txt"] || !names["regional_"+ManifestFileName] {
+		t.Errorf("regional_gtfs_out.zip holds %v, want its stops and manifest", names)
+	}
+}
+
+func TestConsolidateSharedOutputDirOverlappingPrefixes(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	// One prefix starts with the other, and only the shorter is archived.
+	out := filepath.Join(dir, "gtfs_out")
+	opts := testOptions(dir)
+	opts.FilePrefix = "metro_regional_"
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts = testOptions(dir)
+	opts.SkipArchive = false
+	opts.FilePrefix = "metro"
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(readRows(t, filepath.Join(out, "metro_regional_stops.txt"))); got != 4 {
+		t.Errorf("metro_regional_stops.txt has %d rows, want 4", got)
+	}
+	if _, err := os.Stat(filepath.Join(out, "metro_regional_"+ManifestFileName)); err != nil {
+		t.Errorf("removed the other output's manifest: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "metrostops.txt")); !os.IsNotExist(err) {
+		t.Errorf("left metrostops.txt behind once archived: %v", err)
+	}
+	r, err := zip.OpenReader(filepath.Join(dir, "metrogtfs_out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if strings.HasPrefix(f.Name, "metro_") {
+			t.Errorf("metrogtfs_out.zip holds the other output's %s", f.Name)
+		}
+	}
+	if len(r.File) == 0 {
+		t.Error("metrogtfs_out.zip is empty")
+	}
+}
+
+func TestIsOutputFile(t *testing.T) {
+	for _, tt := range []struct {
+		name, prefix string
+		want         bool
+	}{
+		{"stops.txt", "", true},
+		{"stop_times.txt.gz", "", true},
+		{"trips.parquet", "", true},
+		{ManifestFileName, "", true},
+		{"schedule.csv", "", true},
+		{"metro_stops.txt", "", false},
+		{"metro_stops.txt", "metro_", true},
+		{"metro_" + ManifestFileName, "metro_", true},
+		{"metro_regional_stops.txt", "metro_", false},
+		{"metro_regional_stops.txt", "metro", false},
+		{"metrostops.ndjson", "metro", true},
+		{"stops.txt", "metro_", false},
+		{"notes.txt", "", false},
+	} {
+		if got := isOutputFile(tt.name, tt.prefix); got != tt.want {
+			t.Errorf("isOutputFile(%q, %q) = %t, want %t", tt.name, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestConsolidateInvalidFilePrefix(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	for _, prefix := range []string{".hidden_", "a/b_", "../up_", "metro os_", "métro_"} {
+		opts := testOptions(dir)
+		opts.FilePrefix = prefix
+		out := filepath.Join(dir, "out")
+		if err := Consolidate(in, out, opts); err == nil || !strings.Contains(err.Error(), "invalid file prefix") {
+			t.Errorf("got error %v for the prefix %q, want it rejected", err, prefix)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("wrote output with the prefix %q", prefix)
+		}
+	}
+	for _, prefix := range []string{"", "metro_", "v1.2-Regional"} {
+		if err := checkFilePrefix(prefix); err != nil {
+			t.Errorf("checkFilePrefix(%q) = %v", prefix, err)
+		}
+	}
+}
+
+func TestOutputSibling(t *testing.T) {
+	for _, tt := range []struct {
+		path, prefix, ext, want string
+	}{
+		{filepath.Join("data", "gtfs_out"), "", ".zip", filepath.Join("data", "gtfs_out.zip")},
+		{filepath.Join("data", "gtfs_out"), "metro_", ".zip", filepath.Join("data", "metro_gtfs_out.zip")},
+		{"gtfs_out", "metro_", ".db", "metro_gtfs_out.db"},
+	} {
+		if got := outputSibling(tt.path, tt.prefix, tt.ext); got != tt.want {
+			t.Errorf("outputSibling(%q, %q, %q) = %q, want %q", tt.path, tt.prefix, tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestConsolidateReportsConflictingCalendars(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), testFeed)
+	// The second feed runs S1 on weekends rather than weekdays, but repeats the rest.
+	writeFiles(t, filepath.Join(in, "2"), withFiles(testFeed, map[string]string{
+		"calendar.txt": strings.Replace(testFeed["calendar.txt"], "S1,1,1,1,1,1,0,0", "S1,0,0,0,0,0,1,1", 1),
+	}))
+
+	for _, sorted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sorted=%t", sorted), func(t *testing.T) {
+			var logged bytes.Buffer
+			opts := testOptions(dir)
+			opts.Logger = NewLogger(&logged, LevelWarn)
+			opts.Sort = sorted
+			out := filepath.Join(dir, fmt.Sprint("out", sorted))
+			if err := Consolidate(in, out, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range []string{
+				"calendar.txt has 1 conflicting definitions, keeping the first of each",
+				"calendar.txt service_id S1 in ",
+			} {
+				if !strings.Contains(logged.String(), want) {
+					t.Errorf("didn't warn %q:\n%s", want, logged.String())
+				}
+			}
+			// Identical definitions, such as of the agency, aren't conflicts.
+			if strings.Contains(logged.String(), "agency.txt") {
+				t.Errorf("warned of identical agencies:\n%s", logged.String())
+			}
+			if got := len(readRows(t, filepath.Join(out, "calendar.txt"))); got != 2 {
+				t.Errorf("calendar.txt has %d rows, want 2", got)
+			}
+			if sorted {
+				// The definition of the feed whose path sorts first is kept.
+				if got := readRows(t, filepath.Join(out, "calendar.txt"))[0]; !strings.HasPrefix(got, "S1,1,1,1,1,1,0,0") {
+					t.Errorf("kept %q, want the first feed's S1", got)
+				}
+			}
+		})
+	}
+
+	opts := testOptions(dir)
+	opts.Strict = true
+	if err := Consolidate(in, filepath.Join(dir, "strict"), opts); !errors.Is(err, ErrStrict) {
+		t.Errorf("got error %v in strict mode, want ErrStrict", err)
+	}
+
+	var report bytes.Buffer
+	opts = testOptions(dir)
+	opts.DryRun = true
+	opts.Report = &report
+	if err := Consolidate(in, filepath.Join(dir, "dry"), opts); err != nil {
+		t.Fatal(err)
+	}
+	var conflicts string
+	for _, line := range strings.Split(report.String(), "\n") {
+		if f := strings.Fields(line); len(f) == 4 && f[0] == "calendar.txt" {
+			conflicts = f[3]
+		}
+	}
+	if conflicts != "1" {
+		t.Errorf("reported %q conflicting calendars, want 1:\n%s", conflicts, report.String())
+	}
+}
+
+// Counts the source files opened, by name, until the end of the test.
+func countOpenedFiles(t *testing.T) map[string]int {
+	t.Helper()
+	var mu sync.Mutex
+	opened := make(map[string]int)
+	t.Cleanup(func() { openFile = os.Open })
+	openFile = func(path string) (*os.File, error) {
+		mu.Lock()
+		opened[filepath.Base(path)]++
+		mu.Unlock()
+		return os.Open(path)
+	}
+	return opened
+}
+
+func TestConsolidateOnly(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+	opened := countOpenedFiles(t)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Only = []string{"stops", "routes"}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if opened["stop_times.txt"] != 0 || opened["trips.txt"] != 0 {
+		t.Errorf("opened stop_times.txt %d times and trips.txt %d times, want neither", opened["stop_times.txt"], opened["trips.txt"])
+	}
+	if opened["stops.txt"] == 0 || opened["routes.txt"] == 0 {
+		t.Errorf("got files opened %v, want stops.txt and routes.txt", opened)
+	}
+	infos, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	for _, info := range infos {
+		if info.Name() != ManifestFileName {
+			written = append(written, info.Name())
+		}
+	}
+	if want := []string{"routes.txt", "stops.txt"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("wrote %v, want %v", written, want)
+	}
+	if got := len(readRows(t, filepath.Join(out, "stops.txt"))); got != 4 {
+		t.Errorf("stops.txt has %d rows, want 4", got)
+	}
+}
+
+func TestConsolidateOnlyDoesntRequireOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, map[string]string{"stops.txt": testFeed["stops.txt"]})
+
+	opts := testOptions(dir)
+	opts.Only = []string{"stops"}
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Errorf("got %v consolidating stops.txt alone", err)
+	}
+	opts.Only = []string{"stops", "trips"}
+	if err := Consolidate(in, filepath.Join(dir, "missing"), opts); !errors.Is(err, ErrMissingFile) {
+		t.Errorf("got error %v, want ErrMissingFile for trips.txt", err)
+	}
+	opts.Only = []string{"stations"}
+	if err := Consolidate(in, filepath.Join(dir, "unknown"), opts); err == nil || !strings.Contains(err.Error(), "stations") {
+		t.Errorf("got error %v, want one naming the unknown kind", err)
+	}
+}
+
+func TestConsolidateExclude(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	want := filepath.Join(dir, "want")
+	if err := Consolidate(in, want, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	wantFiles, _ := outputRows(t, want)
+
+	opened := countOpenedFiles(t)
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Exclude = []string{"shapes"}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if opened["shapes.txt"] != 0 {
+		t.Errorf("opened shapes.txt %d times, want none", opened["shapes.txt"])
+	}
+	gotFiles, counts := outputRows(t, out)
+	if _, ok := gotFiles["shapes.txt"]; ok || counts["shapes.txt"] != 0 {
+		t.Errorf("wrote shapes.txt: %q", gotFiles["shapes.txt"])
+	}
+	// Every other file is written in full.
+	delete(wantFiles, "shapes.txt")
+	if !reflect.DeepEqual(gotFiles, wantFiles) {
+		t.Errorf("got files %q, want %q", gotFiles, wantFiles)
+	}
+}
+
+func TestConsolidateExcludeStillFilters(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	// Leaving trips.txt out of the output, the stop times kept still follow the trips
+	// of the route kept.
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Exclude = []string{"trips"}
+	opts.Filter = Filter{ExcludeRouteIDs: []string{"R1"}}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "trips.txt")); !os.IsNotExist(err) {
+		t.Errorf("wrote trips.txt: %v", err)
+	}
+	rows := readRows(t, filepath.Join(out, "stop_times.txt"))
+	if len(rows) != 2 {
+		t.Errorf("got stop times %q, want the 2 of T2", rows)
+	}
+	for _, row := range rows {
+		if !strings.HasPrefix(row, "T2,") {
+			t.Errorf("kept the stop time %q of a trip on another route", row)
+		}
+	}
+}
+
+func TestConsolidatedKinds(t *testing.T) {
+	tests := []struct {
+		only, exclude []string
+		want          []string
+	}{
+		{nil, nil, validGTFSFileNames},
+		{[]string{"stops", "routes"}, nil, []string{"routes", "stops"}},
+		{nil, []string{"shapes", "frequencies", "transfers"}, []string{"agency", "calendar_dates", "calendar", "routes", "stop_times", "stops", "trips"}},
+		{[]string{"stops", "routes"}, []string{"routes"}, []string{"stops"}},
+	}
+	for _, tt := range tests {
+		got, err := consolidatedKinds(tt.only, tt.exclude)
+		if err != nil {
+			t.Errorf("consolidatedKinds(%v, %v): %v", tt.only, tt.exclude, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("consolidatedKinds(%v, %v) = %v, want %v", tt.only, tt.exclude, got, tt.want)
+		}
+	}
+	if _, err := consolidatedKinds(nil, []string{"stations"}); err == nil || !strings.Contains(err.Error(), "stations") {
+		t.Errorf("got error %v, want one naming the unknown kind", err)
+	}
+}
+
+// Returns files rewritten with their fields separated by comma.
+func delimitedFiles(t *testing.T, files map[string]string, comma rune) map[string]string {
+	t.Helper()
+	delimited := make(map[string]string, len(files))
+	for name, contents := range files {
+		r := csv.NewReader(strings.NewReader(contents))
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Comma = comma
+		if err := w.WriteAll(records); err != nil {
+			t.Fatal(err)
+		}
+		delimited[name] = buf.String()
+	}
+	return delimited
+}
+
+func TestConsolidateDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	want := filepath.Join(dir, "want")
+	writeFiles(t, filepath.Join(dir, "commas"), testFeed)
+	if err := Consolidate(filepath.Join(dir, "commas"), want, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	in := filepath.Join(dir, "in")
+	files := delimitedFiles(t, testFeed, ';')
+	if !strings.Contains(files["stops.txt"], "A;Flinders St, Stop 1;") {
+		t.Fatalf("the fixture isn't separated by semicolons: %q", files["stops.txt"])
+	}
+	writeFiles(t, in, files)
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Delimiter = ';'
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The output is separated by commas, just as if the source had been.
+	for _, name := range []string{"stops.txt", "stop_times.txt", "trips.txt", "calendar.txt"} {
+		if got, want := readFile(t, filepath.Join(out, name)), readFile(t, filepath.Join(want, name)); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestConsolidateOutputDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.OutputDelimiter = '\t'
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(strings.NewReader(readFile(t, filepath.Join(out, "stops.txt"))))
+	r.Comma = '\t'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || records[1][0] != "A" || records[1][1] != "Flinders St, Stop 1" {
+		t.Errorf("got stops %q, want them separated by tabs", records)
+	}
+}
+
+func TestConsolidateInvalidDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+	for _, d := range []rune{'"', '\n', '\r', utf8.RuneError} {
+		opts := testOptions(dir)
+		opts.Delimiter = d
+		if err := Consolidate(in, filepath.Join(dir, "out"), opts); err == nil {
+			t.Errorf("consolidated with the delimiter %q", d)
+		}
+		opts = testOptions(dir)
+		opts.OutputDelimiter = d
+		if err := Consolidate(in, filepath.Join(dir, "out"), opts); err == nil {
+			t.Errorf("consolidated with the output delimiter %q", d)
+		}
+	}
+}
+
+// completeFeed is testFeed along with the optional files it lacks, so that
+// consolidating it raises no warnings.
+var completeFeed = withFiles(testFeed, map[string]string{
+	"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n",
+	"transfers.txt":   "from_stop_id,to_stop_id,transfer_type,min_transfer_time\n",
+})
+
+func TestConsolidateStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		ok    bool
+	}{
+		{"complete", completeFeed, true},
+		{"missing optional files", testFeed, false},
+		// Only found by validating the consolidated feed.
+		{"bad coordinates", withFiles(completeFeed, map[string]string{
+			"stops.txt": testFeed["stops.txt"] + "Z,Null Island,0,145.0,0\n",
+		}), false},
+		{"dangling reference", withFiles(completeFeed, map[string]string{
+			"stop_times.txt": testFeed["stop_times.txt"] + "T1,08:20:00,08:20:00,X,4,,0,0,3000\n",
+		}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			writeFiles(t, in, tt.files)
+
+			// Without Strict the problems are only warned about.
+			if err := Consolidate(in, filepath.Join(dir, "lenient"), testOptions(dir)); err != nil {
+				t.Fatalf("got %v without Strict", err)
+			}
+
+			out := filepath.Join(dir, "strict")
+			opts := testOptions(dir)
+			opts.Strict = true
+			err := Consolidate(in, out, opts)
+			if tt.ok && err != nil {
+				t.Errorf("got %v, want no problems", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrStrict) {
+				t.Errorf("got error %v, want ErrStrict", err)
+			}
+			// The output is written in full either way.
+			if got := len(readRows(t, filepath.Join(out, "trips.txt"))); got != 3 {
+				t.Errorf("trips.txt has %d rows, want 3", got)
+			}
+		})
+	}
+}
+
+func TestConsolidateStrictLongTrip(t *testing.T) {
+	stopTimes := longTripStopTimes(5000)
+	lines := strings.Split(strings.TrimSpace(stopTimes), "\n")
+	reversed := []string{lines[0]}
+	for i := len(lines) - 1; i > 0; i-- {
+		reversed = append(reversed, lines[i])
+	}
+
+	for name, rows := range map[string]string{
+		"in order": stopTimes,
+		// Valid, as stop times needn't be sorted.
+		"reversed": strings.Join(reversed, "\n") + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			writeFiles(t, in, withFiles(completeFeed, map[string]string{"stop_times.txt": rows}))
+
+			for run := 0; run < 5; run++ {
+				opts := testOptions(dir)
+				opts.Strict = true
+				opts.Concurrency = 8
+				if err := Consolidate(in, filepath.Join(dir, fmt.Sprint("out", run)), opts); err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+			}
+		})
+	}
+}
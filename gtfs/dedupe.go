@@ -0,0 +1,56 @@
+package gtfs
+
+import (
+	"fmt"
+	"math"
+)
+
+// DedupeStopsByLocation finds stops listed more than once under different ids at the
+// same location, once their coordinates are rounded to precision decimal places. The
+// returned map takes the id of each duplicate stop to the id of the stop it duplicates,
+// which is the first such stop in stops. Stops which aren't duplicates don't appear in
+// the map, nor do stops with missing or zero coordinates.
+//
+// The map may be passed to Feed.MergeStops to rewrite the feed in terms of the
+// canonical stops.
+func DedupeStopsByLocation(stops []Stop, precision int) map[string]string {
+	scale := math.Pow(10, float64(precision))
+	canonical := make(map[string]string)
+	merged := make(map[string]string)
+
+	for _, s := range stops {
+		if s.Lat == 0 && s.Lon == 0 {
+			continue
+		}
+
+		location := fmt.Sprintf("%v,%v", math.Round(s.Lat*scale)/scale, math.Round(s.Lon*scale)/scale)
+		id, ok := canonical[location]
+		if !ok {
+			canonical[location] = s.ID
+			continue
+		}
+		if id != s.ID {
+			merged[s.ID] = id
+		}
+	}
+	return merged
+}
+
+// MergeStops replaces each stop in the feed which appears as a key of merged with the
+// stop it maps to, as returned by DedupeStopsByLocation. The merged stops are removed
+// and every stop time calling at one of them is rewritten to call at its replacement.
+func (f *Feed) MergeStops(merged map[string]string) {
+	stops := f.Stops[:0]
+	for _, s := range f.Stops {
+		if _, ok := merged[s.ID]; !ok {
+			stops = append(stops, s)
+		}
+	}
+	f.Stops = stops
+
+	for i, st := range f.StopTimes {
+		if id, ok := merged[st.StopID]; ok {
+			f.StopTimes[i].StopID = id
+		}
+	}
+}
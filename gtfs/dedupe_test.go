@@ -0,0 +1,44 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupeStopsByLocation(t *testing.T) {
+	stops := []Stop{
+		{ID: "A", Lat: -37.81831, Lon: 144.96712},
+		{ID: "B", Lat: -37.8184, Lon: 144.9525},
+		// A2 lies within a metre or so of A, so shares its location at 4 decimal places.
+		{ID: "A2", Lat: -37.81829, Lon: 144.96709},
+		{ID: "A", Lat: -37.81831, Lon: 144.96712},
+		// Stops without coordinates aren't all at 0,0.
+		{ID: "X"},
+		{ID: "Y"},
+	}
+
+	if got, want := DedupeStopsByLocation(stops, 4), map[string]string{"A2": "A"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("at 4 places, got %v, want %v", got, want)
+	}
+	if got := DedupeStopsByLocation(stops, 5); len(got) != 0 {
+		t.Errorf("at 5 places, got %v, want no duplicates", got)
+	}
+}
+
+func TestMergeStops(t *testing.T) {
+	feed := &Feed{
+		Stops: []Stop{{ID: "A"}, {ID: "A2"}, {ID: "B"}},
+		StopTimes: []StopTime{
+			{TripID: "T1", StopID: "A2", StopSequence: 1},
+			{TripID: "T1", StopID: "B", StopSequence: 2},
+		},
+	}
+	feed.MergeStops(map[string]string{"A2": "A"})
+
+	if want := []Stop{{ID: "A"}, {ID: "B"}}; !reflect.DeepEqual(feed.Stops, want) {
+		t.Errorf("got stops %+v, want %+v", feed.Stops, want)
+	}
+	if feed.StopTimes[0].StopID != "A" || feed.StopTimes[1].StopID != "B" {
+		t.Errorf("got stop times %+v, want them to call at A then B", feed.StopTimes)
+	}
+}
@@ -0,0 +1,140 @@
+package gtfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DiffReport describes how the GTFS feed in one directory differs from another, one
+// FileDiff per kind of GTFS file in the order of validGTFSFileNames. Errors holds any
+// failures to read either feed, whose files are left out of the report, along with a
+// DuplicateKeyError for each record sharing its key with an earlier record of the same
+// file. Only the first of those records is compared.
+type DiffReport struct {
+	Files  []FileDiff
+	Errors []error
+}
+
+// FileDiff lists the records of a single kind of GTFS file which were added, removed
+// or changed between two feeds. Records are identified by their DefaultKeys columns,
+// given as the values of those columns joined by commas, such as "T1,3" for a stop
+// time. Each list is sorted.
+type FileDiff struct {
+	File    string
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty returns whether the file is the same in both feeds.
+func (d FileDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffRow is a record read from one side of a diff, along with the header of its file.
+type diffRow struct {
+	h   Header
+	row []string
+}
+
+// Diff compares the GTFS feeds in oldDir and newDir, such as the outputs of two runs of
+// Consolidate, and reports the records added, removed and changed in newDir. A record
+// has changed when any of its values differ, comparing columns by name. Files which
+// don't exist are treated as being empty.
+func Diff(oldDir, newDir string) DiffReport {
+	var report DiffReport
+	for _, kind := range validGTFSFileNames {
+		name := fmt.Sprintf("%s.txt", kind)
+		key := DefaultKeys[kind]
+
+		oldRows, duplicates, err := readDiffRows(filepath.Join(oldDir, name), key)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+			continue
+		}
+		newRows, newDuplicates, err := readDiffRows(filepath.Join(newDir, name), key)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+			continue
+		}
+		report.Errors = append(append(report.Errors, duplicates...), newDuplicates...)
+
+		var added, removed, changed []string
+		for k, n := range newRows {
+			o, ok := oldRows[k]
+			if !ok {
+				added = append(added, k)
+			} else if !sameRow(o, n) {
+				changed = append(changed, k)
+			}
+		}
+		for k := range oldRows {
+			if _, ok := newRows[k]; !ok {
+				removed = append(removed, k)
+			}
+		}
+		report.Files = append(report.Files, FileDiff{
+			File:    name,
+			Added:   displayKeys(added),
+			Removed: displayKeys(removed),
+			Changed: displayKeys(changed),
+		})
+	}
+	return report
+}
+
+// Reads the records of the CSV file at path, keyed by the values of the key columns
+// joined by keySeparator, so that values holding commas can't make two keys collide.
+// Returns a DuplicateKeyError for each record sharing its key with an earlier one,
+// which is left out of the records. A file which doesn't exist has no records.
+func readDiffRows(path string, key []string) (map[string]diffRow, []error, error) {
+	rows := make(map[string]diffRow)
+	var duplicates []error
+	line := 1
+	err := readCSVFile(path, func(h Header, row []string) error {
+		line++
+		values := make([]string, len(key))
+		for i, column := range key {
+			values[i] = h.value(row, column)
+		}
+		k := strings.Join(values, keySeparator)
+		if _, ok := rows[k]; ok {
+			duplicates = append(duplicates, DuplicateKeyError{File: path, Row: line, Key: describeKey(key, values)})
+			return nil
+		}
+		rows[k] = diffRow{h: h, row: row}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return rows, duplicates, nil
+}
+
+// Returns keys sorted, with the values of each joined by commas rather than
+// keySeparator for display.
+func displayKeys(keys []string) []string {
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = strings.Replace(k, keySeparator, ",", -1)
+	}
+	return keys
+}
+
+// Returns whether two records hold the same value for every column of either.
+func sameRow(a, b diffRow) bool {
+	for column := range a.h {
+		if a.h.value(a.row, column) != b.h.value(b.row, column) {
+			return false
+		}
+	}
+	for column := range b.h {
+		if a.h.value(a.row, column) != b.h.value(b.row, column) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package gtfs
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Returns the diff of the named file in report.
+func fileDiff(t *testing.T, report DiffReport, file string) FileDiff {
+	t.Helper()
+	for _, d := range report.Files {
+		if d.File == file {
+			return d
+		}
+	}
+	t.Fatalf("report has no diff of %s", file)
+	return FileDiff{}
+}
+
+func TestDiffKeysHoldingCommas(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, newDir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	// Joined by commas, both keys would be "T1,2,3".
+	writeFiles(t, oldDir, map[string]string{
+		"stop_times.txt": "trip_id,stop_sequence,stop_id\n\"T1,2\",3,A\n",
+	})
+	writeFiles(t, newDir, map[string]string{
+		"stop_times.txt": "trip_id,stop_sequence,stop_id\nT1,\"2,3\",A\n",
+	})
+
+	report := Diff(oldDir, newDir)
+	if len(report.Errors) != 0 {
+		t.Fatal(report.Errors)
+	}
+	d := fileDiff(t, report, "stop_times.txt")
+	want := FileDiff{File: "stop_times.txt", Added: []string{"T1,2,3"}, Removed: []string{"T1,2,3"}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+}
+
+func TestDiffReportsDuplicateKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, newDir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	writeFiles(t, oldDir, map[string]string{
+		"stops.txt": "stop_id,stop_name\nA,Flinders St\n",
+	})
+	writeFiles(t, newDir, map[string]string{
+		"stops.txt": "stop_id,stop_name\nA,Flinders St\nA,Flinders Street\n",
+	})
+
+	report := Diff(oldDir, newDir)
+	var dup DuplicateKeyError
+	if len(report.Errors) != 1 || !errors.As(report.Errors[0], &dup) {
+		t.Fatalf("got errors %v, want a DuplicateKeyError", report.Errors)
+	}
+	if dup.Row != 3 || dup.Key != "stop_id A" {
+		t.Errorf("got %+v, want stop_id A at line 3", dup)
+	}
+	// The first of the duplicates is compared, and it hasn't changed.
+	if d := fileDiff(t, report, "stops.txt"); !d.Empty() {
+		t.Errorf("got %+v, want no differences", d)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, newDir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	writeFiles(t, oldDir, testFeed)
+	writeFiles(t, newDir, withFiles(testFeed, map[string]string{
+		// Stop B is renamed and stop E added, with the columns in another order.
+		"stops.txt": "stop_name,stop_id,stop_lat,stop_lon,wheelchair_boarding\n" +
+			"\"Flinders St, Stop 1\",A,-37.8183,144.9671,1\n" +
+			"Southern Cross Station,B,-37.8184,144.9525,2\n" +
+			"Richmond,C,-37.8240,144.9900,1\n" +
+			"Far Away,D,-36.0,146.0,0\n" +
+			"Box Hill,E,-37.8190,145.1220,1\n",
+		// T3 is dropped, along with its stop times.
+		"trips.txt": "route_id,service_id,trip_id,shape_id,trip_headsign,direction_id,wheelchair_accessible\n" +
+			"R1,S1,T1,SH1,City,0,1\n" +
+			"R2,S3,T2,SH2,Richmond,1,2\n",
+		"stop_times.txt": "",
+	}))
+
+	report := Diff(oldDir, newDir)
+	if len(report.Errors) != 0 {
+		t.Fatal(report.Errors)
+	}
+	if len(report.Files) != len(validGTFSFileNames) {
+		t.Errorf("got diffs of %d files, want %d", len(report.Files), len(validGTFSFileNames))
+	}
+	want := FileDiff{File: "stops.txt", Added: []string{"E"}, Changed: []string{"B"}}
+	if d := fileDiff(t, report, "stops.txt"); !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+	want = FileDiff{File: "trips.txt", Removed: []string{"T3"}}
+	if d := fileDiff(t, report, "trips.txt"); !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+	// A missing file is treated as being empty.
+	d := fileDiff(t, report, "stop_times.txt")
+	if len(d.Removed) != 7 || d.Removed[0] != "T1,1" || len(d.Added) != 0 {
+		t.Errorf("got %+v, want every stop time removed", d)
+	}
+	if d := fileDiff(t, report, "routes.txt"); !d.Empty() {
+		t.Errorf("got %+v, want no differences", d)
+	}
+}
@@ -0,0 +1,65 @@
+package gtfs
+
+import "fmt"
+
+// DirectTrips returns the trips of the GTFS feed in dir which call at fromStopID and
+// later at toStopID, giving a ride between them without changing. See
+// Feed.DirectTrips.
+func DirectTrips(dir, fromStopID, toStopID string) ([]Trip, error) {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil, err
+	}
+	return feed.DirectTrips(fromStopID, toStopID)
+}
+
+// DirectTrips returns the trips of the feed which call at fromStopID and then, at a
+// greater stop_sequence, at toStopID, in the order of f.Trips. Trips calling at the
+// stops the other way around don't take a passenger from one to the other and are
+// left out. Returns an error if either stop doesn't exist.
+func (f *Feed) DirectTrips(fromStopID, toStopID string) ([]Trip, error) {
+	known := make(map[string]bool, 2)
+	for _, s := range f.Stops {
+		if s.ID == fromStopID || s.ID == toStopID {
+			known[s.ID] = true
+		}
+	}
+	for _, id := range []string{fromStopID, toStopID} {
+		if !known[id] {
+			return nil, fmt.Errorf("stop %q not found", id)
+		}
+	}
+
+	// The earliest call of each trip at the origin, and the latest at the destination,
+	// so that a trip looping back through either stop is still found.
+	type calls struct {
+		from, to       int
+		hasFrom, hasTo bool
+	}
+	byTrip := make(map[string]*calls)
+	for _, st := range f.StopTimes {
+		if st.StopID != fromStopID && st.StopID != toStopID {
+			continue
+		}
+		c, ok := byTrip[st.TripID]
+		if !ok {
+			c = &calls{}
+			byTrip[st.TripID] = c
+		}
+		if st.StopID == fromStopID && (!c.hasFrom || st.StopSequence < c.from) {
+			c.from, c.hasFrom = st.StopSequence, true
+		}
+		if st.StopID == toStopID && (!c.hasTo || st.StopSequence > c.to) {
+			c.to, c.hasTo = st.StopSequence, true
+		}
+	}
+
+	var trips []Trip
+	for _, t := range f.Trips {
+		c, ok := byTrip[t.ID]
+		if ok && c.hasFrom && c.hasTo && c.from < c.to {
+			trips = append(trips, t)
+		}
+	}
+	return trips, nil
+}
@@ -0,0 +1,69 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func tripIDs(trips []Trip) []string {
+	var ids []string
+	for _, t := range trips {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestFeedDirectTrips(t *testing.T) {
+	feed := stopSequenceFeed(
+		[]string{"T1", "A", "X", "B"},
+		[]string{"T2", "B", "X", "A"},
+		[]string{"T3", "A", "X"},
+		// A loop calling at B before and after A.
+		[]string{"T4", "B", "A", "C", "B"},
+		[]string{"R2-T5", "A", "B"},
+	)
+	trips, err := feed.DirectTrips("A", "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tripIDs(trips), []string{"T1", "T4", "R2-T5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got trips %v, want %v", got, want)
+	}
+
+	trips, err = feed.DirectTrips("B", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tripIDs(trips), []string{"T2", "T4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got trips %v from B to A, want %v", got, want)
+	}
+}
+
+func TestFeedDirectTripsErrors(t *testing.T) {
+	feed := stopSequenceFeed([]string{"T1", "A", "B"})
+	for _, stops := range [][2]string{{"A", "Z"}, {"Z", "B"}} {
+		if _, err := feed.DirectTrips(stops[0], stops[1]); err == nil {
+			t.Errorf("got no error for trips from %s to %s", stops[0], stops[1])
+		}
+	}
+	// Stops which exist but aren't connected have no trips between them.
+	feed.Stops = append(feed.Stops, Stop{ID: "C"})
+	if trips, err := feed.DirectTrips("A", "C"); err != nil || len(trips) != 0 {
+		t.Errorf("got %v and error %v, want no trips", trips, err)
+	}
+}
+
+func TestDirectTrips(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	trips, err := DirectTrips(dir, "B", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tripIDs(trips), []string{"T1", "T2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got trips %v, want %v", got, want)
+	}
+	if trips[1].Headsign != "Richmond" {
+		t.Errorf("got trip %+v, want the whole of T2", trips[1])
+	}
+}
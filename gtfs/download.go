@@ -0,0 +1,105 @@
+package gtfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+)
+
+// zipContentTypes are the Content-Types a server may reasonably use when serving a zip.
+var zipContentTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip":            true,
+	"application/x-zip-compressed": true,
+	"application/octet-stream":     true,
+	"binary/octet-stream":          true,
+}
+
+// Download fetches the GTFS zip at url into a new temporary file in dir (or the
+// default temporary directory if dir is empty) and returns its path. The caller is
+// responsible for removing the file once it's no longer needed.
+//
+// The whole download must complete within timeout, where zero means no limit. The
+// response must look like a zip and, when the server supplies a Content-Length, be
+// of the advertised size. The temporary file is removed if the download fails.
+//
+// Progress is logged to log, or to stderr if log is nil.
+func Download(url, dir string, timeout time.Duration, log Logger) (path string, err error) {
+	client := &http.Client{Timeout: timeout}
+	log = loggerOrDefault(log)
+
+	log.Infof("Downloading %s...", url)
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: unexpected status %s", url, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || !zipContentTypes[mediaType] {
+			return "", fmt.Errorf("download %s: unexpected Content-Type %q", url, ct)
+		}
+	}
+
+	file, err := ioutil.TempFile(dir, "gtfs-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	progress := &downloadProgress{total: resp.ContentLength, next: time.Now().Add(downloadLogInterval), log: log}
+	n, err := io.Copy(file, io.TeeReader(resp.Body, progress))
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", url, err)
+	}
+	if n == 0 {
+		return "", fmt.Errorf("download %s: empty response", url)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return "", fmt.Errorf("download %s: got %d bytes, expected %d", url, n, resp.ContentLength)
+	}
+	if err = file.Close(); err != nil {
+		return "", err
+	}
+
+	log.Infof("Downloaded %s (%d bytes)", url, n)
+	return file.Name(), nil
+}
+
+// downloadLogInterval is how often the progress of a download is logged.
+var downloadLogInterval = 5 * time.Second
+
+// downloadProgress periodically logs how much of a download has been received.
+type downloadProgress struct {
+	total int64
+	done  int64
+	next  time.Time
+	log   Logger
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+
+	if now := time.Now(); now.After(p.next) {
+		p.next = now.Add(downloadLogInterval)
+		if p.total > 0 {
+			p.log.Infof("Downloaded %d of %d bytes (%.0f%%)", p.done, p.total, float64(p.done)/float64(p.total)*100)
+		} else {
+			p.log.Infof("Downloaded %d bytes", p.done)
+		}
+	}
+	return len(b), nil
+}
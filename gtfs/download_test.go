@@ -0,0 +1,80 @@
+package gtfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	body := zipBytes(t, map[string][]byte{"1/" + innerZipFileName: zipBytes(t, map[string][]byte{"stops.txt": []byte(testFeed["stops.txt"])})})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	path, err := Download(srv.URL+"/gtfs.zip", dir, time.Minute, NewLogger(ioutil.Discard, LevelError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Errorf("downloaded to %s, not within %s", path, dir)
+	}
+	if got := readFile(t, path); got != string(body) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(body))
+	}
+}
+
+func TestDownloadFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"not found", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}},
+		{"html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html></html>"))
+		}},
+		{"empty", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/zip")
+		}},
+		{"truncated", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("PK"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			if _, err := Download(srv.URL, dir, time.Minute, NewLogger(ioutil.Discard, LevelError)); err == nil {
+				t.Fatal("Download didn't return an error")
+			}
+			// The temporary file is removed when the download fails.
+			if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+				t.Errorf("left %d files behind", len(files))
+			}
+		})
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	if _, err := Download(srv.URL, t.TempDir(), 50*time.Millisecond, NewLogger(ioutil.Discard, LevelError)); err == nil {
+		t.Error("Download didn't time out")
+	}
+}
@@ -0,0 +1,179 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// extractRetryDelay is how long extractPTVData waits before first retrying an inner zip
+// which failed to extract. The wait doubles with each further attempt.
+var extractRetryDelay = time.Second
+
+// extractOptions controls how extractPTVData extracts a feed.
+type extractOptions struct {
+	// retries is the number of times to retry extracting an inner zip which fails with
+	// what may be a transient error.
+	retries int
+	// archiver extracts the outer and inner zips, or DefaultArchiver if nil.
+	archiver Archiver
+	// workers is the number of inner zips extracted at once. Defaults to GOMAXPROCS.
+	workers int
+	log     Logger
+}
+
+// innerZipErrors collects the failures encountered when extracting the inner
+// google_transit.zip files of a feed. It is only returned once the outer zip
+// has been extracted, so callers may choose to continue with the inner zips
+// which did extract successfully.
+type innerZipErrors []error
+
+func (e innerZipErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e innerZipErrors) Unwrap() []error {
+	return e
+}
+
+// Extracts the .zip of the GTFS data supplied by PTV into dest, including subdirectories
+// (1, 2, 3 etc.). The inner zips are extracted concurrently, by up to opts.workers at
+// once. Inner zips which fail to extract don't stop the remaining ones from being
+// extracted, and are reported together as an innerZipErrors. Each inner zip is retried
+// up to opts.retries times, with exponential backoff, unless the error shows that
+// trying again can't help. Extraction stops before the next inner zip, or the next
+// attempt, once ctx is cancelled.
+func extractPTVData(ctx context.Context, path string, dest string, opts extractOptions) error {
+	log := loggerOrDefault(opts.log)
+	a := archiverOrDefault(opts.archiver)
+	log.Infof("Extracting %s...", path)
+	// Extract the input zip.
+	err := a.Unarchive(path, dest)
+	if err != nil {
+		return err
+	}
+	log.Infof("Extracted %s. Walking...", path)
+
+	// Find every inner zip before extracting any, so that the walk never sees the
+	// directories being extracted to.
+	var innerZips []string
+	err = filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("access %s: %w", path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Check if we've hit an inner zip file.
+		if info.Name() == innerZipFileName {
+			log.Debugf("Found %s file in path %s", innerZipFileName, path)
+			innerZips = append(innerZips, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	workers := opts.workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// Each inner zip has a slot of its own in errs, so that they're reported in the
+	// order they were found whichever finishes first.
+	errs := make([]error, len(innerZips))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				innerZip := innerZips[j]
+				// Extract zip to a directory of the same name in the same path. Every
+				// inner zip has a directory to itself, so none are extracted over another.
+				innerOutputPath := strings.TrimSuffix(innerZip, ".zip")
+				if err := unarchiveWithRetry(ctx, a, innerZip, innerOutputPath, opts.retries, log); err != nil {
+					errs[j] = err
+					continue
+				}
+				log.Debugf("Extracted %s", innerZip)
+			}
+		}()
+	}
+	for j := range innerZips {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var innerErrs innerZipErrors
+	for j, err := range errs {
+		if err != nil {
+			innerErrs = append(innerErrs, fmt.Errorf("inner zip %s: %w", innerZips[j], err))
+		}
+	}
+	if len(innerErrs) > 0 {
+		return innerErrs
+	}
+	return nil
+}
+
+// Extracts the archive at path into dest with a, retrying up to retries times on failure. The
+// wait between attempts starts at extractRetryDelay and doubles each time. Anything
+// left behind by a failed attempt is removed before the next.
+func unarchiveWithRetry(ctx context.Context, a Archiver, path, dest string, retries int, log Logger) error {
+	delay := extractRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := a.Unarchive(path, dest)
+		if err == nil || attempt >= retries || !isRetryableExtractError(err) {
+			return err
+		}
+
+		log.Warnf("Unable to extract %s, retrying in %s: %s", path, delay, err.Error())
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// Returns whether an error extracting an archive may be transient, such as a read
+// failing part way through on a network filesystem. Errors showing that the file isn't
+// a valid zip, doesn't exist or can't be read due to its permissions aren't, as trying
+// again can't help.
+func isRetryableExtractError(err error) bool {
+	if errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrAlgorithm) {
+		return false
+	}
+	// Not every archiver wraps the errors of archive/zip, so check the message too.
+	if strings.Contains(err.Error(), zip.ErrFormat.Error()) {
+		return false
+	}
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission)
+}
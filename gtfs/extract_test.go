@@ -0,0 +1,281 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExtractPTVDataReportsCorruptInnerZip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	good := zipBytes(t, map[string][]byte{"stops.txt": []byte(testFeed["stops.txt"])})
+	outer := zipBytes(t, map[string][]byte{
+		"1/" + innerZipFileName: good,
+		"2/" + innerZipFileName: []byte("this is not a zip"),
+	})
+	if err := ioutil.WriteFile(input, outer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "in")
+	err := extractPTVData(context.Background(), input, dest, extractOptions{log: NewLogger(ioutil.Discard, LevelError)})
+
+	var innerErrs innerZipErrors
+	if !errors.As(err, &innerErrs) {
+		t.Fatalf("got error %v, want innerZipErrors", err)
+	}
+	if len(innerErrs) != 1 {
+		t.Fatalf("got %d inner zip errors, want 1: %v", len(innerErrs), innerErrs)
+	}
+	if want := filepath.Join(dest, "2", innerZipFileName); !strings.Contains(innerErrs[0].Error(), want) {
+		t.Errorf("error %q doesn't name %s", innerErrs[0], want)
+	}
+	if got := readFile(t, filepath.Join(dest, "1", "google_transit", "stops.txt")); got != testFeed["stops.txt"] {
+		t.Errorf("good inner zip extracted stops.txt as %q", got)
+	}
+}
+
+func TestExtractPTVDataBeneathZipNamedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	// Only the extension of each inner zip is dropped to name its directory.
+	dest := filepath.Join(dir, "feeds.zip.d", "in")
+	if err := extractPTVData(context.Background(), input, dest, extractOptions{log: NewLogger(ioutil.Discard, LevelError)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dest, "1", "google_transit", "stops.txt")); got != testFeed["stops.txt"] {
+		t.Errorf("extracted stops.txt as %q", got)
+	}
+}
+
+func TestExtractPTVDataStopsWhenCancelled(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dest := filepath.Join(dir, "in")
+	err := extractPTVData(ctx, input, dest, extractOptions{log: NewLogger(ioutil.Discard, LevelError)})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "1", "google_transit")); !os.IsNotExist(err) {
+		t.Errorf("inner zip was extracted after cancellation")
+	}
+}
+
+// flakyArchiver fails to extract each inner zip the first failures times with err,
+// before deferring to DefaultArchiver, and counts the attempts at each path.
+type flakyArchiver struct {
+	failures int
+	err      error
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (a *flakyArchiver) Unarchive(source, destination string) error {
+	a.mu.Lock()
+	a.attempts[source]++
+	attempt := a.attempts[source]
+	a.mu.Unlock()
+	if filepath.Base(source) == innerZipFileName && attempt <= a.failures {
+		// Leave a partial extraction behind, as a read failing part way through would.
+		if err := os.MkdirAll(destination, os.ModePerm); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(destination, "partial.txt"), nil, 0644); err != nil {
+			return err
+		}
+		return a.err
+	}
+	return DefaultArchiver.Unarchive(source, destination)
+}
+
+func (a *flakyArchiver) Archive(sources []string, destination string) error {
+	return DefaultArchiver.Archive(sources, destination)
+}
+
+// Sets extractRetryDelay to d for the duration of the test.
+func setExtractRetryDelay(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := extractRetryDelay
+	extractRetryDelay = d
+	t.Cleanup(func() { extractRetryDelay = old })
+}
+
+func TestExtractPTVDataRetries(t *testing.T) {
+	setExtractRetryDelay(t, time.Millisecond)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	a := &flakyArchiver{failures: 2, err: io.ErrUnexpectedEOF, attempts: make(map[string]int)}
+	dest := filepath.Join(dir, "in")
+	err := extractPTVData(context.Background(), input, dest, extractOptions{retries: 2, archiver: a, log: NewLogger(ioutil.Discard, LevelError)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerZip := filepath.Join(dest, "1", innerZipFileName)
+	if a.attempts[innerZip] != 3 {
+		t.Errorf("made %d attempts at %s, want 3", a.attempts[innerZip], innerZip)
+	}
+	if got := readFile(t, filepath.Join(dest, "1", "google_transit", "stops.txt")); got != testFeed["stops.txt"] {
+		t.Errorf("extracted stops.txt as %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "1", "google_transit", "partial.txt")); !os.IsNotExist(err) {
+		t.Error("left the failed attempts' files behind")
+	}
+}
+
+func TestExtractPTVDataGivesUpRetrying(t *testing.T) {
+	setExtractRetryDelay(t, time.Millisecond)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	for i, tt := range []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "transient", err: io.ErrUnexpectedEOF, attempts: 3},
+		{name: "not a zip", err: fmt.Errorf("reading %s: %w", innerZipFileName, zip.ErrFormat), attempts: 1},
+		{name: "unwrapped not a zip", err: errors.New("opening zip archive: " + zip.ErrFormat.Error()), attempts: 1},
+		{name: "missing", err: &fs.PathError{Op: "open", Path: innerZipFileName, Err: fs.ErrNotExist}, attempts: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(dir, fmt.Sprint("in", i))
+			innerZip := filepath.Join(dest, "1", innerZipFileName)
+			a := &flakyArchiver{failures: 5, err: tt.err, attempts: make(map[string]int)}
+			err := extractPTVData(context.Background(), input, dest, extractOptions{retries: 2, archiver: a, log: NewLogger(ioutil.Discard, LevelError)})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if a.attempts[innerZip] != tt.attempts {
+				t.Errorf("made %d attempts, want %d", a.attempts[innerZip], tt.attempts)
+			}
+		})
+	}
+}
+
+func TestExtractPTVDataStopsRetryingWhenCancelled(t *testing.T) {
+	setExtractRetryDelay(t, time.Hour)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	a := &flakyArchiver{failures: 1, err: io.ErrUnexpectedEOF, attempts: make(map[string]int)}
+	err := extractPTVData(ctx, input, filepath.Join(dir, "in"), extractOptions{retries: 1, archiver: a, log: NewLogger(ioutil.Discard, LevelError)})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+// countingArchiver defers to DefaultArchiver, recording the most inner zips it's been
+// extracting at once. Each inner zip takes a little while, so that they overlap.
+type countingArchiver struct {
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (a *countingArchiver) Unarchive(source, destination string) error {
+	if filepath.Base(source) != innerZipFileName {
+		return DefaultArchiver.Unarchive(source, destination)
+	}
+	a.mu.Lock()
+	a.active++
+	if a.active > a.maxActive {
+		a.maxActive = a.active
+	}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.active--
+		a.mu.Unlock()
+	}()
+	time.Sleep(5 * time.Millisecond)
+	return DefaultArchiver.Unarchive(source, destination)
+}
+
+func (a *countingArchiver) Archive(sources []string, destination string) error {
+	return DefaultArchiver.Archive(sources, destination)
+}
+
+func TestExtractPTVDataConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	const n = 12
+	feeds := make(map[string]map[string]string, n)
+	for i := 0; i < n; i++ {
+		feeds[fmt.Sprint(i)] = map[string]string{"stops.txt": fmt.Sprintf("stop_id,stop_name\n%d,Stop %d\n", i, i)}
+	}
+	writePTVZip(t, input, feeds)
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			dest := filepath.Join(dir, fmt.Sprint("in", workers))
+			a := &countingArchiver{}
+			err := extractPTVData(context.Background(), input, dest, extractOptions{archiver: a, workers: workers, log: NewLogger(ioutil.Discard, LevelError)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				if got, want := readFile(t, filepath.Join(dest, fmt.Sprint(i), "google_transit", "stops.txt")), feeds[fmt.Sprint(i)]["stops.txt"]; got != want {
+					t.Errorf("inner zip %d extracted stops.txt as %q", i, got)
+				}
+			}
+			if a.maxActive > workers {
+				t.Errorf("extracted %d inner zips at once, want at most %d", a.maxActive, workers)
+			}
+			if workers > 1 && a.maxActive < 2 {
+				t.Errorf("extracted the inner zips one at a time, want up to %d at once", workers)
+			}
+		})
+	}
+}
+
+func TestExtractPTVDataConcurrentlyReportsEveryFailure(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	good := zipBytes(t, map[string][]byte{"stops.txt": []byte(testFeed["stops.txt"])})
+	files := make(map[string][]byte)
+	for i := 0; i < 6; i++ {
+		files[fmt.Sprintf("%d/%s", i, innerZipFileName)] = good
+		if i%2 == 1 {
+			files[fmt.Sprintf("%d/%s", i, innerZipFileName)] = []byte("this is not a zip")
+		}
+	}
+	if err := ioutil.WriteFile(input, zipBytes(t, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "in")
+	err := extractPTVData(context.Background(), input, dest, extractOptions{workers: 4, log: NewLogger(ioutil.Discard, LevelError)})
+	var innerErrs innerZipErrors
+	if !errors.As(err, &innerErrs) || len(innerErrs) != 3 {
+		t.Fatalf("got error %v, want innerZipErrors of 3 inner zips", err)
+	}
+	// They're reported in the order they were found, whichever failed first.
+	for i, err := range innerErrs {
+		if want := filepath.Join(dest, fmt.Sprint(2*i+1), innerZipFileName); !strings.Contains(err.Error(), want) {
+			t.Errorf("error %d %q doesn't name %s", i, err, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Feed holds every record of a consolidated GTFS feed in memory.
+type Feed struct {
+	Agencies      []Agency
+	Calendars     []Calendar
+	CalendarDates []CalendarDate
+	Routes        []Route
+	Stops         []Stop
+	Trips         []Trip
+	StopTimes     []StopTime
+	Shapes        []ShapePoint
+	Frequencies   []Frequency
+	Transfers     []TransferRule
+}
+
+// LoadFeed reads the GTFS files in dir, such as those written by Consolidate, into a
+// Feed. Files which don't exist are treated as being empty.
+func LoadFeed(dir string) (*Feed, error) {
+	var f Feed
+
+	loaders := map[string]func(h Header, row []string) error{
+		"agency": func(h Header, row []string) error {
+			a, err := ParseAgency(h, row)
+			f.Agencies = append(f.Agencies, a)
+			return err
+		},
+		"calendar": func(h Header, row []string) error {
+			c, err := ParseCalendar(h, row)
+			f.Calendars = append(f.Calendars, c)
+			return err
+		},
+		"calendar_dates": func(h Header, row []string) error {
+			c, err := ParseCalendarDate(h, row)
+			f.CalendarDates = append(f.CalendarDates, c)
+			return err
+		},
+		"routes": func(h Header, row []string) error {
+			r, err := ParseRoute(h, row)
+			f.Routes = append(f.Routes, r)
+			return err
+		},
+		"stops": func(h Header, row []string) error {
+			s, err := ParseStop(h, row)
+			f.Stops = append(f.Stops, s)
+			return err
+		},
+		"trips": func(h Header, row []string) error {
+			t, err := ParseTrip(h, row)
+			f.Trips = append(f.Trips, t)
+			return err
+		},
+		"stop_times": func(h Header, row []string) error {
+			s, err := ParseStopTime(h, row)
+			f.StopTimes = append(f.StopTimes, s)
+			return err
+		},
+		"shapes": func(h Header, row []string) error {
+			s, err := ParseShapePoint(h, row)
+			f.Shapes = append(f.Shapes, s)
+			return err
+		},
+		"frequencies": func(h Header, row []string) error {
+			fr, err := ParseFrequency(h, row)
+			f.Frequencies = append(f.Frequencies, fr)
+			return err
+		},
+		"transfers": func(h Header, row []string) error {
+			t, err := ParseTransferRule(h, row)
+			f.Transfers = append(f.Transfers, t)
+			return err
+		},
+	}
+
+	for kind, load := range loaders {
+		err := readCSVFile(filepath.Join(dir, fmt.Sprintf("%s.txt", kind)), load)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return &f, nil
+}
+
+// LoadStops reads just the stops.txt of the GTFS feed in dir, for when the rest of a
+// large feed isn't needed.
+func LoadStops(dir string) ([]Stop, error) {
+	var stops []Stop
+	err := readCSVFile(filepath.Join(dir, "stops.txt"), func(h Header, row []string) error {
+		s, err := ParseStop(h, row)
+		stops = append(stops, s)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// Calls fn with each row of the CSV file at path, bar the header, along with the
+// Header describing its columns. Reading stops at the first error returned by fn.
+func readCSVFile(path string, fn func(h Header, row []string) error) error {
+	return readDelimitedFile(path, ',', fn)
+}
+
+// Like readCSVFile, but for a file whose fields are separated by comma.
+func readDelimitedFile(path string, comma rune, fn func(h Header, row []string) error) error {
+	return readRowsOf(path, comma, false, fn)
+}
+
+// Like readDelimitedFile, but skips malformed rows, such as those with the wrong number
+// of fields, rather than failing on them.
+func readDelimitedFileSkippingMalformed(path string, comma rune, fn func(h Header, row []string) error) error {
+	return readRowsOf(path, comma, true, fn)
+}
+
+// Does the work of readDelimitedFile, skipping rows whose CSV can't be parsed if
+// skipMalformed is set.
+func readRowsOf(path string, comma rune, skipMalformed bool, fn func(h Header, row []string) error) error {
+	file, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gr, err := decompressGTFSFile(path, file)
+	if err != nil {
+		return err
+	}
+	r := newCSVReader(gr)
+	r.Comma = comma
+	headerRow, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read header of %s: %w", path, err)
+	}
+	header := NewHeader(headerRow)
+
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if skipMalformed && errors.As(err, &parseErr) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", path, err)
+		}
+
+		if err := fn(header, row); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package gtfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Filter restricts the records written by Consolidate. Records which don't match the
+// filter are dropped along with the records depending on them, e.g. removing a route
+// also removes its trips and their stop times, and then any stops, services and shapes
+// no longer referenced by a remaining trip.
+type Filter struct {
+	// RouteTypes keeps only the routes whose route_type is one of those given. A basic
+	// route type also keeps the extended types of the same mode, e.g. 0 keeps trams
+	// given as 900, and an extended category keeps the types within it, e.g. 900 keeps
+	// 901. See ExtendedRouteTypes.
+	RouteTypes []int
+
+	// AgencyIDs keeps only the routes run by one of the given agencies.
+	AgencyIDs []string
+
+	// ActiveOn keeps only the trips whose service runs on the given date, taking
+	// into account the exceptions in calendar_dates. The zero time keeps every trip.
+	ActiveOn time.Time
+
+	// BBox keeps only the stops lying within the box, the stop times at those stops and
+	// the trips making them. Stops without coordinates are never within the box.
+	BBox *BoundingBox
+
+	// ExcludeRouteIDs drops the given routes, e.g. test routes left in the feed.
+	ExcludeRouteIDs []string
+
+	// ExcludeStopIDs drops the given stops and the stop times at them. Trips left
+	// without any stop times are dropped too.
+	ExcludeStopIDs []string
+
+	// AccessibleOnly keeps only the stops with wheelchair boarding and the trips whose
+	// vehicles are wheelchair accessible, those whose wheelchair_boarding or
+	// wheelchair_accessible is 1. Stops and trips where it's unknown are dropped too,
+	// along with the stop times at dropped stops and trips left without any.
+	AccessibleOnly bool
+
+	// SampleTrips keeps only the first n trips in order of their trip_id, of those
+	// matching the rest of the filter, along with the records they depend on. It gives
+	// a small but coherent feed for testing, where every stop time still belongs to a
+	// trip and every trip to a route. Zero keeps every trip.
+	SampleTrips int
+}
+
+// BoundingBox is a range of latitudes and longitudes, given in degrees.
+type BoundingBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// Contains returns whether a point lies within the box, inclusive of its edges.
+func (b BoundingBox) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// Returns whether the filter restricts the feed at all.
+func (f Filter) active() bool {
+	return len(f.RouteTypes) > 0 || len(f.AgencyIDs) > 0 || !f.ActiveOn.IsZero() || f.BBox != nil ||
+		len(f.ExcludeRouteIDs) > 0 || len(f.ExcludeStopIDs) > 0 || f.AccessibleOnly || f.SampleTrips > 0
+}
+
+// Returns whether a stop matches the filter.
+func (f Filter) keepsStop(h Header, row []string) bool {
+	if containsString(f.ExcludeStopIDs, h.value(row, "stop_id")) {
+		return false
+	}
+	if f.AccessibleOnly && h.value(row, "wheelchair_boarding") != "1" {
+		return false
+	}
+	if f.BBox == nil {
+		return true
+	}
+
+	if h.value(row, "stop_lat") == "" || h.value(row, "stop_lon") == "" {
+		return false
+	}
+	stop, err := ParseStop(h, row)
+	if err != nil {
+		return false
+	}
+	return f.BBox.Contains(stop.Lat, stop.Lon)
+}
+
+// Returns whether a route matches the filter.
+func (f Filter) keepsRoute(h Header, row []string) bool {
+	if containsString(f.ExcludeRouteIDs, h.value(row, "route_id")) {
+		return false
+	}
+	if len(f.AgencyIDs) > 0 && !containsString(f.AgencyIDs, h.value(row, "agency_id")) {
+		return false
+	}
+	if len(f.RouteTypes) == 0 {
+		return true
+	}
+
+	routeType, err := strconv.Atoi(h.value(row, "route_type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range f.RouteTypes {
+		if routeTypeMatches(routeType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether s is one of values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keepSet holds the ids of the records which survive a Filter, by kind. A nil keepSet
+// keeps everything.
+type keepSet struct {
+	agencies map[string]bool
+	routes   map[string]bool
+	trips    map[string]bool
+	services map[string]bool
+	shapes   map[string]bool
+	stops    map[string]bool
+}
+
+// Returns whether a record survives filtering.
+func (k *keepSet) keeps(rec GTFSRecord) bool {
+	if k == nil {
+		return true
+	}
+
+	value := func(column string) string {
+		return rec.Header.value(rec.Contents, column)
+	}
+	switch rec.Type {
+	case "agency":
+		// Feeds with a single agency may leave agency_id blank.
+		id := value("agency_id")
+		return id == "" || k.agencies[id]
+	case "routes":
+		return k.routes[value("route_id")]
+	case "trips":
+		return k.trips[value("trip_id")]
+	case "stop_times":
+		return k.trips[value("trip_id")] && k.stops[value("stop_id")]
+	case "stops":
+		return k.stops[value("stop_id")]
+	case "calendar", "calendar_dates":
+		return k.services[value("service_id")]
+	case "shapes":
+		return k.shapes[value("shape_id")]
+	case "frequencies":
+		return k.trips[value("trip_id")]
+	case "transfers":
+		return k.stops[value("from_stop_id")] && k.stops[value("to_stop_id")]
+	default:
+		return true
+	}
+}
+
+// filterTrip holds the references a trip makes to other records.
+type filterTrip struct {
+	routeID   string
+	serviceID string
+	shapeID   string
+}
+
+// Resolves which records of the feed extracted to path survive the filter. This is the
+// first of two passes over the feed: routes, trips, stops and the calendars are read to
+// decide which trips and stops may remain, then stop_times are read to find the stops
+// those trips still serve and, when filtering by stop, the trips still serving a stop.
+// The records themselves are written in the second pass, using the returned keepSet.
+func resolveFilter(ctx context.Context, path string, f Filter, opts walkOptions) (*keepSet, error) {
+	k := &keepSet{
+		agencies: make(map[string]bool),
+		routes:   make(map[string]bool),
+		trips:    make(map[string]bool),
+		services: make(map[string]bool),
+		shapes:   make(map[string]bool),
+		stops:    make(map[string]bool),
+	}
+
+	routeAgencies := make(map[string]string)
+	trips := make(map[string]filterTrip)
+	stops := make(map[string]bool)
+	var calendars []Calendar
+	var calendarDates []CalendarDate
+	var parseErr error
+
+	opts.kinds = []string{"routes", "trips", "stops", "calendar", "calendar_dates"}
+	records, errc := walkPTVData(ctx, path, opts)
+	for rec := range records {
+		value := func(column string) string {
+			return rec.Header.value(rec.Contents, column)
+		}
+		switch rec.Type {
+		case "routes":
+			if f.keepsRoute(rec.Header, rec.Contents) {
+				routeAgencies[value("route_id")] = value("agency_id")
+			}
+		case "trips":
+			if f.AccessibleOnly && value("wheelchair_accessible") != "1" {
+				continue
+			}
+			trips[value("trip_id")] = filterTrip{
+				routeID:   value("route_id"),
+				serviceID: value("service_id"),
+				shapeID:   value("shape_id"),
+			}
+		case "stops":
+			if f.keepsStop(rec.Header, rec.Contents) {
+				stops[value("stop_id")] = true
+			}
+		case "calendar":
+			c, err := ParseCalendar(rec.Header, rec.Contents)
+			if err != nil && parseErr == nil {
+				parseErr = fmt.Errorf("%s: %w", rec.Path, err)
+			}
+			calendars = append(calendars, c)
+		case "calendar_dates":
+			c, err := ParseCalendarDate(rec.Header, rec.Contents)
+			if err != nil && parseErr == nil {
+				parseErr = fmt.Errorf("%s: %w", rec.Path, err)
+			}
+			calendarDates = append(calendarDates, c)
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	var services map[string]bool
+	if !f.ActiveOn.IsZero() {
+		services = ActiveServices(calendars, calendarDates, f.ActiveOn)
+	}
+
+	candidates := make(map[string]bool)
+	for id, t := range trips {
+		if _, ok := routeAgencies[t.routeID]; !ok {
+			continue
+		}
+		if services != nil && !services[t.serviceID] {
+			continue
+		}
+		candidates[id] = true
+	}
+
+	// Trips whose stops have all been filtered out no longer go anywhere.
+	needsStops := f.BBox != nil || len(f.ExcludeStopIDs) > 0 || f.AccessibleOnly
+
+	if f.SampleTrips > 0 {
+		if needsStops {
+			// Only sample the trips which will be kept, so that none of the sample is lost.
+			served, err := servedTrips(ctx, path, candidates, stops, nil, opts)
+			if err != nil {
+				return nil, err
+			}
+			for id := range candidates {
+				if !served[id] {
+					delete(candidates, id)
+				}
+			}
+		}
+		candidates = sampleTrips(candidates, f.SampleTrips)
+	}
+
+	// When filtering stops, only trips which still call at a remaining stop are kept.
+	served, err := servedTrips(ctx, path, candidates, stops, k.stops, opts)
+	if err != nil {
+		return nil, err
+	}
+	for id := range candidates {
+		if needsStops && !served[id] {
+			continue
+		}
+
+		t := trips[id]
+		k.trips[id] = true
+		k.routes[t.routeID] = true
+		k.agencies[routeAgencies[t.routeID]] = true
+		k.services[t.serviceID] = true
+		if t.shapeID != "" {
+			k.shapes[t.shapeID] = true
+		}
+	}
+
+	return k, nil
+}
+
+// Walks the stop_times of the feed beneath path, returning which of the candidate trips
+// call at one of stops. Each of those stops that a candidate calls at is added to
+// called, which may be nil.
+func servedTrips(ctx context.Context, path string, candidates, stops, called map[string]bool, opts walkOptions) (map[string]bool, error) {
+	served := make(map[string]bool)
+	opts.kinds = []string{"stop_times"}
+	records, errc := walkPTVData(ctx, path, opts)
+	for rec := range records {
+		tripID := rec.Header.value(rec.Contents, "trip_id")
+		stopID := rec.Header.value(rec.Contents, "stop_id")
+		if candidates[tripID] && stops[stopID] {
+			if called != nil {
+				called[stopID] = true
+			}
+			served[tripID] = true
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return served, nil
+}
+
+// Returns the first n of the trips in order of their trip_id.
+func sampleTrips(trips map[string]bool, n int) map[string]bool {
+	ids := make([]string, 0, len(trips))
+	for id := range trips {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	sampled := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sampled[id] = true
+	}
+	return sampled
+}
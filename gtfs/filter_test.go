@@ -0,0 +1,290 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Consolidates files with filter, returning the feed written.
+func consolidateFiltered(t *testing.T, files map[string]string, filter Filter) *Feed {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, files)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Filter = filter
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := LoadFeed(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+// feedIDs lists the ids of each kind of record in a feed, sorted, with those of stop
+// times given as trip_id:stop_id and those of services counting both calendar files.
+type feedIDs struct {
+	routes, trips, stopTimes, stops, shapes, services []string
+}
+
+func idsOf(feed *Feed) feedIDs {
+	var ids feedIDs
+	for _, r := range feed.Routes {
+		ids.routes = append(ids.routes, r.ID)
+	}
+	for _, tr := range feed.Trips {
+		ids.trips = append(ids.trips, tr.ID)
+	}
+	for _, st := range feed.StopTimes {
+		ids.stopTimes = append(ids.stopTimes, st.TripID+":"+st.StopID)
+	}
+	for _, s := range feed.Stops {
+		ids.stops = append(ids.stops, s.ID)
+	}
+	shapes := make(map[string]bool)
+	for _, p := range feed.Shapes {
+		if !shapes[p.ShapeID] {
+			shapes[p.ShapeID] = true
+			ids.shapes = append(ids.shapes, p.ShapeID)
+		}
+	}
+	services := make(map[string]bool)
+	for _, c := range feed.Calendars {
+		services[c.ServiceID] = true
+	}
+	for _, c := range feed.CalendarDates {
+		services[c.ServiceID] = true
+	}
+	for id := range services {
+		ids.services = append(ids.services, id)
+	}
+	for _, s := range [][]string{ids.routes, ids.trips, ids.stopTimes, ids.stops, ids.shapes, ids.services} {
+		sort.Strings(s)
+	}
+	return ids
+}
+
+func checkIDs(t *testing.T, got, want feedIDs) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestFilterRouteTypes(t *testing.T) {
+	feed := consolidateFiltered(t, testFeed, Filter{RouteTypes: []int{0}})
+	checkIDs(t, idsOf(feed), feedIDs{
+		routes:    []string{"R2"},
+		trips:     []string{"T2"},
+		stopTimes: []string{"T2:B", "T2:C"},
+		stops:     []string{"B", "C"},
+		shapes:    []string{"SH2"},
+		services:  []string{"S3"},
+	})
+	if len(feed.Agencies) != 1 {
+		t.Errorf("got %d agencies, want 1", len(feed.Agencies))
+	}
+}
+
+func TestFilterExtendedRouteTypes(t *testing.T) {
+	// R2 runs trams given as the extended route type 900.
+	files := withFiles(testFeed, map[string]string{
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R1,1,Sandringham,Sandringham Line,2\n" +
+			"R2,1,96,,900\n",
+	})
+	trams := feedIDs{
+		routes:    []string{"R2"},
+		trips:     []string{"T2"},
+		stopTimes: []string{"T2:B", "T2:C"},
+		stops:     []string{"B", "C"},
+		shapes:    []string{"SH2"},
+		services:  []string{"S3"},
+	}
+	for _, types := range [][]int{{0}, {900}} {
+		checkIDs(t, idsOf(consolidateFiltered(t, files, Filter{RouteTypes: types})), trams)
+	}
+	// 901 is a kind of tram, but not every tram is a 901.
+	if ids := idsOf(consolidateFiltered(t, files, Filter{RouteTypes: []int{901}})); len(ids.routes) != 0 {
+		t.Errorf("got routes %v of route_type 901, want none", ids.routes)
+	}
+}
+
+func TestFilterRouteTypesKeepsSharedStops(t *testing.T) {
+	feed := consolidateFiltered(t, testFeed, Filter{RouteTypes: []int{2}})
+	checkIDs(t, idsOf(feed), feedIDs{
+		routes:    []string{"R1"},
+		trips:     []string{"T1", "T3"},
+		stopTimes: []string{"T1:A", "T1:B", "T1:C", "T3:A", "T3:D"},
+		stops:     []string{"A", "B", "C", "D"},
+		shapes:    []string{"SH1"},
+		services:  []string{"S1", "S2"},
+	})
+}
+
+func TestFilterActiveOn(t *testing.T) {
+	tests := []struct {
+		name string
+		date time.Time
+		want feedIDs
+	}{
+		{
+			// S3 only runs by being added in calendar_dates, while S2 expired in 2020.
+			name: "added by calendar_dates",
+			date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			want: feedIDs{
+				routes:    []string{"R2"},
+				trips:     []string{"T2"},
+				stopTimes: []string{"T2:B", "T2:C"},
+				stops:     []string{"B", "C"},
+				shapes:    []string{"SH2"},
+				services:  []string{"S3"},
+			},
+		},
+		{
+			name: "weekday",
+			date: time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC),
+			want: feedIDs{
+				routes:    []string{"R1"},
+				trips:     []string{"T1"},
+				stopTimes: []string{"T1:A", "T1:B", "T1:C"},
+				stops:     []string{"A", "B", "C"},
+				shapes:    []string{"SH1"},
+				services:  []string{"S1"},
+			},
+		},
+		{
+			// S1 would run on a Monday, but is removed in calendar_dates.
+			name: "removed by calendar_dates",
+			date: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+			want: feedIDs{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkIDs(t, idsOf(consolidateFiltered(t, testFeed, Filter{ActiveOn: tt.date})), tt.want)
+		})
+	}
+}
+
+func TestFilterBBox(t *testing.T) {
+	files := withFiles(testFeed, map[string]string{
+		"stops.txt": testFeed["stops.txt"] + "E,Unknown,,,0\n",
+	})
+	// The box covers Richmond alone, which T1 and T2 call at but T3 doesn't.
+	box := &BoundingBox{MinLat: -37.83, MinLon: 144.98, MaxLat: -37.82, MaxLon: 145.0}
+	checkIDs(t, idsOf(consolidateFiltered(t, files, Filter{BBox: box})), feedIDs{
+		routes:    []string{"R1", "R2"},
+		trips:     []string{"T1", "T2"},
+		stopTimes: []string{"T1:C", "T2:C"},
+		stops:     []string{"C"},
+		shapes:    []string{"SH1", "SH2"},
+		services:  []string{"S1", "S3"},
+	})
+}
+
+func TestFilterBBoxWithoutCoordinates(t *testing.T) {
+	files := withFiles(testFeed, map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nA,Flinders St,,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,A,1\n",
+	})
+	// A box around the whole world still leaves out a stop with no coordinates.
+	box := &BoundingBox{MinLat: -90, MinLon: -180, MaxLat: 90, MaxLon: 180}
+	checkIDs(t, idsOf(consolidateFiltered(t, files, Filter{BBox: box})), feedIDs{})
+}
+
+func TestFilterAgencyIDs(t *testing.T) {
+	files := withFiles(testFeed, map[string]string{
+		"agency.txt": testFeed["agency.txt"] + "2,Yarra Trams,http://yarratrams.com.au,Australia/Melbourne,EN\n",
+		"routes.txt": "route_id,agency_id,route_short_name,route_type\nR1,1,Sandringham,2\nR2,2,96,0\n",
+	})
+	feed := consolidateFiltered(t, files, Filter{AgencyIDs: []string{"2"}})
+	// A and D are only called at by the other agency's trips.
+	checkIDs(t, idsOf(feed), feedIDs{
+		routes:    []string{"R2"},
+		trips:     []string{"T2"},
+		stopTimes: []string{"T2:B", "T2:C"},
+		stops:     []string{"B", "C"},
+		shapes:    []string{"SH2"},
+		services:  []string{"S3"},
+	})
+	if len(feed.Agencies) != 1 || feed.Agencies[0].ID != "2" {
+		t.Errorf("got agencies %+v, want agency 2 alone", feed.Agencies)
+	}
+}
+
+func TestFilterExcludeRouteIDs(t *testing.T) {
+	// E is only called at by T2, so it goes along with R2.
+	files := withFiles(testFeed, map[string]string{
+		"stops.txt":      testFeed["stops.txt"] + "E,Jolimont,-37.8160,144.9840,0\n",
+		"stop_times.txt": testFeed["stop_times.txt"] + "T2,24:20:00,24:20:00,E,3,,0,0,\n",
+	})
+	checkIDs(t, idsOf(consolidateFiltered(t, files, Filter{ExcludeRouteIDs: []string{"R2", "R9"}})), feedIDs{
+		routes:    []string{"R1"},
+		trips:     []string{"T1", "T3"},
+		stopTimes: []string{"T1:A", "T1:B", "T1:C", "T3:A", "T3:D"},
+		stops:     []string{"A", "B", "C", "D"},
+		shapes:    []string{"SH1"},
+		services:  []string{"S1", "S2"},
+	})
+}
+
+func TestFilterExcludeStopIDs(t *testing.T) {
+	// T3 calls only at A and D, so is left without any stop times.
+	checkIDs(t, idsOf(consolidateFiltered(t, testFeed, Filter{ExcludeStopIDs: []string{"A", "D"}})), feedIDs{
+		routes:    []string{"R1", "R2"},
+		trips:     []string{"T1", "T2"},
+		stopTimes: []string{"T1:B", "T1:C", "T2:B", "T2:C"},
+		stops:     []string{"B", "C"},
+		shapes:    []string{"SH1", "SH2"},
+		services:  []string{"S1", "S3"},
+	})
+}
+
+func TestFilterAccessibleOnly(t *testing.T) {
+	// B isn't accessible and D may not be, while T2's vehicle can't carry a wheelchair.
+	feed := consolidateFiltered(t, testFeed, Filter{AccessibleOnly: true})
+	checkIDs(t, idsOf(feed), feedIDs{
+		routes:    []string{"R1"},
+		trips:     []string{"T1", "T3"},
+		stopTimes: []string{"T1:A", "T1:C", "T3:A"},
+		stops:     []string{"A", "C"},
+		shapes:    []string{"SH1"},
+		services:  []string{"S1", "S2"},
+	})
+	for _, s := range feed.Stops {
+		if s.WheelchairBoarding != 1 {
+			t.Errorf("stop %s has wheelchair_boarding %d, want 1", s.ID, s.WheelchairBoarding)
+		}
+	}
+	for _, tr := range feed.Trips {
+		if tr.WheelchairAccessible != 1 {
+			t.Errorf("trip %s has wheelchair_accessible %d, want 1", tr.ID, tr.WheelchairAccessible)
+		}
+	}
+}
+
+func TestConsolidateKeepsWheelchairColumns(t *testing.T) {
+	feed := consolidateFiltered(t, testFeed, Filter{})
+	boarding := make(map[string]int)
+	for _, s := range feed.Stops {
+		boarding[s.ID] = s.WheelchairBoarding
+	}
+	if want := map[string]int{"A": 1, "B": 2, "C": 1, "D": 0}; !reflect.DeepEqual(boarding, want) {
+		t.Errorf("got wheelchair_boarding %v, want %v", boarding, want)
+	}
+	accessible := make(map[string]int)
+	for _, tr := range feed.Trips {
+		accessible[tr.ID] = tr.WheelchairAccessible
+	}
+	if want := map[string]int{"T1": 1, "T2": 2, "T3": 1}; !reflect.DeepEqual(accessible, want) {
+		t.Errorf("got wheelchair_accessible %v, want %v", accessible, want)
+	}
+}
@@ -0,0 +1,151 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FormatFlat writes a single schedule.csv joining each stop time with its trip, the
+// trip's route and the stop, for analysis without having to join the files oneself.
+// Other columns, and the other kinds of record, are not written.
+const FormatFlat = "flat"
+
+// flatColumns are the columns of schedule.csv.
+var flatColumns = []string{
+	"trip_id", "route_id", "route_short_name", "trip_headsign", "stop_sequence",
+	"stop_id", "stop_name", "stop_lat", "stop_lon", "arrival_time", "departure_time",
+}
+
+// flatFormat collects the routes, trips, stops and stop times of a feed, as they may
+// arrive in any order, and writes schedule.csv on close. Each table only writes to its
+// own map, so the tables may be written concurrently.
+type flatFormat struct {
+	sink  Sink
+	comma rune
+
+	routes    map[string][]string
+	trips     map[string][]string
+	stops     map[string][]string
+	stopTimes []StopTime
+
+	// missing counts the stop times written without the trip, route or stop they
+	// refer to, whose columns are left blank.
+	missing int
+}
+
+// The columns kept of each kind of record joined, by the kind.
+var flatJoinColumns = map[string][]string{
+	"routes": {"route_short_name"},
+	"trips":  {"route_id", "trip_headsign"},
+	"stops":  {"stop_name", "stop_lat", "stop_lon"},
+}
+
+func (f *flatFormat) table(kind string, header []string) (tableWriter, error) {
+	h := NewHeader(header)
+	switch kind {
+	case "routes":
+		f.routes = make(map[string][]string)
+		return &flatLookup{header: h, id: "route_id", columns: flatJoinColumns[kind], rows: f.routes}, nil
+	case "trips":
+		f.trips = make(map[string][]string)
+		return &flatLookup{header: h, id: "trip_id", columns: flatJoinColumns[kind], rows: f.trips}, nil
+	case "stops":
+		f.stops = make(map[string][]string)
+		return &flatLookup{header: h, id: "stop_id", columns: flatJoinColumns[kind], rows: f.stops}, nil
+	case "stop_times":
+		return &flatStopTimes{header: h, f: f}, nil
+	default:
+		return discardTable{}, nil
+	}
+}
+
+func (f *flatFormat) close() error {
+	sort.SliceStable(f.stopTimes, func(i, j int) bool {
+		a, b := f.stopTimes[i], f.stopTimes[j]
+		if a.TripID != b.TripID {
+			return a.TripID < b.TripID
+		}
+		return a.StopSequence < b.StopSequence
+	})
+
+	file, err := f.sink.Create("schedule.csv")
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Comma = f.comma
+	w.Write(flatColumns)
+
+	for _, st := range f.stopTimes {
+		trip, tripOK := f.trips[st.TripID]
+		if trip == nil {
+			trip = make([]string, len(flatJoinColumns["trips"]))
+		}
+		route, routeOK := f.routes[trip[0]]
+		if route == nil {
+			route = make([]string, len(flatJoinColumns["routes"]))
+		}
+		stop, stopOK := f.stops[st.StopID]
+		if stop == nil {
+			stop = make([]string, len(flatJoinColumns["stops"]))
+		}
+		if !tripOK || !routeOK || !stopOK {
+			f.missing++
+		}
+
+		w.Write([]string{
+			st.TripID, trip[0], route[0], trip[1], strconv.Itoa(st.StopSequence),
+			st.StopID, stop[0], stop[1], stop[2], st.ArrivalTime, st.DepartureTime,
+		})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write row to file schedule.csv: %w", err)
+	}
+	return file.Close()
+}
+
+// flatLookup holds the columns of a kind of record joined onto the stop times, by the
+// record's id.
+type flatLookup struct {
+	header  Header
+	id      string
+	columns []string
+	rows    map[string][]string
+}
+
+func (t *flatLookup) writeRow(row []string) error {
+	values := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		values[i] = t.header.value(row, column)
+	}
+	t.rows[t.header.value(row, t.id)] = values
+	return nil
+}
+
+func (t *flatLookup) close() error {
+	return nil
+}
+
+// flatStopTimes collects the stop times written to schedule.csv.
+type flatStopTimes struct {
+	header Header
+	f      *flatFormat
+}
+
+func (t *flatStopTimes) writeRow(row []string) error {
+	st, err := ParseStopTime(t.header, row)
+	if err != nil {
+		return fmt.Errorf("stop_times.txt: %w", err)
+	}
+	t.f.stopTimes = append(t.f.stopTimes, st)
+	return nil
+}
+
+func (t *flatStopTimes) close() error {
+	return nil
+}
@@ -0,0 +1,103 @@
+package gtfs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Consolidates files into schedule.csv, returning its records and what was logged.
+func consolidateFlat(t *testing.T, files map[string]string) ([][]string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, files)
+
+	out := filepath.Join(dir, "out")
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Format = FormatFlat
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Name() != "schedule.csv" && info.Name() != ManifestFileName {
+			t.Errorf("wrote %s as well as schedule.csv", info.Name())
+		}
+	}
+	records, err := csv.NewReader(strings.NewReader(readFile(t, filepath.Join(out, "schedule.csv")))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records, logged.String()
+}
+
+func TestConsolidateFlat(t *testing.T) {
+	records, logged := consolidateFlat(t, testFeed)
+	if !reflect.DeepEqual(records[0], flatColumns) {
+		t.Errorf("got header %q, want %q", records[0], flatColumns)
+	}
+	if len(records) != 8 {
+		t.Fatalf("got %d rows, want one for each of the 7 stop times", len(records)-1)
+	}
+	// Rows are ordered by trip and then stop_sequence.
+	want := []string{"T1", "R1", "Sandringham", "City", "2", "B", "Southern Cross", "-37.8184", "144.9525", "08:05:00", "08:06:00"}
+	if !reflect.DeepEqual(records[2], want) {
+		t.Errorf("got row %q, want %q", records[2], want)
+	}
+	if want := []string{"T2", "R2", "96", "Richmond", "2", "C", "Richmond", "-37.8240", "144.9900", "24:10:00", "24:10:00"}; !reflect.DeepEqual(records[5], want) {
+		t.Errorf("got row %q, want %q", records[5], want)
+	}
+	if strings.Contains(logged, "schedule.csv") {
+		t.Errorf("logged %q, want no stop times missing references", logged)
+	}
+}
+
+func TestConsolidateFlatMissingReferences(t *testing.T) {
+	records, logged := consolidateFlat(t, withFiles(testFeed, map[string]string{
+		"stop_times.txt": testFeed["stop_times.txt"] +
+			"T9,10:00:00,10:00:00,A,1,,0,0,0\n" +
+			"T1,08:20:00,08:20:00,X,4,,0,0,3000\n",
+	}))
+
+	rows := make(map[string][]string)
+	for _, rec := range records[1:] {
+		rows[rec[0]+":"+rec[5]] = rec
+	}
+	// The columns of the missing trip and stop are left blank.
+	if want := []string{"T9", "", "", "", "1", "A", "Flinders St, Stop 1", "-37.8183", "144.9671", "10:00:00", "10:00:00"}; !reflect.DeepEqual(rows["T9:A"], want) {
+		t.Errorf("got row %q, want %q", rows["T9:A"], want)
+	}
+	if want := []string{"T1", "R1", "Sandringham", "City", "4", "X", "", "", "", "08:20:00", "08:20:00"}; !reflect.DeepEqual(rows["T1:X"], want) {
+		t.Errorf("got row %q, want %q", rows["T1:X"], want)
+	}
+	if !strings.Contains(logged, "2 stop times in schedule.csv are missing") {
+		t.Errorf("logged %q, want a count of the 2 stop times missing references", logged)
+	}
+}
+
+func TestConsolidateFlatOutputDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatFlat
+	opts.OutputDelimiter = ';'
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readRows(t, filepath.Join(out, "schedule.csv")); len(lines) != 7 || !strings.HasPrefix(lines[0], "T1;R1;Sandringham;City;1;A;Flinders St, Stop 1;") {
+		t.Errorf("got rows %q, want them separated by semicolons", lines)
+	}
+}
@@ -0,0 +1,216 @@
+package gtfs
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The output formats supported by Consolidate.
+const (
+	// FormatCSV writes one GTFS .txt file per kind of record, archived as a zip.
+	FormatCSV = "csv"
+	// FormatCSVGzip is FormatCSV with each file gzipped, as .txt.gz.
+	FormatCSVGzip = "csv.gz"
+	// FormatSQLite writes a single SQLite database with one table per kind of record.
+	FormatSQLite = "sqlite"
+)
+
+// outputFormat produces the consolidated feed in a particular format. Rows are handed
+// to the format one table at a time, and each table is closed before the format itself.
+type outputFormat interface {
+	// Returns a writer for the rows of the given kind of GTFS file, whose columns are
+	// described by header.
+	table(kind string, header []string) (tableWriter, error)
+
+	// Finishes writing the feed once every table has been closed.
+	close() error
+}
+
+// tableWriter writes the rows of a single kind of GTFS file.
+type tableWriter interface {
+	writeRow(row []string) error
+	close() error
+}
+
+// Returns the outputFormat with the given name, which writes its files to sink. A nil
+// sink writes them to the directory at path, each named with prefix at its start.
+// FormatSQLite instead writes a database alongside the directory, and can't be written
+// to a sink. The fields of FormatCSV, FormatCSVGzip and FormatFlat are separated by
+// comma.
+func newOutputFormat(name string, path, prefix string, sink Sink, comma rune) (outputFormat, error) {
+	if name == FormatSQLite {
+		if sink != nil {
+			return nil, fmt.Errorf("the %s format can't be written to a sink", name)
+		}
+		return newSQLiteFormat(outputSibling(path, prefix, ".db"))
+	}
+
+	switch name {
+	case "", FormatCSV, FormatCSVGzip, FormatGeoJSON, FormatNDJSON, FormatProtobuf, FormatParquet, FormatFlat:
+	default:
+		return nil, fmt.Errorf("unknown output format %q", name)
+	}
+	if sink == nil {
+		dir, err := newDirSink(path, prefix)
+		if err != nil {
+			return nil, err
+		}
+		sink = dir
+	}
+
+	switch name {
+	case FormatCSVGzip:
+		return &csvFormat{sink: sink, ext: "txt.gz", gzip: true, comma: comma}, nil
+	case FormatGeoJSON:
+		return &geoJSONFormat{sink: sink}, nil
+	case FormatNDJSON:
+		return &ndjsonFormat{sink: sink}, nil
+	case FormatProtobuf:
+		return &protobufFormat{sink: sink}, nil
+	case FormatParquet:
+		return &parquetFormat{sink: sink}, nil
+	case FormatFlat:
+		return &flatFormat{sink: sink, comma: comma}, nil
+	default:
+		return &csvFormat{sink: sink, ext: "txt", comma: comma}, nil
+	}
+}
+
+// outputExtensions are the extensions of the files the output formats write for each
+// kind of GTFS file, e.g. stops.txt or stops.parquet.
+var outputExtensions = []string{"txt", "txt.gz", "ndjson", "parquet", "pb"}
+
+// Returns whether name is that of a file an output format writes to a directory with
+// prefix at the start of each of its files' names, or of the output's manifest. The
+// files of another output sharing the directory don't match, even if their prefix
+// starts with this one, such as metro_regional_stops.txt for the prefix metro_.
+func isOutputFile(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	switch base := strings.TrimPrefix(name, prefix); base {
+	case ManifestFileName, "schedule.csv", "stops.geojson", "shapes.geojson":
+		return true
+	default:
+		for _, kind := range validGTFSFileNames {
+			for _, ext := range outputExtensions {
+				if base == kind+"."+ext {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns whether the output format with the given name writes each column under its
+// own name, rather than mapping the columns of each kind of GTFS file onto fixed fields.
+func formatNamesColumns(name string) bool {
+	switch name {
+	case "", FormatCSV, FormatCSVGzip, FormatSQLite:
+		return true
+	}
+	return false
+}
+
+// discardFormat writes nothing at all, for when only the consolidation statistics are wanted.
+type discardFormat struct{}
+
+func (discardFormat) table(kind string, header []string) (tableWriter, error) {
+	return discardTable{}, nil
+}
+
+func (discardFormat) close() error {
+	return nil
+}
+
+// discardTable drops every row written to it, for kinds of record a format doesn't output.
+type discardTable struct{}
+
+func (discardTable) writeRow(row []string) error { return nil }
+func (discardTable) close() error                { return nil }
+
+// csvFormat writes each kind of GTFS file to its own CSV file in a sink, optionally
+// gzipping each file.
+type csvFormat struct {
+	sink  Sink
+	ext   string
+	gzip  bool
+	comma rune
+
+	// resume gives the size of each file written by the checkpoint being resumed from,
+	// which is then carried on with rather than replaced.
+	resume map[string]int64
+}
+
+func (f *csvFormat) table(kind string, header []string) (tableWriter, error) {
+	name := fmt.Sprintf("%s.%s", kind, f.ext)
+	if size, ok := f.resume[name]; ok {
+		sink, ok := f.sink.(dirSink)
+		if !ok {
+			return nil, fmt.Errorf("unable to resume writing %s", name)
+		}
+		file, err := sink.reopen(name, size)
+		if err != nil {
+			return nil, err
+		}
+		t := &csvTable{name: name, file: file, writer: csv.NewWriter(file)}
+		t.writer.Comma = f.comma
+		return t, nil
+	}
+
+	file, err := f.sink.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &csvTable{name: name, file: file}
+	var w io.Writer = file
+	if f.gzip {
+		t.gz = gzip.NewWriter(file)
+		w = t.gz
+	}
+	t.writer = csv.NewWriter(w)
+	t.writer.Comma = f.comma
+
+	if err := t.writeRow(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (f *csvFormat) close() error {
+	return nil
+}
+
+// csvTable writes rows to a single CSV file, through gz if the file is gzipped.
+type csvTable struct {
+	name   string
+	file   io.WriteCloser
+	gz     *gzip.Writer
+	writer *csv.Writer
+}
+
+func (t *csvTable) writeRow(row []string) error {
+	if err := t.writer.Write(row); err != nil {
+		return fmt.Errorf("unable to write row to file %s: %w", t.name, err)
+	}
+	return nil
+}
+
+func (t *csvTable) close() error {
+	t.writer.Flush()
+	err := t.writer.Error()
+	if err == nil && t.gz != nil {
+		err = t.gz.Close()
+	}
+	if err != nil {
+		t.file.Close()
+		return fmt.Errorf("unable to write row to file %s: %w", t.name, err)
+	}
+	return t.file.Close()
+}
@@ -0,0 +1,77 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConsolidateCSVGzip(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	plain := filepath.Join(dir, "plain")
+	if err := Consolidate(in, plain, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	gzipped := filepath.Join(dir, "gzipped")
+	opts := testOptions(dir)
+	opts.Format = FormatCSVGzip
+	if err := Consolidate(in, gzipped, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind := range testFeed {
+		want := readFile(t, filepath.Join(plain, kind))
+		f, err := os.Open(filepath.Join(gzipped, kind+".gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte(want)) {
+			t.Errorf("gunzipped %s differs:\n%s\nwant:\n%s", kind, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(gzipped, "stops.txt")); !os.IsNotExist(err) {
+		t.Errorf("uncompressed stops.txt written alongside the gzipped one: %v", err)
+	}
+}
+
+func TestConsolidateCSVGzipArchive(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatCSVGzip
+	opts.SkipArchive = false
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(out + ".zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	found := false
+	for _, f := range r.File {
+		found = found || filepath.Base(f.Name) == "stops.txt.gz"
+	}
+	if !found {
+		t.Error("archive is missing stops.txt.gz")
+	}
+}
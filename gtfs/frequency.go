@@ -0,0 +1,187 @@
+package gtfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FrequencyDepartures returns the times at which the trip run by f departs its first
+// stop: every HeadwaySecs from StartTime for as long as it's before EndTime. Times are
+// measured from the start of the service day, as with ParseGTFSTime.
+func FrequencyDepartures(f Frequency) ([]time.Duration, error) {
+	start, err := ParseGTFSTime(f.StartTime)
+	if err != nil {
+		return nil, fmt.Errorf("frequency of trip %s: %w", f.TripID, err)
+	}
+	end, err := ParseGTFSTime(f.EndTime)
+	if err != nil {
+		return nil, fmt.Errorf("frequency of trip %s: %w", f.TripID, err)
+	}
+	if f.HeadwaySecs <= 0 {
+		return nil, fmt.Errorf("frequency of trip %s: headway_secs must be positive, not %d", f.TripID, f.HeadwaySecs)
+	}
+
+	headway := time.Duration(f.HeadwaySecs) * time.Second
+	var departures []time.Duration
+	for d := start; d < end; d += headway {
+		departures = append(departures, d)
+	}
+	return departures, nil
+}
+
+// frequencyExpander replaces each trip run to frequencies.txt with a concrete trip for
+// every one of its departures, so that the feed can be read by consumers which don't
+// understand frequencies. The rows of frequencies.txt themselves are dropped, as every
+// trip they describe is then listed in full.
+type frequencyExpander struct {
+	// departures holds the departures of each trip run to a frequency, in the order of
+	// its rows in frequencies.txt.
+	departures map[string][]time.Duration
+	// first is the time each of those trips departs its first stop in stop_times.txt,
+	// which the times of its stops are offset from.
+	first map[string]time.Duration
+}
+
+// Walks frequencies.txt and then the stop_times of the trips it runs, returning a
+// frequencyExpander for the feed beneath path. Returns an error if a row of
+// frequencies.txt can't be expanded.
+func resolveFrequencies(ctx context.Context, path string, opts walkOptions) (*frequencyExpander, error) {
+	e := &frequencyExpander{
+		departures: make(map[string][]time.Duration),
+		first:      make(map[string]time.Duration),
+	}
+
+	opts.kinds = []string{"frequencies"}
+	records, errc := walkPTVData(ctx, path, opts)
+	var firstErr error
+	for rec := range records {
+		f, err := ParseFrequency(rec.Header, rec.Contents)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", rec.Path, err)
+			}
+			continue
+		}
+		departures, err := FrequencyDepartures(f)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", rec.Path, err)
+			}
+			continue
+		}
+		e.departures[f.TripID] = append(e.departures[f.TripID], departures...)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if len(e.departures) == 0 {
+		return e, nil
+	}
+
+	// The first stop of a trip is the one with the lowest stop_sequence, wherever it
+	// falls in the file.
+	sequences := make(map[string]int, len(e.departures))
+	opts.kinds = []string{"stop_times"}
+	records, errc = walkPTVData(ctx, path, opts)
+	for rec := range records {
+		tripID := rec.Header.value(rec.Contents, "trip_id")
+		if _, ok := e.departures[tripID]; !ok {
+			continue
+		}
+		st, err := ParseStopTime(rec.Header, rec.Contents)
+		if err != nil {
+			continue
+		}
+		departure, err := ParseGTFSTime(st.DepartureTime)
+		if err != nil {
+			departure, err = ParseGTFSTime(st.ArrivalTime)
+		}
+		if err != nil {
+			continue
+		}
+		if seq, ok := sequences[tripID]; !ok || st.StopSequence < seq {
+			sequences[tripID] = st.StopSequence
+			e.first[tripID] = departure
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Returns the record, or the records it expands to: a trip run to a frequency becomes
+// one trip per departure, as do its stop times, offset to match. A nil expander
+// returns the record as it is.
+func (e *frequencyExpander) expand(rec GTFSRecord) []GTFSRecord {
+	if e == nil {
+		return []GTFSRecord{rec}
+	}
+	if rec.Type == "frequencies" {
+		return nil
+	}
+	if rec.Type != "trips" && rec.Type != "stop_times" {
+		return []GTFSRecord{rec}
+	}
+
+	tripID := rec.Header.value(rec.Contents, "trip_id")
+	departures, ok := e.departures[tripID]
+	if !ok {
+		return []GTFSRecord{rec}
+	}
+	first, ok := e.first[tripID]
+	if rec.Type == "stop_times" && !ok {
+		// Without a time at its first stop the trip's stop times can't be offset.
+		return []GTFSRecord{rec}
+	}
+
+	expanded := make([]GTFSRecord, len(departures))
+	for i, departure := range departures {
+		contents := make([]string, len(rec.Contents))
+		copy(contents, rec.Contents)
+		contents[rec.Header["trip_id"]] = expandedTripID(tripID, departure)
+		if rec.Type == "stop_times" {
+			for _, column := range []string{"arrival_time", "departure_time"} {
+				if j, ok := rec.Header[column]; ok && j < len(contents) {
+					contents[j] = offsetGTFSTime(contents[j], departure-first)
+				}
+			}
+		}
+		expanded[i] = GTFSRecord{Path: rec.Path, Type: rec.Type, Header: rec.Header, Contents: contents, Row: rec.Row}
+	}
+	return expanded
+}
+
+// Returns the trip_ids of the trips the trip with the given trip_id is expanded to,
+// or nil if it isn't run to a frequency.
+func (e *frequencyExpander) tripIDs(tripID string) []string {
+	if e == nil {
+		return nil
+	}
+	var ids []string
+	for _, departure := range e.departures[tripID] {
+		ids = append(ids, expandedTripID(tripID, departure))
+	}
+	return ids
+}
+
+// Returns the trip_id of the trip expanded from tripID which departs at departure,
+// e.g. T1_063000 for the departure of trip T1 at 06:30:00.
+func expandedTripID(tripID string, departure time.Duration) string {
+	return fmt.Sprintf("%s_%s", tripID, strings.ReplaceAll(FormatGTFSTime(departure), ":", ""))
+}
+
+// Returns the GTFS time s moved by offset. Times which are empty or can't be parsed
+// are returned as they are.
+func offsetGTFSTime(s string, offset time.Duration) string {
+	d, err := ParseGTFSTime(s)
+	if err != nil || d+offset < 0 {
+		return s
+	}
+	return FormatGTFSTime(d + offset)
+}
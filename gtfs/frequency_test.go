@@ -0,0 +1,145 @@
+package gtfs
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrequencyDepartures(t *testing.T) {
+	got, err := FrequencyDepartures(Frequency{TripID: "T1", StartTime: "06:00:00", EndTime: "07:00:00", HeadwaySecs: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The trip doesn't depart at the end time itself.
+	want := []time.Duration{6 * time.Hour}
+	for i := 1; i < 6; i++ {
+		want = append(want, 6*time.Hour+time.Duration(i)*10*time.Minute)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, f := range []Frequency{
+		{TripID: "T1", StartTime: "six", EndTime: "07:00:00", HeadwaySecs: 600},
+		{TripID: "T1", StartTime: "06:00:00", EndTime: "", HeadwaySecs: 600},
+		{TripID: "T1", StartTime: "06:00:00", EndTime: "07:00:00", HeadwaySecs: 0},
+	} {
+		if _, err := FrequencyDepartures(f); err == nil || !strings.Contains(err.Error(), "T1") {
+			t.Errorf("FrequencyDepartures(%+v): got error %v, want one naming the trip", f, err)
+		}
+	}
+}
+
+// frequencyFeed is testFeed with T1 run every 10 minutes from 08:00 until 08:30.
+var frequencyFeed = withFiles(testFeed, map[string]string{
+	"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n" +
+		"T1,08:00:00,08:30:00,600,1\n",
+})
+
+func TestLoadFeedFrequencies(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, frequencyFeed)
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Frequency{{TripID: "T1", StartTime: "08:00:00", EndTime: "08:30:00", HeadwaySecs: 600, ExactTimes: 1}}
+	if !reflect.DeepEqual(feed.Frequencies, want) {
+		t.Errorf("got frequencies %+v, want %+v", feed.Frequencies, want)
+	}
+}
+
+func TestConsolidateExpandFrequencies(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, frequencyFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.ExpandFrequencies = true
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := LoadFeed(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Frequencies) != 0 {
+		t.Errorf("got frequencies %+v, want none once expanded", feed.Frequencies)
+	}
+	if got, want := tripIDs(feed.Trips), []string{"T1_080000", "T1_081000", "T1_082000", "T2", "T3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got trips %v, want %v", got, want)
+	}
+
+	times := make(map[string][]string)
+	for _, st := range feed.StopTimes {
+		times[st.TripID] = append(times[st.TripID], st.StopID+" "+st.ArrivalTime+" "+st.DepartureTime)
+	}
+	want := map[string][]string{
+		"T1_080000": {"A 08:00:00 08:00:00", "B 08:05:00 08:06:00", "C 08:10:00 08:10:00"},
+		"T1_081000": {"A 08:10:00 08:10:00", "B 08:15:00 08:16:00", "C 08:20:00 08:20:00"},
+		"T1_082000": {"A 08:20:00 08:20:00", "B 08:25:00 08:26:00", "C 08:30:00 08:30:00"},
+		"T2":        {"B 23:55:00 23:55:00", "C 24:10:00 24:10:00"},
+		"T3":        {"A 09:00:00 09:00:00", "D 09:30:00 09:30:00"},
+	}
+	if !reflect.DeepEqual(times, want) {
+		t.Errorf("got stop times %q, want %q", times, want)
+	}
+}
+
+func TestConsolidateKeepsFrequencies(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, frequencyFeed)
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readRows(t, filepath.Join(out, "frequencies.txt")), []string{"T1,08:00:00,08:30:00,600,1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got frequencies %q, want %q", got, want)
+	}
+	if got := len(readRows(t, filepath.Join(out, "trips.txt"))); got != 3 {
+		t.Errorf("trips.txt has %d rows, want 3 without expanding", got)
+	}
+}
+
+func TestConsolidateExpandFrequenciesErrors(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n" +
+			"T1,08:00:00,08:30:00,0,1\n",
+	}))
+
+	opts := testOptions(dir)
+	opts.ExpandFrequencies = true
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err == nil || !strings.Contains(err.Error(), "headway_secs") {
+		t.Errorf("got error %v, want one of the headway", err)
+	}
+}
+
+func TestValidateFrequencyTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(frequencyFeed, map[string]string{
+		"frequencies.txt": frequencyFeed["frequencies.txt"] + "T9,09:00:00,10:00:00,600,1\n",
+	}))
+	want := DanglingReferenceError{File: "frequencies.txt", Row: 3, Column: "trip_id", Value: "T9", ReferencedFile: "trips.txt"}
+	var found bool
+	for _, err := range Validate(dir) {
+		var dangling DanglingReferenceError
+		if errors.As(err, &dangling) && dangling == want {
+			found = true
+			continue
+		}
+		t.Errorf("unexpected problem: %v", err)
+	}
+	if !found {
+		t.Errorf("%v wasn't reported", want)
+	}
+}
@@ -0,0 +1,76 @@
+package gtfs
+
+import (
+	"math"
+	"sort"
+)
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371008.8
+
+// metersPerDegreeLat is the approximate length of a degree of latitude.
+const metersPerDegreeLat = 111320.0
+
+// Transfer is a walkable connection between two nearby stops which aren't necessarily
+// connected by any trip. Distance is the great-circle distance between them in meters.
+type Transfer struct {
+	FromStopID string
+	ToStopID   string
+	Distance   float64
+}
+
+// ComputeTransfers returns a Transfer in each direction between every pair of stops
+// lying within maxMeters of one another. Stops with missing or zero coordinates are
+// skipped, and a stop never transfers to itself.
+func ComputeTransfers(stops []Stop, maxMeters float64) []Transfer {
+	located := locatedStops(stops)
+
+	// Sweep over the stops in order of latitude, so that each stop is only compared
+	// against those close enough north-south to possibly be within range.
+	sort.Slice(located, func(i, j int) bool {
+		return located[i].Lat < located[j].Lat
+	})
+	maxLat := maxMeters / metersPerDegreeLat
+
+	var transfers []Transfer
+	for i, a := range located {
+		for _, b := range located[i+1:] {
+			if b.Lat-a.Lat > maxLat {
+				break
+			}
+			if a.ID == b.ID {
+				continue
+			}
+
+			d := Haversine(a.Lat, a.Lon, b.Lat, b.Lon)
+			if d > maxMeters {
+				continue
+			}
+			transfers = append(transfers,
+				Transfer{FromStopID: a.ID, ToStopID: b.ID, Distance: d},
+				Transfer{FromStopID: b.ID, ToStopID: a.ID, Distance: d},
+			)
+		}
+	}
+	return transfers
+}
+
+// Haversine returns the great-circle distance in meters between two points given in
+// degrees. Longitudes either side of the antimeridian are handled, so 179.9 and -179.9
+// are a fifth of a degree apart rather than 359.8.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	// Rounding can push a fractionally above 1 for points on opposite sides of the Earth.
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(a, 1)))
+}
+
+// DistanceBetweenStops returns the great-circle distance in meters between two stops.
+func DistanceBetweenStops(a, b Stop) float64 {
+	return Haversine(a.Lat, a.Lon, b.Lat, b.Lon)
+}
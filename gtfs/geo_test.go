@@ -0,0 +1,78 @@
+package gtfs
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestComputeTransfers(t *testing.T) {
+	stops := []Stop{
+		{ID: "A", Lat: -37.8183, Lon: 144.9671},
+		// About 100m east of A.
+		{ID: "B", Lat: -37.8183, Lon: 144.9682},
+		// About 1km south of A.
+		{ID: "C", Lat: -37.8273, Lon: 144.9671},
+		{ID: "Z", Lat: 0, Lon: 0},
+	}
+	transfers := ComputeTransfers(stops, 200)
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].FromStopID < transfers[j].FromStopID })
+
+	var pairs [][2]string
+	for _, tr := range transfers {
+		pairs = append(pairs, [2]string{tr.FromStopID, tr.ToStopID})
+		if tr.Distance < 90 || tr.Distance > 110 {
+			t.Errorf("got a distance of %.1fm from %s to %s, want about 100m", tr.Distance, tr.FromStopID, tr.ToStopID)
+		}
+	}
+	if want := [][2]string{{"A", "B"}, {"B", "A"}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("got transfers %v, want %v", pairs, want)
+	}
+}
+
+func TestComputeTransfersNotToItself(t *testing.T) {
+	// The same stop may be listed more than once, such as by several feeds.
+	stops := []Stop{{ID: "A", Lat: -37.8183, Lon: 144.9671}, {ID: "A", Lat: -37.8183, Lon: 144.9671}}
+	if transfers := ComputeTransfers(stops, 200); len(transfers) != 0 {
+		t.Errorf("got transfers %+v from a stop to itself", transfers)
+	}
+}
+
+func TestHaversine(t *testing.T) {
+	tests := []struct {
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{0, 0, 0, 1, 111195},
+		{0, 0, 1, 0, 111195},
+		{-37.8183, 144.9671, -37.8183, 144.9671, 0},
+		// Either side of the antimeridian.
+		{0, 179.9, 0, -179.9, 22239},
+		// Flinders Street to Southern Cross, and Melbourne to Sydney.
+		{-37.8183, 144.9671, -37.8184, 144.9525, 1282.5},
+		{-37.8136, 144.9631, -33.8688, 151.2093, 713427},
+		// Opposite sides of the Earth are half its circumference apart.
+		{-37.8136, 144.9631, 37.8136, -35.0369, 20015087},
+	}
+	for _, tt := range tests {
+		// Within a metre, or 0.01% of the longer reference distances.
+		if got := Haversine(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-tt.want) > math.Max(1, tt.want*1e-4) {
+			t.Errorf("Haversine(%v, %v, %v, %v) = %.1f, want %.1f", tt.lat1, tt.lon1, tt.lat2, tt.lon2, got, tt.want)
+		}
+	}
+}
+
+func TestDistanceBetweenStops(t *testing.T) {
+	flinders := Stop{ID: "A", Lat: -37.8183, Lon: 144.9671}
+	southernCross := Stop{ID: "B", Lat: -37.8184, Lon: 144.9525}
+	if got := DistanceBetweenStops(flinders, southernCross); math.Abs(got-1282.5) > 1 {
+		t.Errorf("got %.1fm from Flinders Street to Southern Cross, want about 1282.5m", got)
+	}
+	if a, b := DistanceBetweenStops(flinders, southernCross), DistanceBetweenStops(southernCross, flinders); a != b {
+		t.Errorf("got %.1fm there but %.1fm back", a, b)
+	}
+	if got := DistanceBetweenStops(flinders, flinders); got != 0 {
+		t.Errorf("got %.1fm from a stop to itself", got)
+	}
+}
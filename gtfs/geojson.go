@@ -0,0 +1,186 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// FormatGeoJSON writes stops as Point features to stops.geojson and shapes as
+// LineString features to shapes.geojson. Other kinds of record are not written.
+const FormatGeoJSON = "geojson"
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoJSONFormat writes the stops and shapes of a feed as GeoJSON FeatureCollections.
+type geoJSONFormat struct {
+	sink Sink
+}
+
+func (f *geoJSONFormat) table(kind string, header []string) (tableWriter, error) {
+	switch kind {
+	case "stops":
+		c, err := newFeatureCollection(f.sink, "stops.geojson")
+		if err != nil {
+			return nil, err
+		}
+		return &geoJSONStops{header: NewHeader(header), c: c}, nil
+	case "shapes":
+		return &geoJSONShapes{
+			header: NewHeader(header),
+			sink:   f.sink,
+			points: make(map[string][]ShapePoint),
+		}, nil
+	default:
+		return discardTable{}, nil
+	}
+}
+
+func (f *geoJSONFormat) close() error {
+	return nil
+}
+
+// geoJSONStops writes each stop as a Point feature as soon as it arrives.
+type geoJSONStops struct {
+	header Header
+	c      *featureCollection
+}
+
+func (t *geoJSONStops) writeRow(row []string) error {
+	stop, err := ParseStop(t.header, row)
+	if err != nil {
+		return fmt.Errorf("stops.txt: %w", err)
+	}
+
+	return t.c.write(geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONGeometry{
+			Type:        "Point",
+			Coordinates: [2]float64{stop.Lon, stop.Lat},
+		},
+		Properties: map[string]interface{}{
+			"stop_id":             stop.ID,
+			"stop_name":           stop.Name,
+			"wheelchair_boarding": stop.WheelchairBoarding,
+		},
+	})
+}
+
+func (t *geoJSONStops) close() error {
+	return t.c.close()
+}
+
+// geoJSONShapes collects the points of every shape, as they may arrive in any order,
+// and writes each shape as a LineString feature on close.
+type geoJSONShapes struct {
+	header Header
+	sink   Sink
+	points map[string][]ShapePoint
+}
+
+func (t *geoJSONShapes) writeRow(row []string) error {
+	point, err := ParseShapePoint(t.header, row)
+	if err != nil {
+		return fmt.Errorf("shapes.txt: %w", err)
+	}
+	t.points[point.ShapeID] = append(t.points[point.ShapeID], point)
+	return nil
+}
+
+func (t *geoJSONShapes) close() error {
+	c, err := newFeatureCollection(t.sink, "shapes.geojson")
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(t.points))
+	for id := range t.points {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		points := t.points[id]
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].Sequence < points[j].Sequence
+		})
+
+		coords := make([][2]float64, len(points))
+		for i, p := range points {
+			coords[i] = [2]float64{p.Lon, p.Lat}
+		}
+
+		err := c.write(geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{
+				Type:        "LineString",
+				Coordinates: coords,
+			},
+			Properties: map[string]interface{}{
+				"shape_id": id,
+			},
+		})
+		if err != nil {
+			c.close()
+			return err
+		}
+	}
+	return c.close()
+}
+
+// featureCollection streams features to a GeoJSON FeatureCollection file, so that
+// the collection never has to be held in memory as a whole.
+type featureCollection struct {
+	name string
+	file io.WriteCloser
+	w    *bufio.Writer
+	n    int
+}
+
+func newFeatureCollection(sink Sink, name string) (*featureCollection, error) {
+	file, err := sink.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &featureCollection{name: name, file: file, w: bufio.NewWriter(file)}
+	c.w.WriteString(`{"type":"FeatureCollection","features":[`)
+	return c, nil
+}
+
+func (c *featureCollection) write(feature geoJSONFeature) error {
+	b, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+
+	if c.n > 0 {
+		c.w.WriteString(",")
+	}
+	c.w.WriteString("\n")
+	c.n++
+	if _, err := c.w.Write(b); err != nil {
+		return fmt.Errorf("unable to write feature to file %s: %w", c.name, err)
+	}
+	return nil
+}
+
+func (c *featureCollection) close() error {
+	c.w.WriteString("\n]}\n")
+	if err := c.w.Flush(); err != nil {
+		c.file.Close()
+		return fmt.Errorf("unable to write to file %s: %w", c.name, err)
+	}
+	return c.file.Close()
+}
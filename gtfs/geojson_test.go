@@ -0,0 +1,89 @@
+package gtfs
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testFeatureCollection is the part of a GeoJSON FeatureCollection checked by the tests.
+type testFeatureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"features"`
+}
+
+func readFeatureCollection(t *testing.T, path string) testFeatureCollection {
+	t.Helper()
+	var c testFeatureCollection
+	if err := json.Unmarshal([]byte(readFile(t, path)), &c); err != nil {
+		t.Fatalf("%s isn't valid GeoJSON: %v", path, err)
+	}
+	if c.Type != "FeatureCollection" {
+		t.Fatalf("%s is a %q, not a FeatureCollection", path, c.Type)
+	}
+	return c
+}
+
+func TestConsolidateGeoJSON(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatGeoJSON
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	stops := readFeatureCollection(t, filepath.Join(out, "stops.geojson"))
+	if len(stops.Features) != 4 {
+		t.Fatalf("got %d stops, want 4", len(stops.Features))
+	}
+	for _, f := range stops.Features {
+		if f.Type != "Feature" || f.Geometry.Type != "Point" {
+			t.Errorf("stop is a %s %s, not a Point Feature", f.Geometry.Type, f.Type)
+		}
+		if f.Properties["stop_id"] == "B" {
+			var point [2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &point); err != nil {
+				t.Fatal(err)
+			}
+			// GeoJSON positions give the longitude first.
+			if point != [2]float64{144.9525, -37.8184} {
+				t.Errorf("got stop B at %v", point)
+			}
+			if f.Properties["stop_name"] != "Southern Cross" {
+				t.Errorf("got stop B named %v", f.Properties["stop_name"])
+			}
+		}
+	}
+
+	shapes := readFeatureCollection(t, filepath.Join(out, "shapes.geojson"))
+	lines := make(map[interface{}][][2]float64)
+	for _, f := range shapes.Features {
+		if f.Geometry.Type != "LineString" {
+			t.Errorf("shape is a %s, not a LineString", f.Geometry.Type)
+		}
+		var line [][2]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &line); err != nil {
+			t.Fatal(err)
+		}
+		lines[f.Properties["shape_id"]] = line
+	}
+	// SH2's points are given out of sequence.
+	if want := [][2]float64{{144.9525, -37.8184}, {144.99, -37.824}}; !reflect.DeepEqual(lines["SH2"], want) {
+		t.Errorf("got SH2 %v, want %v", lines["SH2"], want)
+	}
+	if len(lines) != 2 {
+		t.Errorf("got %d shapes, want 2", len(lines))
+	}
+}
@@ -0,0 +1,79 @@
+package gtfs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TripGeometry returns the path taken by the trip with the given trip_id as a list of
+// [longitude, latitude] pairs, the order used by GeoJSON LineStrings. The path follows
+// the points of the trip's shape in order of their sequence. A trip without a shape_id,
+// or whose shape has no points, instead takes straight lines between its stops in
+// order of their stop_sequence.
+//
+// Returns an error if the trip can't be found, or if it has to fall back to its stops
+// and has no stop times or calls at a stop which can't be found.
+func TripGeometry(tripID string, trips []Trip, shapes []ShapePoint, stopTimes []StopTime, stops []Stop) ([][2]float64, error) {
+	var trip *Trip
+	for i := range trips {
+		if trips[i].ID == tripID {
+			trip = &trips[i]
+			break
+		}
+	}
+	if trip == nil {
+		return nil, fmt.Errorf("trip %q not found", tripID)
+	}
+
+	if trip.ShapeID != "" {
+		var points []ShapePoint
+		for _, p := range shapes {
+			if p.ShapeID == trip.ShapeID {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			sort.SliceStable(points, func(i, j int) bool {
+				return points[i].Sequence < points[j].Sequence
+			})
+			coords := make([][2]float64, len(points))
+			for i, p := range points {
+				coords[i] = [2]float64{p.Lon, p.Lat}
+			}
+			return coords, nil
+		}
+	}
+
+	var sts []StopTime
+	for _, st := range stopTimes {
+		if st.TripID == tripID {
+			sts = append(sts, st)
+		}
+	}
+	if len(sts) == 0 {
+		return nil, fmt.Errorf("trip %q has no shape or stop times", tripID)
+	}
+	sort.SliceStable(sts, func(i, j int) bool {
+		return sts[i].StopSequence < sts[j].StopSequence
+	})
+
+	byID := make(map[string]Stop, len(stops))
+	for _, s := range stops {
+		byID[s.ID] = s
+	}
+	coords := make([][2]float64, len(sts))
+	for i, st := range sts {
+		s, ok := byID[st.StopID]
+		if !ok {
+			return nil, fmt.Errorf("stop %q of trip %q not found", st.StopID, tripID)
+		}
+		coords[i] = [2]float64{s.Lon, s.Lat}
+	}
+	return coords, nil
+}
+
+// TripGeometry returns the path taken by the trip with the given trip_id, as described
+// by the TripGeometry function.
+func (f *Feed) TripGeometry(tripID string) ([][2]float64, error) {
+	return TripGeometry(tripID, f.Trips, f.Shapes, f.StopTimes, f.Stops)
+}
@@ -0,0 +1,86 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns testFeed loaded from a directory.
+func loadTestFeed(t *testing.T) *Feed {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+func TestTripGeometry(t *testing.T) {
+	feed := loadTestFeed(t)
+	// The points of SH2 are given out of sequence.
+	got, err := feed.TripGeometry("T2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]float64{{144.9525, -37.8184}, {144.9900, -37.8240}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got, err = feed.TripGeometry("T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][2]float64{{144.9671, -37.8183}, {144.9525, -37.8184}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for T1, want %v", got, want)
+	}
+}
+
+func TestTripGeometryFallsBackToStops(t *testing.T) {
+	trips := []Trip{{ID: "T1"}, {ID: "T2", ShapeID: "SH9"}}
+	stopTimes := []StopTime{
+		{TripID: "T1", StopID: "C", StopSequence: 3},
+		{TripID: "T2", StopID: "B", StopSequence: 1},
+		{TripID: "T1", StopID: "A", StopSequence: 1},
+		{TripID: "T1", StopID: "B", StopSequence: 2},
+	}
+	stops := []Stop{
+		{ID: "A", Lat: -37.8183, Lon: 144.9671},
+		{ID: "B", Lat: -37.8184, Lon: 144.9525},
+		{ID: "C", Lat: -37.8240, Lon: 144.9900},
+	}
+
+	got, err := TripGeometry("T1", trips, nil, stopTimes, stops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]float64{{144.9671, -37.8183}, {144.9525, -37.8184}, {144.9900, -37.8240}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A shape_id without any points falls back too.
+	got, err = TripGeometry("T2", trips, []ShapePoint{{ShapeID: "SH1"}}, stopTimes, stops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]float64{{144.9525, -37.8184}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for T2, want %v", got, want)
+	}
+}
+
+func TestTripGeometryErrors(t *testing.T) {
+	trips := []Trip{{ID: "T1"}, {ID: "T2"}}
+	stopTimes := []StopTime{{TripID: "T1", StopID: "Z", StopSequence: 1}}
+	for _, tripID := range []string{"T9", "T1", "T2"} {
+		if _, err := TripGeometry(tripID, trips, nil, stopTimes, nil); err == nil {
+			t.Errorf("got no error for %s", tripID)
+		}
+	}
+	if _, err := loadTestFeed(t).TripGeometry("T9"); err == nil {
+		t.Error("got no error for a trip the feed doesn't have")
+	}
+}
@@ -0,0 +1,119 @@
+package gtfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// requiredColumns are the columns the GTFS reference requires of each kind of file.
+// Columns which are only conditionally required, such as a stop time's arrival_time,
+// are treated as optional.
+var requiredColumns = map[string][]string{
+	"agency":         {"agency_name", "agency_url", "agency_timezone"},
+	"calendar_dates": {"service_id", "date", "exception_type"},
+	"calendar":       {"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+	"routes":         {"route_id", "route_type"},
+	"stop_times":     {"trip_id", "stop_id", "stop_sequence"},
+	"stops":          {"stop_id", "stop_name", "stop_lat", "stop_lon"},
+	"trips":          {"route_id", "service_id", "trip_id"},
+	"shapes":         {"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"},
+	"frequencies":    {"trip_id", "start_time", "end_time", "headway_secs"},
+	"transfers":      {"from_stop_id", "to_stop_id", "transfer_type"},
+}
+
+// optionalColumns are the other columns the GTFS reference defines for each kind of
+// file.
+var optionalColumns = map[string][]string{
+	"agency":         {"agency_id", "agency_lang", "agency_phone", "agency_fare_url", "agency_email"},
+	"calendar_dates": {},
+	"calendar":       {},
+	"routes":         {"agency_id", "route_short_name", "route_long_name", "route_desc", "route_url", "route_color", "route_text_color", "route_sort_order", "continuous_pickup", "continuous_drop_off", "network_id"},
+	"stop_times":     {"arrival_time", "departure_time", "stop_headsign", "pickup_type", "drop_off_type", "continuous_pickup", "continuous_drop_off", "shape_dist_traveled", "timepoint"},
+	"stops":          {"stop_code", "stop_desc", "tts_stop_name", "zone_id", "stop_url", "location_type", "parent_station", "stop_timezone", "wheelchair_boarding", "level_id", "platform_code"},
+	"trips":          {"trip_headsign", "trip_short_name", "direction_id", "block_id", "shape_id", "wheelchair_accessible", "bikes_allowed"},
+	"shapes":         {"shape_dist_traveled"},
+	"frequencies":    {"exact_times"},
+	"transfers":      {"min_transfer_time", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id"},
+}
+
+// HeaderIssue describes a problem with the header row of a GTFS file. File is the path
+// of the file relative to the directory checked, and Column the column concerned, if
+// any. Fatal is set for problems which leave the file unusable, such as a missing
+// required column, and unset for those only worth a warning, such as a column the GTFS
+// reference doesn't define.
+type HeaderIssue struct {
+	File    string
+	Column  string
+	Message string
+	Fatal   bool
+}
+
+func (i HeaderIssue) Error() string {
+	if i.Column == "" {
+		return fmt.Sprintf("%s: %s", i.File, i.Message)
+	}
+	return fmt.Sprintf("%s: column %s %s", i.File, i.Column, i.Message)
+}
+
+// ValidateHeaders checks the header row of every GTFS file beneath dir, such as a feed
+// extracted before it's consolidated, against the columns the GTFS reference defines
+// for its kind of file. A fatal HeaderIssue is returned for each required column a
+// header is missing, and for each file whose header can't be read, and a HeaderIssue
+// which isn't fatal for each column which isn't defined for the file, such as one
+// misspelt. Issues are returned in the order the files are walked, and within each file
+// the missing columns come before the unexpected ones.
+func ValidateHeaders(dir string) []HeaderIssue {
+	var issues []HeaderIssue
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !fileIsGTFSFile(info.Name(), validGTFSFileNames) {
+			return nil
+		}
+
+		file, err := filepath.Rel(dir, path)
+		if err != nil {
+			file = path
+		}
+		header, err := readHeader(path, ',')
+		if err != nil {
+			issues = append(issues, HeaderIssue{File: file, Message: err.Error(), Fatal: true})
+			return nil
+		}
+		issues = append(issues, headerIssues(file, strings.Split(info.Name(), ".")[0], header)...)
+		return nil
+	})
+	if err != nil {
+		issues = append(issues, HeaderIssue{File: dir, Message: err.Error(), Fatal: true})
+	}
+	return issues
+}
+
+// Returns the issues with the header of a file of the given kind.
+func headerIssues(file, kind string, header []string) []HeaderIssue {
+	present := make(map[string]bool, len(header))
+	for _, column := range header {
+		present[strings.TrimSpace(column)] = true
+	}
+
+	var issues []HeaderIssue
+	defined := make(map[string]bool)
+	for _, column := range requiredColumns[kind] {
+		defined[column] = true
+		if !present[column] {
+			issues = append(issues, HeaderIssue{File: file, Column: column, Message: "is required but missing", Fatal: true})
+		}
+	}
+	for _, column := range optionalColumns[kind] {
+		defined[column] = true
+	}
+	for _, column := range header {
+		if column = strings.TrimSpace(column); !defined[column] {
+			issues = append(issues, HeaderIssue{File: file, Column: column, Message: "isn't defined by the GTFS reference"})
+		}
+	}
+	return issues
+}
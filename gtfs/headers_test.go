@@ -0,0 +1,54 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateHeaders(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, filepath.Join(dir, "1"), testFeed)
+	// Spaces around the names of columns are ignored.
+	writeFiles(t, filepath.Join(dir, "2"), withFiles(testFeed, map[string]string{
+		"stops.txt":  "stop_id,stop_nmae,stop_lon\nA,Flinders St,144.9671\n",
+		"routes.txt": "route_id, route_type ,route_short_name\nR1,2,Sandringham\n",
+	}))
+
+	want := []HeaderIssue{
+		{File: filepath.Join("2", "stops.txt"), Column: "stop_name", Message: "is required but missing", Fatal: true},
+		{File: filepath.Join("2", "stops.txt"), Column: "stop_lat", Message: "is required but missing", Fatal: true},
+		{File: filepath.Join("2", "stops.txt"), Column: "stop_nmae", Message: "isn't defined by the GTFS reference"},
+	}
+	if got := ValidateHeaders(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got issues %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateHeadersUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{"trips.txt": "\"route_id,trip_id\n"}))
+	issues := ValidateHeaders(dir)
+	if len(issues) != 1 || issues[0].File != "trips.txt" || !issues[0].Fatal {
+		t.Errorf("got issues %+v, want one that trips.txt can't be read", issues)
+	}
+
+	issues = ValidateHeaders(filepath.Join(dir, "missing"))
+	if len(issues) != 1 || !issues[0].Fatal {
+		t.Errorf("got issues %+v, want one that the directory can't be walked", issues)
+	}
+}
+
+func TestHeaderIssueError(t *testing.T) {
+	for _, tt := range []struct {
+		issue HeaderIssue
+		want  string
+	}{
+		{HeaderIssue{File: "stops.txt", Column: "stop_lat", Message: "is required but missing", Fatal: true}, "stops.txt: column stop_lat is required but missing"},
+		{HeaderIssue{File: "trips.txt", Message: "EOF", Fatal: true}, "trips.txt: EOF"},
+	} {
+		if got := tt.issue.Error(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
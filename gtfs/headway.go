@@ -0,0 +1,146 @@
+package gtfs
+
+import (
+	"sort"
+	"time"
+)
+
+// HeadwayBucket summarises the departures of a route within one hour of the service
+// day. Hour counts from the start of the service day, so trips departing after
+// midnight but belonging to the previous day fall into hours 24 and beyond.
+// AverageHeadway is the mean time since the route's previous departure over the
+// departures in the hour, leaving out the route's first departure of the day; it's
+// zero if that leaves none.
+type HeadwayBucket struct {
+	RouteID        string
+	Hour           int
+	Departures     int
+	AverageHeadway time.Duration
+}
+
+// HeadwaySummary returns a HeadwayBucket for every hour in which each route of the GTFS
+// feed in dir has at least one departure. Returns nil if the feed can't be loaded; use
+// LoadFeed and Feed.HeadwaySummary to find out why.
+func HeadwaySummary(dir string) []HeadwayBucket {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil
+	}
+	return feed.HeadwaySummary()
+}
+
+// HeadwaySummary returns a HeadwayBucket for every hour in which each route of the feed
+// has at least one departure, ordered by route as in f.Routes and then by hour. A trip
+// departs at the departure_time of its first stop; trips whose first departure can't be
+// parsed are left out.
+//
+// The trips of every service are counted together, so a feed running different
+// timetables on different days should first be filtered to a single day, e.g. with
+// Filter.ActiveOn.
+func (f *Feed) HeadwaySummary() []HeadwayBucket {
+	byTrip := make(map[string][]StopTime)
+	for _, st := range f.StopTimes {
+		byTrip[st.TripID] = append(byTrip[st.TripID], st)
+	}
+
+	departures := make(map[string][]time.Duration, len(f.Routes))
+	for _, trip := range f.Trips {
+		sts := byTrip[trip.ID]
+		if len(sts) == 0 {
+			continue
+		}
+		first := sts[0]
+		for _, st := range sts[1:] {
+			if st.StopSequence < first.StopSequence {
+				first = st
+			}
+		}
+
+		departure, err := ParseGTFSTime(first.DepartureTime)
+		if err != nil {
+			continue
+		}
+		departures[trip.RouteID] = append(departures[trip.RouteID], departure)
+	}
+
+	var buckets []HeadwayBucket
+	for _, r := range f.Routes {
+		deps := departures[r.ID]
+		sort.Slice(deps, func(i, j int) bool { return deps[i] < deps[j] })
+
+		var bucket *HeadwayBucket
+		var total time.Duration
+		var headways int
+		finish := func() {
+			if bucket == nil {
+				return
+			}
+			if headways > 0 {
+				bucket.AverageHeadway = total / time.Duration(headways)
+			}
+			buckets = append(buckets, *bucket)
+		}
+
+		for i, d := range deps {
+			hour := int(d / time.Hour)
+			if bucket == nil || bucket.Hour != hour {
+				finish()
+				bucket = &HeadwayBucket{RouteID: r.ID, Hour: hour}
+				total, headways = 0, 0
+			}
+			bucket.Departures++
+			if i > 0 {
+				total += d - deps[i-1]
+				headways++
+			}
+		}
+		finish()
+	}
+	return buckets
+}
+
+// StopDepartureHeatmap returns the number of departures from each stop of the GTFS feed
+// in dir in each hour of the day, keyed by stop_id. Returns nil if the feed can't be
+// loaded; use LoadFeed and Feed.StopDepartureHeatmap to find out why.
+func StopDepartureHeatmap(dir string) map[string][24]int {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil
+	}
+	return feed.StopDepartureHeatmap()
+}
+
+// StopDepartureHeatmap returns the number of departures from each stop of the feed in
+// each hour of the day, keyed by stop_id. Every stop time departs its stop, at its
+// departure_time or failing that its arrival_time, except the last of each trip, where
+// the trip ends instead. Times past midnight wrap around into the hours of the next
+// day, so a departure at 24:10:00 falls into hour 0. Stops without any departures are
+// left out, as are stop times whose time can't be parsed.
+//
+// As with HeadwaySummary, the trips of every service are counted together.
+func (f *Feed) StopDepartureHeatmap() map[string][24]int {
+	last := make(map[string]int)
+	for _, st := range f.StopTimes {
+		if seq, ok := last[st.TripID]; !ok || st.StopSequence > seq {
+			last[st.TripID] = st.StopSequence
+		}
+	}
+
+	heatmap := make(map[string][24]int)
+	for _, st := range f.StopTimes {
+		if st.StopSequence == last[st.TripID] {
+			continue
+		}
+		departure, err := ParseGTFSTime(st.DepartureTime)
+		if err != nil {
+			departure, err = ParseGTFSTime(st.ArrivalTime)
+		}
+		if err != nil {
+			continue
+		}
+		hours := heatmap[st.StopID]
+		hours[int(departure/time.Hour)%24]++
+		heatmap[st.StopID] = hours
+	}
+	return heatmap
+}
@@ -0,0 +1,103 @@
+package gtfs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns a feed of route R1 with trips departing at each of departures, each calling
+// at two stops. The stop times of each trip are given last stop first.
+func headwayFeed(departures ...string) *Feed {
+	feed := &Feed{Routes: []Route{{ID: "R1"}, {ID: "R2"}}}
+	for i, d := range departures {
+		id := fmt.Sprint("T", i)
+		feed.Trips = append(feed.Trips, Trip{ID: id, RouteID: "R1"})
+		feed.StopTimes = append(feed.StopTimes,
+			StopTime{TripID: id, StopID: "B", StopSequence: 2, DepartureTime: "27:00:00"},
+			StopTime{TripID: id, StopID: "A", StopSequence: 1, DepartureTime: d},
+		)
+	}
+	return feed
+}
+
+func TestFeedHeadwaySummary(t *testing.T) {
+	feed := headwayFeed("08:40:00", "08:00:00", "08:20:00", "09:10:00", "23:50:00", "24:10:00", "24:30:00", "")
+	got := feed.HeadwaySummary()
+	want := []HeadwayBucket{
+		// The first departure of the day has no headway.
+		{RouteID: "R1", Hour: 8, Departures: 3, AverageHeadway: 20 * time.Minute},
+		{RouteID: "R1", Hour: 9, Departures: 1, AverageHeadway: 30 * time.Minute},
+		{RouteID: "R1", Hour: 23, Departures: 1, AverageHeadway: 14*time.Hour + 40*time.Minute},
+		{RouteID: "R1", Hour: 24, Departures: 2, AverageHeadway: 20 * time.Minute},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFeedHeadwaySummaryFirstDepartureAlone(t *testing.T) {
+	got := headwayFeed("07:15:00").HeadwaySummary()
+	want := []HeadwayBucket{{RouteID: "R1", Hour: 7, Departures: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHeadwaySummary(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	got := HeadwaySummary(dir)
+	want := []HeadwayBucket{
+		{RouteID: "R1", Hour: 8, Departures: 1},
+		{RouteID: "R1", Hour: 9, Departures: 1, AverageHeadway: time.Hour},
+		{RouteID: "R2", Hour: 23, Departures: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	writeFiles(t, dir, map[string]string{"stop_times.txt": "trip_id,stop_sequence\nT1,first\n"})
+	if got := HeadwaySummary(dir); got != nil {
+		t.Errorf("got %+v for a feed which can't be loaded, want nil", got)
+	}
+}
+
+func TestFeedStopDepartureHeatmap(t *testing.T) {
+	feed := headwayFeed("08:40:00", "08:00:00", "08:20:00", "09:10:00", "24:10:00", "")
+	// A stop time without a departure_time departs at its arrival_time.
+	feed.StopTimes = append(feed.StopTimes,
+		StopTime{TripID: "T9", StopID: "C", StopSequence: 1, ArrivalTime: "17:05:00"},
+		StopTime{TripID: "T9", StopID: "B", StopSequence: 2, ArrivalTime: "17:15:00"},
+	)
+
+	var a, c [24]int
+	a[8], a[9] = 3, 1
+	// 24:10:00 wraps around to the first hour of the day.
+	a[0] = 1
+	c[17] = 1
+	// B ends every trip, so nothing departs from it.
+	want := map[string][24]int{"A": a, "C": c}
+	if got := feed.StopDepartureHeatmap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestStopDepartureHeatmap(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+
+	var a, b [24]int
+	a[8], a[9] = 1, 1
+	b[8], b[23] = 1, 1
+	want := map[string][24]int{"A": a, "B": b}
+	if got := StopDepartureHeatmap(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	writeFiles(t, dir, map[string]string{"stop_times.txt": "trip_id,stop_sequence\nT1,first\n"})
+	if got := StopDepartureHeatmap(dir); got != nil {
+		t.Errorf("got %v for a feed which can't be loaded, want nil", got)
+	}
+}
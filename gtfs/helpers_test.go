@@ -0,0 +1,139 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFeed is a small feed of two routes and three trips. T2 runs past midnight and T3
+// calls at a stop far from the others. S1 runs until 2099, while S2 has long expired.
+var testFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name,agency_url,agency_timezone,agency_lang\n" +
+		"1,PTV,http://ptv.vic.gov.au,Australia/Melbourne,EN\n",
+	"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+		"S1,1,1,1,1,1,0,0,20240101,20991231\n" +
+		"S2,0,0,0,0,0,1,1,20200101,20201231\n",
+	"calendar_dates.txt": "service_id,date,exception_type\n" +
+		"S3,20240601,1\n" +
+		"S1,20240603,2\n",
+	"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type,route_color,route_text_color\n" +
+		"R1,1,Sandringham,\"Sandringham, City\",2,FF0000,FFFFFF\n" +
+		"R2,1,96,,0,,\n",
+	"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,wheelchair_boarding\n" +
+		"A,\"Flinders St, Stop 1\",-37.8183,144.9671,1\n" +
+		"B,Southern Cross,-37.8184,144.9525,2\n" +
+		"C,Richmond,-37.8240,144.9900,1\n" +
+		"D,Far Away,-36.0,146.0,0\n",
+	"trips.txt": "route_id,service_id,trip_id,shape_id,trip_headsign,direction_id,wheelchair_accessible\n" +
+		"R1,S1,T1,SH1,City,0,1\n" +
+		"R2,S3,T2,SH2,Richmond,1,2\n" +
+		"R1,S2,T3,SH1,City,0,1\n",
+	"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,stop_headsign,pickup_type,drop_off_type,shape_dist_traveled\n" +
+		"T1,08:00:00,08:00:00,A,1,,0,0,0\n" +
+		"T1,08:05:00,08:06:00,B,2,,0,0,1300\n" +
+		"T1,08:10:00,08:10:00,C,3,,0,0,2000\n" +
+		"T2,23:55:00,23:55:00,B,1,,0,0,0\n" +
+		"T2,24:10:00,24:10:00,C,2,,0,0,3000\n" +
+		"T3,09:00:00,09:00:00,A,1,,0,0,0\n" +
+		"T3,09:30:00,09:30:00,D,2,,0,0,1000\n",
+	"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence,shape_dist_traveled\n" +
+		"SH1,-37.8183,144.9671,1,0\n" +
+		"SH1,-37.8184,144.9525,2,1300\n" +
+		"SH2,-37.8240,144.9900,2,3000\n" +
+		"SH2,-37.8184,144.9525,1,0\n",
+}
+
+// Returns a copy of files with the given files replaced, or removed if their contents
+// are empty.
+func withFiles(files map[string]string, replace map[string]string) map[string]string {
+	out := make(map[string]string, len(files))
+	for name, contents := range files {
+		out[name] = contents
+	}
+	for name, contents := range replace {
+		if contents == "" {
+			delete(out, name)
+			continue
+		}
+		out[name] = contents
+	}
+	return out
+}
+
+// Writes files to dir, creating it if need be.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the bytes of a zip holding files.
+func zipBytes(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, contents := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Writes a zip laid out as PTV supplies its feeds to path: a directory for each of
+// feeds, holding a google_transit.zip of the feed's files.
+func writePTVZip(t *testing.T, path string, feeds map[string]map[string]string) {
+	t.Helper()
+	outer := make(map[string][]byte, len(feeds))
+	for dir, files := range feeds {
+		inner := make(map[string][]byte, len(files))
+		for name, contents := range files {
+			inner[name] = []byte(contents)
+		}
+		outer[dir+"/"+innerZipFileName] = zipBytes(t, inner)
+	}
+	if err := ioutil.WriteFile(path, zipBytes(t, outer), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the contents of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// Returns the rows of the CSV file at path, bar its header, as lines.
+func readRows(t *testing.T, path string) []string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+	return lines[1:]
+}
+
+// Returns Options consolidating within dir without archiving the output or logging
+// anything but errors.
+func testOptions(dir string) Options {
+	return Options{TmpDir: dir, SkipArchive: true, Logger: NewLogger(ioutil.Discard, LevelError)}
+}
@@ -0,0 +1,196 @@
+package gtfs
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// StopIndex is a k-d tree of stops for answering many nearest stop queries without
+// scanning every stop each time. Build one with BuildStopIndex.
+//
+// The stops are held as points on a unit sphere rather than by latitude and longitude,
+// so that straight-line distances within the tree rise and fall with the great-circle
+// distances between the stops, even across the antimeridian.
+type StopIndex struct {
+	// stops is the tree itself: the median of each range of stops sits at its middle, with
+	// the stops nearer on that level's axis before it and those further after.
+	stops []indexedStop
+}
+
+// indexedStop is a stop along with its position on a unit sphere.
+type indexedStop struct {
+	stop Stop
+	p    [3]float64
+}
+
+// chordSlack widens the distances at which branches of the tree are pruned, so that
+// rounding never prunes a stop exactly on the boundary.
+const chordSlack = 1e-9
+
+// BuildStopIndex returns an index of the stops, skipping those with missing or zero
+// coordinates as NearestStops does.
+func BuildStopIndex(stops []Stop) *StopIndex {
+	located := locatedStops(stops)
+	idx := &StopIndex{stops: make([]indexedStop, len(located))}
+	for i, s := range located {
+		idx.stops[i] = indexedStop{stop: s, p: toUnitSphere(s.Lat, s.Lon)}
+	}
+	buildTree(idx.stops, 0)
+	return idx
+}
+
+// Arranges stops into a k-d tree, splitting on the given axis and cycling through the
+// others at each level below.
+func buildTree(stops []indexedStop, axis int) {
+	if len(stops) <= 1 {
+		return
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].p[axis] < stops[j].p[axis]
+	})
+	mid := len(stops) / 2
+	buildTree(stops[:mid], (axis+1)%3)
+	buildTree(stops[mid+1:], (axis+1)%3)
+}
+
+// Nearest returns the k stops closest to the point lat, lon, nearest first, exactly as
+// NearestStops would for the stops of the index.
+func (idx *StopIndex) Nearest(lat, lon float64, k int) []Stop {
+	return idx.nearest(lat, lon, k)
+}
+
+func (idx *StopIndex) nearest(lat, lon float64, k int) []Stop {
+	if k <= 0 {
+		return nil
+	}
+
+	q := query{lat: lat, lon: lon, p: toUnitSphere(lat, lon)}
+	best := &farthestFirst{}
+	searchNearest(idx.stops, 0, q, k, best)
+
+	found := []stopDistance(*best)
+	sortByDistance(found)
+	nearest := make([]Stop, len(found))
+	for i, f := range found {
+		nearest[i] = f.stop
+	}
+	return nearest
+}
+
+// Within returns every stop of the index lying within radius meters of the point
+// lat, lon, nearest first. Stops at the same distance are sorted by stop_id.
+func (idx *StopIndex) Within(lat, lon, radius float64) []Stop {
+	q := query{lat: lat, lon: lon, p: toUnitSphere(lat, lon)}
+	var found []stopDistance
+	searchWithin(idx.stops, 0, q, radius, chordLength(radius)+chordSlack, &found)
+
+	sortByDistance(found)
+	within := make([]Stop, len(found))
+	for i, f := range found {
+		within[i] = f.stop
+	}
+	return within
+}
+
+// query is the point searched for, in degrees and on the unit sphere.
+type query struct {
+	lat, lon float64
+	p        [3]float64
+}
+
+// Adds the stops of the tree closer to q than the farthest of best, keeping only the
+// closest k.
+func searchNearest(stops []indexedStop, axis int, q query, k int, best *farthestFirst) {
+	if len(stops) == 0 {
+		return
+	}
+	mid := len(stops) / 2
+	node := stops[mid]
+
+	sd := stopDistance{stop: node.stop, distance: Haversine(q.lat, q.lon, node.stop.Lat, node.stop.Lon)}
+	if best.Len() < k {
+		heap.Push(best, sd)
+	} else if closer(sd, (*best)[0]) {
+		(*best)[0] = sd
+		heap.Fix(best, 0)
+	}
+
+	near, far := stops[:mid], stops[mid+1:]
+	diff := q.p[axis] - node.p[axis]
+	if diff > 0 {
+		near, far = far, near
+	}
+	next := (axis + 1) % 3
+	searchNearest(near, next, q, k, best)
+
+	// The far side can only hold a closer stop if the splitting plane is closer than
+	// the farthest stop found so far.
+	if best.Len() < k || math.Abs(diff) <= chordLength((*best)[0].distance)+chordSlack {
+		searchNearest(far, next, q, k, best)
+	}
+}
+
+// Appends the stops of the tree within radius meters of q to found. chord is the
+// straight-line distance on the unit sphere corresponding to radius.
+func searchWithin(stops []indexedStop, axis int, q query, radius, chord float64, found *[]stopDistance) {
+	if len(stops) == 0 {
+		return
+	}
+	mid := len(stops) / 2
+	node := stops[mid]
+
+	if d := Haversine(q.lat, q.lon, node.stop.Lat, node.stop.Lon); d <= radius {
+		*found = append(*found, stopDistance{stop: node.stop, distance: d})
+	}
+
+	diff := q.p[axis] - node.p[axis]
+	next := (axis + 1) % 3
+	if diff <= chord {
+		searchWithin(stops[:mid], next, q, radius, chord, found)
+	}
+	if diff >= -chord {
+		searchWithin(stops[mid+1:], next, q, radius, chord, found)
+	}
+}
+
+// Returns whether a sorts before b when nearest first, breaking ties by stop_id.
+func closer(a, b stopDistance) bool {
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	return a.stop.ID < b.stop.ID
+}
+
+// farthestFirst is a heap of stops with the farthest at its root, so that it can be
+// replaced as soon as a closer stop is found.
+type farthestFirst []stopDistance
+
+func (h farthestFirst) Len() int            { return len(h) }
+func (h farthestFirst) Less(i, j int) bool  { return closer(h[j], h[i]) }
+func (h farthestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(x interface{}) { *h = append(*h, x.(stopDistance)) }
+func (h *farthestFirst) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Returns the position of a point given in degrees on a sphere of radius 1.
+func toUnitSphere(lat, lon float64) [3]float64 {
+	phi := lat * math.Pi / 180
+	lambda := lon * math.Pi / 180
+	return [3]float64{
+		math.Cos(phi) * math.Cos(lambda),
+		math.Cos(phi) * math.Sin(lambda),
+		math.Sin(phi),
+	}
+}
+
+// Returns the straight-line distance between two points on a sphere of radius 1 which
+// are meters apart along its surface.
+func chordLength(meters float64) float64 {
+	angle := math.Min(meters/earthRadius, math.Pi)
+	return 2 * math.Sin(angle/2)
+}
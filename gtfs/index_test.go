@@ -0,0 +1,93 @@
+package gtfs
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Returns n stops at random within the box, from a fixed seed.
+func randomStops(n int, seed int64, b BoundingBox) []Stop {
+	r := rand.New(rand.NewSource(seed))
+	stops := make([]Stop, n)
+	for i := range stops {
+		stops[i] = Stop{
+			ID:  fmt.Sprint(i),
+			Lat: b.MinLat + r.Float64()*(b.MaxLat-b.MinLat),
+			Lon: b.MinLon + r.Float64()*(b.MaxLon-b.MinLon),
+		}
+	}
+	return stops
+}
+
+// Returns the stops within radius meters of lat, lon by scanning every one.
+func linearWithin(stops []Stop, lat, lon, radius float64) []Stop {
+	var found []stopDistance
+	for _, s := range locatedStops(stops) {
+		if d := Haversine(lat, lon, s.Lat, s.Lon); d <= radius {
+			found = append(found, stopDistance{stop: s, distance: d})
+		}
+	}
+	sortByDistance(found)
+	var within []Stop
+	for _, f := range found {
+		within = append(within, f.stop)
+	}
+	return within
+}
+
+func TestStopIndexMatchesLinearScan(t *testing.T) {
+	for name, box := range map[string]BoundingBox{
+		"victoria": *StopBounds,
+		// Stops either side of the antimeridian are near one another.
+		"antimeridian": {MinLat: -20, MinLon: -180, MaxLat: -10, MaxLon: 180},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stops := append(randomStops(2000, 1, box), Stop{ID: "unlocated"})
+			idx := BuildStopIndex(stops)
+			r := rand.New(rand.NewSource(2))
+			for _, q := range randomStops(200, 3, box) {
+				k := 1 + r.Intn(20)
+				if got, want := idx.Nearest(q.Lat, q.Lon, k), NearestStops(stops, q.Lat, q.Lon, k); !reflect.DeepEqual(got, want) {
+					t.Fatalf("Nearest(%v, %v, %d) = %v, want %v", q.Lat, q.Lon, k, stopIDs(got), stopIDs(want))
+				}
+				radius := r.Float64() * 20000
+				if got, want := idx.Within(q.Lat, q.Lon, radius), linearWithin(stops, q.Lat, q.Lon, radius); len(got)+len(want) > 0 && !reflect.DeepEqual(got, want) {
+					t.Fatalf("Within(%v, %v, %.0f) = %v, want %v", q.Lat, q.Lon, radius, stopIDs(got), stopIDs(want))
+				}
+			}
+		})
+	}
+}
+
+func TestStopIndexEmpty(t *testing.T) {
+	idx := BuildStopIndex([]Stop{{ID: "unlocated"}})
+	if got := idx.Nearest(-37.8, 144.9, 3); len(got) != 0 {
+		t.Errorf("got %v from an empty index", stopIDs(got))
+	}
+	if got := idx.Within(-37.8, 144.9, 1000); len(got) != 0 {
+		t.Errorf("got %v from an empty index", stopIDs(got))
+	}
+}
+
+// Compares nearest stop queries answered by the k-d tree against scanning every stop.
+func BenchmarkNearestStops(b *testing.B) {
+	stops := randomStops(50000, 1, *StopBounds)
+	queries := randomStops(1000, 2, *StopBounds)
+	searchers := []struct {
+		name string
+		s    stopSearcher
+	}{
+		{"linear", linearSearch(locatedStops(stops))},
+		{"index", BuildStopIndex(stops)},
+	}
+	for _, s := range searchers {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := queries[i%len(queries)]
+				s.s.nearest(q.Lat, q.Lon, 10)
+			}
+		})
+	}
+}
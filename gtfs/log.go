@@ -0,0 +1,82 @@
+package gtfs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger receives the progress messages logged while consolidating a feed.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Level is the severity of a logged message.
+type Level int
+
+// The levels of message which may be logged, from most to least verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level with the given name: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// NewLogger returns a Logger which writes messages at or above level to w, each
+// prefixed with the date, time and level of the message.
+func NewLogger(w io.Writer, level Level) Logger {
+	return &levelLogger{l: log.New(w, "", log.LstdFlags), level: level}
+}
+
+// defaultLogger is used when no Logger is supplied, and logs milestones to stderr.
+var defaultLogger = NewLogger(os.Stderr, LevelInfo)
+
+// Returns l, or the default logger if l is nil.
+func loggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return defaultLogger
+	}
+	return l
+}
+
+// levelLogger is a Logger which discards messages below its level.
+type levelLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+func (l *levelLogger) logf(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.l.Printf(strings.ToUpper(level.String())+" "+format, args...)
+}
+
+func (l *levelLogger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *levelLogger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *levelLogger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *levelLogger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
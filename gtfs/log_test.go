@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	for _, tt := range []struct {
+		level Level
+		want  []string
+	}{
+		{LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{LevelWarn, []string{"WARN", "ERROR"}},
+		{LevelError, []string{"ERROR"}},
+	} {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			l := NewLogger(&buf, tt.level)
+			l.Debugf("one")
+			l.Infof("two")
+			l.Warnf("three")
+			l.Errorf("four")
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(tt.want), buf.String())
+			}
+			for i, line := range lines {
+				if !strings.Contains(line, " "+tt.want[i]+" ") {
+					t.Errorf("line %q isn't logged at %s", line, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "Warn": LevelWarn, "error": LevelError} {
+		if got, err := ParseLevel(name); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+func TestConsolidateLogsFilesAtDebug(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{"1": testFeed})
+
+	for _, tt := range []struct {
+		level Level
+		debug bool
+	}{{LevelDebug, true}, {LevelInfo, false}} {
+		var buf bytes.Buffer
+		opts := testOptions(dir)
+		opts.Logger = NewLogger(&buf, tt.level)
+		if err := Consolidate(input, filepath.Join(dir, "out"), opts); err != nil {
+			t.Fatal(err)
+		}
+
+		// Debug logs every inner zip extracted and every file read, info milestones alone.
+		logged := buf.String()
+		for _, msg := range []struct{ prefix, path string }{
+			{"DEBUG Extracted ", filepath.Join("1", innerZipFileName)},
+			{"DEBUG Reading ", filepath.Join("1", "google_transit", "stops.txt")},
+		} {
+			if got := loggedPath(logged, msg.prefix, msg.path); got != tt.debug {
+				t.Errorf("at %s, logged %s%s: %t, want %t", tt.level, msg.prefix, msg.path, got, tt.debug)
+			}
+		}
+		if !strings.Contains(logged, "INFO Finished consolidating") {
+			t.Errorf("at %s, didn't log finishing:\n%s", tt.level, logged)
+		}
+	}
+}
+
+// Returns whether a line of logged has prefix followed by a path ending in path.
+func loggedPath(logged, prefix, path string) bool {
+	for _, line := range strings.Split(logged, "\n") {
+		if strings.Contains(line, prefix) && strings.HasSuffix(line, string(filepath.Separator)+path) {
+			return true
+		}
+	}
+	return false
+}
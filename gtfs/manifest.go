@@ -0,0 +1,91 @@
+package gtfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestFileName is the name of the manifest written alongside the consolidated files.
+const ManifestFileName = "manifest.json"
+
+// Manifest describes the files making up a consolidated feed, so that consumers can
+// verify they've received all of it intact.
+type Manifest struct {
+	Build *BuildInfo     `json:"build,omitempty"`
+	Files []ManifestFile `json:"files"`
+}
+
+// BuildInfo identifies the build of the program which produced a consolidated feed.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+// ManifestFile describes a single file of a consolidated feed. Rows is the number of
+// records written to the file, excluding any header.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Writes a manifest of every output file in the directory at path named with prefix,
+// as given by isOutputFile, to ManifestFileName within it, itself with prefix at the
+// start of its name.
+// stats holds the number of records written for each kind of GTFS file, which is matched
+// against each file's name bar its prefix and extension. build, if set, is recorded in
+// the manifest.
+func writeManifest(path, prefix string, stats map[string]tableStats, build *BuildInfo) error {
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	m := Manifest{Build: build}
+	for _, info := range infos {
+		if info.IsDir() || !isOutputFile(info.Name(), prefix) || info.Name() == prefix+ManifestFileName {
+			continue
+		}
+
+		sum, err := sha256File(filepath.Join(path, info.Name()))
+		if err != nil {
+			return err
+		}
+		kind := strings.SplitN(strings.TrimPrefix(info.Name(), prefix), ".", 2)[0]
+		m.Files = append(m.Files, ManifestFile{
+			Name:   info.Name(),
+			SHA256: sum,
+			Rows:   stats[kind].rows,
+			Bytes:  info.Size(),
+		})
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, prefix+ManifestFileName), append(b, '\n'), 0644)
+}
+
+// Returns the hex encoded SHA-256 checksum of the file at path.
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
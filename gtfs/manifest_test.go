@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Returns the manifest at path.
+func readManifest(t *testing.T, path string) Manifest {
+	t.Helper()
+	var m Manifest
+	if err := json.Unmarshal([]byte(readFile(t, path)), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestConsolidateManifest(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.KeepIntermediate = true
+	opts.Build = &BuildInfo{Version: "v1.2.3", Commit: "abc123", Date: "2024-06-01"}
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	m := readManifest(t, filepath.Join(out, ManifestFileName))
+	if m.Build == nil || *m.Build != *opts.Build {
+		t.Errorf("got build %+v, want %+v", m.Build, opts.Build)
+	}
+	// Every file written bar the manifest itself is listed.
+	infos, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != len(infos)-1 {
+		t.Errorf("manifest lists %d files, want %d", len(m.Files), len(infos)-1)
+	}
+	for _, f := range m.Files {
+		path := filepath.Join(out, f.Name)
+		b := readFile(t, path)
+		sum := sha256.Sum256([]byte(b))
+		if got := hex.EncodeToString(sum[:]); got != f.SHA256 {
+			t.Errorf("%s has checksum %s, manifest says %s", f.Name, got, f.SHA256)
+		}
+		if int64(len(b)) != f.Bytes {
+			t.Errorf("%s has %d bytes, manifest says %d", f.Name, len(b), f.Bytes)
+		}
+		if got := len(readRows(t, path)); got != f.Rows {
+			t.Errorf("%s has %d rows, manifest says %d", f.Name, got, f.Rows)
+		}
+	}
+
+	// The manifest is archived along with the files it describes.
+	r, err := zip.OpenReader(out + ".zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	found := false
+	for _, f := range r.File {
+		found = found || filepath.Base(f.Name) == ManifestFileName
+	}
+	if !found {
+		t.Error("archive is missing the manifest")
+	}
+}
+
+func TestWriteManifestOnlyPrefixedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"metro_stops.txt": testFeed["stops.txt"],
+		"stops.txt":       testFeed["stops.txt"],
+	})
+	if err := writeManifest(dir, "metro_", map[string]tableStats{"stops": {rows: 4}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	m := readManifest(t, filepath.Join(dir, "metro_"+ManifestFileName))
+	if len(m.Files) != 1 || m.Files[0].Name != "metro_stops.txt" || m.Files[0].Rows != 4 {
+		t.Errorf("got files %+v, want metro_stops.txt of 4 rows alone", m.Files)
+	}
+}
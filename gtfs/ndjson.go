@@ -0,0 +1,77 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// FormatNDJSON writes one <kind>.ndjson file per kind of record, with each row as a JSON
+// object on its own line. Rows are parsed into their typed structs first, so numeric
+// columns are written as JSON numbers and columns without a struct field are dropped.
+const FormatNDJSON = "ndjson"
+
+// recordParsers parse a row of each kind of GTFS file into its typed struct.
+var recordParsers = map[string]func(h Header, row []string) (interface{}, error){
+	"agency":         func(h Header, row []string) (interface{}, error) { return ParseAgency(h, row) },
+	"calendar_dates": func(h Header, row []string) (interface{}, error) { return ParseCalendarDate(h, row) },
+	"calendar":       func(h Header, row []string) (interface{}, error) { return ParseCalendar(h, row) },
+	"routes":         func(h Header, row []string) (interface{}, error) { return ParseRoute(h, row) },
+	"stop_times":     func(h Header, row []string) (interface{}, error) { return ParseStopTime(h, row) },
+	"stops":          func(h Header, row []string) (interface{}, error) { return ParseStop(h, row) },
+	"trips":          func(h Header, row []string) (interface{}, error) { return ParseTrip(h, row) },
+	"shapes":         func(h Header, row []string) (interface{}, error) { return ParseShapePoint(h, row) },
+	"frequencies":    func(h Header, row []string) (interface{}, error) { return ParseFrequency(h, row) },
+	"transfers":      func(h Header, row []string) (interface{}, error) { return ParseTransferRule(h, row) },
+}
+
+// ndjsonFormat writes each kind of GTFS file as newline delimited JSON to a sink.
+type ndjsonFormat struct {
+	sink Sink
+}
+
+func (f *ndjsonFormat) table(kind string, header []string) (tableWriter, error) {
+	parse, ok := recordParsers[kind]
+	if !ok {
+		return discardTable{}, nil
+	}
+
+	file, err := f.sink.Create(fmt.Sprintf("%s.ndjson", kind))
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &ndjsonTable{kind: kind, header: NewHeader(header), parse: parse, file: file, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (f *ndjsonFormat) close() error {
+	return nil
+}
+
+// ndjsonTable encodes each row of a single kind of GTFS file as it arrives.
+type ndjsonTable struct {
+	kind   string
+	header Header
+	parse  func(h Header, row []string) (interface{}, error)
+	file   io.WriteCloser
+	w      *bufio.Writer
+	enc    *json.Encoder
+}
+
+func (t *ndjsonTable) writeRow(row []string) error {
+	v, err := t.parse(t.header, row)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.kind, err)
+	}
+	// Encode terminates each object with a newline.
+	return t.enc.Encode(v)
+}
+
+func (t *ndjsonTable) close() error {
+	if err := t.w.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
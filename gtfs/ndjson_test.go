@@ -0,0 +1,61 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsolidateNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatNDJSON
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(out, "stop_times.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var stopTimes []StopTime
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var st StopTime
+		if err := json.Unmarshal(s.Bytes(), &st); err != nil {
+			t.Fatalf("line %q: %v", s.Text(), err)
+		}
+		stopTimes = append(stopTimes, st)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stopTimes) != 7 {
+		t.Fatalf("got %d stop times, want 7", len(stopTimes))
+	}
+	want := StopTime{TripID: "T1", ArrivalTime: "08:05:00", DepartureTime: "08:06:00", StopID: "B", StopSequence: 2, ShapeDistTraveled: 1300}
+	if stopTimes[1] != want {
+		t.Errorf("got %+v, want %+v", stopTimes[1], want)
+	}
+
+	// Numeric columns are written as JSON numbers rather than strings.
+	first := strings.SplitN(readFile(t, filepath.Join(out, "stops.ndjson")), "\n", 2)[0]
+	var stop map[string]interface{}
+	if err := json.Unmarshal([]byte(first), &stop); err != nil {
+		t.Fatal(err)
+	}
+	if lat, ok := stop["stop_lat"].(float64); !ok || lat != -37.8183 {
+		t.Errorf("got stop_lat %#v, want the number -37.8183", stop["stop_lat"])
+	}
+	if id, ok := stop["stop_id"].(string); !ok || id != "A" {
+		t.Errorf("got stop_id %#v, want the string A", stop["stop_id"])
+	}
+}
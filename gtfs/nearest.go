@@ -0,0 +1,67 @@
+package gtfs
+
+import "sort"
+
+// stopSearcher finds the stops closest to a point. NearestStops scans every stop, while
+// a StopIndex answers the same query from a k-d tree.
+type stopSearcher interface {
+	nearest(lat, lon float64, k int) []Stop
+}
+
+// linearSearch is a stopSearcher comparing the point against every stop in turn.
+type linearSearch []Stop
+
+// NearestStops returns the k stops closest to the point lat, lon, sorted by their
+// great-circle distance from it, nearest first. Stops at the same distance are sorted
+// by stop_id. Stops with missing or zero coordinates are skipped. Fewer than k stops
+// are returned if there aren't k stops with coordinates.
+func NearestStops(stops []Stop, lat, lon float64, k int) []Stop {
+	var s stopSearcher = linearSearch(locatedStops(stops))
+	return s.nearest(lat, lon, k)
+}
+
+func (l linearSearch) nearest(lat, lon float64, k int) []Stop {
+	if k <= 0 {
+		return nil
+	}
+
+	found := make([]stopDistance, len(l))
+	for i, s := range l {
+		found[i] = stopDistance{stop: s, distance: Haversine(lat, lon, s.Lat, s.Lon)}
+	}
+	sortByDistance(found)
+	if len(found) > k {
+		found = found[:k]
+	}
+
+	nearest := make([]Stop, len(found))
+	for i, f := range found {
+		nearest[i] = f.stop
+	}
+	return nearest
+}
+
+// stopDistance is a stop along with its distance in meters from the point searched for.
+type stopDistance struct {
+	stop     Stop
+	distance float64
+}
+
+// Sorts stops nearest first, breaking ties by stop_id.
+func sortByDistance(stops []stopDistance) {
+	sort.Slice(stops, func(i, j int) bool {
+		return closer(stops[i], stops[j])
+	})
+}
+
+// Returns the stops which have coordinates, i.e. those which aren't at 0,0.
+func locatedStops(stops []Stop) []Stop {
+	located := make([]Stop, 0, len(stops))
+	for _, s := range stops {
+		if s.Lat == 0 && s.Lon == 0 {
+			continue
+		}
+		located = append(located, s)
+	}
+	return located
+}
@@ -0,0 +1,49 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns the ids of stops.
+func stopIDs(stops []Stop) []string {
+	var ids []string
+	for _, s := range stops {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// Stops around Flinders Street, with their distances from -37.8183,144.9671.
+var nearbyStops = []Stop{
+	{ID: "Southern Cross", Lat: -37.8184, Lon: 144.9525},  // 1283m
+	{ID: "Richmond", Lat: -37.8240, Lon: 144.9900},        // 2109m
+	{ID: "Flinders Street", Lat: -37.8183, Lon: 144.9671}, // 0m
+	{ID: "Unknown"},
+	{ID: "Parliament", Lat: -37.8110, Lon: 144.9730}, // 963m
+	{ID: "Box Hill", Lat: -37.8190, Lon: 145.1220},   // 13607m
+}
+
+func TestNearestStops(t *testing.T) {
+	got := stopIDs(NearestStops(nearbyStops, -37.8183, 144.9671, 3))
+	if want := []string{"Flinders Street", "Parliament", "Southern Cross"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The stop without coordinates is never returned, however many are asked for.
+	got = stopIDs(NearestStops(nearbyStops, -37.8183, 144.9671, 10))
+	if want := []string{"Flinders Street", "Parliament", "Southern Cross", "Richmond", "Box Hill"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := NearestStops(nearbyStops, -37.8183, 144.9671, 0); len(got) != 0 {
+		t.Errorf("got %v for k of 0", stopIDs(got))
+	}
+}
+
+func TestNearestStopsTies(t *testing.T) {
+	// B and A are the same distance either side of the point.
+	stops := []Stop{{ID: "B", Lat: -37.81, Lon: 144.97}, {ID: "A", Lat: -37.83, Lon: 144.97}}
+	if got, want := stopIDs(NearestStops(stops, -37.82, 144.97, 2)), []string{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,415 @@
+package gtfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// FormatParquet writes one <kind>.parquet file per kind of record, with a column for
+// each field of its typed struct: strings as UTF-8 byte arrays, ints as INT32, bools as
+// BOOLEAN and floats as DOUBLE. Every column is required, and its pages are PLAIN
+// encoded and gzip compressed. As with FormatNDJSON, columns without a field in the
+// typed records are dropped.
+const FormatParquet = "parquet"
+
+// parquetRowGroupRows is the number of rows held in memory before they're written out
+// as a row group.
+const parquetRowGroupRows = 1 << 17
+
+// parquetMagic begins and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// The Parquet physical types, encodings and codecs used by parquetTable, as numbered in
+// parquet.thrift.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetGzip = 2
+
+	parquetRequired = 0
+	parquetUTF8     = 0
+	parquetDataPage = 0
+)
+
+// parquetFormat writes each kind of GTFS file as a Parquet file to a sink.
+type parquetFormat struct {
+	sink Sink
+}
+
+func (f *parquetFormat) table(kind string, header []string) (tableWriter, error) {
+	parse, ok := recordParsers[kind]
+	if !ok {
+		return discardTable{}, nil
+	}
+	// An empty row parses to the zero value of the kind's typed record, giving its fields.
+	v, err := parse(Header{}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.sink.Create(fmt.Sprintf("%s.parquet", kind))
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	t := &parquetTable{kind: kind, header: NewHeader(header), parse: parse, file: file, buf: buf, w: &countingWriter{w: buf}}
+
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		var physical int32
+		switch field.Type.Kind() {
+		case reflect.String:
+			physical = parquetByteArray
+		case reflect.Int:
+			physical = parquetInt32
+		case reflect.Bool:
+			physical = parquetBoolean
+		case reflect.Float64:
+			physical = parquetDouble
+		default:
+			file.Close()
+			return nil, fmt.Errorf("gtfs: no parquet type for %s", field.Type)
+		}
+		t.columns = append(t.columns, &parquetColumn{name: name, physical: physical})
+	}
+
+	if _, err := io.WriteString(t.w, parquetMagic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (f *parquetFormat) close() error {
+	return nil
+}
+
+// parquetTable holds the rows of a single kind of GTFS file until it has enough for a
+// row group, then writes them out column by column.
+type parquetTable struct {
+	kind    string
+	header  Header
+	parse   func(h Header, row []string) (interface{}, error)
+	file    io.WriteCloser
+	buf     *bufio.Writer
+	w       *countingWriter
+	columns []*parquetColumn
+
+	rows      int64
+	totalRows int64
+	groups    []parquetRowGroup
+}
+
+// parquetColumn is a column of a parquetTable along with the PLAIN encoded values of
+// the rows in the current row group.
+type parquetColumn struct {
+	name     string
+	physical int32
+	values   []byte
+	bools    []bool
+}
+
+// parquetRowGroup records where the chunks of a row group were written, for the footer.
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+// parquetChunk records where the single page of a column chunk was written.
+type parquetChunk struct {
+	offset       int64
+	compressed   int64
+	uncompressed int64
+}
+
+func (t *parquetTable) writeRow(row []string) error {
+	v, err := t.parse(t.header, row)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.kind, err)
+	}
+
+	rv := reflect.ValueOf(v)
+	for i, c := range t.columns {
+		field := rv.Field(i)
+		switch c.physical {
+		case parquetByteArray:
+			c.values = binary.LittleEndian.AppendUint32(c.values, uint32(field.Len()))
+			c.values = append(c.values, field.String()...)
+		case parquetInt32:
+			c.values = binary.LittleEndian.AppendUint32(c.values, uint32(int32(field.Int())))
+		case parquetBoolean:
+			c.bools = append(c.bools, field.Bool())
+		case parquetDouble:
+			c.values = binary.LittleEndian.AppendUint64(c.values, math.Float64bits(field.Float()))
+		}
+	}
+
+	t.rows++
+	if t.rows >= parquetRowGroupRows {
+		return t.flushRowGroup()
+	}
+	return nil
+}
+
+// Writes the rows held so far as a row group, with a single gzip compressed data page
+// for each column.
+func (t *parquetTable) flushRowGroup() error {
+	if t.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rows: t.rows}
+	for _, c := range t.columns {
+		data := c.values
+		if c.physical == parquetBoolean {
+			// Booleans are packed one to a bit, starting from the least significant.
+			data = make([]byte, (len(c.bools)+7)/8)
+			for i, b := range c.bools {
+				if b {
+					data[i/8] |= 1 << uint(i%8)
+				}
+			}
+		}
+
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var header compactWriter
+		header.beginStruct()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(compressed.Len()))
+		header.beginStructField(5)
+		header.i32(1, int32(t.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetChunk{
+			offset:       t.w.n,
+			compressed:   int64(len(header.b) + compressed.Len()),
+			uncompressed: int64(len(header.b) + len(data)),
+		}
+		if _, err := t.w.Write(header.b); err != nil {
+			return err
+		}
+		if _, err := t.w.Write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressed
+
+		c.values = c.values[:0]
+		c.bools = c.bools[:0]
+	}
+
+	t.groups = append(t.groups, group)
+	t.totalRows += t.rows
+	t.rows = 0
+	return nil
+}
+
+func (t *parquetTable) close() error {
+	err := t.flushRowGroup()
+	if err == nil {
+		err = t.writeFooter()
+	}
+	if err == nil {
+		err = t.buf.Flush()
+	}
+	if err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+// Writes the file metadata describing the schema and row groups of the table, followed
+// by its length and the closing magic number.
+func (t *parquetTable) writeFooter() error {
+	var m compactWriter
+	m.beginStruct()
+	m.i32(1, 1)
+
+	// The schema is a root element with a required leaf for each column.
+	m.listField(2, compactStruct, len(t.columns)+1)
+	m.beginStruct()
+	m.str(4, "schema")
+	m.i32(5, int32(len(t.columns)))
+	m.endStruct()
+	for _, c := range t.columns {
+		m.beginStruct()
+		m.i32(1, c.physical)
+		m.i32(3, parquetRequired)
+		m.str(4, c.name)
+		if c.physical == parquetByteArray {
+			m.i32(6, parquetUTF8)
+			m.beginStructField(10)
+			m.beginStructField(1)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.endStruct()
+	}
+
+	m.i64(3, t.totalRows)
+
+	m.listField(4, compactStruct, len(t.groups))
+	for _, g := range t.groups {
+		m.beginStruct()
+		m.listField(1, compactStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			c := t.columns[i]
+			m.beginStruct()
+			m.i64(2, chunk.offset)
+			m.beginStructField(3)
+			m.i32(1, c.physical)
+			m.listField(2, compactI32, 1)
+			m.appendVarint(parquetPlain)
+			m.listField(3, compactBinary, 1)
+			m.appendString(c.name)
+			m.i32(4, parquetGzip)
+			m.i64(5, g.rows)
+			m.i64(6, chunk.uncompressed)
+			m.i64(7, chunk.compressed)
+			m.i64(9, chunk.offset)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.endStruct()
+	}
+
+	m.str(6, "ptv-graph")
+	m.endStruct()
+
+	if _, err := t.w.Write(m.b); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(m.b)))
+	if _, err := t.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(t.w, parquetMagic)
+	return err
+}
+
+// countingWriter counts the bytes written through it, so that the offsets of column
+// chunks can be recorded.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// The Thrift compact protocol types used by the Parquet metadata.
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter encodes Thrift structs with the compact protocol, as used by the
+// metadata of Parquet files. Each field's id is encoded relative to the previous field
+// of the same struct, so the id of the last field of each open struct is kept.
+type compactWriter struct {
+	b    []byte
+	last []int16
+}
+
+// Begins a struct, such as an element of a list.
+func (w *compactWriter) beginStruct() {
+	w.last = append(w.last, 0)
+}
+
+// Begins a struct held in a field of the current struct.
+func (w *compactWriter) beginStructField(id int16) {
+	w.fieldHeader(id, compactStruct)
+	w.beginStruct()
+}
+
+// Ends the current struct.
+func (w *compactWriter) endStruct() {
+	w.b = append(w.b, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.b = append(w.b, byte(delta)<<4|typ)
+	} else {
+		w.b = append(w.b, typ)
+		w.appendVarint(int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, compactI32)
+	w.appendVarint(int64(v))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, compactI64)
+	w.appendVarint(v)
+}
+
+func (w *compactWriter) str(id int16, s string) {
+	w.fieldHeader(id, compactBinary)
+	w.appendString(s)
+}
+
+// Begins a list field of n elements of the given type, which must then be appended.
+func (w *compactWriter) listField(id int16, elem byte, n int) {
+	w.fieldHeader(id, compactList)
+	if n < 15 {
+		w.b = append(w.b, byte(n)<<4|elem)
+		return
+	}
+	w.b = append(w.b, 0xf0|elem)
+	w.b = binary.AppendUvarint(w.b, uint64(n))
+}
+
+// Appends an integer as a zigzag encoded varint.
+func (w *compactWriter) appendVarint(v int64) {
+	w.b = binary.AppendVarint(w.b, v)
+}
+
+func (w *compactWriter) appendString(s string) {
+	w.b = binary.AppendUvarint(w.b, uint64(len(s)))
+	w.b = append(w.b, s...)
+}
@@ -0,0 +1,191 @@
+package gtfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// parquetStop and parquetStopTime are Stop and StopTime as read back by parquet-go,
+// which names columns by their parquet tags.
+type parquetStop struct {
+	ID                 string  `parquet:"stop_id"`
+	Name               string  `parquet:"stop_name"`
+	Lat                float64 `parquet:"stop_lat"`
+	Lon                float64 `parquet:"stop_lon"`
+	WheelchairBoarding int32   `parquet:"wheelchair_boarding"`
+}
+
+type parquetStopTime struct {
+	TripID            string  `parquet:"trip_id"`
+	ArrivalTime       string  `parquet:"arrival_time"`
+	DepartureTime     string  `parquet:"departure_time"`
+	StopID            string  `parquet:"stop_id"`
+	StopSequence      int32   `parquet:"stop_sequence"`
+	StopHeadsign      string  `parquet:"stop_headsign"`
+	PickupType        int32   `parquet:"pickup_type"`
+	DropOffType       int32   `parquet:"drop_off_type"`
+	ShapeDistTraveled float64 `parquet:"shape_dist_traveled"`
+}
+
+// Opens the Parquet file at path with parquet-go, closing it at the end of the test.
+func openParquet(t *testing.T, path string) (*os.File, *parquet.File) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatalf("parquet-go can't open %s: %v", path, err)
+	}
+	return file, pf
+}
+
+// Reads every row of the Parquet file at path into values of the type of row, returning
+// them as a slice of that type.
+func readParquetRows(t *testing.T, path string, row interface{}) interface{} {
+	t.Helper()
+	file, _ := openParquet(t, path)
+	r := parquet.NewReader(file, parquet.SchemaOf(row))
+	defer r.Close()
+
+	typ := reflect.TypeOf(row)
+	rows := reflect.MakeSlice(reflect.SliceOf(typ), 0, int(r.NumRows()))
+	for {
+		v := reflect.New(typ)
+		err := r.Read(v.Interface())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		rows = reflect.Append(rows, v.Elem())
+	}
+	return rows.Interface()
+}
+
+func TestConsolidateParquet(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatParquet
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	stops := readParquetRows(t, filepath.Join(out, "stops.parquet"), parquetStop{}).([]parquetStop)
+	if len(stops) != 4 {
+		t.Fatalf("got %d stops, want 4", len(stops))
+	}
+	if want := (parquetStop{ID: "A", Name: "Flinders St, Stop 1", Lat: -37.8183, Lon: 144.9671, WheelchairBoarding: 1}); stops[0] != want {
+		t.Errorf("got stop %+v, want %+v", stops[0], want)
+	}
+
+	stopTimes := readParquetRows(t, filepath.Join(out, "stop_times.parquet"), parquetStopTime{}).([]parquetStopTime)
+	if len(stopTimes) != 7 {
+		t.Fatalf("got %d stop times, want 7", len(stopTimes))
+	}
+	want := parquetStopTime{TripID: "T1", ArrivalTime: "08:05:00", DepartureTime: "08:06:00", StopID: "B", StopSequence: 2, ShapeDistTraveled: 1300}
+	if stopTimes[1] != want {
+		t.Errorf("got stop time %+v, want %+v", stopTimes[1], want)
+	}
+}
+
+func TestConsolidateParquetSchema(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatParquet
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pf := openParquet(t, filepath.Join(out, "stops.parquet"))
+	want := map[string]parquet.Kind{
+		"stop_id":             parquet.ByteArray,
+		"stop_name":           parquet.ByteArray,
+		"stop_lat":            parquet.Double,
+		"stop_lon":            parquet.Double,
+		"wheelchair_boarding": parquet.Int32,
+	}
+	fields := pf.Schema().Fields()
+	if len(fields) != len(want) {
+		t.Errorf("got %d columns, want %d", len(fields), len(want))
+	}
+	for _, f := range fields {
+		kind, ok := want[f.Name()]
+		if !ok {
+			t.Errorf("got an unexpected column %s", f.Name())
+			continue
+		}
+		if f.Type().Kind() != kind {
+			t.Errorf("column %s is %s, want %s", f.Name(), f.Type().Kind(), kind)
+		}
+		if !f.Required() {
+			t.Errorf("column %s isn't required", f.Name())
+		}
+		if kind == parquet.ByteArray && (f.Type().LogicalType() == nil || f.Type().LogicalType().UTF8 == nil) {
+			t.Errorf("column %s isn't annotated as UTF-8", f.Name())
+		}
+	}
+	for _, rg := range pf.Metadata().RowGroups {
+		for _, c := range rg.Columns {
+			if c.MetaData.Codec != format.Gzip {
+				t.Errorf("column %s is compressed with %s, want gzip", strings.Join(c.MetaData.PathInSchema, "."), c.MetaData.Codec)
+			}
+		}
+	}
+}
+
+func TestConsolidateParquetRowGroups(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	// Enough stops to fill more than one row group.
+	const n = parquetRowGroupRows + 100
+	var sb strings.Builder
+	sb.WriteString("stop_id,stop_name,stop_lat,stop_lon\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "%d,Stop %d,-37.8,144.9\n", i, i)
+	}
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"stops.txt": sb.String()}))
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatParquet
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(out, "stops.parquet")
+	_, pf := openParquet(t, path)
+	if got := len(pf.RowGroups()); got != 2 {
+		t.Errorf("got %d row groups, want 2", got)
+	}
+	stops := readParquetRows(t, path, parquetStop{}).([]parquetStop)
+	if len(stops) != n {
+		t.Fatalf("got %d stops, want %d", len(stops), n)
+	}
+	if last := stops[n-1]; last.ID != fmt.Sprint(n-1) || last.Name != fmt.Sprint("Stop ", n-1) {
+		t.Errorf("got last stop %+v, want stop %d", last, n-1)
+	}
+}
@@ -0,0 +1,59 @@
+package gtfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// progressCounter totals the bytes read across every file of a walk and reports them,
+// along with the combined size of those files, to a callback.
+type progressCounter struct {
+	mu    sync.Mutex
+	done  int64
+	total int64
+	fn    func(done, total int64)
+}
+
+// Returns a progressCounter reporting to fn against the total size of the files of the
+// given kinds beneath path, or nil if fn is nil. Files which can't be read don't count
+// towards the total; the walk will report them itself.
+func newProgressCounter(path string, kinds []string, fn func(done, total int64)) *progressCounter {
+	if fn == nil {
+		return nil
+	}
+
+	c := &progressCounter{fn: fn}
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && fileIsGTFSFile(info.Name(), kinds) {
+			c.total += info.Size()
+		}
+		return nil
+	})
+	return c
+}
+
+// Adds n bytes to the count and reports the new count. Holding the lock while reporting
+// keeps the reported counts in order when files are read concurrently.
+func (c *progressCounter) add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done += int64(n)
+	c.fn(c.done, c.total)
+}
+
+// countingReader adds the bytes read through it to a progressCounter.
+type countingReader struct {
+	r       io.Reader
+	counter *progressCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.counter.add(n)
+	}
+	return n, err
+}
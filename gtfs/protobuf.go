@@ -0,0 +1,122 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// FormatProtobuf writes one <kind>.pb file per kind of record, each a stream of the
+// length-delimited Protocol Buffers messages defined in gtfs.proto. As with
+// FormatNDJSON, columns without a field in the typed records are dropped.
+const FormatProtobuf = "protobuf"
+
+// The protobuf wire types used by the messages in gtfs.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protobufFormat writes each kind of GTFS file as a stream of protobuf messages to a sink.
+type protobufFormat struct {
+	sink Sink
+}
+
+func (f *protobufFormat) table(kind string, header []string) (tableWriter, error) {
+	parse, ok := recordParsers[kind]
+	if !ok {
+		return discardTable{}, nil
+	}
+
+	file, err := f.sink.Create(fmt.Sprintf("%s.pb", kind))
+	if err != nil {
+		return nil, err
+	}
+	return &protobufTable{kind: kind, header: NewHeader(header), parse: parse, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (f *protobufFormat) close() error {
+	return nil
+}
+
+// protobufTable encodes each row of a single kind of GTFS file as it arrives.
+type protobufTable struct {
+	kind   string
+	header Header
+	parse  func(h Header, row []string) (interface{}, error)
+	file   io.WriteCloser
+	w      *bufio.Writer
+	buf    []byte
+}
+
+func (t *protobufTable) writeRow(row []string) error {
+	v, err := t.parse(t.header, row)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.kind, err)
+	}
+
+	t.buf, err = appendMessage(t.buf[:0], reflect.ValueOf(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.kind, err)
+	}
+	var size [binary.MaxVarintLen64]byte
+	if _, err := t.w.Write(size[:binary.PutUvarint(size[:], uint64(len(t.buf)))]); err != nil {
+		return err
+	}
+	_, err = t.w.Write(t.buf)
+	return err
+}
+
+func (t *protobufTable) close() error {
+	if err := t.w.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+// Appends the protobuf encoding of a typed record to b. Each field of the struct is
+// encoded with the field number given by its protobuf tag, which must match gtfs.proto,
+// and fields without one are left out. Fields holding their zero value are omitted as
+// in proto3. Returns an error for a field of a type without a protobuf encoding.
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	for i := 0; i < v.NumField(); i++ {
+		tag, ok := v.Type().Field(i).Tag.Lookup("protobuf")
+		if !ok {
+			continue
+		}
+		num, err := strconv.ParseUint(tag, 10, 29)
+		if err != nil || num == 0 {
+			return nil, fmt.Errorf("gtfs: invalid protobuf field number %q of %s.%s", tag, v.Type(), v.Type().Field(i).Name)
+		}
+		field := v.Field(i)
+		if field.IsZero() {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			b = binary.AppendUvarint(b, num<<3|wireBytes)
+			b = binary.AppendUvarint(b, uint64(field.Len()))
+			b = append(b, field.String()...)
+		case reflect.Int:
+			// Negative int32 values are sign extended to 64 bits.
+			b = binary.AppendUvarint(b, num<<3|wireVarint)
+			b = binary.AppendUvarint(b, uint64(int32(field.Int())))
+		case reflect.Bool:
+			b = binary.AppendUvarint(b, num<<3|wireVarint)
+			b = append(b, 1)
+		case reflect.Float64:
+			b = binary.AppendUvarint(b, num<<3|wireFixed64)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(field.Float()))
+		default:
+			return nil, fmt.Errorf("gtfs: no protobuf encoding for %s.%s of type %s", v.Type(), v.Type().Field(i).Name, field.Type())
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,206 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// Decodes the protobuf message b into the struct v points to, by the fields' protobuf
+// tags, the reverse of appendMessage.
+func decodeMessage(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	fields := make(map[uint64]reflect.Value)
+	for i := 0; i < rv.NumField(); i++ {
+		if tag, ok := rv.Type().Field(i).Tag.Lookup("protobuf"); ok {
+			num, _ := strconv.ParseUint(tag, 10, 64)
+			fields[num] = rv.Field(i)
+		}
+	}
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid key")
+		}
+		b = b[n:]
+		field, ok := fields[key>>3]
+		if !ok {
+			return fmt.Errorf("unknown field %d", key>>3)
+		}
+		switch key & 7 {
+		case wireVarint:
+			x, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint of field %d", key>>3)
+			}
+			b = b[n:]
+			if field.Kind() == reflect.Bool {
+				field.SetBool(x != 0)
+			} else {
+				field.SetInt(int64(int32(x)))
+			}
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("short fixed64 of field %d", key>>3)
+			}
+			field.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("invalid length of field %d", key>>3)
+			}
+			field.SetString(string(b[n : n+int(size)]))
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("unknown wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+// Returns the length-delimited messages of the protobuf stream at path.
+func readMessages(t *testing.T, path string) [][]byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var messages [][]byte
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make([]byte, size)
+		if _, err := io.ReadFull(r, m); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+}
+
+func TestConsolidateProtobuf(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatProtobuf
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readMessages(t, filepath.Join(out, "stop_times.pb"))
+	if len(messages) != 7 {
+		t.Fatalf("got %d stop times, want 7", len(messages))
+	}
+	var st StopTime
+	if err := decodeMessage(messages[1], &st); err != nil {
+		t.Fatal(err)
+	}
+	want := StopTime{TripID: "T1", ArrivalTime: "08:05:00", DepartureTime: "08:06:00", StopID: "B", StopSequence: 2, ShapeDistTraveled: 1300}
+	if st != want {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+
+	var stop Stop
+	if err := decodeMessage(readMessages(t, filepath.Join(out, "stops.pb"))[0], &stop); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Stop{ID: "A", Name: "Flinders St, Stop 1", Lat: -37.8183, Lon: 144.9671, WheelchairBoarding: 1}); stop != want {
+		t.Errorf("got %+v, want %+v", stop, want)
+	}
+}
+
+func TestAppendMessageRoundTrip(t *testing.T) {
+	for _, v := range []interface{}{
+		Calendar{ServiceID: "S1", Monday: true, Sunday: true, StartDate: "20240101", EndDate: "20241231"},
+		Route{ID: "R1", AgencyID: "1", ShortName: "Sandringham", Type: 2, Color: "FF0000"},
+		Trip{RouteID: "R1", ServiceID: "S1", ID: "T1", DirectionID: 1, WheelchairAccessible: 2},
+		ShapePoint{ShapeID: "SH1", Lat: -37.8184, Lon: 144.9525, Sequence: 3, DistTraveled: 12.5},
+		TransferRule{FromStopID: "A", ToStopID: "B", TransferType: 2, MinTransferTime: -1},
+	} {
+		b, err := appendMessage(nil, reflect.ValueOf(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.New(reflect.TypeOf(v))
+		if err := decodeMessage(b, got.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), v) {
+			t.Errorf("got %+v, want %+v", got.Elem().Interface(), v)
+		}
+	}
+}
+
+func TestAppendMessageUnsupportedField(t *testing.T) {
+	v := struct {
+		ID    string   `protobuf:"1"`
+		Names []string `protobuf:"2"`
+	}{ID: "A", Names: []string{"Flinders Street"}}
+	if _, err := appendMessage(nil, reflect.ValueOf(v)); err == nil {
+		t.Error("appendMessage encoded a field without a protobuf encoding")
+	}
+}
+
+// The protobuf tags of the typed records number their fields as gtfs.proto does.
+func TestProtobufTagsMatchProto(t *testing.T) {
+	b, err := ioutil.ReadFile("gtfs.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]reflect.Type{
+		"Agency":       reflect.TypeOf(Agency{}),
+		"Calendar":     reflect.TypeOf(Calendar{}),
+		"CalendarDate": reflect.TypeOf(CalendarDate{}),
+		"Route":        reflect.TypeOf(Route{}),
+		"Stop":         reflect.TypeOf(Stop{}),
+		"Trip":         reflect.TypeOf(Trip{}),
+		"StopTime":     reflect.TypeOf(StopTime{}),
+		"ShapePoint":   reflect.TypeOf(ShapePoint{}),
+		"Frequency":    reflect.TypeOf(Frequency{}),
+		"TransferRule": reflect.TypeOf(TransferRule{}),
+	}
+
+	messages := regexp.MustCompile(`(?s)message (\w+) \{(.*?)\}`).FindAllStringSubmatch(string(b), -1)
+	if len(messages) != len(types) {
+		t.Errorf("gtfs.proto has %d messages, want %d", len(messages), len(types))
+	}
+	field := regexp.MustCompile(`\w+ (\w+) = (\d+);`)
+	for _, m := range messages {
+		typ, ok := types[m[1]]
+		if !ok {
+			t.Errorf("no typed record for message %s", m[1])
+			continue
+		}
+		want := make(map[string]string)
+		for _, f := range field.FindAllStringSubmatch(m[2], -1) {
+			want[f[1]] = f[2]
+		}
+		got := make(map[string]string)
+		for i := 0; i < typ.NumField(); i++ {
+			got[typ.Field(i).Tag.Get("json")] = typ.Field(i).Tag.Get("protobuf")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s has fields %v, gtfs.proto %v", typ.Name(), got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gtfs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// RouteTypeColors maps the route_type codes of routes.txt to the route_color given to
+// routes which leave it blank when Options.FillRouteColors is set. It holds PTV's
+// colours for its modes, and may be modified to change them or to add others.
+var RouteTypeColors = map[int]string{
+	0: "78BE20",
+	1: "0072CE",
+	2: "0072CE",
+	3: "FF8200",
+	4: "00A7E1",
+}
+
+// RouteColor returns the colour of a route_type from RouteTypeColors, as a six digit
+// hex string. As with RouteTypeName, extended route types without a colour of their
+// own take that of their category, or of their basic type. Other types are given a colour derived from the
+// route_type itself, so that a type always has the same colour.
+func RouteColor(routeType int) string {
+	if color, ok := RouteTypeColors[routeType]; ok {
+		return color
+	}
+	if routeType >= 100 {
+		if color, ok := RouteTypeColors[routeType/100*100]; ok {
+			return color
+		}
+		if color, ok := RouteTypeColors[BasicRouteType(routeType)]; ok {
+			return color
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strconv.Itoa(routeType)))
+	return fmt.Sprintf("%06X", h.Sum32()&0xFFFFFF)
+}
+
+// ValidRouteColor returns whether s is a colour as given by route_color and
+// route_text_color: six hex digits, without a leading #.
+func ValidRouteColor(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 32)
+	return err == nil
+}
+
+// Returns the text colour legible against the background colour, black or white.
+func textColorFor(color string) string {
+	v, err := strconv.ParseUint(color, 16, 32)
+	if err != nil || len(color) != 6 {
+		return "000000"
+	}
+	r, g, b := float64(v>>16&0xFF), float64(v>>8&0xFF), float64(v&0xFF)
+	// The perceived brightness of the colour, from 0 to 255.
+	if 0.299*r+0.587*g+0.114*b > 150 {
+		return "000000"
+	}
+	return "FFFFFF"
+}
+
+// Derives the route_color column of a row of routes.txt, filling a blank colour with
+// that of its route_type.
+func routeColorColumn(h Header, row []string) string {
+	if color := h.value(row, "route_color"); color != "" {
+		return color
+	}
+	routeType, err := strconv.Atoi(h.value(row, "route_type"))
+	if err != nil {
+		return ""
+	}
+	return RouteColor(routeType)
+}
+
+// Derives the route_text_color column of a row of routes.txt, filling a blank colour
+// with whichever of black and white is legible against its route_color.
+func routeTextColorColumn(h Header, row []string) string {
+	if color := h.value(row, "route_text_color"); color != "" {
+		return color
+	}
+	color := routeColorColumn(h, row)
+	if color == "" {
+		return ""
+	}
+	return textColorFor(color)
+}
@@ -0,0 +1,134 @@
+package gtfs
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRouteColor(t *testing.T) {
+	for routeType, want := range map[int]string{0: "78BE20", 2: "0072CE", 3: "FF8200"} {
+		if got := RouteColor(routeType); got != want {
+			t.Errorf("RouteColor(%d) = %s, want %s", routeType, got, want)
+		}
+	}
+
+	// An extended type takes the colour of the basic type it falls under: buses.
+	if got := RouteColor(715); got != "FF8200" {
+		t.Errorf("RouteColor(715) = %s, want the colour of buses", got)
+	}
+
+	// Types without a colour are given one of their own, the same every time.
+	derived := RouteColor(5)
+	if !ValidRouteColor(derived) || RouteColor(5) != derived || RouteColor(6) == derived {
+		t.Errorf("RouteColor(5) = %s, RouteColor(6) = %s, want distinct and repeatable colours", derived, RouteColor(6))
+	}
+}
+
+func TestValidRouteColor(t *testing.T) {
+	for s, want := range map[string]bool{
+		"FF0000":  true,
+		"0072ce":  true,
+		"GGGGGG":  false,
+		"#FF0000": false,
+		"FFF":     false,
+		"+FFFFF":  false,
+		"":        false,
+	} {
+		if got := ValidRouteColor(s); got != want {
+			t.Errorf("ValidRouteColor(%q) = %t, want %t", s, got, want)
+		}
+	}
+}
+
+func TestTextColorFor(t *testing.T) {
+	for color, want := range map[string]string{
+		"FFFFFF": "000000",
+		"FFFF00": "000000",
+		"0072CE": "FFFFFF",
+		"000000": "FFFFFF",
+		"bad":    "000000",
+	} {
+		if got := textColorFor(color); got != want {
+			t.Errorf("textColorFor(%s) = %s, want %s", color, got, want)
+		}
+	}
+}
+
+// Consolidates files, filling blank route colours, and returns the routes written.
+func consolidateRouteColors(t *testing.T, files map[string]string) []Route {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, files)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.FillRouteColors = true
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := LoadFeed(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed.Routes
+}
+
+func TestConsolidateFillRouteColors(t *testing.T) {
+	routes := consolidateRouteColors(t, testFeed)
+	colors := make(map[string][2]string)
+	for _, r := range routes {
+		colors[r.ID] = [2]string{r.Color, r.TextColor}
+	}
+	want := map[string][2]string{
+		// R1's own colours are kept.
+		"R1": {"FF0000", "FFFFFF"},
+		// R2 is a tram, whose green is light enough for black text.
+		"R2": {"78BE20", "000000"},
+	}
+	if !reflect.DeepEqual(colors, want) {
+		t.Errorf("got colours %v, want %v", colors, want)
+	}
+}
+
+func TestConsolidateFillRouteColorsAddsColumns(t *testing.T) {
+	routes := consolidateRouteColors(t, withFiles(testFeed, map[string]string{
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R1,1,Sandringham,Sandringham Line,2\n" +
+			"R2,1,96,,0\n",
+	}))
+	for _, r := range routes {
+		if r.Color != RouteColor(r.Type) || r.TextColor != textColorFor(r.Color) {
+			t.Errorf("got route %+v, want the colours of route_type %d", r, r.Type)
+		}
+	}
+}
+
+func TestValidateRouteColors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type,route_color,route_text_color\n" +
+			"R1,1,Sandringham,Sandringham Line,2,GGGGGG,FFFFFF\n" +
+			"R2,1,96,,0,,#000000\n",
+	}))
+
+	var got []ValidationError
+	for _, err := range Validate(dir) {
+		var v ValidationError
+		if !errors.As(err, &v) {
+			t.Errorf("unexpected problem: %v", err)
+			continue
+		}
+		got = append(got, v)
+	}
+	// R2's blank route_color isn't a problem.
+	want := []ValidationError{
+		{File: "routes.txt", Row: 2, Column: "route_color", Value: "GGGGGG", Message: "of route R1 is not a six digit hex colour"},
+		{File: "routes.txt", Row: 3, Column: "route_text_color", Value: "#000000", Message: "of route R2 is not a six digit hex colour"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
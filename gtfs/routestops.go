@@ -0,0 +1,95 @@
+package gtfs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StopsForRoute returns every stop served by a trip of the route with the given
+// route_id in the GTFS feed in dir, each stop once. See Feed.StopsForRoute for the
+// order of the stops.
+func StopsForRoute(dir, routeID string) ([]Stop, error) {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil, err
+	}
+	return feed.StopsForRoute(routeID)
+}
+
+// StopsForRoute returns every stop served by a trip of the route with the given
+// route_id, each stop once.
+//
+// The stops are ordered as the route's longest trip calls at them. The stops of its
+// other trips which the longest doesn't call at are then slotted in after the stop at
+// which they were called at before, so that a branch or short working appears in the
+// order it runs. Trips of equal length are taken in the order they appear in f.Trips.
+//
+// Returns an error if the route doesn't exist or calls at a stop which doesn't.
+func (f *Feed) StopsForRoute(routeID string) ([]Stop, error) {
+	found := false
+	for _, r := range f.Routes {
+		if r.ID == routeID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("route %q not found", routeID)
+	}
+
+	byTrip := make(map[string][]StopTime)
+	for _, st := range f.StopTimes {
+		byTrip[st.TripID] = append(byTrip[st.TripID], st)
+	}
+	var sequences [][]StopTime
+	for _, t := range f.Trips {
+		if t.RouteID != routeID {
+			continue
+		}
+		sts := byTrip[t.ID]
+		sort.SliceStable(sts, func(i, j int) bool {
+			return sts[i].StopSequence < sts[j].StopSequence
+		})
+		sequences = append(sequences, sts)
+	}
+	sort.SliceStable(sequences, func(i, j int) bool {
+		return len(sequences[i]) > len(sequences[j])
+	})
+
+	var order []string
+	seen := make(map[string]bool)
+	for _, sts := range sequences {
+		// at is the position in order of the last stop of this trip already placed.
+		at := -1
+		for _, st := range sts {
+			if seen[st.StopID] {
+				for i, id := range order {
+					if id == st.StopID {
+						at = i
+						break
+					}
+				}
+				continue
+			}
+			seen[st.StopID] = true
+			at++
+			order = append(order, "")
+			copy(order[at+1:], order[at:])
+			order[at] = st.StopID
+		}
+	}
+
+	stops := make(map[string]Stop, len(f.Stops))
+	for _, s := range f.Stops {
+		stops[s.ID] = s
+	}
+	result := make([]Stop, len(order))
+	for i, id := range order {
+		s, ok := stops[id]
+		if !ok {
+			return nil, fmt.Errorf("stop %q of route %q not found", id, routeID)
+		}
+		result[i] = s
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package gtfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns a feed of the given trips, each a trip_id followed by the stop_ids it calls at
+// in turn. Trips are on route R1 unless their id starts with "R2-". Every stop called at
+// is added to the feed.
+func stopSequenceFeed(trips ...[]string) *Feed {
+	feed := &Feed{Routes: []Route{{ID: "R1"}, {ID: "R2"}}}
+	seen := make(map[string]bool)
+	for _, calls := range trips {
+		trip := Trip{ID: calls[0], RouteID: "R1"}
+		if strings.HasPrefix(trip.ID, "R2-") {
+			trip.RouteID = "R2"
+		}
+		feed.Trips = append(feed.Trips, trip)
+		// Stop times are given in reverse, so their stop_sequence has to be followed.
+		for i := len(calls) - 1; i > 0; i-- {
+			feed.StopTimes = append(feed.StopTimes, StopTime{TripID: trip.ID, StopID: calls[i], StopSequence: i * 10})
+			if !seen[calls[i]] {
+				seen[calls[i]] = true
+				feed.Stops = append(feed.Stops, Stop{ID: calls[i], Name: "Stop " + calls[i]})
+			}
+		}
+	}
+	return feed
+}
+
+func TestFeedStopsForRoute(t *testing.T) {
+	feed := stopSequenceFeed(
+		// A branch through X, and a short working starting before A at Y.
+		[]string{"T2", "A", "B", "X", "D"},
+		[]string{"T3", "Y", "A"},
+		[]string{"T1", "A", "B", "C", "D", "E"},
+		[]string{"R2-T4", "A", "Z"},
+	)
+	stops, err := feed.StopsForRoute("R1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stopIDs(stops), []string{"Y", "A", "B", "X", "C", "D", "E"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stops %v, want %v", got, want)
+	}
+	if stops[0].Name != "Stop Y" {
+		t.Errorf("got stop %+v, want the whole of stop Y", stops[0])
+	}
+}
+
+func TestFeedStopsForRouteEqualLengths(t *testing.T) {
+	// The first of the longest trips sets the order.
+	feed := stopSequenceFeed([]string{"T1", "A", "B", "C"}, []string{"T2", "C", "B", "A"})
+	stops, err := feed.StopsForRoute("R1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stopIDs(stops), []string{"A", "B", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stops %v, want %v", got, want)
+	}
+}
+
+func TestFeedStopsForRouteErrors(t *testing.T) {
+	feed := stopSequenceFeed([]string{"T1", "A", "B"})
+	if _, err := feed.StopsForRoute("R9"); err == nil {
+		t.Error("got no error for a route which doesn't exist")
+	}
+	feed.Stops = feed.Stops[:1]
+	if _, err := feed.StopsForRoute("R1"); err == nil {
+		t.Error("got no error for a stop which doesn't exist")
+	}
+	// A route without trips serves no stops.
+	if stops, err := feed.StopsForRoute("R2"); err != nil || len(stops) != 0 {
+		t.Errorf("got %v and error %v for a route without trips, want no stops", stops, err)
+	}
+}
+
+func TestStopsForRoute(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	stops, err := StopsForRoute(dir, "R1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// T3 leaves T1's route after A for D.
+	if got, want := stopIDs(stops), []string{"A", "D", "B", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stops %v, want %v", got, want)
+	}
+}
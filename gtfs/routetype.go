@@ -0,0 +1,97 @@
+package gtfs
+
+import "strconv"
+
+// RouteTypeNames maps the route_type codes of routes.txt to the names written to the
+// route_type_name column when Options.AddRouteTypeNames is set. It holds the basic GTFS
+// route types, and may be modified to rename them or to add others.
+var RouteTypeNames = map[int]string{
+	0:  "tram",
+	1:  "metro",
+	2:  "rail",
+	3:  "bus",
+	4:  "ferry",
+	5:  "cable_tram",
+	6:  "aerial_lift",
+	7:  "funicular",
+	11: "trolleybus",
+	12: "monorail",
+}
+
+// ExtendedRouteTypes maps the extended route_type codes used by some feeds, such as
+// 900 for trams, to the basic GTFS route type closest to them. Codes not listed take
+// the basic type of their category, e.g. 901 that of 900.
+var ExtendedRouteTypes = map[int]int{
+	100:  2,  // Railway
+	200:  3,  // Coach
+	400:  1,  // Urban railway
+	405:  12, // Monorail
+	700:  3,  // Bus
+	800:  11, // Trolleybus
+	900:  0,  // Tram
+	1000: 4,  // Water transport
+	1200: 4,  // Ferry
+	1300: 6,  // Aerial lift
+	1400: 7,  // Funicular
+}
+
+// BasicRouteType returns the basic GTFS route type of an extended route_type from
+// ExtendedRouteTypes, looking up its category if it isn't listed itself. Basic types,
+// and extended types without a basic equivalent, are returned as they are.
+func BasicRouteType(routeType int) int {
+	if routeType < 100 {
+		return routeType
+	}
+	if basic, ok := ExtendedRouteTypes[routeType]; ok {
+		return basic
+	}
+	if basic, ok := ExtendedRouteTypes[routeType/100*100]; ok {
+		return basic
+	}
+	return routeType
+}
+
+// Returns whether a route of the given route_type matches a route_type given to
+// Filter.RouteTypes. A basic type matches extended types of the same mode, e.g. 0 for
+// trams matches 900 and 901, and an extended category matches every type within it,
+// e.g. 900 matches 901. Other extended types only match themselves.
+func routeTypeMatches(routeType, want int) bool {
+	switch {
+	case routeType == want:
+		return true
+	case want < 100:
+		return BasicRouteType(routeType) == want
+	case want%100 == 0:
+		return routeType/100*100 == want
+	}
+	return false
+}
+
+// RouteTypeName returns the name of a route_type from RouteTypeNames. Extended route
+// types (100 and above) without a name of their own take the name of their category,
+// e.g. 701 is named after 700 if it isn't listed, or failing that the name of their
+// basic type from ExtendedRouteTypes, so 900 is "tram". Types without a name are
+// "unknown".
+func RouteTypeName(routeType int) string {
+	if name, ok := RouteTypeNames[routeType]; ok {
+		return name
+	}
+	if routeType >= 100 {
+		if name, ok := RouteTypeNames[routeType/100*100]; ok {
+			return name
+		}
+		if name, ok := RouteTypeNames[BasicRouteType(routeType)]; ok {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Derives the route_type_name column of a row of routes.txt.
+func routeTypeNameColumn(h Header, row []string) string {
+	routeType, err := strconv.Atoi(h.value(row, "route_type"))
+	if err != nil {
+		return "unknown"
+	}
+	return RouteTypeName(routeType)
+}
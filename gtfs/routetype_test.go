@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRouteTypeName(t *testing.T) {
+	for routeType, want := range map[int]string{
+		2:    "rail",
+		0:    "tram",
+		900:  "tram",
+		901:  "tram",
+		1200: "ferry",
+		8:    "unknown",
+		1700: "unknown",
+	} {
+		if got := RouteTypeName(routeType); got != want {
+			t.Errorf("RouteTypeName(%d) = %q, want %q", routeType, got, want)
+		}
+	}
+}
+
+func TestRouteTypeNameOverridden(t *testing.T) {
+	defer func(names map[int]string) { RouteTypeNames = names }(RouteTypeNames)
+	RouteTypeNames = map[int]string{2: "vline", 900: "light_rail"}
+
+	for routeType, want := range map[int]string{2: "vline", 901: "light_rail", 3: "unknown"} {
+		if got := RouteTypeName(routeType); got != want {
+			t.Errorf("RouteTypeName(%d) = %q, want %q", routeType, got, want)
+		}
+	}
+}
+
+func TestBasicRouteType(t *testing.T) {
+	for routeType, want := range map[int]int{3: 3, 700: 3, 712: 3, 405: 12, 401: 1, 1700: 1700} {
+		if got := BasicRouteType(routeType); got != want {
+			t.Errorf("BasicRouteType(%d) = %d, want %d", routeType, got, want)
+		}
+	}
+}
+
+func TestConsolidateRouteTypeNames(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{
+		"routes.txt": "route_id,route_short_name,route_type\nR1,Sandringham,2\nR2,96,900\nR3,Unknown,99\nR4,Broken,bus\n",
+	}))
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.AddRouteTypeNames = true
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(out, "routes.txt"))), "\n")
+	want := []string{
+		"route_id,route_short_name,route_type,route_type_name",
+		"R1,Sandringham,2,rail",
+		"R2,96,900,tram",
+		"R3,Unknown,99,unknown",
+		"R4,Broken,bus,unknown",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got routes.txt\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRouteTypeMatches(t *testing.T) {
+	tests := []struct {
+		routeType, want int
+		match           bool
+	}{
+		{0, 0, true},
+		{900, 0, true},
+		{901, 0, true},
+		{700, 3, true},
+		{200, 3, true},
+		{900, 900, true},
+		{901, 900, true},
+		{900, 901, false},
+		{0, 900, false},
+		{700, 900, false},
+		{3, 0, false},
+	}
+	for _, tt := range tests {
+		if got := routeTypeMatches(tt.routeType, tt.want); got != tt.match {
+			t.Errorf("routeTypeMatches(%d, %d) = %t, want %t", tt.routeType, tt.want, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package gtfs
+
+import "sync"
+
+// sampler limits each kind of GTFS file to the first n rows written, so that a large
+// feed can be consolidated quickly when testing. Once a kind has its n rows, the rest
+// of its files are no longer read.
+//
+// Its methods may be called on a nil sampler, which doesn't limit anything.
+type sampler struct {
+	n int
+
+	mu   sync.Mutex
+	full map[string]bool
+}
+
+// Returns a sampler keeping the first n rows of each kind of GTFS file, or nil if n
+// isn't positive.
+func newSampler(n int) *sampler {
+	if n <= 0 {
+		return nil
+	}
+	return &sampler{n: n, full: make(map[string]bool)}
+}
+
+// Returns the number of rows to keep of each kind of GTFS file, or zero for every row.
+func (s *sampler) limit() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+// Records that the given kind of GTFS file has its rows.
+func (s *sampler) fill(kind string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.full[kind] = true
+}
+
+// Returns whether the given kind of GTFS file has its rows, so needn't be read further.
+func (s *sampler) filled(kind string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.full[kind]
+}
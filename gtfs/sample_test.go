@@ -0,0 +1,91 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConsolidateSample(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	// Three feeds holding 200 distinct stop times each, of which only a few are taken.
+	writeCheckpointFeeds(t, in)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Sample = 5
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"agency.txt":     1,
+		"calendar.txt":   2,
+		"routes.txt":     2,
+		"shapes.txt":     4,
+		"stop_times.txt": 5,
+		"stops.txt":      5,
+		"trips.txt":      3,
+	}
+	for name, rows := range want {
+		lines := strings.Split(strings.TrimSuffix(readFile(t, filepath.Join(out, name)), "\n"), "\n")
+		if !strings.Contains(lines[0], "_id") {
+			t.Errorf("%s starts %q, want its header", name, lines[0])
+		}
+		if len(lines)-1 != rows {
+			t.Errorf("%s has %d rows, want %d", name, len(lines)-1, rows)
+		}
+	}
+}
+
+func TestConsolidateSampleTrips(t *testing.T) {
+	ids := idsOf(consolidateFiltered(t, testFeed, Filter{SampleTrips: 1}))
+	checkIDs(t, ids, feedIDs{
+		routes:    []string{"R1"},
+		trips:     []string{"T1"},
+		stopTimes: []string{"T1:A", "T1:B", "T1:C"},
+		stops:     []string{"A", "B", "C"},
+		shapes:    []string{"SH1"},
+		services:  []string{"S1"},
+	})
+
+	// The sample is taken from the trips matching the rest of the filter.
+	ids = idsOf(consolidateFiltered(t, testFeed, Filter{RouteTypes: []int{0}, SampleTrips: 1}))
+	checkIDs(t, ids, feedIDs{
+		routes:    []string{"R2"},
+		trips:     []string{"T2"},
+		stopTimes: []string{"T2:B", "T2:C"},
+		stops:     []string{"B", "C"},
+		shapes:    []string{"SH2"},
+		services:  []string{"S3"},
+	})
+
+	// Of the trips calling at a stop within the box, T3 alone calls at D.
+	box := &BoundingBox{MinLat: -36.5, MinLon: 145.5, MaxLat: -35.5, MaxLon: 146.5}
+	ids = idsOf(consolidateFiltered(t, testFeed, Filter{BBox: box, SampleTrips: 1}))
+	if want := []string{"T3"}; !reflect.DeepEqual(ids.trips, want) {
+		t.Errorf("got trips %v, want %v", ids.trips, want)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	var s *sampler
+	if s.limit() != 0 || s.filled("stops") {
+		t.Error("a nil sampler limits rows")
+	}
+	s.fill("stops")
+	if newSampler(0) != nil {
+		t.Error("newSampler(0) limits rows")
+	}
+
+	s = newSampler(3)
+	if s.limit() != 3 || s.filled("stops") {
+		t.Errorf("got limit %d, full %t, want 3 and false", s.limit(), s.filled("stops"))
+	}
+	s.fill("stops")
+	if !s.filled("stops") || s.filled("routes") {
+		t.Error("filling stops didn't fill stops alone")
+	}
+}
@@ -0,0 +1,188 @@
+package gtfs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StopArrival is a trip's scheduled call at one of its stops. Arrival and Departure are
+// measured from the start of the service day, as with ParseGTFSTime, so a trip running
+// past midnight arrives after 24 hours. Interpolated is set if the stop time had
+// neither an arrival_time nor a departure_time, and the call's times were estimated
+// from those of the stops either side.
+type StopArrival struct {
+	StopID       string
+	StopSequence int
+	Arrival      time.Duration
+	Departure    time.Duration
+	Interpolated bool
+}
+
+// TripSchedule returns the scheduled calls of the trip with the given trip_id in the
+// GTFS feed in dir, in the order the trip makes them. See Feed.TripSchedule.
+func TripSchedule(dir, tripID string) ([]StopArrival, error) {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil, err
+	}
+	return feed.TripSchedule(tripID)
+}
+
+// TripSchedule returns the scheduled calls of the trip with the given trip_id, ordered
+// by stop_sequence. A stop time missing one of arrival_time and departure_time takes
+// the other for both, and one missing both has its times interpolated between the
+// nearest stops either side which have them, by shape_dist_traveled where each of the
+// three stops has one and otherwise by the number of stops between them.
+//
+// Returns an error if the trip doesn't exist, has no stop times, or its first or last
+// stop has no time, as a time at every stop then can't be worked out. Use ScheduleFrom
+// to find the calls after a given stop, e.g. for a departures board.
+func (f *Feed) TripSchedule(tripID string) ([]StopArrival, error) {
+	found := false
+	for _, t := range f.Trips {
+		if t.ID == tripID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("trip %q not found", tripID)
+	}
+
+	var sts []StopTime
+	for _, st := range f.StopTimes {
+		if st.TripID == tripID {
+			sts = append(sts, st)
+		}
+	}
+	if len(sts) == 0 {
+		return nil, fmt.Errorf("trip %q has no stop times", tripID)
+	}
+	sort.SliceStable(sts, func(i, j int) bool { return sts[i].StopSequence < sts[j].StopSequence })
+	return scheduleStopTimes(tripID, sts)
+}
+
+// InterpolateStopTimes returns a copy of stopTimes in which the stop times left without
+// an arrival_time or departure_time, such as those between the timepoints of a trip,
+// are given both, interpolated between the nearest stop times of the same trip which
+// have them as with Feed.TripSchedule. Other stop times are left as they are, as are the
+// stop times of trips whose first or last stop has no time or whose times can't be
+// parsed. The stop times are returned in the same order as they're given.
+func InterpolateStopTimes(stopTimes []StopTime) []StopTime {
+	interpolated := make([]StopTime, len(stopTimes))
+	copy(interpolated, stopTimes)
+
+	// Only the trips with a stop time missing both times need interpolating.
+	blank := make(map[string]bool)
+	for _, st := range stopTimes {
+		if st.ArrivalTime == "" && st.DepartureTime == "" {
+			blank[st.TripID] = true
+		}
+	}
+	byTrip := make(map[string][]int, len(blank))
+	for i, st := range stopTimes {
+		if blank[st.TripID] {
+			byTrip[st.TripID] = append(byTrip[st.TripID], i)
+		}
+	}
+
+	for tripID, indices := range byTrip {
+		sort.SliceStable(indices, func(i, j int) bool {
+			return stopTimes[indices[i]].StopSequence < stopTimes[indices[j]].StopSequence
+		})
+		sts := make([]StopTime, len(indices))
+		for i, j := range indices {
+			sts[i] = stopTimes[j]
+		}
+
+		schedule, err := scheduleStopTimes(tripID, sts)
+		if err != nil {
+			continue
+		}
+		for i, a := range schedule {
+			if a.Interpolated {
+				st := &interpolated[indices[i]]
+				st.ArrivalTime, st.DepartureTime = FormatGTFSTime(a.Arrival), FormatGTFSTime(a.Departure)
+			}
+		}
+	}
+	return interpolated
+}
+
+// Returns the schedule of a trip from its stop times, sorted by stop_sequence, as
+// described by Feed.TripSchedule.
+func scheduleStopTimes(tripID string, sts []StopTime) ([]StopArrival, error) {
+	schedule := make([]StopArrival, len(sts))
+	known := make([]bool, len(sts))
+	for i, st := range sts {
+		schedule[i] = StopArrival{StopID: st.StopID, StopSequence: st.StopSequence}
+
+		arrival, arrErr := parseOptionalGTFSTime(st.ArrivalTime)
+		departure, depErr := parseOptionalGTFSTime(st.DepartureTime)
+		if arrErr != nil {
+			return nil, fmt.Errorf("trip %q stop_sequence %d: %w", tripID, st.StopSequence, arrErr)
+		}
+		if depErr != nil {
+			return nil, fmt.Errorf("trip %q stop_sequence %d: %w", tripID, st.StopSequence, depErr)
+		}
+		switch {
+		case arrival < 0 && departure < 0:
+			continue
+		case arrival < 0:
+			arrival = departure
+		case departure < 0:
+			departure = arrival
+		}
+		schedule[i].Arrival, schedule[i].Departure = arrival, departure
+		known[i] = true
+	}
+	if !known[0] || !known[len(sts)-1] {
+		return nil, fmt.Errorf("trip %q has no time at its first or last stop", tripID)
+	}
+
+	for prev, i := 0, 1; i < len(sts); i++ {
+		if !known[i] {
+			continue
+		}
+		for j := prev + 1; j < i; j++ {
+			d := schedule[prev].Departure + time.Duration(float64(schedule[i].Arrival-schedule[prev].Departure)*stopFraction(sts, prev, j, i))
+			// Timetables are given to the second.
+			d = d.Round(time.Second)
+			schedule[j].Arrival, schedule[j].Departure = d, d
+			schedule[j].Interpolated = true
+		}
+		prev = i
+	}
+	return schedule, nil
+}
+
+// ScheduleFrom returns the calls of schedule from the first call at the stop with the
+// given stop_id onwards, or nil if the schedule doesn't call there.
+func ScheduleFrom(schedule []StopArrival, stopID string) []StopArrival {
+	for i, a := range schedule {
+		if a.StopID == stopID {
+			return schedule[i:]
+		}
+	}
+	return nil
+}
+
+// Returns how far stop time j lies between stop times prev and next, from 0 at prev to
+// 1 at next: by shape_dist_traveled if the three of them have one which increases along
+// the trip, and otherwise by position.
+func stopFraction(sts []StopTime, prev, j, next int) float64 {
+	from, at, to := sts[prev].ShapeDistTraveled, sts[j].ShapeDistTraveled, sts[next].ShapeDistTraveled
+	if from < at && at < to {
+		return (at - from) / (to - from)
+	}
+	return float64(j-prev) / float64(next-prev)
+}
+
+// Parses a GTFS time which may be left empty, returning -1 if it is.
+func parseOptionalGTFSTime(s string) (time.Duration, error) {
+	if s == "" {
+		return -1, nil
+	}
+	return ParseGTFSTime(s)
+}
@@ -0,0 +1,151 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the time of day given in hours, minutes and seconds.
+func hms(h, m, s int) time.Duration {
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+}
+
+func TestTripSchedule(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+
+	got, err := TripSchedule(dir, "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StopArrival{
+		{StopID: "A", StopSequence: 1, Arrival: hms(8, 0, 0), Departure: hms(8, 0, 0)},
+		{StopID: "B", StopSequence: 2, Arrival: hms(8, 5, 0), Departure: hms(8, 6, 0)},
+		{StopID: "C", StopSequence: 3, Arrival: hms(8, 10, 0), Departure: hms(8, 10, 0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// T2 runs past midnight, into the 24th hour of its service day.
+	got, err = TripSchedule(dir, "T2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Arrival != hms(24, 10, 0) {
+		t.Errorf("got %+v, want T2 arriving at C at 24:10:00", got)
+	}
+
+	if from := ScheduleFrom(want, "B"); !reflect.DeepEqual(from, want[1:]) {
+		t.Errorf("got %+v from B, want %+v", from, want[1:])
+	}
+	if from := ScheduleFrom(want, "D"); from != nil {
+		t.Errorf("got %+v from a stop T1 doesn't call at", from)
+	}
+}
+
+func TestFeedTripScheduleMissingTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		stopTimes []StopTime
+		want      []StopArrival
+	}{
+		{
+			name: "by distance",
+			stopTimes: []StopTime{
+				// Out of order, as they may be in stop_times.txt.
+				{StopID: "C", StopSequence: 3, DepartureTime: "08:10:00", ShapeDistTraveled: 1000},
+				{StopID: "A", StopSequence: 1, ArrivalTime: "08:00:00", DepartureTime: "08:00:00"},
+				{StopID: "B", StopSequence: 2, ShapeDistTraveled: 750},
+			},
+			want: []StopArrival{
+				{StopID: "A", StopSequence: 1, Arrival: hms(8, 0, 0), Departure: hms(8, 0, 0)},
+				{StopID: "B", StopSequence: 2, Arrival: hms(8, 7, 30), Departure: hms(8, 7, 30), Interpolated: true},
+				{StopID: "C", StopSequence: 3, Arrival: hms(8, 10, 0), Departure: hms(8, 10, 0)},
+			},
+		},
+		{
+			name: "by position",
+			stopTimes: []StopTime{
+				{StopID: "A", StopSequence: 1, ArrivalTime: "08:00:00"},
+				{StopID: "B", StopSequence: 2},
+				{StopID: "C", StopSequence: 3},
+				{StopID: "D", StopSequence: 4, ArrivalTime: "08:09:00", DepartureTime: "08:09:00"},
+			},
+			want: []StopArrival{
+				{StopID: "A", StopSequence: 1, Arrival: hms(8, 0, 0), Departure: hms(8, 0, 0)},
+				{StopID: "B", StopSequence: 2, Arrival: hms(8, 3, 0), Departure: hms(8, 3, 0), Interpolated: true},
+				{StopID: "C", StopSequence: 3, Arrival: hms(8, 6, 0), Departure: hms(8, 6, 0), Interpolated: true},
+				{StopID: "D", StopSequence: 4, Arrival: hms(8, 9, 0), Departure: hms(8, 9, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		feed := &Feed{Trips: []Trip{{ID: "T1"}}}
+		for _, st := range tt.stopTimes {
+			st.TripID = "T1"
+			feed.StopTimes = append(feed.StopTimes, st)
+		}
+		got, err := feed.TripSchedule("T1")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFeedTripScheduleErrors(t *testing.T) {
+	feed := &Feed{
+		Trips: []Trip{{ID: "T1"}, {ID: "T2"}, {ID: "T3"}, {ID: "T4"}},
+		StopTimes: []StopTime{
+			{TripID: "T2", StopID: "A", StopSequence: 1},
+			{TripID: "T2", StopID: "B", StopSequence: 2, ArrivalTime: "08:05:00"},
+			{TripID: "T3", StopID: "A", StopSequence: 1, ArrivalTime: "08:00:00"},
+			{TripID: "T3", StopID: "B", StopSequence: 2, ArrivalTime: "half past eight"},
+			{TripID: "T4", StopID: "A", StopSequence: 1, ArrivalTime: "08:00:00"},
+			{TripID: "T4", StopID: "B", StopSequence: 2},
+		},
+	}
+	// T1 has no stop times, T2 and T4 lack a time at one end, and T3's can't be parsed.
+	for _, id := range []string{"T1", "T2", "T3", "T4", "T9"} {
+		if got, err := feed.TripSchedule(id); err == nil {
+			t.Errorf("got schedule %+v of trip %s, want an error", got, id)
+		}
+	}
+}
+
+func TestInterpolateStopTimes(t *testing.T) {
+	stopTimes := []StopTime{
+		// T1 has timepoints at A and D, with B and C between them by distance.
+		{TripID: "T1", StopID: "D", StopSequence: 4, ArrivalTime: "08:20:00", DepartureTime: "08:20:00", ShapeDistTraveled: 2000},
+		{TripID: "T1", StopID: "A", StopSequence: 1, ArrivalTime: "08:00:00", DepartureTime: "08:00:00", ShapeDistTraveled: 0},
+		{TripID: "T1", StopID: "B", StopSequence: 2, ShapeDistTraveled: 500},
+		{TripID: "T1", StopID: "C", StopSequence: 3, ShapeDistTraveled: 1500},
+		// T2's times are spread evenly between its stops, which have no distances.
+		{TripID: "T2", StopID: "A", StopSequence: 1, DepartureTime: "23:50:00"},
+		{TripID: "T2", StopID: "B", StopSequence: 2},
+		{TripID: "T2", StopID: "C", StopSequence: 3, ArrivalTime: "24:10:00"},
+		// T3 can't be interpolated without a time at its last stop.
+		{TripID: "T3", StopID: "A", StopSequence: 1, DepartureTime: "09:00:00"},
+		{TripID: "T3", StopID: "B", StopSequence: 2},
+	}
+	original := make([]StopTime, len(stopTimes))
+	copy(original, stopTimes)
+
+	got := InterpolateStopTimes(stopTimes)
+	want := make([]StopTime, len(stopTimes))
+	copy(want, stopTimes)
+	want[2].ArrivalTime, want[2].DepartureTime = "08:05:00", "08:05:00"
+	want[3].ArrivalTime, want[3].DepartureTime = "08:15:00", "08:15:00"
+	want[5].ArrivalTime, want[5].DepartureTime = "24:00:00", "24:00:00"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if !reflect.DeepEqual(stopTimes, original) {
+		t.Error("the stop times given were modified")
+	}
+}
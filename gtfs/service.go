@@ -0,0 +1,174 @@
+package gtfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunsOn returns whether the calendar's weekly pattern includes the weekday of day.
+// It doesn't consider the calendar's start and end dates.
+func (c Calendar) RunsOn(day time.Weekday) bool {
+	switch day {
+	case time.Monday:
+		return c.Monday
+	case time.Tuesday:
+		return c.Tuesday
+	case time.Wednesday:
+		return c.Wednesday
+	case time.Thursday:
+		return c.Thursday
+	case time.Friday:
+		return c.Friday
+	case time.Saturday:
+		return c.Saturday
+	default:
+		return c.Sunday
+	}
+}
+
+// ActiveServices returns the set of service_ids which run on the given day. A service
+// runs if its calendar covers the day, unless calendar_dates removes it on that date
+// (exception_type 2). Services may also be added for the date by calendar_dates
+// (exception_type 1), whether or not they appear in calendar at all. Only the year,
+// month and day of day are considered.
+func ActiveServices(calendars []Calendar, dates []CalendarDate, day time.Time) map[string]bool {
+	date := day.Format(gtfsDateLayout)
+	active := make(map[string]bool)
+
+	for _, c := range calendars {
+		// YYYYMMDD dates compare chronologically as strings.
+		if date < c.StartDate || date > c.EndDate || !c.RunsOn(day.Weekday()) {
+			continue
+		}
+		active[c.ServiceID] = true
+	}
+
+	for _, cd := range dates {
+		if cd.Date != date {
+			continue
+		}
+		switch cd.ExceptionType {
+		case 1:
+			active[cd.ServiceID] = true
+		case 2:
+			delete(active, cd.ServiceID)
+		}
+	}
+	return active
+}
+
+// TripInstance is a trip run on a particular date. Date is midnight at the start of the
+// service day, so the times of the trip's stop times are measured from it as with
+// ParseGTFSTime.
+type TripInstance struct {
+	TripID    string
+	RouteID   string
+	ServiceID string
+	Date      time.Time
+}
+
+// ExpandTrips returns a TripInstance for every date from from to to, inclusive, on which
+// each trip of the GTFS feed in dir runs. Returns an error if the feed can't be loaded.
+func ExpandTrips(dir string, from, to time.Time) ([]TripInstance, error) {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil, err
+	}
+	return feed.ExpandTrips(from, to), nil
+}
+
+// ExpandTrips returns a TripInstance for every date from from to to, inclusive, on which
+// each trip of the feed runs, as given by ActiveServices so that the exceptions of
+// calendar_dates are respected. Only the year, month and day of from and to are
+// considered, and each Date is in the location of from. The instances are ordered by
+// date, and then as the trips appear in f.Trips.
+func (f *Feed) ExpandTrips(from, to time.Time) []TripInstance {
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	last := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, from.Location())
+
+	var instances []TripInstance
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		active := ActiveServices(f.Calendars, f.CalendarDates, day)
+		if len(active) == 0 {
+			continue
+		}
+		for _, t := range f.Trips {
+			if active[t.ServiceID] {
+				instances = append(instances, TripInstance{TripID: t.ID, RouteID: t.RouteID, ServiceID: t.ServiceID, Date: day})
+			}
+		}
+	}
+	return instances
+}
+
+// ServiceWindow returns the first and last dates, as YYYYMMDD, on which any service may
+// run: the earliest start_date and latest end_date in calendars, widened to cover any
+// date on which calendar_dates adds a service (exception_type 1). Both are empty if
+// there are no such dates.
+func ServiceWindow(calendars []Calendar, dates []CalendarDate) (string, string) {
+	var first, last string
+	widen := func(start, end string) {
+		// YYYYMMDD dates compare chronologically as strings.
+		if start != "" && (first == "" || start < first) {
+			first = start
+		}
+		if end != "" && end > last {
+			last = end
+		}
+	}
+
+	for _, c := range calendars {
+		widen(c.StartDate, c.EndDate)
+	}
+	for _, cd := range dates {
+		if cd.ExceptionType == 1 {
+			widen(cd.Date, cd.Date)
+		}
+	}
+	return first, last
+}
+
+// Returns the last date, as YYYYMMDD, on which any service of the GTFS files beneath
+// path may run, as found by ServiceWindow across all of their calendar and
+// calendar_dates files, whose fields are separated by comma. Rows which can't be parsed,
+// or are malformed as walkPTVData would skip them, are skipped. Returns an empty string if there's no such date.
+func sourceServiceEnd(path string, comma rune) (string, error) {
+	var calendars []Calendar
+	var dates []CalendarDate
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("access %s: %w", path, err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch info.Name() {
+		case "calendar.txt", "calendar.txt.gz":
+			return readDelimitedFileSkippingMalformed(path, comma, func(h Header, row []string) error {
+				c, err := ParseCalendar(h, row)
+				if err != nil {
+					return nil
+				}
+				calendars = append(calendars, c)
+				return nil
+			})
+		case "calendar_dates.txt", "calendar_dates.txt.gz":
+			return readDelimitedFileSkippingMalformed(path, comma, func(h Header, row []string) error {
+				cd, err := ParseCalendarDate(h, row)
+				if err != nil {
+					return nil
+				}
+				dates = append(dates, cd)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	_, last := ServiceWindow(calendars, dates)
+	return last, nil
+}
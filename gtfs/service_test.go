@@ -0,0 +1,203 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveServices(t *testing.T) {
+	calendars := []Calendar{
+		{ServiceID: "weekdays", Monday: true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true, StartDate: "20240101", EndDate: "20241231"},
+		{ServiceID: "expired", Saturday: true, Sunday: true, StartDate: "20200101", EndDate: "20201231"},
+	}
+	dates := []CalendarDate{
+		{ServiceID: "special", Date: "20240601", ExceptionType: 1},
+		{ServiceID: "weekdays", Date: "20240603", ExceptionType: 2},
+	}
+	tests := []struct {
+		date string
+		want map[string]bool
+	}{
+		{"20240601", map[string]bool{"special": true}},
+		{"20240603", map[string]bool{}},
+		{"20240604", map[string]bool{"weekdays": true}},
+		{"20240101", map[string]bool{"weekdays": true}},
+		{"20241231", map[string]bool{"weekdays": true}},
+		{"20250101", map[string]bool{}},
+		{"20200104", map[string]bool{"expired": true}},
+	}
+	for _, tt := range tests {
+		day, err := ParseGTFSDate(tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ActiveServices(calendars, dates, day); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ActiveServices on %s = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestActiveServicesIgnoresTimeOfDay(t *testing.T) {
+	calendars := []Calendar{{ServiceID: "S1", Saturday: true, StartDate: "20240601", EndDate: "20240601"}}
+	day := time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC)
+	if got := ActiveServices(calendars, nil, day); !got["S1"] {
+		t.Errorf("S1 isn't active late on its only day: %v", got)
+	}
+}
+
+func TestServiceWindow(t *testing.T) {
+	calendars := []Calendar{
+		{ServiceID: "S1", StartDate: "20240101", EndDate: "20241231"},
+		{ServiceID: "S2", StartDate: "20230601", EndDate: "20240630"},
+	}
+	dates := []CalendarDate{
+		{ServiceID: "S3", Date: "20250105", ExceptionType: 1},
+		// Removing a service on a date doesn't widen the window.
+		{ServiceID: "S1", Date: "20221225", ExceptionType: 2},
+	}
+	if first, last := ServiceWindow(calendars, dates); first != "20230601" || last != "20250105" {
+		t.Errorf("got window %s to %s, want 20230601 to 20250105", first, last)
+	}
+	if first, last := ServiceWindow(nil, dates[1:]); first != "" || last != "" {
+		t.Errorf("got window %q to %q without any services, want neither", first, last)
+	}
+}
+
+// Returns testFeed with every service expired by the end of 2020.
+func expiredFeed() map[string]string {
+	return withFiles(testFeed, map[string]string{
+		"calendar.txt":       "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nS1,1,1,1,1,1,0,0,20200101,20201130\nS2,0,0,0,0,0,1,1,20200101,20201231\n",
+		"calendar_dates.txt": "service_id,date,exception_type\nS3,20200601,1\nS1,20210603,2\n",
+	})
+}
+
+func TestConsolidateWarnsOfExpiredFeed(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, expiredFeed())
+
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Every service in the feed has expired, the last on 20201231"; !strings.Contains(logged.String(), want) {
+		t.Errorf("didn't warn %q:\n%s", want, logged.String())
+	}
+
+	opts.Strict = true
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); !errors.Is(err, ErrStrict) {
+		t.Errorf("got error %v in strict mode, want ErrStrict", err)
+	}
+}
+
+func TestConsolidateDoesntWarnOfCurrentFeed(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	// Only S3's date, added well into the future, keeps the feed from having expired.
+	writeFiles(t, in, withFiles(expiredFeed(), map[string]string{
+		"calendar_dates.txt": "service_id,date,exception_type\nS3,20990601,1\n",
+	}))
+
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged.String(), "expired") {
+		t.Errorf("warned of a feed which hasn't expired:\n%s", logged.String())
+	}
+}
+
+func TestConsolidateMalformedCalendarRow(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(expiredFeed(), map[string]string{
+		"calendar.txt": expiredFeed()["calendar.txt"] + "S9,1,1\n",
+	}))
+
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	// The walk skips the malformed row as one of few enough, so looking for the last
+	// service mustn't fail on it either.
+	opts.MaxMalformedRows = 0.5
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readRows(t, filepath.Join(out, "calendar.txt"))); got != 2 {
+		t.Errorf("calendar.txt has %d rows, want 2", got)
+	}
+	if !strings.Contains(logged.String(), "the last on 20201231") {
+		t.Errorf("didn't warn that the feed has expired:\n%s", logged.String())
+	}
+
+	last, err := sourceServiceEnd(in, ',')
+	if err != nil || last != "20201231" {
+		t.Errorf("got last service %q and error %v, want 20201231", last, err)
+	}
+}
+
+func TestExpandTrips(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+
+	// S1 runs on the Thursday and Friday, and S3 on the Saturday alone.
+	from := time.Date(2024, 5, 30, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	want := []TripInstance{
+		{TripID: "T1", RouteID: "R1", ServiceID: "S1", Date: from},
+		{TripID: "T1", RouteID: "R1", ServiceID: "S1", Date: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{TripID: "T2", RouteID: "R2", ServiceID: "S3", Date: to},
+	}
+	if got, err := ExpandTrips(dir, from, to); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got instances %+v and error %v, want %+v", got, err, want)
+	}
+
+	// S1 is removed on the Monday.
+	from = time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	to = time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)
+	want = []TripInstance{{TripID: "T1", RouteID: "R1", ServiceID: "S1", Date: to}}
+	if got, err := ExpandTrips(dir, from, to); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got instances %+v and error %v, want %+v", got, err, want)
+	}
+
+	if got, err := ExpandTrips(dir, to, from); err != nil || len(got) != 0 {
+		t.Errorf("got instances %+v and error %v for a range which ends before it starts", got, err)
+	}
+	unloadable := t.TempDir()
+	writeFiles(t, unloadable, withFiles(testFeed, map[string]string{"trips.txt": "\"route_id,trip_id\n"}))
+	if got, err := ExpandTrips(unloadable, from, to); err == nil || got != nil {
+		t.Errorf("got instances %+v and no error from a feed which can't be loaded", got)
+	}
+}
+
+func TestFeedExpandTripsIgnoresTimeOfDay(t *testing.T) {
+	melbourne := loadLocation(t, "Australia/Melbourne")
+	feed := &Feed{
+		Calendars: []Calendar{{ServiceID: "S1", Friday: true, Saturday: true, StartDate: "20240101", EndDate: "20241231"}},
+		Trips:     []Trip{{ID: "T1", RouteID: "R1", ServiceID: "S1"}, {ID: "T2", RouteID: "R1", ServiceID: "S1"}},
+	}
+	// Only the dates of from and to count, however late or early in the day they are.
+	from := time.Date(2024, 5, 31, 18, 30, 0, 0, melbourne)
+	to := time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC)
+	got := feed.ExpandTrips(from, to)
+	var ids []string
+	for _, in := range got {
+		ids = append(ids, in.TripID+"@"+in.Date.Format("2006-01-02"))
+		if in.Date.Location() != melbourne || in.Date.Hour() != 0 {
+			t.Errorf("got date %s, want midnight in Melbourne", in.Date)
+		}
+	}
+	if want := []string{"T1@2024-05-31", "T2@2024-05-31", "T1@2024-06-01", "T2@2024-06-01"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got instances %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,231 @@
+package gtfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Sink receives the files of a consolidated feed, such as a local directory, a bucket
+// in remote storage or a stream. Files may be written concurrently with one another.
+type Sink interface {
+	// Create returns a writer for the file with the given name, such as stops.txt. The
+	// file is complete once the writer has been closed without error.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// dirSink writes each file to a directory on the local filesystem, with prefix added
+// to the start of its name.
+type dirSink struct {
+	path   string
+	prefix string
+}
+
+// NewDirSink returns a Sink writing each file to the directory at path, which is
+// created if it doesn't exist. Files already in the directory are replaced.
+func NewDirSink(path string) (Sink, error) {
+	return newDirSink(path, "")
+}
+
+// Like NewDirSink, but adds prefix to the start of the name of each file.
+func newDirSink(path, prefix string) (dirSink, error) {
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return dirSink{}, err
+	}
+	return dirSink{path: path, prefix: prefix}, nil
+}
+
+func (s dirSink) Create(name string) (io.WriteCloser, error) {
+	path := filepath.Join(s.path, s.prefix+name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create output file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// streamSink interleaves the records of every file written to it on a single stream,
+// tagging each with the name of its file.
+type streamSink struct {
+	mu sync.Mutex
+	w  io.Writer
+	// quoted is set if a record may span several lines within double quotes, as in CSV.
+	quoted bool
+	// tag appends a record of the named file, without its line ending, to out as it's
+	// to appear on the stream.
+	tag func(out []byte, name string, record []byte) ([]byte, error)
+}
+
+// NewNDJSONSink returns a Sink concatenating every file written to it onto w, such as
+// os.Stdout, as a single stream of newline delimited JSON. Each line of a file is
+// wrapped in an object naming the file it belongs to, e.g.
+//
+//	{"file":"stops.ndjson","record":{"stop_id":"1000",...}}
+//
+// The files must themselves be newline delimited JSON, as written by FormatNDJSON.
+// Lines of different files are interleaved as they're written, but never split.
+func NewNDJSONSink(w io.Writer) Sink {
+	return &streamSink{w: w, tag: tagNDJSON}
+}
+
+// NewCSVStreamSink returns a Sink concatenating every file written to it onto w, such
+// as os.Stdout, as a single stream of CSV records. Each record of a file, including its
+// header, is prefixed with a field naming the file it belongs to, e.g.
+//
+//	stops.txt,stop_id,stop_name,stop_lat,stop_lon
+//	stops.txt,1000,Flinders Street,-37.8183,144.9671
+//
+// so a consumer can split each line at its first comma to demultiplex the files. The
+// files must themselves be CSV, as written by FormatCSV. Records of different files
+// are interleaved as they're written, but never split, even those with a quoted field
+// spanning several lines.
+func NewCSVStreamSink(w io.Writer) Sink {
+	return NewDelimitedStreamSink(w, ',')
+}
+
+// NewDelimitedStreamSink is like NewCSVStreamSink, but for files whose fields are
+// separated by comma, such as those written with an Options.OutputDelimiter of ';'.
+// The field naming each record's file is separated from it by comma too, so that the
+// stream can be read with the same delimiter as the files. A zero comma means a comma.
+func NewDelimitedStreamSink(w io.Writer, comma rune) Sink {
+	if comma == 0 {
+		comma = ','
+	}
+	return &streamSink{w: w, quoted: true, tag: tagCSV(comma)}
+}
+
+func (s *streamSink) Create(name string) (io.WriteCloser, error) {
+	return &streamSinkFile{sink: s, name: name}, nil
+}
+
+// Wraps a line of newline delimited JSON in an object naming its file.
+func tagNDJSON(out []byte, name string, line []byte) ([]byte, error) {
+	if !json.Valid(line) {
+		return nil, fmt.Errorf("%s can't be written as newline delimited JSON: %q isn't JSON", name, line)
+	}
+	tag, err := json.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, `{"file":`...)
+	out = append(out, tag...)
+	out = append(out, `,"record":`...)
+	out = append(out, line...)
+	return append(out, "}\n"...), nil
+}
+
+// Returns a tag prefixing a CSV record, whose fields are separated by comma, with a
+// field naming its file.
+func tagCSV(comma rune) func(out []byte, name string, record []byte) ([]byte, error) {
+	sep := string(comma)
+	return func(out []byte, name string, record []byte) ([]byte, error) {
+		if strings.ContainsAny(name, sep+"\"\r\n") {
+			return nil, fmt.Errorf("%s can't be named in a CSV stream", name)
+		}
+		out = append(out, name...)
+		out = append(out, sep...)
+		out = append(out, bytes.TrimSuffix(record, []byte("\r"))...)
+		return append(out, '\n'), nil
+	}
+}
+
+// streamSinkFile buffers a file written to a streamSink until it has a complete record
+// to hand on.
+type streamSinkFile struct {
+	sink *streamSink
+	name string
+	buf  []byte
+	out  []byte
+	// scanned is how much of buf is known not to end a record, and inQuotes whether
+	// that much of it leaves a quoted field open.
+	scanned  int
+	inQuotes bool
+}
+
+// streamSinkBatch is the size of the complete records a file gathers before writing
+// them to the stream, so that the stream isn't locked for every record.
+const streamSinkBatch = 64 << 10
+
+func (f *streamSinkFile) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := f.recordEnd()
+		if i < 0 {
+			break
+		}
+		if err := f.wrap(f.buf[:i]); err != nil {
+			return 0, err
+		}
+		f.buf = f.buf[i+1:]
+		f.scanned = 0
+	}
+	// Keep the partial record at the start of the buffer, rather than growing it forever.
+	f.buf = append(f.buf[:0:0], f.buf...)
+
+	if len(f.out) >= streamSinkBatch {
+		if err := f.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Returns the index in buf of the newline ending the first record, or -1 if it's yet
+// to be written. Newlines within a quoted field don't end a record of a quoted sink.
+func (f *streamSinkFile) recordEnd() int {
+	if !f.sink.quoted {
+		return bytes.IndexByte(f.buf, '\n')
+	}
+	for i := f.scanned; i < len(f.buf); i++ {
+		switch f.buf[i] {
+		case '"':
+			// An escaped quote closes and reopens the field, leaving it open.
+			f.inQuotes = !f.inQuotes
+		case '\n':
+			if !f.inQuotes {
+				return i
+			}
+		}
+	}
+	f.scanned = len(f.buf)
+	return -1
+}
+
+// Appends a record of the file to the records waiting to be written, tagged with the
+// name of the file.
+func (f *streamSinkFile) wrap(record []byte) error {
+	if len(bytes.TrimSpace(record)) == 0 {
+		return nil
+	}
+	out, err := f.sink.tag(f.out, f.name, record)
+	if err != nil {
+		return err
+	}
+	f.out = out
+	return nil
+}
+
+// Writes the waiting records to the stream.
+func (f *streamSinkFile) flush() error {
+	if len(f.out) == 0 {
+		return nil
+	}
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	_, err := f.sink.w.Write(f.out)
+	f.out = f.out[:0]
+	return err
+}
+
+func (f *streamSinkFile) Close() error {
+	if err := f.wrap(f.buf); err != nil {
+		return err
+	}
+	f.buf = nil
+	return f.flush()
+}
@@ -0,0 +1,254 @@
+package gtfs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memSink is a Sink holding the bytes of each file written to it in memory.
+type memSink struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+	// closed counts the files whose writers have been closed.
+	closed int
+}
+
+func newMemSink() *memSink {
+	return &memSink{files: make(map[string]*bytes.Buffer)}
+}
+
+func (s *memSink) Create(name string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := &bytes.Buffer{}
+	s.files[name] = buf
+	return &memSinkFile{sink: s, buf: buf}, nil
+}
+
+type memSinkFile struct {
+	sink *memSink
+	buf  *bytes.Buffer
+}
+
+func (f *memSinkFile) Write(p []byte) (int, error) {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *memSinkFile) Close() error {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	f.sink.closed++
+	return nil
+}
+
+func TestConsolidateToSink(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	sink := newMemSink()
+	opts := testOptions(dir)
+	opts.SkipArchive = false
+	opts.Sink = sink
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int{"agency.txt": 1, "stops.txt": 4, "stop_times.txt": 7, "trips.txt": 3} {
+		buf, ok := sink.files[name]
+		if !ok {
+			t.Errorf("%s wasn't written to the sink", name)
+			continue
+		}
+		rows, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(rows)-1 != want {
+			t.Errorf("%s has %d rows, want %d", name, len(rows)-1, want)
+		}
+	}
+	if sink.closed != len(sink.files) {
+		t.Errorf("closed %d of %d files", sink.closed, len(sink.files))
+	}
+	// Nothing is written to the output directory, nor archived from it.
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 1 {
+		t.Errorf("left %d files in %s, want the input alone", len(infos), dir)
+	}
+}
+
+func TestConsolidateToNDJSONSink(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	var stream bytes.Buffer
+	opts := testOptions(dir)
+	opts.Format = FormatNDJSON
+	opts.Sink = NewNDJSONSink(&stream)
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(stream.String()), "\n") {
+		var tagged struct {
+			File   string          `json:"file"`
+			Record json.RawMessage `json:"record"`
+		}
+		if err := json.Unmarshal([]byte(line), &tagged); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		counts[tagged.File]++
+		if tagged.File == "stops.ndjson" {
+			var s Stop
+			if err := json.Unmarshal(tagged.Record, &s); err != nil || s.ID == "" {
+				t.Errorf("got stop %q, error %v", tagged.Record, err)
+			}
+		}
+	}
+	for name, want := range map[string]int{"stops.ndjson": 4, "stop_times.ndjson": 7, "trips.ndjson": 3} {
+		if counts[name] != want {
+			t.Errorf("got %d records of %s, want %d", counts[name], name, want)
+		}
+	}
+}
+
+func TestCSVStreamSinkKeepsRecordsWhole(t *testing.T) {
+	var stream bytes.Buffer
+	sink := NewCSVStreamSink(&stream)
+	stops, err := sink.Create("stops.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := sink.Create("routes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are written a few bytes at a time, interleaved between the files, and one
+	// spans two lines within quotes.
+	writes := []struct {
+		w io.Writer
+		s string
+	}{
+		{stops, "stop_id,stop_name\r\nA,\"Flinders"},
+		{routes, "route_id\nR1"},
+		{stops, " St,\nStop 1\"\n"},
+		{stops, "B,Southern Cross"},
+		{routes, "\n"},
+	}
+	for _, w := range writes {
+		if _, err := io.WriteString(w.w, w.s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []io.Closer{stops, routes} {
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := csv.NewReader(&stream)
+	// The files have differing numbers of fields.
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byFile := make(map[string][][]string)
+	for _, rec := range records {
+		byFile[rec[0]] = append(byFile[rec[0]], rec[1:])
+	}
+	wantStops := [][]string{{"stop_id", "stop_name"}, {"A", "Flinders St,\nStop 1"}, {"B", "Southern Cross"}}
+	if got := byFile["stops.txt"]; len(got) != len(wantStops) || got[1][1] != wantStops[1][1] || got[2][1] != wantStops[2][1] {
+		t.Errorf("got stops %q, want %q", got, wantStops)
+	}
+	if got := byFile["routes.txt"]; len(got) != 2 || got[1][0] != "R1" {
+		t.Errorf("got routes %q, want route_id and R1", got)
+	}
+}
+
+func TestDelimitedStreamSink(t *testing.T) {
+	var stream bytes.Buffer
+	f, err := NewDelimitedStreamSink(&stream, ';').Create("stops.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "stop_id;stop_name\nA;\"Flinders St, Stop 1\"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file's name is separated from each record by the files' own delimiter.
+	r := csv.NewReader(&stream)
+	r.Comma = ';'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"stops.txt", "stop_id", "stop_name"}, {"stops.txt", "A", "Flinders St, Stop 1"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got records %q, want %q", records, want)
+	}
+
+	f, err = NewDelimitedStreamSink(ioutil.Discard, ';').Create("stops;2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "stop_id\n"); err == nil {
+		t.Error("wrote a file whose name holds the delimiter to a delimited stream")
+	}
+}
+
+func TestStreamSinkErrors(t *testing.T) {
+	f, err := NewNDJSONSink(ioutil.Discard).Create("stops.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "stop_id,stop_name\n"); err == nil {
+		t.Error("wrote a line which isn't JSON to an NDJSON sink")
+	}
+
+	f, err = NewCSVStreamSink(ioutil.Discard).Create("stops,2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "stop_id\n"); err == nil {
+		t.Error("wrote a file whose name holds a comma to a CSV stream")
+	}
+}
+
+func TestNewDirSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b")
+	sink, err := NewDirSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := sink.Create("stops.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "stop_id\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(path, "stops.txt")); got != "stop_id\n" {
+		t.Errorf("wrote %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"math"
+	"sort"
+)
+
+// SnapStopsToShape returns the distance in meters along the shape of trip at which each
+// of stops lies, for filling in shape_dist_traveled where a feed leaves it blank. stops
+// must be given in the order the trip calls at them; the points of shape which belong
+// to the trip's shape_id are joined in order of their sequence.
+//
+// Each stop is projected onto the nearest segment of the polyline at or beyond where
+// the previous stop was snapped to, so the distances never decrease even where the
+// shape doubles back close to itself. Returns nil if shape has no points of the trip's
+// shape.
+func SnapStopsToShape(trip Trip, shape []ShapePoint, stops []Stop) []float64 {
+	var points []ShapePoint
+	for _, p := range shape {
+		if p.ShapeID == trip.ShapeID {
+			points = append(points, p)
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Sequence < points[j].Sequence
+	})
+
+	// along[i] is the distance along the shape to points[i].
+	along := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		along[i] = along[i-1] + Haversine(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+
+	dists := make([]float64, len(stops))
+	if len(points) == 1 {
+		return dists
+	}
+
+	segment, from := 0, 0.0
+	for i, s := range stops {
+		bestSegment, bestT, bestOffset := segment, from, math.Inf(1)
+		for j := segment; j < len(points)-1; j++ {
+			t := projectOntoSegment(s.Lat, s.Lon, points[j], points[j+1])
+			if j == segment && t < from {
+				// Don't move backwards along the segment the previous stop was snapped to.
+				t = from
+			}
+			offset := distanceToSegmentPoint(s.Lat, s.Lon, points[j], points[j+1], t)
+			if offset < bestOffset {
+				bestSegment, bestT, bestOffset = j, t, offset
+			}
+		}
+
+		segment, from = bestSegment, bestT
+		dists[i] = along[segment] + from*(along[segment+1]-along[segment])
+	}
+	return dists
+}
+
+// Projects the point lat, lon onto the segment from a to b, returning how far along the
+// segment the closest point lies as a fraction between 0 and 1. The segment is treated as a straight line on a plane
+// centred on a, which is accurate over the short segments of a shape.
+func projectOntoSegment(lat, lon float64, a, b ShapePoint) float64 {
+	bx, by := planeOffset(a, b.Lat, b.Lon)
+	px, py := planeOffset(a, lat, lon)
+
+	length := bx*bx + by*by
+	if length == 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, (px*bx+py*by)/length))
+}
+
+// Returns the distance in meters of the point lat, lon from the point which lies the
+// fraction t of the way along the segment from a to b.
+func distanceToSegmentPoint(lat, lon float64, a, b ShapePoint, t float64) float64 {
+	bx, by := planeOffset(a, b.Lat, b.Lon)
+	px, py := planeOffset(a, lat, lon)
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// Returns the offset in meters east and north of the point lat, lon from origin.
+func planeOffset(origin ShapePoint, lat, lon float64) (float64, float64) {
+	dLon := math.Remainder(lon-origin.Lon, 360)
+	x := dLon * metersPerDegreeLat * math.Cos(origin.Lat*math.Pi/180)
+	y := (lat - origin.Lat) * metersPerDegreeLat
+	return x, y
+}
@@ -0,0 +1,78 @@
+package gtfs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSnapStopsToShape(t *testing.T) {
+	trip := Trip{ID: "T1", ShapeID: "SH1"}
+	// A straight shape running east along a line of latitude, given out of order and
+	// among the points of another shape.
+	shape := []ShapePoint{
+		{ShapeID: "SH1", Lat: -37.8, Lon: 144.97, Sequence: 3},
+		{ShapeID: "SH2", Lat: -37.9, Lon: 145.5, Sequence: 1},
+		{ShapeID: "SH1", Lat: -37.8, Lon: 144.95, Sequence: 1},
+		{ShapeID: "SH1", Lat: -37.8, Lon: 144.96, Sequence: 2},
+	}
+	stops := []Stop{
+		{ID: "A", Lat: -37.8001, Lon: 144.95},
+		{ID: "B", Lat: -37.7999, Lon: 144.955},
+		{ID: "C", Lat: -37.8002, Lon: 144.965},
+		{ID: "D", Lat: -37.8, Lon: 144.97},
+	}
+
+	got := SnapStopsToShape(trip, shape, stops)
+	if len(got) != len(stops) {
+		t.Fatalf("got %d distances, want %d", len(got), len(stops))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Errorf("distance to %s is %.1fm, not beyond the %.1fm to %s", stops[i].ID, got[i], got[i-1], stops[i-1].ID)
+		}
+	}
+	// Each stop lies against the shape at its own longitude.
+	for i, s := range stops {
+		want := Haversine(-37.8, 144.95, -37.8, s.Lon)
+		if math.Abs(got[i]-want) > 1 {
+			t.Errorf("distance to %s is %.1fm, want %.1fm", s.ID, got[i], want)
+		}
+	}
+}
+
+func TestSnapStopsToShapeDoublingBack(t *testing.T) {
+	// The shape runs east and then back west a little north of itself, so a stop on the
+	// return leg lies as close to the outward one.
+	trip := Trip{ID: "T1", ShapeID: "SH1"}
+	shape := []ShapePoint{
+		{ShapeID: "SH1", Lat: -37.8, Lon: 144.95, Sequence: 1},
+		{ShapeID: "SH1", Lat: -37.8, Lon: 144.97, Sequence: 2},
+		{ShapeID: "SH1", Lat: -37.7998, Lon: 144.97, Sequence: 3},
+		{ShapeID: "SH1", Lat: -37.7998, Lon: 144.95, Sequence: 4},
+	}
+	stops := []Stop{
+		{ID: "A", Lat: -37.8, Lon: 144.96},
+		{ID: "B", Lat: -37.8, Lon: 144.97},
+		{ID: "C", Lat: -37.7999, Lon: 144.955},
+	}
+
+	got := SnapStopsToShape(trip, shape, stops)
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Errorf("distance to %s is %.1fm, before the %.1fm to %s", stops[i].ID, got[i], got[i-1], stops[i-1].ID)
+		}
+	}
+	if outward := Haversine(-37.8, 144.95, -37.8, 144.97); got[2] < outward {
+		t.Errorf("snapped C to %.1fm, on the outward leg of %.1fm", got[2], outward)
+	}
+}
+
+func TestSnapStopsToShapeWithoutPoints(t *testing.T) {
+	stops := []Stop{{ID: "A", Lat: -37.8, Lon: 144.95}}
+	if got := SnapStopsToShape(Trip{ShapeID: "SH1"}, []ShapePoint{{ShapeID: "SH2", Lat: -37.8, Lon: 144.95}}, stops); got != nil {
+		t.Errorf("got %v for a shape without points, want nil", got)
+	}
+	if got := SnapStopsToShape(Trip{ShapeID: "SH1"}, []ShapePoint{{ShapeID: "SH1", Lat: -37.8, Lon: 144.95}}, stops); len(got) != 1 || got[0] != 0 {
+		t.Errorf("got %v for a shape of one point, want [0]", got)
+	}
+}
@@ -0,0 +1,153 @@
+package gtfs
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	// Registers the sqlite3 driver with database/sql.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteColumnTypes lists the columns which aren't stored as TEXT in the SQLite output.
+var sqliteColumnTypes = map[string]string{
+	"stop_lat":            "REAL",
+	"stop_lon":            "REAL",
+	"shape_pt_lat":        "REAL",
+	"shape_pt_lon":        "REAL",
+	"shape_dist_traveled": "REAL",
+	"stop_sequence":       "INTEGER",
+	"shape_pt_sequence":   "INTEGER",
+	"route_type":          "INTEGER",
+	"direction_id":        "INTEGER",
+	"pickup_type":         "INTEGER",
+	"drop_off_type":       "INTEGER",
+	"exception_type":      "INTEGER",
+	"monday":              "INTEGER",
+	"tuesday":             "INTEGER",
+	"wednesday":           "INTEGER",
+	"thursday":            "INTEGER",
+	"friday":              "INTEGER",
+	"saturday":            "INTEGER",
+	"sunday":              "INTEGER",
+	"headway_secs":        "INTEGER",
+	"exact_times":         "INTEGER",
+	"transfer_type":       "INTEGER",
+	"min_transfer_time":   "INTEGER",
+}
+
+// sqliteIndexes are created once every table has been populated, to speed up the
+// joins most commonly made across the feed. An index is skipped if its column wasn't
+// written to the output.
+var sqliteIndexes = []struct{ table, column string }{
+	{"stop_times", "trip_id"},
+	{"stop_times", "stop_id"},
+	{"trips", "route_id"},
+}
+
+// sqliteFormat writes each kind of GTFS file to its own table in a SQLite database.
+// Every table is populated within a single transaction, which is committed on close.
+type sqliteFormat struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	columns map[string]map[string]bool
+}
+
+// Creates a new SQLite database at path, replacing any database left behind by a
+// previous run.
+func newSQLiteFormat(path string) (*sqliteFormat, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteFormat{db: db, tx: tx, columns: make(map[string]map[string]bool)}, nil
+}
+
+func (f *sqliteFormat) table(kind string, header []string) (tableWriter, error) {
+	columns := make([]string, len(header))
+	placeholders := make([]string, len(header))
+	numeric := make([]bool, len(header))
+	f.columns[kind] = make(map[string]bool, len(header))
+	for i, name := range header {
+		f.columns[kind][name] = true
+		columnType, ok := sqliteColumnTypes[name]
+		if !ok {
+			columnType = "TEXT"
+		}
+		columns[i] = fmt.Sprintf("%q %s", name, columnType)
+		placeholders[i] = "?"
+		numeric[i] = ok
+	}
+
+	_, err := f.tx.Exec(fmt.Sprintf("CREATE TABLE %q (%s)", kind, strings.Join(columns, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create table %s: %w", kind, err)
+	}
+
+	stmt, err := f.tx.Prepare(fmt.Sprintf("INSERT INTO %q VALUES (%s)", kind, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare insert into %s: %w", kind, err)
+	}
+	return &sqliteTable{kind: kind, stmt: stmt, numeric: numeric}, nil
+}
+
+func (f *sqliteFormat) close() error {
+	for _, index := range sqliteIndexes {
+		if !f.columns[index.table][index.column] {
+			continue
+		}
+		stmt := fmt.Sprintf("CREATE INDEX %q ON %q (%q)", index.table+"_"+index.column, index.table, index.column)
+		if _, err := f.tx.Exec(stmt); err != nil {
+			f.tx.Rollback()
+			f.db.Close()
+			return fmt.Errorf("unable to create index: %w", err)
+		}
+	}
+
+	if err := f.tx.Commit(); err != nil {
+		f.db.Close()
+		return err
+	}
+	return f.db.Close()
+}
+
+// sqliteTable inserts rows into a single table of a SQLite database.
+type sqliteTable struct {
+	kind    string
+	stmt    *sql.Stmt
+	numeric []bool
+}
+
+func (t *sqliteTable) writeRow(row []string) error {
+	values := make([]interface{}, len(t.numeric))
+	for i := range values {
+		if i >= len(row) {
+			continue
+		}
+		// Leave empty numeric values as NULL rather than storing them as text.
+		if t.numeric[i] && row[i] == "" {
+			continue
+		}
+		values[i] = row[i]
+	}
+
+	if _, err := t.stmt.Exec(values...); err != nil {
+		return fmt.Errorf("unable to insert row into %s: %w", t.kind, err)
+	}
+	return nil
+}
+
+func (t *sqliteTable) close() error {
+	return t.stmt.Close()
+}
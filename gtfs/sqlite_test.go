@@ -0,0 +1,80 @@
+package gtfs
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConsolidateSQLite(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.Format = FormatSQLite
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", out+".db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT stops.stop_name, stop_times.stop_sequence, stops.stop_lat
+		FROM trips
+		JOIN stop_times ON stop_times.trip_id = trips.trip_id
+		JOIN stops ON stops.stop_id = stop_times.stop_id
+		WHERE trips.route_id = 'R1' AND trips.trip_id = 'T1'
+		ORDER BY stop_times.stop_sequence`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	var sequences []int
+	for rows.Next() {
+		var name string
+		var seq int
+		var lat float64
+		if err := rows.Scan(&name, &seq, &lat); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		sequences = append(sequences, seq)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Flinders St, Stop 1", "Southern Cross", "Richmond"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got stops %q, want %q", names, want)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(sequences, want) {
+		t.Errorf("got sequences %v, want %v", sequences, want)
+	}
+
+	var latType, seqType string
+	if err := db.QueryRow(`SELECT typeof(stop_lat) FROM stops LIMIT 1`).Scan(&latType); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT typeof(stop_sequence) FROM stop_times LIMIT 1`).Scan(&seqType); err != nil {
+		t.Fatal(err)
+	}
+	if latType != "real" || seqType != "integer" {
+		t.Errorf("got stop_lat stored as %s and stop_sequence as %s, want real and integer", latType, seqType)
+	}
+
+	var indexes int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name IN ('stop_times', 'trips')`).Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != len(sqliteIndexes) {
+		t.Errorf("got %d indexes, want %d", indexes, len(sqliteIndexes))
+	}
+}
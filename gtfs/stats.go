@@ -0,0 +1,164 @@
+package gtfs
+
+import (
+	"sort"
+	"time"
+)
+
+// RouteStat summarises the trips of a single route. FirstDeparture and LastDeparture
+// are the earliest and latest times at which one of the route's trips departs its
+// first stop, as offsets from the start of the service day. AverageDuration is the
+// mean time from a trip departing its first stop to arriving at its last. Routes
+// without any trips have zero for each of these.
+type RouteStat struct {
+	RouteID         string
+	Trips           int
+	Stops           int
+	FirstDeparture  time.Duration
+	LastDeparture   time.Duration
+	AverageDuration time.Duration
+}
+
+// RouteStats returns a RouteStat for every route of the GTFS feed in dir, in the order
+// the routes appear in routes.txt. Returns nil if the feed can't be loaded; use
+// LoadFeed and Feed.RouteStats to find out why.
+func RouteStats(dir string) []RouteStat {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil
+	}
+	return feed.RouteStats()
+}
+
+// RouteStats returns a RouteStat for every route of the feed, in the order of f.Routes.
+// Stop times whose times can't be parsed are left out of the departure times and
+// durations, but their stops are still counted.
+func (f *Feed) RouteStats() []RouteStat {
+	byTrip := make(map[string][]StopTime)
+	for _, st := range f.StopTimes {
+		byTrip[st.TripID] = append(byTrip[st.TripID], st)
+	}
+
+	type routeTotals struct {
+		stat      RouteStat
+		stops     map[string]bool
+		durations int
+		total     time.Duration
+		departed  bool
+	}
+	totals := make(map[string]*routeTotals, len(f.Routes))
+	for _, r := range f.Routes {
+		totals[r.ID] = &routeTotals{stat: RouteStat{RouteID: r.ID}, stops: make(map[string]bool)}
+	}
+
+	for _, trip := range f.Trips {
+		t, ok := totals[trip.RouteID]
+		if !ok {
+			continue
+		}
+		t.stat.Trips++
+
+		sts := byTrip[trip.ID]
+		for _, st := range sts {
+			t.stops[st.StopID] = true
+		}
+		if len(sts) == 0 {
+			continue
+		}
+		sort.Slice(sts, func(i, j int) bool {
+			return sts[i].StopSequence < sts[j].StopSequence
+		})
+
+		departure, err := ParseGTFSTime(sts[0].DepartureTime)
+		if err != nil {
+			continue
+		}
+		if !t.departed || departure < t.stat.FirstDeparture {
+			t.stat.FirstDeparture = departure
+		}
+		if !t.departed || departure > t.stat.LastDeparture {
+			t.stat.LastDeparture = departure
+		}
+		t.departed = true
+
+		arrival, err := ParseGTFSTime(sts[len(sts)-1].ArrivalTime)
+		if err != nil {
+			continue
+		}
+		t.total += arrival - departure
+		t.durations++
+	}
+
+	stats := make([]RouteStat, 0, len(f.Routes))
+	for _, r := range f.Routes {
+		t := totals[r.ID]
+		t.stat.Stops = len(t.stops)
+		if t.durations > 0 {
+			t.stat.AverageDuration = t.total / time.Duration(t.durations)
+		}
+		stats = append(stats, t.stat)
+	}
+	return stats
+}
+
+// ServiceStat summarises the trips run to a single service_id. StartDate and EndDate
+// are the first and last dates, as YYYYMMDD, on which the service may run, as found by
+// ServiceWindow from its calendar and calendar_dates. A service without any trips runs
+// nothing on those dates, which usually means the calendar and trips don't match.
+type ServiceStat struct {
+	ServiceID string
+	Trips     int
+	StartDate string
+	EndDate   string
+}
+
+// ServiceStats returns a ServiceStat for every service of the GTFS feed in dir, ordered
+// by service_id. Returns nil if the feed can't be loaded; use LoadFeed and
+// Feed.ServiceStats to find out why.
+func ServiceStats(dir string) []ServiceStat {
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return nil
+	}
+	return feed.ServiceStats()
+}
+
+// ServiceStats returns a ServiceStat for every service given in f.Calendars or
+// f.CalendarDates, ordered by service_id. Trips of services which aren't given there
+// aren't counted.
+func (f *Feed) ServiceStats() []ServiceStat {
+	calendars := make(map[string][]Calendar)
+	for _, c := range f.Calendars {
+		calendars[c.ServiceID] = append(calendars[c.ServiceID], c)
+	}
+	dates := make(map[string][]CalendarDate)
+	for _, cd := range f.CalendarDates {
+		dates[cd.ServiceID] = append(dates[cd.ServiceID], cd)
+	}
+
+	stats := make(map[string]*ServiceStat)
+	add := func(id string) {
+		if _, ok := stats[id]; !ok {
+			start, end := ServiceWindow(calendars[id], dates[id])
+			stats[id] = &ServiceStat{ServiceID: id, StartDate: start, EndDate: end}
+		}
+	}
+	for id := range calendars {
+		add(id)
+	}
+	for id := range dates {
+		add(id)
+	}
+	for _, t := range f.Trips {
+		if s, ok := stats[t.ServiceID]; ok {
+			s.Trips++
+		}
+	}
+
+	result := make([]ServiceStat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ServiceID < result[j].ServiceID })
+	return result
+}
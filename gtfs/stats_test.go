@@ -0,0 +1,60 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteStats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"routes.txt": testFeed["routes.txt"] + "R3,1,Unused,,3,,\n",
+	}))
+
+	want := []RouteStat{
+		// T1 takes 10 minutes and T3 30, between them calling at A, B, C and D.
+		{RouteID: "R1", Trips: 2, Stops: 4, FirstDeparture: 8 * time.Hour, LastDeparture: 9 * time.Hour, AverageDuration: 20 * time.Minute},
+		{RouteID: "R2", Trips: 1, Stops: 2, FirstDeparture: 23*time.Hour + 55*time.Minute, LastDeparture: 23*time.Hour + 55*time.Minute, AverageDuration: 15 * time.Minute},
+		// Routes without any trips are still reported.
+		{RouteID: "R3"},
+	}
+	if got := RouteStats(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRouteStatsUnloadableFeed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"stops.txt": "stop_id,stop_lat\nA,north\n"})
+	if got := RouteStats(dir); got != nil {
+		t.Errorf("got %+v for a feed which can't be loaded", got)
+	}
+}
+
+func TestServiceStats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"calendar.txt": testFeed["calendar.txt"] + "S4,1,1,1,1,1,0,0,20240101,20241231\n",
+		// S9 isn't given by either calendar, so its trip isn't counted.
+		"trips.txt": testFeed["trips.txt"] + "R1,S9,T9,SH1,City,0,1\n",
+	}))
+
+	want := []ServiceStat{
+		{ServiceID: "S1", Trips: 1, StartDate: "20240101", EndDate: "20991231"},
+		{ServiceID: "S2", Trips: 1, StartDate: "20200101", EndDate: "20201231"},
+		{ServiceID: "S3", Trips: 1, StartDate: "20240601", EndDate: "20240601"},
+		{ServiceID: "S4", Trips: 0, StartDate: "20240101", EndDate: "20241231"},
+	}
+	if got := ServiceStats(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestServiceStatsUnloadableFeed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"stops.txt": "stop_id,stop_lat\nA,north\n"})
+	if got := ServiceStats(dir); got != nil {
+		t.Errorf("got %+v for a feed which can't be loaded", got)
+	}
+}
@@ -0,0 +1,26 @@
+package gtfs
+
+import (
+	"sort"
+	"strings"
+)
+
+// FindStopsByName returns the stops whose stop_name contains query, ignoring case,
+// e.g. "flinders" finds "Flinders Street Railway Station". The stops are sorted by name
+// and then by stop_id.
+func FindStopsByName(stops []Stop, query string) []Stop {
+	query = strings.ToLower(query)
+	var found []Stop
+	for _, s := range stops {
+		if strings.Contains(strings.ToLower(s.Name), query) {
+			found = append(found, s)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].ID < found[j].ID
+	})
+	return found
+}
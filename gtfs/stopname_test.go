@@ -0,0 +1,53 @@
+package gtfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindStopsByName(t *testing.T) {
+	stops := []Stop{
+		{ID: "3", Name: "Flinders Street Railway Station"},
+		{ID: "2", Name: "Southern Cross"},
+		{ID: "9", Name: "Flinders St/Elizabeth St"},
+		{ID: "1", Name: "Flinders Street Railway Station"},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Sorted by name, then by stop_id.
+		{"flinders", []string{"9", "1", "3"}},
+		{"CROSS", []string{"2"}},
+		{"Elizabeth St", []string{"9"}},
+		{"richmond", nil},
+	}
+	for _, tt := range tests {
+		if got := stopIDs(FindStopsByName(stops, tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindStopsByName(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLoadStops(t *testing.T) {
+	dir := t.TempDir()
+	// Only stops.txt is read, so the rest of the feed needn't be loadable.
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"stop_times.txt": "trip_id,stop_sequence\nT1,first\n",
+	}))
+	stops, err := LoadStops(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stopIDs(stops), []string{"A", "B", "C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stops %v, want %v", got, want)
+	}
+	if want := (Stop{ID: "A", Name: "Flinders St, Stop 1", Lat: -37.8183, Lon: 144.9671, WheelchairBoarding: 1}); stops[0] != want {
+		t.Errorf("got stop %+v, want %+v", stops[0], want)
+	}
+
+	writeFiles(t, dir, map[string]string{"stops.txt": "stop_id,stop_lat\nA,north\n"})
+	if _, err := LoadStops(dir); err == nil {
+		t.Error("loaded a stop whose stop_lat can't be parsed")
+	}
+}
@@ -0,0 +1,47 @@
+package gtfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseGTFSTime parses an arrival or departure time of the form HH:MM:SS into the
+// time elapsed since the start of the service day. GTFS times aren't wall-clock
+// times: services running past midnight carry on from 24:00:00, so values such as
+// 25:30:00 are valid. The hour may be given as a single digit, e.g. 5:30:00.
+func ParseGTFSTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || len(parts[0]) < 1 || len(parts[1]) != 2 || len(parts[2]) != 2 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+
+	var fields [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || strings.HasPrefix(p, "+") {
+			return 0, fmt.Errorf("invalid GTFS time %q", s)
+		}
+		fields[i] = n
+	}
+
+	h, m, sec := fields[0], fields[1], fields[2]
+	if m > 59 || sec > 59 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second, nil
+}
+
+// gtfsDateLayout is the YYYYMMDD layout of dates in calendar.txt and calendar_dates.txt.
+const gtfsDateLayout = "20060102"
+
+// ParseGTFSDate parses a date of the form YYYYMMDD, as used by calendar.txt and
+// calendar_dates.txt. The returned time is midnight UTC on that date.
+func ParseGTFSDate(s string) (time.Time, error) {
+	t, err := time.Parse(gtfsDateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid GTFS date %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,51 @@
+package gtfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGTFSTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"25:30:00", 25*time.Hour + 30*time.Minute},
+		{"00:05:00", 5 * time.Minute},
+		{"08:06:07", 8*time.Hour + 6*time.Minute + 7*time.Second},
+		{"5:30:00", 5*time.Hour + 30*time.Minute},
+		{"24:00:00", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := ParseGTFSTime(tt.in)
+		if err != nil {
+			t.Errorf("ParseGTFSTime(%q) returned %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseGTFSTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseGTFSTimeMalformed(t *testing.T) {
+	for _, in := range []string{"", "9:9", "09:09", "08:60:00", "08:00:60", "-1:00:00", "+1:00:00", "08:00:00:00", "ab:cd:ef", " 8:00:00"} {
+		if d, err := ParseGTFSTime(in); err == nil {
+			t.Errorf("ParseGTFSTime(%q) = %v, want an error", in, d)
+		}
+	}
+}
+
+func TestParseGTFSTimeOrdersPastMidnight(t *testing.T) {
+	before, err := ParseGTFSTime("23:55:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ParseGTFSTime("24:10:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after-before != 15*time.Minute {
+		t.Errorf("got %v between 23:55:00 and 24:10:00, want 15m", after-before)
+	}
+}
@@ -0,0 +1,204 @@
+package gtfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NormalizeGTFSTime converts the arrival or departure time s of a trip running on date,
+// given relative to the service day in from, to the equivalent time relative to the
+// service day in to. As GTFS times are measured from noon less 12 hours, rather than
+// midnight, the result accounts for either zone changing to or from daylight saving
+// time on that date. Returns an error if the converted time would fall before the
+// start of the service day, which GTFS can't represent.
+func NormalizeGTFSTime(s string, from, to *time.Location, date time.Time) (string, error) {
+	d, err := ParseGTFSTime(s)
+	if err != nil {
+		return "", err
+	}
+	y, m, day := date.Date()
+	start := func(loc *time.Location) time.Time {
+		return time.Date(y, m, day, 12, 0, 0, 0, loc).Add(-12 * time.Hour)
+	}
+
+	normalized := start(from).Add(d).Sub(start(to))
+	if normalized < 0 {
+		return "", fmt.Errorf("%s in %s is before the start of the service day in %s", s, from, to)
+	}
+	return FormatGTFSTime(normalized), nil
+}
+
+// FormatGTFSTime formats the time elapsed since the start of the service day as
+// HH:MM:SS, the inverse of ParseGTFSTime. Hours carry on past 23 rather than wrapping.
+func FormatGTFSTime(d time.Duration) string {
+	secs := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+}
+
+// timezoneNormalizer converts the times of stop_times.txt from the timezone of each
+// trip's agency to a single target zone, as the columns derived from those rows.
+type timezoneNormalizer struct {
+	target *time.Location
+
+	// date is the date whose offsets are used to convert times. Offsets which change
+	// during the feed, such as at the start of daylight saving time, aren't followed.
+	date time.Time
+
+	// zones holds the timezone of each trip whose agency isn't already in target.
+	zones map[string]*time.Location
+
+	mu  sync.Mutex
+	err error
+}
+
+// Walks the agencies, routes and trips of the feed beneath path, returning a
+// timezoneNormalizer converting the times of every trip to target, or to the timezone
+// of the agency whose agency_id sorts first if target is empty. Returns an error if
+// target or any agency_timezone isn't a known timezone.
+func resolveTimezones(ctx context.Context, path, target string, opts walkOptions) (*timezoneNormalizer, error) {
+	agencies := make(map[string]string)
+	routeAgencies := make(map[string]string)
+	tripRoutes := make(map[string]string)
+	var calendars []Calendar
+	var calendarDates []CalendarDate
+
+	opts.kinds = []string{"agency", "routes", "trips", "calendar", "calendar_dates"}
+	records, errc := walkPTVData(ctx, path, opts)
+	for rec := range records {
+		value := func(column string) string {
+			return rec.Header.value(rec.Contents, column)
+		}
+		switch rec.Type {
+		case "agency":
+			agencies[value("agency_id")] = value("agency_timezone")
+		case "routes":
+			routeAgencies[value("route_id")] = value("agency_id")
+		case "trips":
+			tripRoutes[value("trip_id")] = value("route_id")
+		case "calendar":
+			if c, err := ParseCalendar(rec.Header, rec.Contents); err == nil {
+				calendars = append(calendars, c)
+			}
+		case "calendar_dates":
+			if c, err := ParseCalendarDate(rec.Header, rec.Contents); err == nil {
+				calendarDates = append(calendarDates, c)
+			}
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(agencies))
+	for id := range agencies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	zones := make(map[string]*time.Location, len(agencies))
+	for _, id := range ids {
+		loc, err := time.LoadLocation(agencies[id])
+		if err != nil || agencies[id] == "" {
+			return nil, fmt.Errorf("agency %s has an unknown agency_timezone %q", id, agencies[id])
+		}
+		zones[id] = loc
+	}
+
+	n := &timezoneNormalizer{zones: make(map[string]*time.Location)}
+	if target != "" {
+		loc, err := time.LoadLocation(target)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", target)
+		}
+		n.target = loc
+	} else if len(ids) > 0 {
+		n.target = zones[ids[0]]
+	} else {
+		return nil, fmt.Errorf("the feed has no agency_timezone to normalize times to")
+	}
+
+	n.date = time.Now()
+	if first, _ := ServiceWindow(calendars, calendarDates); first != "" {
+		if d, err := ParseGTFSDate(first); err == nil {
+			n.date = d
+		}
+	}
+
+	for tripID, routeID := range tripRoutes {
+		agencyID := routeAgencies[routeID]
+		if agencyID == "" && len(ids) == 1 {
+			// agency_id may be left out of routes.txt when the feed has a single agency.
+			agencyID = ids[0]
+		}
+		loc, ok := zones[agencyID]
+		if ok && loc.String() != n.target.String() {
+			n.zones[tripID] = loc
+		}
+	}
+	return n, nil
+}
+
+// Converts the times of the trips expanded by e in the timezone of the trip they're
+// expanded from.
+func (n *timezoneNormalizer) expand(e *frequencyExpander) {
+	for tripID := range e.departures {
+		loc, ok := n.zones[tripID]
+		if !ok {
+			continue
+		}
+		for _, id := range e.tripIDs(tripID) {
+			n.zones[id] = loc
+		}
+	}
+}
+
+// Returns the derived columns which normalize the times of stop_times.txt, and the
+// agency_timezone of agency.txt to match.
+func (n *timezoneNormalizer) derived() map[string]map[string]func(h Header, row []string) string {
+	return map[string]map[string]func(h Header, row []string) string{
+		"agency": {
+			"agency_timezone": func(h Header, row []string) string { return n.target.String() },
+		},
+		"stop_times": {
+			"arrival_time":   n.column("arrival_time"),
+			"departure_time": n.column("departure_time"),
+		},
+	}
+}
+
+// Returns a derived column normalizing the given time column of stop_times.txt. Times
+// which are empty or can't be parsed are left as they are; the first time which can't
+// be normalized is kept to be returned by result.
+func (n *timezoneNormalizer) column(column string) func(h Header, row []string) string {
+	return func(h Header, row []string) string {
+		value := h.value(row, column)
+		loc, ok := n.zones[h.value(row, "trip_id")]
+		if !ok || value == "" {
+			return value
+		}
+		if _, err := ParseGTFSTime(value); err != nil {
+			return value
+		}
+
+		normalized, err := NormalizeGTFSTime(value, loc, n.target, n.date)
+		if err != nil {
+			n.mu.Lock()
+			if n.err == nil {
+				n.err = fmt.Errorf("stop_times.txt trip %s %s: %w", h.value(row, "trip_id"), column, err)
+			}
+			n.mu.Unlock()
+			return value
+		}
+		return normalized
+	}
+}
+
+// Returns the first error found normalizing times.
+func (n *timezoneNormalizer) result() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
@@ -0,0 +1,140 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Loads the named timezone, skipping the test if the timezone database is missing.
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone database unavailable: %v", err)
+	}
+	return loc
+}
+
+func TestNormalizeGTFSTime(t *testing.T) {
+	melbourne := loadLocation(t, "Australia/Melbourne")
+	perth := loadLocation(t, "Australia/Perth")
+	tokyo := loadLocation(t, "Asia/Tokyo")
+
+	tests := []struct {
+		s        string
+		from, to *time.Location
+		date     time.Time
+		want     string
+	}{
+		{"08:00:00", melbourne, perth, time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC), "06:00:00"},
+		// Melbourne is on daylight saving time, while Perth never is.
+		{"08:00:00", melbourne, perth, time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), "05:00:00"},
+		{"25:30:15", melbourne, perth, time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC), "23:30:15"},
+		{"06:00:00", perth, melbourne, time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC), "08:00:00"},
+		{"08:00:00", melbourne, melbourne, time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC), "08:00:00"},
+		{"08:00:00", melbourne, tokyo, time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC), "07:00:00"},
+		// Daylight saving time starts on the morning of 6 October in Melbourne. The
+		// service day starts at noon less 12 hours, 23:00 the night before.
+		{"08:00:00", melbourne, tokyo, time.Date(2024, 10, 6, 0, 0, 0, 0, time.UTC), "06:00:00"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeGTFSTime(tt.s, tt.from, tt.to, tt.date)
+		if err != nil {
+			t.Errorf("NormalizeGTFSTime(%s, %s, %s, %s): %v", tt.s, tt.from, tt.to, tt.date.Format("2006-01-02"), err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeGTFSTime(%s, %s, %s, %s) = %s, want %s", tt.s, tt.from, tt.to, tt.date.Format("2006-01-02"), got, tt.want)
+		}
+	}
+
+	date := time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)
+	if _, err := NormalizeGTFSTime("01:00:00", melbourne, perth, date); err == nil {
+		t.Error("normalized a time to before the start of the service day")
+	}
+	if _, err := NormalizeGTFSTime("eight", melbourne, perth, date); err == nil {
+		t.Error("normalized a time which can't be parsed")
+	}
+}
+
+func TestFormatGTFSTime(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0: "00:00:00",
+		8*time.Hour + 5*time.Minute + 9*time.Second: "08:05:09",
+		25*time.Hour + 30*time.Minute:               "25:30:00",
+	} {
+		if got := FormatGTFSTime(d); got != want {
+			t.Errorf("FormatGTFSTime(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+// Consolidates files, normalizing their times to timezone, and returns the rows of
+// stop_times.txt and agency.txt written.
+func consolidateNormalized(t *testing.T, files map[string]string, timezone string) ([]string, []string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, files)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.NormalizeTimezone = true
+	opts.Timezone = timezone
+	if err := Consolidate(in, out, opts); err != nil {
+		return nil, nil, err
+	}
+	return readRows(t, filepath.Join(out, "stop_times.txt")), readRows(t, filepath.Join(out, "agency.txt")), nil
+}
+
+func TestConsolidateNormalizeTimezone(t *testing.T) {
+	loadLocation(t, "Australia/Perth")
+	stopTimes, agencies, err := consolidateNormalized(t, testFeed, "Australia/Perth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The offsets are taken on the first day of service, in January, when Melbourne is
+	// three hours ahead of Perth.
+	for i, want := range []string{"T1,05:00:00,05:00:00,A,", "T1,05:05:00,05:06:00,B,", "T2,20:55:00,20:55:00,B,", "T2,21:10:00,21:10:00,C,"} {
+		found := false
+		for _, row := range stopTimes {
+			found = found || strings.HasPrefix(row, want)
+		}
+		if !found {
+			t.Errorf("stop time %d: no row starts %q: %q", i, want, stopTimes)
+		}
+	}
+	if len(agencies) != 1 || !strings.Contains(agencies[0], ",Australia/Perth,") {
+		t.Errorf("got agencies %q, want the timezone Australia/Perth", agencies)
+	}
+}
+
+func TestConsolidateNormalizeTimezoneToAgency(t *testing.T) {
+	loadLocation(t, "Australia/Melbourne")
+	// Without a target, times are normalized to the timezone of the only agency.
+	stopTimes, agencies, err := consolidateNormalized(t, testFeed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Split(strings.TrimSpace(testFeed["stop_times.txt"]), "\n")[1:]; !reflect.DeepEqual(stopTimes, want) {
+		t.Errorf("got stop times %q, want them unchanged: %q", stopTimes, want)
+	}
+	if len(agencies) != 1 || !strings.Contains(agencies[0], ",Australia/Melbourne,") {
+		t.Errorf("got agencies %q, want the timezone Australia/Melbourne", agencies)
+	}
+}
+
+func TestConsolidateNormalizeTimezoneErrors(t *testing.T) {
+	if _, _, err := consolidateNormalized(t, testFeed, "Australia/Gotham"); err == nil || !strings.Contains(err.Error(), "Australia/Gotham") {
+		t.Errorf("got error %v, want one naming the unknown timezone", err)
+	}
+	files := withFiles(testFeed, map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\n1,PTV,http://ptv.vic.gov.au,Melbourne\n",
+	})
+	if _, _, err := consolidateNormalized(t, files, "Australia/Perth"); err == nil || !strings.Contains(err.Error(), "agency_timezone") {
+		t.Errorf("got error %v, want one of the agency's unknown timezone", err)
+	}
+}
@@ -0,0 +1,88 @@
+package gtfs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// TransferWalkingSpeed is the speed in meters per second at which the walking transfers
+// written by Options.WalkingTransfers are assumed to be walked, as with
+// graph.WalkingSpeed.
+var TransferWalkingSpeed = 1.4
+
+// Walks the stops of the feed beneath path, returning a row of transfers.txt for each
+// Transfer given by ComputeTransfers between those kept by keep within maxMeters of one
+// another. Each has a transfer_type of 2, with a min_transfer_time of the seconds taken
+// to walk it at TransferWalkingSpeed. Pairs of stops already given a transfer by the
+// feed's own transfers.txt are left to it.
+func resolveTransfers(ctx context.Context, path string, keep *keepSet, maxMeters float64, opts walkOptions) ([]GTFSRecord, error) {
+	var stops []Stop
+	seen := make(map[string]bool)
+	given := make(map[string]bool)
+	var parseErr error
+
+	opts.kinds = []string{"stops", "transfers"}
+	records, errc := walkPTVData(ctx, path, opts)
+	for rec := range records {
+		if !keep.keeps(rec) {
+			continue
+		}
+		value := func(column string) string {
+			return rec.Header.value(rec.Contents, column)
+		}
+		switch rec.Type {
+		case "stops":
+			// Stops repeated across feeds are only compared once.
+			if seen[value("stop_id")] {
+				continue
+			}
+			seen[value("stop_id")] = true
+			s, err := ParseStop(rec.Header, rec.Contents)
+			if err != nil && parseErr == nil {
+				parseErr = fmt.Errorf("%s: %w", rec.Path, err)
+			}
+			stops = append(stops, s)
+		case "transfers":
+			given[value("from_stop_id")+keySeparator+value("to_stop_id")] = true
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	header := NewHeader(outputColumns["transfers"])
+	var transfers []GTFSRecord
+	for _, t := range ComputeTransfers(stops, maxMeters) {
+		if given[t.FromStopID+keySeparator+t.ToStopID] {
+			continue
+		}
+		walk := int(math.Ceil(t.Distance / TransferWalkingSpeed))
+		transfers = append(transfers, GTFSRecord{
+			Type:     "transfers",
+			Header:   header,
+			Contents: []string{t.FromStopID, t.ToStopID, "2", strconv.Itoa(walk)},
+		})
+	}
+	return transfers, nil
+}
+
+// Returns a channel receiving every record from records followed by those of extra,
+// which is closed once they've all been sent.
+func appendRecords(records chan GTFSRecord, extra []GTFSRecord) chan GTFSRecord {
+	c := make(chan GTFSRecord)
+	go func() {
+		defer close(c)
+		for rec := range records {
+			c <- rec
+		}
+		for _, rec := range extra {
+			c <- rec
+		}
+	}()
+	return c
+}
@@ -0,0 +1,121 @@
+package gtfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// transferFeed is testFeed with a stop E about 100m east of A, the only two stops
+// within a short walk of one another.
+var transferFeed = withFiles(testFeed, map[string]string{
+	"stops.txt": testFeed["stops.txt"] + "E,Flinders St East,-37.8183,144.9682,1\n",
+})
+
+// Consolidates files with walking transfers between stops within maxMeters of one
+// another, returning the rows of transfers.txt written.
+func consolidateTransfers(t *testing.T, files map[string]string, maxMeters float64) []string {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, files)
+
+	out := filepath.Join(dir, "out")
+	opts := testOptions(dir)
+	opts.WalkingTransfers = maxMeters
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if header := strings.SplitN(readFile(t, filepath.Join(out, "transfers.txt")), "\n", 2)[0]; header != "from_stop_id,to_stop_id,transfer_type,min_transfer_time" {
+		t.Errorf("got header %q", header)
+	}
+	return readRows(t, filepath.Join(out, "transfers.txt"))
+}
+
+func TestConsolidateWalkingTransfers(t *testing.T) {
+	// A and E are about 97m apart, taking 70 seconds to walk.
+	got := consolidateTransfers(t, transferFeed, 200)
+	if want := []string{"A,E,2,70", "E,A,2,70"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transfers %q, want %q", got, want)
+	}
+
+	// B is about 1.28km from A but 1.38km from E.
+	got = consolidateTransfers(t, transferFeed, 1300)
+	if len(got) != 4 {
+		t.Fatalf("got transfers %q, want 4", got)
+	}
+	pairs := make(map[string]bool)
+	for _, row := range got {
+		fields := strings.Split(row, ",")
+		pairs[fields[0]+"-"+fields[1]] = true
+		if fields[2] != "2" {
+			t.Errorf("transfer %q doesn't have a transfer_type of 2", row)
+		}
+	}
+	for _, pair := range []string{"A-E", "E-A", "A-B", "B-A"} {
+		if !pairs[pair] {
+			t.Errorf("no transfer %s in %q", pair, got)
+		}
+	}
+}
+
+func TestConsolidateWalkingTransfersKeepsFeedTransfers(t *testing.T) {
+	files := withFiles(transferFeed, map[string]string{
+		"transfers.txt": "from_stop_id,to_stop_id,transfer_type,min_transfer_time\nA,E,1,\n",
+	})
+	got := consolidateTransfers(t, files, 200)
+	// The feed's own transfer from A to E is kept in place of the computed one.
+	if want := []string{"A,E,1,", "E,A,2,70"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transfers %q, want %q", got, want)
+	}
+}
+
+func TestConsolidateTransfers(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(transferFeed, map[string]string{
+		"transfers.txt": "from_stop_id,to_stop_id,transfer_type,min_transfer_time\nA,E,2,120\nB,C,0,\n",
+	}))
+
+	// Without -walking-transfers, transfers.txt is consolidated as given.
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readRows(t, filepath.Join(out, "transfers.txt")), []string{"A,E,2,120", "B,C,0,"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transfers %q, want %q", got, want)
+	}
+
+	feed, err := LoadFeed(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TransferRule{
+		{FromStopID: "A", ToStopID: "E", TransferType: 2, MinTransferTime: 120},
+		{FromStopID: "B", ToStopID: "C"},
+	}
+	if !reflect.DeepEqual(feed.Transfers, want) {
+		t.Errorf("loaded transfers %+v, want %+v", feed.Transfers, want)
+	}
+}
+
+func TestValidateTransfers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"transfers.txt": "from_stop_id,to_stop_id,transfer_type,min_transfer_time\nA,B,2,60\nA,Z,2,60\nY,C,0,\n",
+	}))
+	var got []DanglingReferenceError
+	for _, err := range Validate(dir) {
+		if e, ok := err.(DanglingReferenceError); ok && e.File == "transfers.txt" {
+			got = append(got, e)
+		}
+	}
+	want := []DanglingReferenceError{
+		{File: "transfers.txt", Row: 3, Column: "to_stop_id", Value: "Z", ReferencedFile: "stops.txt"},
+		{File: "transfers.txt", Row: 4, Column: "from_stop_id", Value: "Y", ReferencedFile: "stops.txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package gtfs
+
+import (
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// tripPatterns records the stops at which each of the feeds being consolidated has
+// every trip call, so that a trip_id defined by more than one of them can be checked
+// for calling at the same stops in each. Otherwise only one of the trips survives, with
+// a mix of the stop times of both, and the trip's route through the network becomes
+// ambiguous.
+//
+// Its methods may be called on a nil tripPatterns, which doesn't record anything.
+type tripPatterns struct {
+	mu sync.Mutex
+	// defined holds the feeds whose trips.txt defines each trip, by trip_id and then by
+	// the directory of the feed.
+	defined map[string]map[string]bool
+	// patterns holds a hash of the stop_sequence and stop_id of every stop time each
+	// feed gives a trip, by trip_id and then by the directory of the feed. The hashes of
+	// the stop times are summed, so that they may be added in any order.
+	patterns map[string]map[string]uint64
+}
+
+// tripConflict is a trip_id defined by two feeds, whose stop times differ between them.
+type tripConflict struct {
+	tripID string
+	feeds  [2]string
+}
+
+// Returns an empty tripPatterns.
+func newTripPatterns() *tripPatterns {
+	return &tripPatterns{
+		defined:  make(map[string]map[string]bool),
+		patterns: make(map[string]map[string]uint64),
+	}
+}
+
+// Records a trip or stop time, ignoring records of any other kind.
+func (p *tripPatterns) add(rec GTFSRecord) {
+	if p == nil || (rec.Type != "trips" && rec.Type != "stop_times") {
+		return
+	}
+	tripID := rec.Header.value(rec.Contents, "trip_id")
+	feed := filepath.Dir(rec.Path)
+
+	var h uint64
+	if rec.Type == "stop_times" {
+		hash := fnv.New64a()
+		hash.Write([]byte(rec.Header.value(rec.Contents, "stop_sequence") + keySeparator + rec.Header.value(rec.Contents, "stop_id")))
+		h = hash.Sum64()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rec.Type == "trips" {
+		if p.defined[tripID] == nil {
+			p.defined[tripID] = make(map[string]bool)
+		}
+		p.defined[tripID][feed] = true
+		return
+	}
+	if p.patterns[tripID] == nil {
+		p.patterns[tripID] = make(map[string]uint64)
+	}
+	p.patterns[tripID][feed] += h
+}
+
+// Returns the trips defined by more than one feed whose stop times differ between
+// them, in order of their trip_id. Each names the first two of the feeds, in order of
+// their directories, which differ. Feeds without any stop times for a trip, such as
+// those whose stop_times.txt was skipped when resuming a checkpoint, aren't compared.
+func (p *tripPatterns) conflicts() []tripConflict {
+	if p == nil {
+		return nil
+	}
+	var conflicts []tripConflict
+	for tripID, defined := range p.defined {
+		if len(defined) < 2 {
+			continue
+		}
+		var feeds []string
+		for feed := range defined {
+			if _, ok := p.patterns[tripID][feed]; ok {
+				feeds = append(feeds, feed)
+			}
+		}
+		sort.Strings(feeds)
+		for i := 1; i < len(feeds); i++ {
+			if p.patterns[tripID][feeds[i]] != p.patterns[tripID][feeds[0]] {
+				conflicts = append(conflicts, tripConflict{tripID: tripID, feeds: [2]string{feeds[0], feeds[i]}})
+				break
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].tripID < conflicts[j].tripID })
+	return conflicts
+}
@@ -0,0 +1,131 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTripPatterns(t *testing.T) {
+	trips := NewHeader([]string{"trip_id"})
+	stopTimes := NewHeader([]string{"trip_id", "stop_id", "stop_sequence"})
+	trip := func(feed, id string) GTFSRecord {
+		return GTFSRecord{Type: "trips", Header: trips, Contents: []string{id}, Path: filepath.Join(feed, "trips.txt")}
+	}
+	stopTime := func(feed, id, stop, sequence string) GTFSRecord {
+		return GTFSRecord{Type: "stop_times", Header: stopTimes, Contents: []string{id, stop, sequence}, Path: filepath.Join(feed, "stop_times.txt")}
+	}
+
+	p := newTripPatterns()
+	for _, rec := range []GTFSRecord{
+		// T1 calls at the same stops in both feeds, in whatever order they're added.
+		trip("a", "T1"), stopTime("a", "T1", "A", "1"), stopTime("a", "T1", "B", "2"),
+		trip("b", "T1"), stopTime("b", "T1", "B", "2"), stopTime("b", "T1", "A", "1"),
+		// T2 calls at C in place of B in c.
+		trip("a", "T2"), stopTime("a", "T2", "A", "1"), stopTime("a", "T2", "B", "2"),
+		trip("b", "T2"), stopTime("b", "T2", "A", "1"), stopTime("b", "T2", "B", "2"),
+		trip("c", "T2"), stopTime("c", "T2", "A", "1"), stopTime("c", "T2", "C", "2"),
+		// T3 calls at its stops in a different sequence in b.
+		trip("a", "T3"), stopTime("a", "T3", "A", "1"), stopTime("a", "T3", "B", "2"),
+		trip("b", "T3"), stopTime("b", "T3", "B", "1"), stopTime("b", "T3", "A", "2"),
+		// T4 has no stop times in b, so isn't compared.
+		trip("a", "T4"), stopTime("a", "T4", "A", "1"),
+		trip("b", "T4"),
+		// Only a defines T5, though b gives it stop times.
+		trip("a", "T5"), stopTime("a", "T5", "A", "1"), stopTime("b", "T5", "B", "1"),
+		{Type: "stops", Header: NewHeader([]string{"stop_id"}), Contents: []string{"A"}, Path: filepath.Join("a", "stops.txt")},
+	} {
+		p.add(rec)
+	}
+	want := []tripConflict{
+		{tripID: "T2", feeds: [2]string{"a", "c"}},
+		{tripID: "T3", feeds: [2]string{"a", "b"}},
+	}
+	if got := p.conflicts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got conflicts %+v, want %+v", got, want)
+	}
+
+	// A nil tripPatterns records nothing.
+	var none *tripPatterns
+	none.add(trip("a", "T1"))
+	if got := none.conflicts(); got != nil {
+		t.Errorf("got conflicts %+v from a nil tripPatterns", got)
+	}
+}
+
+// Consolidates feeds, each in a directory named by its index, returning everything
+// logged and the error.
+func consolidateFeeds(t *testing.T, opts func(*Options), feeds ...map[string]string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	for i, files := range feeds {
+		writeFiles(t, filepath.Join(in, fmt.Sprint(i)), files)
+	}
+
+	var logged bytes.Buffer
+	o := testOptions(dir)
+	o.Logger = NewLogger(&logged, LevelWarn)
+	if opts != nil {
+		opts(&o)
+	}
+	err := Consolidate(in, filepath.Join(dir, "out"), o)
+	return logged.String(), err
+}
+
+func TestConsolidateWarnsOfTripConflicts(t *testing.T) {
+	// T1 calls at D in place of C in the second feed.
+	other := withFiles(testFeed, map[string]string{
+		"stop_times.txt": strings.Replace(testFeed["stop_times.txt"], "T1,08:10:00,08:10:00,C,3", "T1,08:10:00,08:10:00,D,3", 1),
+	})
+	logged, err := consolidateFeeds(t, nil, testFeed, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged, "trips.txt has 1 trips defined by several feeds calling at different stops") {
+		t.Errorf("logged %q, want a count of the conflicting trips", logged)
+	}
+	if !strings.Contains(logged, `trip_id "T1" calls at different stops`) || strings.Contains(logged, `"T2"`) {
+		t.Errorf("logged %q, want T1 alone named", logged)
+	}
+
+	// Strict fails on the conflict alone.
+	_, err = consolidateFeeds(t, func(o *Options) { o.Strict = true }, completeFeed, withFiles(completeFeed, map[string]string{
+		"stop_times.txt": other["stop_times.txt"],
+	}))
+	if !errors.Is(err, ErrStrict) {
+		t.Errorf("got error %v, want ErrStrict", err)
+	}
+}
+
+func TestConsolidateNoTripConflicts(t *testing.T) {
+	// The same trips repeated by several feeds, or with their stop times in another
+	// order, don't conflict.
+	lines := strings.Split(strings.TrimSpace(testFeed["stop_times.txt"]), "\n")
+	reversed := []string{lines[0]}
+	for i := len(lines) - 1; i > 0; i-- {
+		reversed = append(reversed, lines[i])
+	}
+	other := withFiles(testFeed, map[string]string{"stop_times.txt": strings.Join(reversed, "\n") + "\n"})
+	logged, err := consolidateFeeds(t, nil, testFeed, testFeed, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged, "calling at different stops") {
+		t.Errorf("logged %q, want no conflicting trips", logged)
+	}
+
+	// A sample holds only part of each feed's stop times, so trips aren't compared.
+	short := withFiles(testFeed, map[string]string{"stop_times.txt": strings.Join(lines[:2], "\n") + "\n"})
+	logged, err = consolidateFeeds(t, func(o *Options) { o.Sample = 3 }, testFeed, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged, "calling at different stops") {
+		t.Errorf("logged %q sampling, want no conflicting trips", logged)
+	}
+}
@@ -0,0 +1,330 @@
+package gtfs
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Header maps the column names of a GTFS file to their position in each row, so
+// that rows can be parsed regardless of the order the columns were exported in.
+type Header map[string]int
+
+// NewHeader returns the Header described by the header row of a GTFS file.
+func NewHeader(row []string) Header {
+	h := make(Header, len(row))
+	for i, name := range row {
+		h[name] = i
+	}
+	return h
+}
+
+// Returns the value of the named column in row, or an empty string if the file
+// has no such column or the row is too short to contain it.
+func (h Header) value(row []string, column string) string {
+	i, ok := h[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Agency is a row of agency.txt.
+type Agency struct {
+	ID       string `json:"agency_id" protobuf:"1"`
+	Name     string `json:"agency_name" protobuf:"2"`
+	URL      string `json:"agency_url" protobuf:"3"`
+	Timezone string `json:"agency_timezone" protobuf:"4"`
+	Lang     string `json:"agency_lang" protobuf:"5"`
+}
+
+// Calendar is a row of calendar.txt. StartDate and EndDate are in the YYYYMMDD
+// form used by GTFS.
+type Calendar struct {
+	ServiceID string `json:"service_id" protobuf:"1"`
+	Monday    bool   `json:"monday" protobuf:"2"`
+	Tuesday   bool   `json:"tuesday" protobuf:"3"`
+	Wednesday bool   `json:"wednesday" protobuf:"4"`
+	Thursday  bool   `json:"thursday" protobuf:"5"`
+	Friday    bool   `json:"friday" protobuf:"6"`
+	Saturday  bool   `json:"saturday" protobuf:"7"`
+	Sunday    bool   `json:"sunday" protobuf:"8"`
+	StartDate string `json:"start_date" protobuf:"9"`
+	EndDate   string `json:"end_date" protobuf:"10"`
+}
+
+// CalendarDate is a row of calendar_dates.txt. An ExceptionType of 1 adds the
+// service on Date, whereas 2 removes it.
+type CalendarDate struct {
+	ServiceID     string `json:"service_id" protobuf:"1"`
+	Date          string `json:"date" protobuf:"2"`
+	ExceptionType int    `json:"exception_type" protobuf:"3"`
+}
+
+// Route is a row of routes.txt.
+type Route struct {
+	ID        string `json:"route_id" protobuf:"1"`
+	AgencyID  string `json:"agency_id" protobuf:"2"`
+	ShortName string `json:"route_short_name" protobuf:"3"`
+	LongName  string `json:"route_long_name" protobuf:"4"`
+	Type      int    `json:"route_type" protobuf:"5"`
+	Color     string `json:"route_color" protobuf:"6"`
+	TextColor string `json:"route_text_color" protobuf:"7"`
+}
+
+// Stop is a row of stops.txt. WheelchairBoarding is 1 if wheelchair boarding is
+// possible at the stop, 2 if it isn't, and 0 if that's unknown.
+type Stop struct {
+	ID                 string  `json:"stop_id" protobuf:"1"`
+	Name               string  `json:"stop_name" protobuf:"2"`
+	Lat                float64 `json:"stop_lat" protobuf:"3"`
+	Lon                float64 `json:"stop_lon" protobuf:"4"`
+	WheelchairBoarding int     `json:"wheelchair_boarding" protobuf:"5"`
+}
+
+// Trip is a row of trips.txt. WheelchairAccessible is 1 if the vehicle can carry at
+// least one wheelchair, 2 if it can't, and 0 if that's unknown.
+type Trip struct {
+	RouteID              string `json:"route_id" protobuf:"1"`
+	ServiceID            string `json:"service_id" protobuf:"2"`
+	ID                   string `json:"trip_id" protobuf:"3"`
+	ShapeID              string `json:"shape_id" protobuf:"4"`
+	Headsign             string `json:"trip_headsign" protobuf:"5"`
+	DirectionID          int    `json:"direction_id" protobuf:"6"`
+	WheelchairAccessible int    `json:"wheelchair_accessible" protobuf:"7"`
+}
+
+// StopTime is a row of stop_times.txt. ArrivalTime and DepartureTime are kept in
+// their original HH:MM:SS form, which may legitimately exceed 24:00:00.
+type StopTime struct {
+	TripID            string  `json:"trip_id" protobuf:"1"`
+	ArrivalTime       string  `json:"arrival_time" protobuf:"2"`
+	DepartureTime     string  `json:"departure_time" protobuf:"3"`
+	StopID            string  `json:"stop_id" protobuf:"4"`
+	StopSequence      int     `json:"stop_sequence" protobuf:"5"`
+	StopHeadsign      string  `json:"stop_headsign" protobuf:"6"`
+	PickupType        int     `json:"pickup_type" protobuf:"7"`
+	DropOffType       int     `json:"drop_off_type" protobuf:"8"`
+	ShapeDistTraveled float64 `json:"shape_dist_traveled" protobuf:"9"`
+}
+
+// ShapePoint is a row of shapes.txt, i.e. a single vertex of a shape's polyline.
+type ShapePoint struct {
+	ShapeID      string  `json:"shape_id" protobuf:"1"`
+	Lat          float64 `json:"shape_pt_lat" protobuf:"2"`
+	Lon          float64 `json:"shape_pt_lon" protobuf:"3"`
+	Sequence     int     `json:"shape_pt_sequence" protobuf:"4"`
+	DistTraveled float64 `json:"shape_dist_traveled" protobuf:"5"`
+}
+
+// Frequency is a row of frequencies.txt, which runs a trip every HeadwaySecs seconds
+// from StartTime until EndTime rather than listing each of its departures in trips.txt.
+// ExactTimes is 1 if the trips depart exactly on the headway, and 0 if the headway is
+// only approximate.
+type Frequency struct {
+	TripID      string `json:"trip_id" protobuf:"1"`
+	StartTime   string `json:"start_time" protobuf:"2"`
+	EndTime     string `json:"end_time" protobuf:"3"`
+	HeadwaySecs int    `json:"headway_secs" protobuf:"4"`
+	ExactTimes  int    `json:"exact_times" protobuf:"5"`
+}
+
+// TransferRule is a row of transfers.txt, describing how passengers may change between
+// two stops. A TransferType of 2 requires at least MinTransferTime seconds to change,
+// such as the time taken to walk between them.
+type TransferRule struct {
+	FromStopID      string `json:"from_stop_id" protobuf:"1"`
+	ToStopID        string `json:"to_stop_id" protobuf:"2"`
+	TransferType    int    `json:"transfer_type" protobuf:"3"`
+	MinTransferTime int    `json:"min_transfer_time" protobuf:"4"`
+}
+
+// rowParser reads typed values out of a CSV row by column name, remembering the
+// first value which failed to parse so that callers only check for an error once.
+type rowParser struct {
+	h   Header
+	row []string
+	err error
+}
+
+func (p *rowParser) str(column string) string {
+	return p.h.value(p.row, column)
+}
+
+// Empty values are treated as zero, as GTFS leaves most numeric columns optional.
+func (p *rowParser) int(column string) int {
+	v := p.str(column)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("column %s: invalid integer %q", column, v)
+	}
+	return i
+}
+
+func (p *rowParser) float(column string) float64 {
+	v := p.str(column)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("column %s: invalid number %q", column, v)
+	}
+	return f
+}
+
+func (p *rowParser) bool(column string) bool {
+	switch v := p.str(column); v {
+	case "1":
+		return true
+	case "0", "":
+		return false
+	default:
+		if p.err == nil {
+			p.err = fmt.Errorf("column %s: invalid flag %q", column, v)
+		}
+		return false
+	}
+}
+
+// ParseAgency maps a row of agency.txt to an Agency.
+func ParseAgency(h Header, row []string) (Agency, error) {
+	p := rowParser{h: h, row: row}
+	a := Agency{
+		ID:       p.str("agency_id"),
+		Name:     p.str("agency_name"),
+		URL:      p.str("agency_url"),
+		Timezone: p.str("agency_timezone"),
+		Lang:     p.str("agency_lang"),
+	}
+	return a, p.err
+}
+
+// ParseCalendar maps a row of calendar.txt to a Calendar.
+func ParseCalendar(h Header, row []string) (Calendar, error) {
+	p := rowParser{h: h, row: row}
+	c := Calendar{
+		ServiceID: p.str("service_id"),
+		Monday:    p.bool("monday"),
+		Tuesday:   p.bool("tuesday"),
+		Wednesday: p.bool("wednesday"),
+		Thursday:  p.bool("thursday"),
+		Friday:    p.bool("friday"),
+		Saturday:  p.bool("saturday"),
+		Sunday:    p.bool("sunday"),
+		StartDate: p.str("start_date"),
+		EndDate:   p.str("end_date"),
+	}
+	return c, p.err
+}
+
+// ParseCalendarDate maps a row of calendar_dates.txt to a CalendarDate.
+func ParseCalendarDate(h Header, row []string) (CalendarDate, error) {
+	p := rowParser{h: h, row: row}
+	c := CalendarDate{
+		ServiceID:     p.str("service_id"),
+		Date:          p.str("date"),
+		ExceptionType: p.int("exception_type"),
+	}
+	return c, p.err
+}
+
+// ParseRoute maps a row of routes.txt to a Route.
+func ParseRoute(h Header, row []string) (Route, error) {
+	p := rowParser{h: h, row: row}
+	r := Route{
+		ID:        p.str("route_id"),
+		AgencyID:  p.str("agency_id"),
+		ShortName: p.str("route_short_name"),
+		LongName:  p.str("route_long_name"),
+		Type:      p.int("route_type"),
+		Color:     p.str("route_color"),
+		TextColor: p.str("route_text_color"),
+	}
+	return r, p.err
+}
+
+// ParseStop maps a row of stops.txt to a Stop.
+func ParseStop(h Header, row []string) (Stop, error) {
+	p := rowParser{h: h, row: row}
+	s := Stop{
+		ID:                 p.str("stop_id"),
+		Name:               p.str("stop_name"),
+		Lat:                p.float("stop_lat"),
+		Lon:                p.float("stop_lon"),
+		WheelchairBoarding: p.int("wheelchair_boarding"),
+	}
+	return s, p.err
+}
+
+// ParseTrip maps a row of trips.txt to a Trip.
+func ParseTrip(h Header, row []string) (Trip, error) {
+	p := rowParser{h: h, row: row}
+	t := Trip{
+		RouteID:              p.str("route_id"),
+		ServiceID:            p.str("service_id"),
+		ID:                   p.str("trip_id"),
+		ShapeID:              p.str("shape_id"),
+		Headsign:             p.str("trip_headsign"),
+		DirectionID:          p.int("direction_id"),
+		WheelchairAccessible: p.int("wheelchair_accessible"),
+	}
+	return t, p.err
+}
+
+// ParseStopTime maps a row of stop_times.txt to a StopTime.
+func ParseStopTime(h Header, row []string) (StopTime, error) {
+	p := rowParser{h: h, row: row}
+	s := StopTime{
+		TripID:            p.str("trip_id"),
+		ArrivalTime:       p.str("arrival_time"),
+		DepartureTime:     p.str("departure_time"),
+		StopID:            p.str("stop_id"),
+		StopSequence:      p.int("stop_sequence"),
+		StopHeadsign:      p.str("stop_headsign"),
+		PickupType:        p.int("pickup_type"),
+		DropOffType:       p.int("drop_off_type"),
+		ShapeDistTraveled: p.float("shape_dist_traveled"),
+	}
+	return s, p.err
+}
+
+// ParseShapePoint maps a row of shapes.txt to a ShapePoint.
+func ParseShapePoint(h Header, row []string) (ShapePoint, error) {
+	p := rowParser{h: h, row: row}
+	s := ShapePoint{
+		ShapeID:      p.str("shape_id"),
+		Lat:          p.float("shape_pt_lat"),
+		Lon:          p.float("shape_pt_lon"),
+		Sequence:     p.int("shape_pt_sequence"),
+		DistTraveled: p.float("shape_dist_traveled"),
+	}
+	return s, p.err
+}
+
+// ParseFrequency maps a row of frequencies.txt to a Frequency.
+func ParseFrequency(h Header, row []string) (Frequency, error) {
+	p := rowParser{h: h, row: row}
+	f := Frequency{
+		TripID:      p.str("trip_id"),
+		StartTime:   p.str("start_time"),
+		EndTime:     p.str("end_time"),
+		HeadwaySecs: p.int("headway_secs"),
+		ExactTimes:  p.int("exact_times"),
+	}
+	return f, p.err
+}
+
+// ParseTransferRule maps a row of transfers.txt to a TransferRule.
+func ParseTransferRule(h Header, row []string) (TransferRule, error) {
+	p := rowParser{h: h, row: row}
+	t := TransferRule{
+		FromStopID:      p.str("from_stop_id"),
+		ToStopID:        p.str("to_stop_id"),
+		TransferType:    p.int("transfer_type"),
+		MinTransferTime: p.int("min_transfer_time"),
+	}
+	return t, p.err
+}
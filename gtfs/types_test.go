@@ -0,0 +1,111 @@
+package gtfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Parses a header and row given as comma separated strings with fn.
+func parseRow(header, row string, fn func(h Header, row []string) (interface{}, error)) (interface{}, error) {
+	return fn(NewHeader(strings.Split(header, ",")), strings.Split(row, ","))
+}
+
+func TestParseReorderedColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		header, row string
+		parse       func(h Header, row []string) (interface{}, error)
+		want        interface{}
+	}{
+		{
+			name:   "agency",
+			header: "agency_timezone,agency_name,agency_id,agency_url",
+			row:    "Australia/Melbourne,PTV,1,http://ptv.vic.gov.au",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseAgency(h, row) },
+			want:   Agency{ID: "1", Name: "PTV", URL: "http://ptv.vic.gov.au", Timezone: "Australia/Melbourne"},
+		},
+		{
+			name:   "calendar",
+			header: "end_date,start_date,sunday,saturday,friday,thursday,wednesday,tuesday,monday,service_id",
+			row:    "20241231,20240101,1,0,0,0,0,0,1,S1",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseCalendar(h, row) },
+			want:   Calendar{ServiceID: "S1", Monday: true, Sunday: true, StartDate: "20240101", EndDate: "20241231"},
+		},
+		{
+			name:   "calendar_dates",
+			header: "exception_type,date,service_id",
+			row:    "2,20240603,S1",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseCalendarDate(h, row) },
+			want:   CalendarDate{ServiceID: "S1", Date: "20240603", ExceptionType: 2},
+		},
+		{
+			name:   "routes",
+			header: "route_type,route_color,route_id,route_short_name",
+			row:    "2,FF0000,R1,Sandringham",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseRoute(h, row) },
+			want:   Route{ID: "R1", ShortName: "Sandringham", Type: 2, Color: "FF0000"},
+		},
+		{
+			name:   "stops",
+			header: "stop_lon,stop_lat,stop_name,stop_id",
+			row:    "144.9671,-37.8183,Flinders Street,A",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseStop(h, row) },
+			want:   Stop{ID: "A", Name: "Flinders Street", Lat: -37.8183, Lon: 144.9671},
+		},
+		{
+			name:   "trips",
+			header: "trip_id,direction_id,service_id,route_id,shape_id",
+			row:    "T1,1,S1,R1,SH1",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseTrip(h, row) },
+			want:   Trip{RouteID: "R1", ServiceID: "S1", ID: "T1", ShapeID: "SH1", DirectionID: 1},
+		},
+		{
+			name:   "stop_times",
+			header: "stop_sequence,stop_id,departure_time,arrival_time,trip_id,shape_dist_traveled",
+			row:    "2,B,08:06:00,08:05:00,T1,1300.5",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseStopTime(h, row) },
+			want:   StopTime{TripID: "T1", ArrivalTime: "08:05:00", DepartureTime: "08:06:00", StopID: "B", StopSequence: 2, ShapeDistTraveled: 1300.5},
+		},
+		{
+			name:   "shapes",
+			header: "shape_pt_sequence,shape_pt_lon,shape_pt_lat,shape_id",
+			row:    "3,144.9525,-37.8184,SH1",
+			parse:  func(h Header, row []string) (interface{}, error) { return ParseShapePoint(h, row) },
+			want:   ShapePoint{ShapeID: "SH1", Lat: -37.8184, Lon: 144.9525, Sequence: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRow(tt.header, tt.row, tt.parse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalidValues(t *testing.T) {
+	if _, err := ParseStopTime(NewHeader([]string{"trip_id", "stop_sequence"}), []string{"T1", "second"}); err == nil {
+		t.Error("ParseStopTime accepted a stop_sequence which isn't a number")
+	}
+	if _, err := ParseStop(NewHeader([]string{"stop_id", "stop_lat"}), []string{"A", "north"}); err == nil {
+		t.Error("ParseStop accepted a stop_lat which isn't a number")
+	}
+	if _, err := ParseCalendar(NewHeader([]string{"service_id", "monday"}), []string{"S1", "yes"}); err == nil {
+		t.Error("ParseCalendar accepted a monday which isn't 0 or 1")
+	}
+}
+
+func TestHeaderValueOfShortRow(t *testing.T) {
+	h := NewHeader([]string{"stop_id", "stop_name", "stop_lat"})
+	if got := h.value([]string{"A"}, "stop_lat"); got != "" {
+		t.Errorf("got %q for a column beyond the end of the row", got)
+	}
+	if got := h.value([]string{"A", "Flinders Street"}, "platform_code"); got != "" {
+		t.Errorf("got %q for a column the file doesn't have", got)
+	}
+}
@@ -0,0 +1,324 @@
+package gtfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StopBounds is the range within which Validate expects every stop to lie. It defaults
+// to roughly the extent of Victoria, so that stops at 0,0 or with their latitude and
+// longitude swapped are caught. Set it to nil to skip the check.
+var StopBounds = &BoundingBox{MinLat: -39, MinLon: 140, MaxLat: -34, MaxLon: 150}
+
+// ValidationError describes a problem found in a consolidated GTFS feed. Row is the
+// line number of the offending record within File, counting the header as line 1,
+// and Value is the offending value of Column.
+type ValidationError struct {
+	File    string
+	Row     int
+	Column  string
+	Value   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Row == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s line %d: %s %q %s", e.File, e.Row, e.Column, e.Value, e.Message)
+}
+
+// DanglingReferenceError describes a record of a GTFS feed which refers to a record of
+// ReferencedFile that doesn't exist. Row is the line number of the record within File,
+// counting the header as line 1, and Value is the value of Column which wasn't found.
+type DanglingReferenceError struct {
+	File           string
+	Row            int
+	Column         string
+	Value          string
+	ReferencedFile string
+}
+
+func (e DanglingReferenceError) Error() string {
+	return fmt.Sprintf("%s line %d: %s %q not found in %s", e.File, e.Row, e.Column, e.Value, e.ReferencedFile)
+}
+
+// DuplicateKeyError describes a record of a GTFS feed which shares its key, as given by
+// DefaultKeys, with an earlier record of the same file. Key names the key columns along
+// with their values, e.g. "trip_id T1, stop_sequence 2", and Row is the line number of
+// the later record.
+type DuplicateKeyError struct {
+	File string
+	Row  int
+	Key  string
+}
+
+func (e DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%s line %d: %s is a duplicate of an earlier record", e.File, e.Row, e.Key)
+}
+
+// Validate checks the referential integrity of the GTFS feed in dir, such as one
+// written by Consolidate, and returns a DanglingReferenceError for every reference to a
+// record which doesn't exist:
+//
+//   - trips.route_id must exist in routes
+//   - trips.service_id must exist in calendar or calendar_dates
+//   - trips.shape_id, when given, must exist in shapes
+//   - stop_times.trip_id must exist in trips
+//   - stop_times.stop_id must exist in stops
+//   - frequencies.trip_id must exist in trips
+//   - transfers.from_stop_id and transfers.to_stop_id must exist in stops
+//
+// The stop_sequence of each stop time must also differ from those of the trip's other
+// stop times, as a trip which calls at two stops at once can't be followed from one to
+// the next. Stop times needn't be sorted, so a trip's stop times may be given in any
+// order.
+//
+// A DuplicateKeyError is returned for every record sharing its key with an earlier
+// record of the same file.
+//
+// Stops whose coordinates can't be parsed or lie outside StopBounds are also reported,
+// as are agencies whose agency_timezone isn't a known IANA timezone, routes whose
+// route_color or route_text_color isn't six hex digits, and a feed whose every service
+// has already ended, which can't plan any journeys. So is each service without any
+// trips, at its first row of calendar.txt, or of calendar_dates.txt if it's only given
+// there. These, and any other problems, are returned as a ValidationError.
+func Validate(dir string) []error {
+	// Coordinates are checked first, as a stop which can't be parsed also stops the
+	// feed from being loaded.
+	errs := validateStopCoordinates(filepath.Join(dir, "stops.txt"), StopBounds)
+	errs = append(errs, validateKeys(dir)...)
+
+	feed, err := LoadFeed(dir)
+	if err != nil {
+		return append(errs, ValidationError{File: dir, Message: err.Error()})
+	}
+
+	routes := make(map[string]bool, len(feed.Routes))
+	for _, r := range feed.Routes {
+		routes[r.ID] = true
+	}
+	services := make(map[string]bool, len(feed.Calendars))
+	for _, c := range feed.Calendars {
+		services[c.ServiceID] = true
+	}
+	for _, c := range feed.CalendarDates {
+		services[c.ServiceID] = true
+	}
+	shapes := make(map[string]bool)
+	for _, s := range feed.Shapes {
+		shapes[s.ShapeID] = true
+	}
+	trips := make(map[string]bool, len(feed.Trips))
+	for _, t := range feed.Trips {
+		trips[t.ID] = true
+	}
+	stops := make(map[string]bool, len(feed.Stops))
+	for _, s := range feed.Stops {
+		stops[s.ID] = true
+	}
+
+	if _, last := ServiceWindow(feed.Calendars, feed.CalendarDates); last != "" && last < time.Now().Format(gtfsDateLayout) {
+		errs = append(errs, ValidationError{
+			File:    "calendar.txt",
+			Message: fmt.Sprintf("every service has expired, the last on %s", last),
+		})
+	}
+
+	serviceTrips := make(map[string]int)
+	for _, s := range feed.ServiceStats() {
+		serviceTrips[s.ServiceID] = s.Trips
+	}
+	reported := make(map[string]bool)
+	unused := func(file string, i int, serviceID string) {
+		if serviceTrips[serviceID] > 0 || reported[serviceID] {
+			return
+		}
+		reported[serviceID] = true
+		errs = append(errs, ValidationError{
+			File:    file,
+			Row:     i + 2,
+			Column:  "service_id",
+			Value:   serviceID,
+			Message: "has no trips",
+		})
+	}
+	for i, c := range feed.Calendars {
+		unused("calendar.txt", i, c.ServiceID)
+	}
+	for i, c := range feed.CalendarDates {
+		unused("calendar_dates.txt", i, c.ServiceID)
+	}
+
+	for i, a := range feed.Agencies {
+		if _, err := time.LoadLocation(a.Timezone); err != nil || a.Timezone == "" {
+			errs = append(errs, ValidationError{
+				File:    "agency.txt",
+				Row:     i + 2,
+				Column:  "agency_timezone",
+				Value:   a.Timezone,
+				Message: fmt.Sprintf("of agency %s is not a known timezone", a.ID),
+			})
+		}
+	}
+
+	for i, r := range feed.Routes {
+		for _, c := range []struct{ column, value string }{{"route_color", r.Color}, {"route_text_color", r.TextColor}} {
+			if c.value != "" && !ValidRouteColor(c.value) {
+				errs = append(errs, ValidationError{
+					File:    "routes.txt",
+					Row:     i + 2,
+					Column:  c.column,
+					Value:   c.value,
+					Message: fmt.Sprintf("of route %s is not a six digit hex colour", r.ID),
+				})
+			}
+		}
+	}
+
+	missing := func(file string, i int, column, value, referenced string) {
+		errs = append(errs, DanglingReferenceError{
+			File:           file,
+			Row:            i + 2,
+			Column:         column,
+			Value:          value,
+			ReferencedFile: referenced,
+		})
+	}
+
+	for i, t := range feed.Trips {
+		if !routes[t.RouteID] {
+			missing("trips.txt", i, "route_id", t.RouteID, "routes.txt")
+		}
+		if !services[t.ServiceID] {
+			missing("trips.txt", i, "service_id", t.ServiceID, "calendar.txt or calendar_dates.txt")
+		}
+		if t.ShapeID != "" && !shapes[t.ShapeID] {
+			missing("trips.txt", i, "shape_id", t.ShapeID, "shapes.txt")
+		}
+	}
+
+	// sequences holds the row of the first stop time of each trip and stop_sequence.
+	sequences := make(map[string]int, len(feed.StopTimes))
+	for i, st := range feed.StopTimes {
+		if !trips[st.TripID] {
+			missing("stop_times.txt", i, "trip_id", st.TripID, "trips.txt")
+		}
+		if !stops[st.StopID] {
+			missing("stop_times.txt", i, "stop_id", st.StopID, "stops.txt")
+		}
+
+		key := st.TripID + keySeparator + strconv.Itoa(st.StopSequence)
+		if first, ok := sequences[key]; ok {
+			errs = append(errs, ValidationError{
+				File:    "stop_times.txt",
+				Row:     i + 2,
+				Column:  "stop_sequence",
+				Value:   strconv.Itoa(st.StopSequence),
+				Message: fmt.Sprintf("of trip %s repeats that of line %d", st.TripID, first),
+			})
+			continue
+		}
+		sequences[key] = i + 2
+	}
+
+	for i, f := range feed.Frequencies {
+		if !trips[f.TripID] {
+			missing("frequencies.txt", i, "trip_id", f.TripID, "trips.txt")
+		}
+	}
+
+	for i, t := range feed.Transfers {
+		if !stops[t.FromStopID] {
+			missing("transfers.txt", i, "from_stop_id", t.FromStopID, "stops.txt")
+		}
+		if !stops[t.ToStopID] {
+			missing("transfers.txt", i, "to_stop_id", t.ToStopID, "stops.txt")
+		}
+	}
+
+	return errs
+}
+
+// Returns a ValidationError for each stop in the stops.txt file at path whose stop_lat
+// or stop_lon is missing, can't be parsed, or lies outside bounds. A nil bounds only
+// checks that the coordinates parse.
+func validateStopCoordinates(path string, bounds *BoundingBox) []error {
+	var errs []error
+	line := 1
+	err := readCSVFile(path, func(h Header, row []string) error {
+		line++
+		id := h.value(row, "stop_id")
+
+		check := func(column string, min, max float64) {
+			value := h.value(row, column)
+			flag := func(message string) {
+				errs = append(errs, ValidationError{
+					File:    "stops.txt",
+					Row:     line,
+					Column:  column,
+					Value:   value,
+					Message: fmt.Sprintf("of stop %s %s", id, message),
+				})
+			}
+
+			if value == "" {
+				flag("is missing")
+				return
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				flag("is not a number")
+				return
+			}
+			if bounds != nil && (f < min || f > max) {
+				flag(fmt.Sprintf("is outside the plausible range %v to %v", min, max))
+			}
+		}
+
+		var b BoundingBox
+		if bounds != nil {
+			b = *bounds
+		}
+		check("stop_lat", b.MinLat, b.MaxLat)
+		check("stop_lon", b.MinLon, b.MaxLon)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, ValidationError{File: "stops.txt", Message: err.Error()})
+	}
+	return errs
+}
+
+// Returns a DuplicateKeyError for each record of the GTFS files in dir which shares its
+// DefaultKeys with an earlier record of the same file.
+func validateKeys(dir string) []error {
+	var errs []error
+	for _, kind := range validGTFSFileNames {
+		file := fmt.Sprintf("%s.txt", kind)
+		columns := DefaultKeys[kind]
+		seen := make(map[string]bool)
+		line := 1
+		err := readCSVFile(filepath.Join(dir, file), func(h Header, row []string) error {
+			line++
+			values := make([]string, len(columns))
+			for i, column := range columns {
+				values[i] = h.value(row, column)
+			}
+			key := strings.Join(values, keySeparator)
+			if seen[key] {
+				errs = append(errs, DuplicateKeyError{File: file, Row: line, Key: describeKey(columns, values)})
+			}
+			seen[key] = true
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, ValidationError{File: file, Message: err.Error()})
+		}
+	}
+	return errs
+}
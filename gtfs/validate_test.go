@@ -0,0 +1,264 @@
+package gtfs
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConsistentFeed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	for _, err := range Validate(dir) {
+		t.Errorf("unexpected problem: %v", err)
+	}
+}
+
+func TestValidateDanglingReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"stop_times.txt": testFeed["stop_times.txt"] + "T1,08:20:00,08:20:00,X,4,,0,0,3000\n",
+		"trips.txt":      testFeed["trips.txt"] + "R9,S1,T9,SH9,Nowhere,0,0\n",
+	}))
+
+	want := map[DanglingReferenceError]bool{
+		{File: "stop_times.txt", Row: 9, Column: "stop_id", Value: "X", ReferencedFile: "stops.txt"}: true,
+		{File: "trips.txt", Row: 5, Column: "route_id", Value: "R9", ReferencedFile: "routes.txt"}:   true,
+		{File: "trips.txt", Row: 5, Column: "shape_id", Value: "SH9", ReferencedFile: "shapes.txt"}:  true,
+	}
+	for _, err := range Validate(dir) {
+		var dangling DanglingReferenceError
+		if !errors.As(err, &dangling) {
+			t.Errorf("unexpected problem: %v", err)
+			continue
+		}
+		if !want[dangling] {
+			t.Errorf("unexpected dangling reference: %+v", dangling)
+		}
+		delete(want, dangling)
+	}
+	for missing := range want {
+		t.Errorf("dangling reference not reported: %+v", missing)
+	}
+}
+
+func TestValidateMissingService(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"trips.txt": testFeed["trips.txt"] + "R1,S9,T9,SH1,City,0,1\n",
+	}))
+
+	found := false
+	for _, err := range Validate(dir) {
+		var dangling DanglingReferenceError
+		if errors.As(err, &dangling) && dangling.Column == "service_id" && dangling.Value == "S9" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("trip with an unknown service_id wasn't reported")
+	}
+}
+
+func TestValidateStopCoordinates(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"stops.txt": "stop_id,stop_lat,stop_lon\n" +
+			"A,-37.8183,144.9671\n" +
+			"Z,0,0\n" +
+			"S,144.9671,-37.8183\n" +
+			"N,north,\n",
+	})
+	path := filepath.Join(dir, "stops.txt")
+
+	type flagged struct {
+		row          int
+		column, stop string
+	}
+	check := func(t *testing.T, errs []error, want []flagged) {
+		t.Helper()
+		var got []flagged
+		for _, err := range errs {
+			var v ValidationError
+			if !errors.As(err, &v) {
+				t.Fatalf("unexpected problem: %v", err)
+			}
+			got = append(got, flagged{v.Row, v.Column, strings.Fields(v.Message)[2]})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v\nwant %+v", got, want)
+		}
+	}
+
+	// The stop at 0,0 and the one with its coordinates swapped lie outside Victoria.
+	check(t, validateStopCoordinates(path, StopBounds), []flagged{
+		{3, "stop_lat", "Z"}, {3, "stop_lon", "Z"},
+		{4, "stop_lat", "S"}, {4, "stop_lon", "S"},
+		{5, "stop_lat", "N"}, {5, "stop_lon", "N"},
+	})
+	// Without bounds, only coordinates which are missing or aren't numbers are flagged.
+	check(t, validateStopCoordinates(path, nil), []flagged{{5, "stop_lat", "N"}, {5, "stop_lon", "N"}})
+	// Bounds around the equator take in the stop at 0,0 alone.
+	check(t, validateStopCoordinates(path, &BoundingBox{MinLat: -1, MinLon: -1, MaxLat: 1, MaxLon: 1}), []flagged{
+		{2, "stop_lat", "A"}, {2, "stop_lon", "A"},
+		{4, "stop_lat", "S"}, {4, "stop_lon", "S"},
+		{5, "stop_lat", "N"}, {5, "stop_lon", "N"},
+	})
+}
+
+func TestValidateReportsBadCoordinate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"stops.txt": testFeed["stops.txt"] + "Z,Null Island,0,145.0,0\n",
+	}))
+	var found bool
+	for _, err := range Validate(dir) {
+		var v ValidationError
+		if errors.As(err, &v) && v.Column == "stop_lat" {
+			found = true
+			if want := `stops.txt line 6: stop_lat "0" of stop Z is outside the plausible range -39 to -34`; v.Error() != want {
+				t.Errorf("got %q, want %q", v.Error(), want)
+			}
+		}
+	}
+	if !found {
+		t.Error("stop at latitude 0 wasn't flagged")
+	}
+}
+
+func TestValidateStopSequence(t *testing.T) {
+	dir := t.TempDir()
+	header := strings.SplitN(testFeed["stop_times.txt"], "\n", 2)[0] + "\n"
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"stop_times.txt": header +
+			"T1,08:00:00,08:00:00,A,1,,0,0,0\n" +
+			"T1,08:05:00,08:06:00,B,2,,0,0,1300\n" +
+			"T1,08:06:00,08:06:00,B,2,,0,0,1300\n" +
+			"T1,08:10:00,08:10:00,C,3,,0,0,2000\n" +
+			"T2,23:55:00,23:55:00,B,1,,0,0,0\n" +
+			"T2,24:10:00,24:10:00,C,2,,0,0,3000\n" +
+			// T3 repeats its first stop_sequence after that of another trip.
+			"T3,09:00:00,09:00:00,A,1,,0,0,0\n" +
+			"T3,09:30:00,09:30:00,D,2,,0,0,1000\n" +
+			"T3,09:40:00,09:40:00,C,1,,0,0,1500\n",
+	}))
+
+	var got []ValidationError
+	for _, err := range Validate(dir) {
+		var v ValidationError
+		if errors.As(err, &v) && v.Column == "stop_sequence" {
+			got = append(got, v)
+		}
+	}
+	want := []ValidationError{
+		{File: "stop_times.txt", Row: 4, Column: "stop_sequence", Value: "2", Message: "of trip T1 repeats that of line 3"},
+		{File: "stop_times.txt", Row: 10, Column: "stop_sequence", Value: "1", Message: "of trip T3 repeats that of line 8"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateStopSequenceOutOfOrder(t *testing.T) {
+	// Stop times needn't be sorted, by trip or by stop_sequence, to be valid.
+	dir := t.TempDir()
+	header := strings.SplitN(testFeed["stop_times.txt"], "\n", 2)[0] + "\n"
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"stop_times.txt": header +
+			"T1,08:10:00,08:10:00,C,3,,0,0,2000\n" +
+			"T2,24:10:00,24:10:00,C,2,,0,0,3000\n" +
+			"T1,08:00:00,08:00:00,A,1,,0,0,0\n" +
+			"T3,09:30:00,09:30:00,D,20,,0,0,1000\n" +
+			"T2,23:55:00,23:55:00,B,1,,0,0,0\n" +
+			"T1,08:05:00,08:06:00,B,2,,0,0,1300\n" +
+			"T3,09:00:00,09:00:00,A,10,,0,0,0\n",
+	}))
+	for _, err := range Validate(dir) {
+		var v ValidationError
+		if errors.As(err, &v) && v.Column == "stop_sequence" {
+			t.Errorf("got %v for stop times which are valid but out of order", v)
+		}
+	}
+}
+
+func TestValidateDuplicateKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"routes.txt":     testFeed["routes.txt"] + "R1,1,Sandringham,Sandringham Line,2,FF0000,FFFFFF\n",
+		"stop_times.txt": testFeed["stop_times.txt"] + "T1,08:10:00,08:10:00,C,3,,0,0,2000\n",
+	}))
+
+	var dups []DuplicateKeyError
+	for _, err := range Validate(dir) {
+		switch err := err.(type) {
+		case DuplicateKeyError:
+			dups = append(dups, err)
+		case ValidationError:
+			// The repeated stop time also repeats its stop_sequence.
+			if err.Column != "stop_sequence" {
+				t.Errorf("unexpected problem: %v", err)
+			}
+		default:
+			t.Errorf("unexpected problem: %v", err)
+		}
+	}
+	want := []DuplicateKeyError{
+		{File: "routes.txt", Row: 4, Key: "route_id R1"},
+		{File: "stop_times.txt", Row: 9, Key: "trip_id T1, stop_sequence 3"},
+	}
+	if !reflect.DeepEqual(dups, want) {
+		t.Errorf("got %+v, want %+v", dups, want)
+	}
+}
+
+func TestValidationErrorMessages(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{
+			DanglingReferenceError{File: "trips.txt", Row: 5, Column: "route_id", Value: "R9", ReferencedFile: "routes.txt"},
+			`trips.txt line 5: route_id "R9" not found in routes.txt`,
+		},
+		{
+			DuplicateKeyError{File: "stops.txt", Row: 3, Key: "stop_id A"},
+			"stops.txt line 3: stop_id A is a duplicate of an earlier record",
+		},
+		{
+			ValidationError{File: "stops.txt", Message: "unable to read"},
+			"stops.txt: unable to read",
+		},
+	} {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestValidateServiceWithoutTrips(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, withFiles(testFeed, map[string]string{
+		"calendar.txt":       testFeed["calendar.txt"] + "S4,1,1,1,1,1,0,0,20240101,20241231\n",
+		"calendar_dates.txt": testFeed["calendar_dates.txt"] + "S5,20240602,1\nS5,20240609,1\nS4,20240610,2\n",
+	}))
+
+	var got []ValidationError
+	for _, err := range Validate(dir) {
+		var v ValidationError
+		if !errors.As(err, &v) {
+			t.Errorf("unexpected problem: %v", err)
+			continue
+		}
+		got = append(got, v)
+	}
+	// Each is reported once, at its first row, in calendar.txt where it's given there.
+	want := []ValidationError{
+		{File: "calendar.txt", Row: 4, Column: "service_id", Value: "S4", Message: "has no trips"},
+		{File: "calendar_dates.txt", Row: 4, Column: "service_id", Value: "S5", Message: "has no trips"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
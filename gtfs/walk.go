@@ -0,0 +1,308 @@
+package gtfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// GTFSRecord represents a GTFS record which has been read by walking the extracted
+// input zip. The Type property denotes the kind of GTFS file residing at this path,
+// valid values are those in the validGTFSFileNames array. Header describes the columns
+// of the file the record came from, and may be used with the Parse functions to obtain
+// a typed record. Row is the record's position among those read from the file, from 1,
+// or 0 if it wasn't read from a file.
+type GTFSRecord struct {
+	Path     string
+	Type     string
+	Header   Header
+	Contents []string
+	Row      int
+}
+
+// openFile opens the source files of a feed for reading, so that tests can see which
+// files are read.
+var openFile = os.Open
+
+// Returns whether a given filename is likely a GTFS file of one of the given kinds,
+// i.e. if its name matches one of the values in kinds, either as it is or gzipped as
+// with stop_times.txt.gz.
+func fileIsGTFSFile(fileName string, kinds []string) bool {
+	for _, str := range kinds {
+		if fileName == fmt.Sprintf("%s.txt", str) || fileName == fmt.Sprintf("%s.txt.gz", str) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns r, read from the GTFS file at path, decompressed if the file is gzipped.
+func decompressGTFSFile(path string, r io.Reader) (io.Reader, error) {
+	if !strings.HasSuffix(path, ".gz") {
+		return r, nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress %s: %w", path, err)
+	}
+	return zr, nil
+}
+
+// walkOptions controls which files walkPTVData reads and how.
+type walkOptions struct {
+	// kinds lists the kinds of GTFS file to read, or all of validGTFSFileNames if nil.
+	kinds []string
+	// concurrency limits how many files are open at any one time; values below 1 mean GOMAXPROCS.
+	concurrency int
+	log         Logger
+	// progress, if set, is called with the bytes read so far of the total size of the files.
+	progress func(done, total int64)
+	// maxMalformed is the fraction of the rows of a file which may be malformed and
+	// skipped before reading it fails. Zero means DefaultMaxMalformedRows, and a
+	// negative value fails on any malformed row.
+	maxMalformed float64
+	// comma separates the fields of the files; zero means a comma.
+	comma rune
+	// checkpoint, if set, skips the files written before the checkpoint being resumed
+	// from, and is told of each file as it's started and finished.
+	checkpoint *checkpointer
+	// sample, if set, stops the files of each kind being read once it has enough rows.
+	sample *sampler
+}
+
+// DefaultMaxMalformedRows is the fraction of the rows of a GTFS file which may be
+// malformed, such as having the wrong number of fields, and be skipped without failing.
+const DefaultMaxMalformedRows = 0.01
+
+// malformedRowLogLimit is the number of malformed rows of each file which are logged
+// individually; the rest are only counted.
+const malformedRowLogLimit = 5
+
+// Walks the fully extracted PTV GTFS zip and outputs each row of each GTFS CSV through a goroutine
+// channel. Each row is wrapped in a GTFSRecord struct which contains the path of the parent file,
+// the kind of file (stop_times, routes etc.), and the string slice of CSV data itself.
+//
+// Only the kinds of file listed in opts are read, with at most opts.concurrency open at once.
+// Rows which can't be parsed are logged and skipped, unless more than opts.maxMalformed
+// of the rows of a file are malformed, in which case reading it fails.
+//
+// Files already written according to opts.checkpoint are skipped, and no new files are
+// started while it waits to save a checkpoint. Files of a kind of which opts.sample has
+// enough rows are skipped, or stop being read part way through.
+//
+// The returned error channel receives a single value once the record channel has been closed:
+// the first error encountered while walking or reading, or nil if every file was read in full.
+// Once ctx is cancelled no further files are opened and no further records are sent, the
+// record channel is closed and ctx.Err() is reported.
+func walkPTVData(ctx context.Context, path string, opts walkOptions) (chan GTFSRecord, chan error) {
+	kinds := opts.kinds
+	if kinds == nil {
+		kinds = validGTFSFileNames
+	}
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	c := make(chan GTFSRecord)
+	errc := make(chan error, 1)
+	sem := make(chan struct{}, concurrency)
+	log := loggerOrDefault(opts.log)
+	counter := newProgressCounter(path, kinds, opts.progress)
+	maxMalformed := opts.maxMalformed
+	if maxMalformed == 0 {
+		maxMalformed = DefaultMaxMalformedRows
+	}
+	comma := opts.comma
+	if comma == 0 {
+		comma = ','
+	}
+	var wg sync.WaitGroup
+
+	var errOnce sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+
+	// The walk runs in the background as it may block waiting for a free slot, which
+	// only opens up once the caller starts consuming records.
+	go func() {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return fmt.Errorf("access %s: %w", path, err)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Check if we've arrived at a GTFS txt file.
+			if !info.IsDir() && fileIsGTFSFile(info.Name(), kinds) {
+				if opts.checkpoint.skips(path) {
+					log.Debugf("Skipping %s, which was written before the checkpoint", path)
+					return nil
+				}
+				if opts.sample.filled(strings.Split(info.Name(), ".")[0]) {
+					log.Debugf("Skipping %s, as enough of its rows have been sampled", path)
+					return nil
+				}
+
+				// Wait for a free slot, add a task to the waitgroup and fire off a goroutine.
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				opts.checkpoint.begin(path)
+				log.Debugf("Reading %s", path)
+				wg.Add(1)
+				go func() {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+
+					rows, err := readGTFSFile(ctx, path, info.Name(), c, counter, maxMalformed, comma, opts.sample, log)
+					if err != nil {
+						setErr(err)
+					}
+					opts.checkpoint.finish(path, rows, err)
+				}()
+			}
+
+			return nil
+		})
+		if err != nil {
+			setErr(err)
+		}
+
+		// Close the channel after all records from all files have been read.
+		wg.Wait()
+		close(c)
+		errc <- firstErr
+	}()
+
+	return c, errc
+}
+
+// recordChunkRows is the number of rows' worth of fields readGTFSFile allocates at once.
+const recordChunkRows = 1024
+
+// Reads every row of the GTFS file at path, bar the header, and sends it through c until
+// ctx is cancelled, returning the number of rows sent. Fields are separated by comma.
+// The bytes read are added to counter, which may be nil.
+//
+// Rows which can't be parsed are skipped, logging the first few of them. Once the whole
+// file has been read an error is returned if more than maxMalformed of its rows were
+// skipped; a negative maxMalformed instead fails on the first malformed row. Reading
+// stops early once sample has enough rows of the file's kind.
+func readGTFSFile(ctx context.Context, path string, name string, c chan GTFSRecord, counter *progressCounter, maxMalformed float64, comma rune, sample *sampler, log Logger) (int, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if counter != nil {
+		// Progress is counted in the bytes of the file, which is how its total was found.
+		r = &countingReader{r: file, counter: counter}
+	}
+	r, err = decompressGTFSFile(path, r)
+	if err != nil {
+		return 0, err
+	}
+	csvFile := newCSVReader(r)
+	csvFile.ReuseRecord = true
+	csvFile.Comma = comma
+	headerRow, err := csvFile.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to read header of %s: %w", path, err)
+	}
+	header := NewHeader(headerRow)
+
+	recordType := strings.Split(name, ".")[0]
+	// The reader reuses its record slice, so each row is copied out before being sent.
+	// The copies are carved from chunks of recordChunkRows rows at a time rather than
+	// allocated one by one.
+	var chunk []string
+	var rows, malformed int
+	// Iterate through the records of the current file.
+	for {
+		if sample.filled(recordType) {
+			break
+		}
+		record, err := csvFile.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if maxMalformed < 0 {
+				return rows, fmt.Errorf("unable to read %s: %w", path, err)
+			}
+			malformed++
+			if malformed <= malformedRowLogLimit {
+				log.Warnf("Skipping malformed row of %s: %s", path, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			return rows, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+
+		if len(chunk) < len(record) {
+			chunk = make([]string, recordChunkRows*len(record))
+		}
+		contents := chunk[:len(record):len(record)]
+		copy(contents, record)
+		chunk = chunk[len(record):]
+
+		select {
+		case c <- GTFSRecord{Path: path, Type: recordType, Header: header, Contents: contents, Row: rows + 1}:
+			rows++
+		case <-ctx.Done():
+			return rows, ctx.Err()
+		}
+	}
+
+	if malformed == 0 {
+		return rows, nil
+	}
+	total := rows + malformed
+	if float64(malformed) > maxMalformed*float64(total) {
+		return rows, fmt.Errorf("unable to read %s: %d of %d rows are malformed", path, malformed, total)
+	}
+	log.Warnf("Skipped %d malformed rows of %s", malformed, path)
+	return rows, nil
+}
+
+// utf8BOM is the byte order mark which some exporters write at the start of a file.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Returns a csv.Reader over r which skips any UTF-8 byte order mark at its start, so
+// that the first column of the header isn't read with the mark attached. Lines may end
+// in either LF or CRLF.
+func newCSVReader(r io.Reader) *csv.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	return csv.NewReader(br)
+}
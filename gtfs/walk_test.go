@@ -0,0 +1,413 @@
+package gtfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWalkPTVDataManyFilesLowConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	const feeds = 200
+	for i := 0; i < feeds; i++ {
+		writeFiles(t, filepath.Join(dir, fmt.Sprint(i), "google_transit"), map[string]string{
+			"stops.txt":  fmt.Sprintf("stop_id,stop_name\n%d,Stop %d\n", i, i),
+			"routes.txt": fmt.Sprintf("route_id,route_type\nR%d,3\n", i),
+		})
+	}
+
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{concurrency: 2})
+	counts := make(map[string]int)
+	for rec := range records {
+		counts[rec.Type]++
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if counts["stops"] != feeds || counts["routes"] != feeds {
+		t.Errorf("got %v, want %d stops and routes", counts, feeds)
+	}
+}
+
+func TestWalkPTVDataCancelled(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 50; i++ {
+		writeFiles(t, filepath.Join(dir, fmt.Sprint(i)), testFeed)
+	}
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	records, errc := walkPTVData(ctx, dir, walkOptions{concurrency: 4})
+	// Stop partway through, then stop receiving altogether for a moment, so that the
+	// walk's goroutines are blocked sending when they're cancelled.
+	for i := 0; i < 10; i++ {
+		<-records
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	for range records {
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	// Every goroutine of the walk exits once it's been cancelled.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines still running, up from %d", n, before)
+	}
+}
+
+func TestConsolidateCancelled(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, testFeed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ConsolidateContext(ctx, in, filepath.Join(dir, "out"), testOptions(dir)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestWalkPTVDataProgress(t *testing.T) {
+	dir := t.TempDir()
+	var total int64
+	for i := 0; i < 20; i++ {
+		writeFiles(t, filepath.Join(dir, fmt.Sprint(i)), testFeed)
+		for _, contents := range testFeed {
+			total += int64(len(contents))
+		}
+	}
+
+	// The callback is never called concurrently, so needn't lock.
+	var last int64
+	calls := 0
+	progress := func(done, n int64) {
+		calls++
+		if n != total {
+			t.Errorf("got a total of %d bytes, want %d", n, total)
+		}
+		if done < last {
+			t.Errorf("progress went backwards from %d to %d", last, done)
+		}
+		last = done
+	}
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{concurrency: 4, progress: progress})
+	for range records {
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 || last != total {
+		t.Errorf("got %d calls finishing at %d bytes, want to finish at %d", calls, last, total)
+	}
+}
+
+func TestNewCSVReaderBOMAndCRLF(t *testing.T) {
+	r := newCSVReader(strings.NewReader("\ufeffstop_id,stop_name\r\nA,Flinders Street\r\n"))
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"stop_id", "stop_name"}, {"A", "Flinders Street"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q, want %q", rows, want)
+	}
+}
+
+func TestConsolidateBOMAndCRLF(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), testFeed)
+	// The same stops exported on Windows, which are duplicates once keyed correctly.
+	writeFiles(t, filepath.Join(in, "2"), map[string]string{
+		"stops.txt": "\ufeff" + strings.Replace(testFeed["stops.txt"], "\n", "\r\n", -1),
+	})
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, filepath.Join(out, "stops.txt")), testFeed["stops.txt"]; got != want {
+		t.Errorf("got stops.txt %q, want %q", got, want)
+	}
+}
+
+func TestWalkPTVDataRecordsArentReused(t *testing.T) {
+	dir := t.TempDir()
+	// Enough rows to span several chunks of copies, with rows of differing lengths so
+	// that a copy running over into its neighbour would show.
+	const feeds, rows = 8, 3 * recordChunkRows
+	for i := 0; i < feeds; i++ {
+		var sb strings.Builder
+		sb.WriteString("trip_id,stop_id,stop_sequence\n")
+		for j := 0; j < rows; j++ {
+			fmt.Fprintf(&sb, "F%dT%d,%s,%d\n", i, j, strings.Repeat("S", j%7+1), j)
+		}
+		writeFiles(t, filepath.Join(dir, fmt.Sprint(i)), map[string]string{"stop_times.txt": sb.String()})
+	}
+
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{concurrency: 4})
+	var recs []GTFSRecord
+	for rec := range records {
+		// Appending to a record mustn't write over the one after it.
+		rec.Contents = append(rec.Contents, "appended")
+		recs = append(recs, rec)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != feeds*rows {
+		t.Fatalf("got %d records, want %d", len(recs), feeds*rows)
+	}
+
+	// Every record is checked only once all have been read, so any reader reusing a
+	// record already sent would have overwritten it by now.
+	seen := make(map[string]bool)
+	for _, rec := range recs {
+		var i, j int
+		if _, err := fmt.Sscanf(rec.Header.value(rec.Contents, "trip_id"), "F%dT%d", &i, &j); err != nil {
+			t.Fatalf("record %q: %v", rec.Contents, err)
+		}
+		want := []string{fmt.Sprintf("F%dT%d", i, j), strings.Repeat("S", j%7+1), fmt.Sprint(j), "appended"}
+		if !reflect.DeepEqual(rec.Contents, want) {
+			t.Fatalf("got record %q, want %q", rec.Contents, want)
+		}
+		seen[want[0]] = true
+	}
+	if len(seen) != feeds*rows {
+		t.Errorf("got %d distinct records, want %d", len(seen), feeds*rows)
+	}
+}
+
+// Returns a stops.txt of n rows, of which those numbered in malformed have a field too
+// few.
+func stopsWithMalformedRows(n int, malformed ...int) string {
+	var sb strings.Builder
+	sb.WriteString("stop_id,stop_name,stop_lat,stop_lon\n")
+	bad := make(map[int]bool)
+	for _, i := range malformed {
+		bad[i] = true
+	}
+	for i := 0; i < n; i++ {
+		if bad[i] {
+			fmt.Fprintf(&sb, "%d,Stop %d,-37.8\n", i, i)
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,Stop %d,-37.8,144.9\n", i, i)
+	}
+	return sb.String()
+}
+
+func TestConsolidateSkipsMalformedRows(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"stops.txt": stopsWithMalformedRows(200, 50)}))
+
+	var logged bytes.Buffer
+	opts := testOptions(dir)
+	opts.Logger = NewLogger(&logged, LevelWarn)
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(in, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	rows := readRows(t, filepath.Join(out, "stops.txt"))
+	if len(rows) != 199 {
+		t.Errorf("stops.txt has %d rows, want 199", len(rows))
+	}
+	for _, row := range rows {
+		if strings.HasPrefix(row, "50,") {
+			t.Errorf("wrote the malformed row %q", row)
+		}
+	}
+	for _, want := range []string{"Skipping malformed row of", "Skipped 1 malformed rows of"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("didn't log %q:\n%s", want, logged.String())
+		}
+	}
+}
+
+func TestConsolidateTooManyMalformedRows(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"stops.txt": stopsWithMalformedRows(100, 10, 20)}))
+
+	err := Consolidate(in, filepath.Join(dir, "out"), testOptions(dir))
+	if err == nil || !strings.Contains(err.Error(), "2 of 100 rows are malformed") {
+		t.Errorf("got error %v, want 2 of 100 rows malformed", err)
+	}
+
+	opts := testOptions(dir)
+	opts.MaxMalformedRows = 0.05
+	if err := Consolidate(in, filepath.Join(dir, "out"), opts); err != nil {
+		t.Errorf("failed with a higher limit: %v", err)
+	}
+}
+
+func TestWalkPTVDataFailsOnFirstMalformedRow(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"stops.txt": stopsWithMalformedRows(1000, 3)})
+
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{maxMalformed: -1})
+	var got int
+	for range records {
+		got++
+	}
+	var parseErr *csv.ParseError
+	if err := <-errc; !errors.As(err, &parseErr) || parseErr.Line != 5 {
+		t.Fatalf("got error %v, want a csv.ParseError on line 5", err)
+	}
+	// Nothing beyond the malformed row is read.
+	if got != 3 {
+		t.Errorf("got %d records, want the 3 before the malformed row", got)
+	}
+}
+
+func TestConsolidateStrictMalformedRow(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{"stops.txt": stopsWithMalformedRows(1000, 3)}))
+
+	opts := testOptions(dir)
+	opts.Strict = true
+	err := Consolidate(in, filepath.Join(dir, "out"), opts)
+	var parseErr *csv.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("got error %v, want a csv.ParseError", err)
+	}
+}
+
+// Returns s compressed with gzip.
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// Returns files with each of gzipped compressed and renamed with a .gz extension.
+func gzippedFiles(t *testing.T, files map[string]string, gzipped ...string) map[string]string {
+	t.Helper()
+	replace := make(map[string]string)
+	for _, name := range gzipped {
+		replace[name] = ""
+		replace[name+".gz"] = gzipString(t, files[name])
+	}
+	return withFiles(files, replace)
+}
+
+func TestFileIsGTFSFileGzipped(t *testing.T) {
+	kinds := []string{"stops", "stop_times"}
+	for name, want := range map[string]bool{
+		"stop_times.txt":    true,
+		"stop_times.txt.gz": true,
+		"stops.txt.gz":      true,
+		"stop_times.gz":     false,
+		"trips.txt.gz":      false,
+		"stop_times.csv.gz": false,
+	} {
+		if got := fileIsGTFSFile(name, kinds); got != want {
+			t.Errorf("fileIsGTFSFile(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestConsolidateGzippedFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "gtfs.zip")
+	writePTVZip(t, input, map[string]map[string]string{
+		"1": gzippedFiles(t, testFeed, "stop_times.txt", "calendar.txt"),
+	})
+
+	out := filepath.Join(dir, "out")
+	if err := Consolidate(input, out, testOptions(dir)); err != nil {
+		t.Fatal(err)
+	}
+	// The gzipped rows are written out uncompressed, as .txt files.
+	want := strings.Split(strings.TrimSpace(testFeed["stop_times.txt"]), "\n")[1:]
+	if got := readRows(t, filepath.Join(out, "stop_times.txt")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got stop times %q, want %q", got, want)
+	}
+	if got := len(readRows(t, filepath.Join(out, "calendar.txt"))); got != 2 {
+		t.Errorf("calendar.txt has %d rows, want 2", got)
+	}
+}
+
+func TestWalkPTVDataGzippedRecordType(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, gzippedFiles(t, testFeed, "stop_times.txt"))
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{concurrency: 1, kinds: []string{"stop_times"}})
+	n := 0
+	for rec := range records {
+		n++
+		if rec.Type != "stop_times" {
+			t.Errorf("got a record of type %q, want stop_times", rec.Type)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Errorf("got %d records, want 7", n)
+	}
+}
+
+func TestCheckGzippedSourceFiles(t *testing.T) {
+	// The source files are also checked before they're consolidated.
+	dir := t.TempDir()
+	writeFiles(t, dir, gzippedFiles(t, expiredFeed(), "stops.txt", "calendar.txt", "calendar_dates.txt"))
+	if last, err := sourceServiceEnd(dir, ','); err != nil || last != "20201231" {
+		t.Errorf("got the last service on %q and error %v, want 20201231", last, err)
+	}
+	if issues := ValidateHeaders(dir); len(issues) != 0 {
+		t.Errorf("got header issues %+v", issues)
+	}
+}
+
+func TestConsolidateCorruptGzippedFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, in, withFiles(testFeed, map[string]string{
+		"stop_times.txt":    "",
+		"stop_times.txt.gz": testFeed["stop_times.txt"],
+	}))
+	err := Consolidate(in, filepath.Join(dir, "out"), testOptions(dir))
+	if err == nil || !strings.Contains(err.Error(), "unable to decompress") {
+		t.Errorf("got error %v, want one that stop_times.txt.gz couldn't be decompressed", err)
+	}
+}
+
+func TestWalkPTVDataRecordRows(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, testFeed)
+	records, errc := walkPTVData(context.Background(), dir, walkOptions{concurrency: 1, kinds: []string{"stop_times"}})
+	var rows []int
+	for rec := range records {
+		rows = append(rows, rec.Row)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 4, 5, 6, 7}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,540 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultKeys lists, for each kind of GTFS file, the columns which together identify
+// a record. Records sharing the same values for these columns are considered to be
+// duplicates of one another when consolidating.
+var DefaultKeys = map[string][]string{
+	"agency":         {"agency_id"},
+	"calendar_dates": {"service_id", "date"},
+	"calendar":       {"service_id"},
+	"routes":         {"route_id"},
+	"stop_times":     {"trip_id", "stop_sequence"},
+	"stops":          {"stop_id"},
+	"trips":          {"trip_id"},
+	"shapes":         {"shape_id", "shape_pt_sequence"},
+	"frequencies":    {"trip_id", "start_time"},
+	"transfers":      {"from_stop_id", "to_stop_id"},
+}
+
+// outputColumns lists the header row written for each kind of GTFS file when the
+// source feed doesn't contain any files of that kind.
+var outputColumns = map[string][]string{
+	"agency":         {"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang"},
+	"calendar_dates": {"service_id", "date", "exception_type"},
+	"calendar":       {"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+	"routes":         {"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color"},
+	"stop_times":     {"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "stop_headsign", "pickup_type", "drop_off_type", "shape_dist_traveled"},
+	"stops":          {"stop_id", "stop_name", "stop_lat", "stop_lon", "wheelchair_boarding"},
+	"trips":          {"route_id", "service_id", "trip_id", "shape_id", "trip_headsign", "direction_id", "wheelchair_accessible"},
+	"shapes":         {"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"},
+	"frequencies":    {"trip_id", "start_time", "end_time", "headway_secs", "exact_times"},
+	"transfers":      {"from_stop_id", "to_stop_id", "transfer_type", "min_transfer_time"},
+}
+
+// conflictCheckedKinds are the kinds of GTFS file whose duplicate records are compared
+// against the record kept, so that conflicting definitions sharing a key are reported
+// rather than silently dropped. They're limited to the smaller files, as the values
+// of every record kept have to be remembered.
+var conflictCheckedKinds = map[string]bool{"agency": true, "calendar": true, "routes": true, "stops": true}
+
+// conflictExampleLimit is the number of conflicts of each kind of GTFS file which are
+// kept to be reported individually; the rest are only counted.
+const conflictExampleLimit = 5
+
+// keySeparator joins the values of a composite key. It's a control character so
+// that it can't appear within any of the values themselves.
+const keySeparator = "\x1f"
+
+// gtfsTable streams the consolidated rows of a single kind of GTFS file to its
+// output as they arrive, remembering only the keys of the records written so far in
+// a set so that duplicates can be found without scanning every earlier record.
+// Each table carries its own lock, as a checkpoint may snapshot it from another
+// goroutine while rows are being added.
+//
+// A sorted table instead holds every row until it's closed, then writes them in order
+// of their keys so that the output doesn't depend on the order the rows arrived in.
+type gtfsTable struct {
+	mu      sync.Mutex
+	columns []string
+	key     []string
+	keys    map[string]struct{}
+	stats   tableStats
+	w       tableWriter
+	derived map[string]func(h Header, row []string) string
+
+	sorted  bool
+	pending map[string]sortedRow
+
+	// kept holds the row written for each key, when checking for conflicts.
+	kept map[string]keptRow
+
+	// limit, if positive, is the most rows the table takes, after which sampled is called.
+	limit   int
+	sampled func()
+}
+
+// keptRow is the row kept for a key of a table checked for conflicts, and the file it
+// came from.
+type keptRow struct {
+	path string
+	row  []string
+}
+
+// rowConflict describes a record which shares its key with the record kept, but
+// differs in its other values. key names the key columns along with their values, and
+// paths gives the files of the kept and dropped records.
+type rowConflict struct {
+	key   string
+	paths [2]string
+}
+
+// sortedRow is a row held by a sorted gtfsTable, along with the values of its key, the
+// file it came from and its position within that file, as given by GTFSRecord.Row.
+type sortedRow struct {
+	key   []string
+	path  string
+	index int
+	row   []string
+}
+
+// tableStats counts the rows written to a gtfsTable and those skipped as duplicates.
+// conflicts counts the duplicates whose values differ from the row kept, the first few
+// of which are described in conflictExamples.
+type tableStats struct {
+	rows             int
+	duplicates       int
+	conflicts        int
+	conflictExamples []rowConflict
+}
+
+// Writes the contents of a GTFSRecord to the table's output unless a record with the
+// same key has already been written. Returns whether the record was written.
+//
+// Values are looked up by column name, as the source file may order its columns
+// differently to the output or omit some of them altogether.
+//
+// A sorted table keeps, of the records sharing a key, the first row of the file whose
+// path sorts first, rather than the first to arrive.
+//
+// If the table checks for conflicts, a duplicate whose values differ from those of the
+// record kept is counted as a conflict.
+//
+// Once a table with a limit has that many rows, any other records are dropped.
+func (t *gtfsTable) add(rec GTFSRecord) (bool, error) {
+	key := recordKey(rec, t.key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sorted {
+		row := t.project(rec)
+		existing, ok := t.pending[key]
+		if ok {
+			t.stats.duplicates++
+			if t.kept != nil && !equalRows(row, existing.row) {
+				t.conflict(key, existing.path, rec.Path)
+			}
+			if rec.Path > existing.path || rec.Path == existing.path && rec.Row >= existing.index {
+				return false, nil
+			}
+		} else {
+			if t.full() {
+				return false, nil
+			}
+			t.stats.rows++
+			t.checkSampled()
+		}
+		t.pending[key] = sortedRow{key: strings.Split(key, keySeparator), path: rec.Path, index: rec.Row, row: row}
+		return true, nil
+	}
+
+	if _, ok := t.keys[key]; ok {
+		t.stats.duplicates++
+		if kept, ok := t.kept[key]; ok && !equalRows(t.project(rec), kept.row) {
+			t.conflict(key, kept.path, rec.Path)
+		}
+		return false, nil
+	}
+	if t.full() {
+		return false, nil
+	}
+
+	row := t.project(rec)
+	if err := t.w.writeRow(row); err != nil {
+		return false, err
+	}
+	t.keys[key] = struct{}{}
+	if t.kept != nil {
+		t.kept[key] = keptRow{path: rec.Path, row: row}
+	}
+	t.stats.rows++
+	t.checkSampled()
+	return true, nil
+}
+
+// Returns whether the table has as many rows as its limit allows.
+func (t *gtfsTable) full() bool {
+	return t.limit > 0 && t.stats.rows >= t.limit
+}
+
+// Calls sampled once the table has as many rows as its limit allows.
+func (t *gtfsTable) checkSampled() {
+	if t.limit > 0 && t.stats.rows == t.limit && t.sampled != nil {
+		t.sampled()
+	}
+}
+
+// Counts a conflict between the record kept for key, from keptPath, and another from
+// path, keeping the first few to be reported.
+func (t *gtfsTable) conflict(key, keptPath, path string) {
+	t.stats.conflicts++
+	if len(t.stats.conflictExamples) >= conflictExampleLimit {
+		return
+	}
+	t.stats.conflictExamples = append(t.stats.conflictExamples, rowConflict{
+		key:   describeKey(t.key, strings.Split(key, keySeparator)),
+		paths: [2]string{keptPath, path},
+	})
+}
+
+// Names each of the key columns along with its value, e.g. "trip_id T1, stop_sequence 2".
+func describeKey(columns, values []string) string {
+	named := make([]string, len(values))
+	for i, v := range values {
+		named[i] = fmt.Sprintf("%s %s", columns[i], v)
+	}
+	return strings.Join(named, ", ")
+}
+
+// Returns whether two rows hold the same values.
+func equalRows(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the values of the table's columns of a record, computing those of any derived
+// columns from the rest of the record.
+func (t *gtfsTable) project(rec GTFSRecord) []string {
+	row := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		if derive, ok := t.derived[column]; ok {
+			row[i] = derive(rec.Header, rec.Contents)
+			continue
+		}
+		row[i] = rec.Header.value(rec.Contents, column)
+	}
+	return row
+}
+
+// Flushes any buffered rows and closes the table's output. A sorted table writes all
+// of its rows now.
+func (t *gtfsTable) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sorted {
+		rows := make([]sortedRow, 0, len(t.pending))
+		for _, r := range t.pending {
+			rows = append(rows, r)
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			return compareKeys(rows[i].key, rows[j].key) < 0
+		})
+		t.pending = nil
+
+		for _, r := range rows {
+			if err := t.w.writeRow(r.row); err != nil {
+				t.w.close()
+				return err
+			}
+		}
+	}
+	return t.w.close()
+}
+
+// Compares two keys value by value, returning a negative number if a sorts before b,
+// a positive number if after, or zero if they're equal. Values which are both whole
+// numbers are compared numerically, so that stop sequence 10 sorts after 9.
+func compareKeys(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.ParseInt(a[i], 10, 64)
+		y, errY := strconv.ParseInt(b[i], 10, 64)
+		if errX == nil && errY == nil && x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a[i], b[i])
+	}
+	return len(a) - len(b)
+}
+
+// Returns the values of the supplied key columns of a record, joined into a single string.
+func recordKey(rec GTFSRecord, columns []string) string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = rec.Header.value(rec.Contents, column)
+	}
+	return strings.Join(values, keySeparator)
+}
+
+// Creates a table for each kind of GTFS file in opts.kinds in the supplied output format,
+// writing the columns given for its kind in opts.columns under the names in
+// opts.headers. Each table is keyed by the columns given for its kind in opts.keys,
+// falling back to DefaultKeys, and sorted if opts.sorted is set.
+//
+// If a table can't be created any tables created so far are closed and the error is
+// returned, unless opts.continueOnError is set. In that case the tables which could be
+// created are returned along with the errors for those which couldn't.
+//
+// The set of tables is fixed up front and never modified afterwards, so the map
+// itself is safe to read from multiple goroutines; writes go through each table's lock.
+func newOutputData(f outputFormat, opts writeOptions) (map[string]*gtfsTable, error) {
+	kinds := opts.kinds
+	if kinds == nil {
+		kinds = validGTFSFileNames
+	}
+	data := make(map[string]*gtfsTable, len(kinds))
+	var errs []error
+	for _, kind := range kinds {
+		key := DefaultKeys[kind]
+		if k, ok := opts.keys[kind]; ok {
+			key = k
+		}
+
+		header := opts.columns[kind]
+		if h, ok := opts.headers[kind]; ok {
+			header = h
+		}
+		w, err := f.table(kind, header)
+		if err != nil && opts.continueOnError {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			for _, t := range data {
+				t.close()
+			}
+			return nil, err
+		}
+		t := &gtfsTable{columns: opts.columns[kind], key: key, w: w, derived: opts.derived[kind], sorted: opts.sorted}
+		if t.sorted {
+			t.pending = make(map[string]sortedRow)
+		} else {
+			t.keys = make(map[string]struct{})
+		}
+		if conflictCheckedKinds[kind] {
+			t.kept = make(map[string]keptRow)
+		}
+		if ct, ok := opts.restored[kind]; ok {
+			t.restore(ct)
+		}
+		if limit := opts.sample.limit(); limit > 0 {
+			sampledKind := kind
+			t.limit = limit
+			t.sampled = func() { opts.sample.fill(sampledKind) }
+		}
+		data[kind] = t
+	}
+	return data, errors.Join(errs...)
+}
+
+// writeOptions controls how writeOutput writes the consolidated feed.
+type writeOptions struct {
+	// kinds lists the kinds of GTFS file to write, or all of validGTFSFileNames if nil.
+	kinds []string
+	// columns gives the columns written for each kind of GTFS file.
+	columns map[string][]string
+	// headers gives the names under which the columns are written, if they differ from
+	// the columns themselves.
+	headers map[string][]string
+	// keys overrides the key columns of the given kinds of GTFS file.
+	keys map[string][]string
+	// keep, if set, restricts the records written to those it keeps.
+	keep *keepSet
+	// expand, if set, replaces the trips run to frequencies with concrete trips.
+	expand *frequencyExpander
+	// continueOnError carries on writing the other tables when one fails.
+	continueOnError bool
+	// workers is the number of tables written at once; values below 1 mean GOMAXPROCS.
+	workers int
+	// sorted writes the rows of each table in order of their keys.
+	sorted bool
+	// derived gives, for each kind of GTFS file, the functions computing the values of
+	// the columns which aren't read from the source.
+	derived map[string]map[string]func(h Header, row []string) string
+	// checkpoint, if set, is told of each record as it's written, and snapshots the
+	// tables when saving a checkpoint.
+	checkpoint *checkpointer
+	// restored gives what had been written of each table by the checkpoint resumed from.
+	restored map[string]checkpointTable
+	// sample, if set, limits each table to its first rows.
+	sample *sampler
+	// patterns, if set, is given every trip and stop time kept, before duplicates are
+	// skipped.
+	patterns *tripPatterns
+}
+
+// Writes each record received from records to the table for its kind in the supplied
+// output format, skipping duplicates and any records not kept by opts.keep. The records
+// kept are first expanded by opts.expand. Rows are written as they arrive rather than
+// being held in memory, by up to opts.workers goroutines at once so that tables of
+// different kinds are written concurrently, while the rows of each table are written by
+// one of them in the order they were received. The channel is always drained, even
+// after an error, so that its producers are never left blocked.
+//
+// If opts.checkpoint is set it's told of every record received, whether written or not,
+// and may snapshot the tables between records to save a checkpoint.
+//
+// Writing stops at the first error, unless continueOnError is set. In that case only
+// the table which failed stops being written to, and every error is returned once the
+// remaining tables have been written in full. Either way, every table and the format
+// itself have been closed by the time writeOutput returns.
+//
+// Returns the number of rows written and duplicates skipped for each kind of GTFS file.
+func writeOutput(records <-chan GTFSRecord, f outputFormat, opts writeOptions) (map[string]tableStats, error) {
+	var errs []error
+	data, err := newOutputData(f, opts)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	workers := opts.workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// errs and failed are shared between the workers.
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	skip := func(kind string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return (len(errs) > 0 && !opts.continueOnError) || failed[kind]
+	}
+
+	write := func(record GTFSRecord) {
+		if skip(record.Type) || !opts.keep.keeps(record) {
+			return
+		}
+		opts.patterns.add(record)
+		table, ok := data[record.Type]
+		if !ok {
+			return
+		}
+		for _, rec := range opts.expand.expand(record) {
+			if _, err := table.add(rec); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				failed[record.Type] = true
+				mu.Unlock()
+				return
+			}
+		}
+	}
+
+	// Each kind of GTFS file is written by a single worker, so that the rows of each
+	// table are written in the order they arrive and the first of any duplicates is the
+	// one kept, however many workers there are.
+	kinds := opts.kinds
+	if kinds == nil {
+		kinds = validGTFSFileNames
+	}
+	if workers > len(kinds) {
+		workers = len(kinds)
+	}
+	owners := make(map[string]int, len(kinds))
+	for i, kind := range kinds {
+		owners[kind] = i % workers
+	}
+
+	opts.checkpoint.start(func() (map[string]checkpointTable, error) {
+		return snapshotTables(data)
+	})
+	var wg sync.WaitGroup
+	queues := make([]chan GTFSRecord, workers)
+	for i := range queues {
+		queues[i] = make(chan GTFSRecord)
+		wg.Add(1)
+		go func(queue <-chan GTFSRecord) {
+			defer wg.Done()
+			for record := range queue {
+				write(record)
+				opts.checkpoint.wrote(record.Path)
+			}
+		}(queues[i])
+	}
+	// Records of kinds which aren't written go to the first worker, to be skipped.
+	for record := range records {
+		queues[owners[record.Type]] <- record
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	stats := make(map[string]tableStats, len(data))
+	for kind, table := range data {
+		if err := table.close(); err != nil {
+			errs = append(errs, err)
+		}
+		stats[kind] = table.stats
+	}
+	if err := f.close(); err != nil {
+		errs = append(errs, err)
+	}
+	return stats, errors.Join(errs...)
+}
+
+// Archives the output files in the directory at path, named with prefix at their start
+// as given by isOutputFile, to <prefix><path>.zip with a, as given by outputSibling,
+// replacing any archive left behind by a previous run. The files are placed at the root of the archive rather than
+// within a directory, as GTFS consumers expect.
+func archiveOutput(path, prefix string, a Archiver) error {
+	archivePath := outputSibling(path, prefix, ".zip")
+	if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && isOutputFile(e.Name(), prefix) {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	return a.Archive(files, archivePath)
+}
+
+// Returns the path of a file written alongside the output directory at path, such as
+// its archive, given the prefix of the files within the directory and the file's
+// extension: <prefix><dir><ext> in the directory's parent, e.g. metro_gtfs_out.zip.
+func outputSibling(path, prefix, ext string) string {
+	return filepath.Join(filepath.Dir(path), prefix+filepath.Base(path)) + ext
+}
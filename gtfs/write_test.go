@@ -0,0 +1,360 @@
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGTFSTableConcurrentAdds(t *testing.T) {
+	header := NewHeader([]string{"trip_id", "stop_sequence"})
+	table := &gtfsTable{
+		columns: []string{"trip_id", "stop_sequence"},
+		key:     DefaultKeys["stop_times"],
+		keys:    make(map[string]struct{}),
+		w:       discardTable{},
+	}
+
+	// Every goroutine adds the same rows, so all but one copy of each is a duplicate.
+	const goroutines, rows = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rows; j++ {
+				rec := GTFSRecord{Type: "stop_times", Header: header, Contents: []string{"T1", fmt.Sprint(j)}}
+				if _, err := table.add(rec); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if table.stats.rows != rows || table.stats.duplicates != (goroutines-1)*rows {
+		t.Errorf("got %d rows and %d duplicates, want %d and %d", table.stats.rows, table.stats.duplicates, rows, (goroutines-1)*rows)
+	}
+}
+
+// rowsTable is a tableWriter holding the rows written to it.
+type rowsTable struct {
+	rows [][]string
+}
+
+func (t *rowsTable) writeRow(row []string) error {
+	t.rows = append(t.rows, row)
+	return nil
+}
+
+func (t *rowsTable) close() error { return nil }
+
+func TestSortedTableKeepsFirstRow(t *testing.T) {
+	header := NewHeader([]string{"stop_id", "stop_name"})
+	record := func(path string, row int, name string) GTFSRecord {
+		return GTFSRecord{Path: path, Type: "stops", Header: header, Contents: []string{"A", name}, Row: row}
+	}
+	tests := []struct {
+		name    string
+		records []GTFSRecord
+		want    string
+	}{
+		{"in order", []GTFSRecord{record("1/stops.txt", 2, "First"), record("1/stops.txt", 5, "Second")}, "First"},
+		{"out of order", []GTFSRecord{record("1/stops.txt", 5, "Second"), record("1/stops.txt", 2, "First")}, "First"},
+		// The file sorting first is kept from, whatever the rows.
+		{"across files", []GTFSRecord{record("2/stops.txt", 1, "Second"), record("1/stops.txt", 9, "First")}, "First"},
+	}
+	for _, tt := range tests {
+		w := &rowsTable{}
+		table := &gtfsTable{
+			columns: []string{"stop_id", "stop_name"},
+			key:     DefaultKeys["stops"],
+			w:       w,
+			sorted:  true,
+			pending: make(map[string]sortedRow),
+		}
+		for _, rec := range tt.records {
+			if _, err := table.add(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := table.close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.rows) != 1 || w.rows[0][1] != tt.want {
+			t.Errorf("%s: wrote %q, want stop A named %s", tt.name, w.rows, tt.want)
+		}
+	}
+}
+
+func TestWriteOutputConcurrentWorkers(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeFiles(t, filepath.Join(in, "1"), testFeed)
+	writeFiles(t, filepath.Join(in, "2"), withFiles(testFeed, map[string]string{
+		"stop_times.txt": testFeed["stop_times.txt"] + "T1,08:20:00,08:20:00,D,4,,0,0,3000\n",
+	}))
+
+	columns, _, err := sourceColumns(in, nil, validGTFSFileNames, ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	format, err := newOutputFormat(FormatCSV, out, "", nil, ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, errc := walkPTVData(context.Background(), in, walkOptions{concurrency: 4})
+	stats, err := writeOutput(records, format, writeOptions{columns: columns, workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, want := range map[string]int{"stops": 4, "trips": 3, "stop_times": 8, "shapes": 4} {
+		if got := len(readRows(t, filepath.Join(out, kind+".txt"))); got != want || stats[kind].rows != want {
+			t.Errorf("%s.txt has %d rows, counted %d, want %d", kind, got, stats[kind].rows, want)
+		}
+	}
+	if got := stats["stop_times"].duplicates; got != 7 {
+		t.Errorf("got %d duplicate stop times, want 7", got)
+	}
+}
+
+// Returns the stop_times.txt of a single trip T1 calling at n stops in turn.
+func longTripStopTimes(n int) string {
+	var sb strings.Builder
+	sb.WriteString(strings.SplitN(testFeed["stop_times.txt"], "\n", 2)[0] + "\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "T1,%s,%s,%s,%d,,0,0,%d\n", FormatGTFSTime(hms(8, 0, i)), FormatGTFSTime(hms(8, 0, i)), []string{"A", "B", "C"}[i%3], i+1, i*10)
+	}
+	return sb.String()
+}
+
+func TestWriteOutputKeepsRowOrder(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	stopTimes := longTripStopTimes(5000)
+	// A later row repeating the key of the first differs in its other values, so
+	// whichever is kept shows in the output.
+	writeFiles(t, in, withFiles(testFeed, map[string]string{
+		"stop_times.txt": stopTimes + "T1,07:00:00,07:00:00,C,1,,0,0,0\n",
+	}))
+	want := strings.Split(strings.TrimSpace(stopTimes), "\n")[1:]
+
+	var first string
+	for run := 0; run < 5; run++ {
+		out := filepath.Join(dir, fmt.Sprint("out", run))
+		opts := testOptions(dir)
+		opts.Concurrency = 8
+		if err := Consolidate(in, out, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := readRows(t, filepath.Join(out, "stop_times.txt")); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d wrote %d stop times out of the order they were read in", run, len(got))
+		}
+		// Every file is identical from one run to the next.
+		var files strings.Builder
+		for _, kind := range validGTFSFileNames {
+			if b, err := ioutil.ReadFile(filepath.Join(out, kind+".txt")); err == nil {
+				files.Write(b)
+			}
+		}
+		if run == 0 {
+			first = files.String()
+		} else if files.String() != first {
+			t.Errorf("run %d wrote different files to the first", run)
+		}
+	}
+}
+
+// Writes a stop_times.txt of n rows, across n/10 trips, to dir.
+func writeSyntheticStopTimes(b *testing.B, dir string, n int) {
+	b.Helper()
+	var sb strings.Builder
+	sb.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "T%d,08:%02d:00,08:%02d:00,S%d,%d\n", i/10, i%60, i%60, i%1000, i%10+1)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		b.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "stop_times.txt"), []byte(sb.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// Measures the allocations made consolidating synthetic feeds of increasing size. As
+// rows are streamed to disk rather than held until the end, the bytes allocated per
+// row stay roughly the same however large the feed grows.
+func BenchmarkWriteOutput(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			dir := b.TempDir()
+			in := filepath.Join(dir, "in")
+			writeSyntheticStopTimes(b, in, n)
+			kinds := []string{"stop_times"}
+			columns, _, err := sourceColumns(in, nil, kinds, ',')
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				format, err := newOutputFormat(FormatCSV, filepath.Join(dir, "out"), "", nil, ',')
+				if err != nil {
+					b.Fatal(err)
+				}
+				records, errc := walkPTVData(context.Background(), in, walkOptions{kinds: kinds})
+				if _, err := writeOutput(records, format, writeOptions{kinds: kinds, columns: columns}); err != nil {
+					b.Fatal(err)
+				}
+				if err := <-errc; err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(n), "rows/op")
+		})
+	}
+}
+
+// Returns whether key is one of keys, as duplicates were once found by scanning every
+// earlier record.
+func linearContains(keys []string, key string) bool {
+	for _, k := range keys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares finding duplicates among the keys of every earlier record by scanning them,
+// which is quadratic in the number of rows, against looking them up in gtfsTable's set.
+func BenchmarkDedupe(b *testing.B) {
+	const n = 100000
+	header := NewHeader([]string{"trip_id", "stop_sequence"})
+	recs := make([]GTFSRecord, n)
+	for i := range recs {
+		recs[i] = GTFSRecord{Type: "stop_times", Header: header, Contents: []string{fmt.Sprintf("T%d", i/10), fmt.Sprint(i%10 + 1)}}
+	}
+
+	b.Run("linear", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var keys []string
+			for _, rec := range recs {
+				key := recordKey(rec, DefaultKeys["stop_times"])
+				if !linearContains(keys, key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+	})
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			table := &gtfsTable{
+				columns: []string{"trip_id", "stop_sequence"},
+				key:     DefaultKeys["stop_times"],
+				keys:    make(map[string]struct{}),
+				w:       discardTable{},
+			}
+			for _, rec := range recs {
+				if _, err := table.add(rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// failingFormat wraps an outputFormat, failing every row written to the table of one
+// kind, and counts the tables closed.
+type failingFormat struct {
+	outputFormat
+	kind   string
+	mu     sync.Mutex
+	closed int
+}
+
+func (f *failingFormat) table(kind string, header []string) (tableWriter, error) {
+	w, err := f.outputFormat.table(kind, header)
+	if err != nil {
+		return nil, err
+	}
+	return &failingTable{tableWriter: w, fail: kind == f.kind, format: f}, nil
+}
+
+type failingTable struct {
+	tableWriter
+	fail   bool
+	format *failingFormat
+}
+
+func (t *failingTable) writeRow(row []string) error {
+	if t.fail {
+		return errors.New("disk full")
+	}
+	return t.tableWriter.writeRow(row)
+}
+
+func (t *failingTable) close() error {
+	t.format.mu.Lock()
+	t.format.closed++
+	t.format.mu.Unlock()
+	return t.tableWriter.close()
+}
+
+func TestWriteOutputSurfacesWriterErrors(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	for i := 0; i < 10; i++ {
+		writeFiles(t, filepath.Join(in, fmt.Sprint(i)), testFeed)
+	}
+	columns, _, err := sourceColumns(in, nil, validGTFSFileNames, ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, continueOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("continue=%t", continueOnError), func(t *testing.T) {
+			out := filepath.Join(dir, fmt.Sprint("out", continueOnError))
+			csv, err := newOutputFormat(FormatCSV, out, "", nil, ',')
+			if err != nil {
+				t.Fatal(err)
+			}
+			format := &failingFormat{outputFormat: csv, kind: "trips"}
+
+			records, errc := walkPTVData(context.Background(), in, walkOptions{concurrency: 4})
+			_, err = writeOutput(records, format, writeOptions{columns: columns, workers: 4, continueOnError: continueOnError})
+			if err == nil || !strings.Contains(err.Error(), "disk full") {
+				t.Errorf("got error %v, want the trips table's", err)
+			}
+			// The walk isn't left blocked sending records nobody receives.
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if format.closed != len(validGTFSFileNames) {
+				t.Errorf("closed %d tables, want %d", format.closed, len(validGTFSFileNames))
+			}
+			if continueOnError {
+				for kind, want := range map[string]int{"stops": 4, "routes": 2, "stop_times": 7, "shapes": 4} {
+					if got := len(readRows(t, filepath.Join(out, kind+".txt"))); got != want {
+						t.Errorf("%s.txt has %d rows, want %d", kind, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,228 +1,583 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/mholt/archiver"
-	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+var defaultOutput = "./gtfs_out"
+
+// stdoutOutput is the -output which streams the consolidated files to stdout.
+const stdoutOutput = "-"
+
+// Build metadata, set at build time with e.g.
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
 )
 
-var looseInputFiles = "./gtfs_in"
-var consolidatedOutputFiles = "./gtfs_out"
-var innerZipFileName = "google_transit.zip"
-var validGTFSFileNames = []string{"agency", "calendar_dates", "calendar", "routes", "stop_times", "stops", "trips", "shapes"}
-
-// GTFSRecord represents a GTFS record which has been read by walking the extracted
-// input zip. The Type property denotes the kind of GTFS file residing at this path,
-// valid values are those in the validGTFSFileNames array.
-type GTFSRecord struct {
-	Path     string
-	Type     string
-	Contents []string
+// config holds the options for a single run of the tool, as parsed from the command line.
+type config struct {
+	inputs            []string
+	url               string
+	downloadTimeout   time.Duration
+	output            string
+	tmp               string
+	keepIntermediate  bool
+	extractRetries    int
+	maxMalformed      float64
+	delimiter         rune
+	outputDelimiter   rune
+	concurrency       int
+	format            string
+	routeTypes        []int
+	agencies          []string
+	excludeRoutes     []string
+	excludeStops      []string
+	accessibleOnly    bool
+	sample            int
+	sampleCoherent    bool
+	checkpoint        time.Duration
+	resume            bool
+	activeOn          time.Time
+	bbox              *gtfs.BoundingBox
+	dryRun            bool
+	continueOnError   bool
+	strict            bool
+	noArchive         bool
+	outputPrefix      string
+	logLevel          gtfs.Level
+	quiet             bool
+	only              []string
+	exclude           []string
+	columns           map[string][]string
+	renames           map[string]map[string]string
+	keys              map[string][]string
+	minRows           map[string]int
+	configPath        string
+	sort              bool
+	routeTypeNames    bool
+	fillRouteColors   bool
+	normalizeTimezone bool
+	expandFrequencies bool
+	walkingTransfers  float64
+	timezone          string
+	showVersion       bool
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Input .zip not provided. Usage: ./prepare-ptv-data <input.zip>")
-		os.Exit(1)
-	}
+// fileConfig is the configuration of a single kind of GTFS file within a -config file.
+type fileConfig struct {
+	// Key lists the columns identifying a record, replacing gtfs.DefaultKeys.
+	Key []string `json:"key"`
+	// Columns lists the columns to output, as with -columns.
+	Columns []string `json:"columns"`
+	// Rename maps columns to the names they're output under, as with -columns-rename.
+	Rename map[string]string `json:"rename"`
+	// MinRows is the fewest rows expected in the output, as with -min-rows.
+	MinRows int `json:"min_rows"`
+}
 
-	inputPath := os.Args[1]
+// Parses the command line arguments (excluding the program name) into a config. Input
+// zips may be supplied with -input or as positional arguments, which for backwards
+// compatibility reproduce the original gtfs_in/gtfs_out layout with the defaults when
+// only one is given.
+func parseFlags(args []string) (config, error) {
+	var cfg config
+
+	fs := flag.NewFlagSet("prepare-ptv-data", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ./prepare-ptv-data [flags] <input.zip> [<input.zip>...]")
+		fmt.Fprintln(fs.Output(), "       ./prepare-ptv-data query-stop [-feed path] <name>")
+		fs.PrintDefaults()
+	}
+	fs.Func("input", "path to a GTFS .zip supplied by PTV, or to a directory one has already been extracted to; may be repeated to merge several feeds", func(s string) error {
+		cfg.inputs = append(cfg.inputs, s)
+		return nil
+	})
+	fs.StringVar(&cfg.url, "url", "", "download the GTFS .zip from this URL instead of reading -input")
+	fs.DurationVar(&cfg.downloadTimeout, "download-timeout", 10*time.Minute, "maximum time to spend downloading -url, or 0 for no limit")
+	fs.StringVar(&cfg.output, "output", defaultOutput, "directory to write the consolidated files to; the archive is written to <output>.zip. Use - to write every file to stdout as a single stream instead, each record prefixed with its file's name (csv) or wrapped in an object naming it (ndjson)")
+	fs.StringVar(&cfg.outputPrefix, "output-prefix", "", "prefix to add to the name of every file written to -output and of its archive, e.g. metro_ gives metro_stops.txt in <dir>/metro_<base>.zip, so that several outputs can share a directory")
+	fs.StringVar(&cfg.tmp, "tmp", ".", "directory to extract the input .zip into")
+	fs.DurationVar(&cfg.checkpoint, "checkpoint-interval", 0, "save progress to <output>.checkpoint this often so that an interrupted run can be continued with -resume, or 0 to never save it")
+	fs.BoolVar(&cfg.resume, "resume", false, "continue from the checkpoint saved by an interrupted run with the same inputs, skipping the files it had written")
+	fs.BoolVar(&cfg.keepIntermediate, "keep-intermediate", false, "don't remove the extracted and consolidated directories when finished")
+	fs.IntVar(&cfg.extractRetries, "extract-retries", 0, "number of times to retry extracting an inner feed after a read error, backing off exponentially")
+	fs.Float64Var(&cfg.maxMalformed, "max-malformed-rows", gtfs.DefaultMaxMalformedRows, "fraction of the rows of a file which may be malformed and skipped before failing, or a negative number to fail on any")
+	fs.Func("delimiter", "character separating the fields of the input files, e.g. ; or \\t for a tab (default ,)", func(s string) error {
+		var err error
+		cfg.delimiter, err = parseDelimiter(s)
+		return err
+	})
+	fs.Func("output-delimiter", "character separating the fields of the csv, csv.gz and flat output, including -output -, e.g. ; or \\t for a tab (default ,)", func(s string) error {
+		var err error
+		cfg.outputDelimiter, err = parseDelimiter(s)
+		return err
+	})
+	fs.StringVar(&cfg.format, "format", gtfs.FormatCSV, "output format: csv, csv.gz, sqlite, geojson, ndjson, protobuf, parquet or flat, a single schedule.csv joining each stop time with its trip, route and stop")
+	fs.Func("route-type", "only output routes of the given route_type, as a comma separated list (e.g. 2 for trains); basic types also match extended ones of the same mode, e.g. 0 matches 900", func(s string) error {
+		types, err := parseIntList(s)
+		cfg.routeTypes = append(cfg.routeTypes, types...)
+		return err
+	})
+	fs.Func("agency", "only output routes run by the given agency_id; may be repeated", func(s string) error {
+		cfg.agencies = append(cfg.agencies, s)
+		return nil
+	})
+	fs.Func("exclude-route", "don't output the route with the given route_id, nor its trips; may be repeated", func(s string) error {
+		cfg.excludeRoutes = append(cfg.excludeRoutes, s)
+		return nil
+	})
+	fs.Func("exclude-stop", "don't output the stop with the given stop_id, nor the stop times at it; may be repeated", func(s string) error {
+		cfg.excludeStops = append(cfg.excludeStops, s)
+		return nil
+	})
+	fs.BoolVar(&cfg.accessibleOnly, "accessible-only", false, "only output wheelchair accessible stops and trips, and the records which depend on them")
+	fs.Func("active-on", "only output trips whose service runs on the given date (YYYY-MM-DD)", func(s string) error {
+		t, err := time.Parse("2006-01-02", s)
+		cfg.activeOn = t
+		return err
+	})
+	fs.Func("bbox", "only output stops within the box minLat,minLon,maxLat,maxLon and the trips serving them", func(s string) error {
+		var err error
+		cfg.bbox, err = parseBoundingBox(s)
+		return err
+	})
+	fs.Func("only", "only read and output the given kinds of file, as a comma separated list (e.g. stops,routes)", func(s string) error {
+		for _, kind := range strings.Split(s, ",") {
+			kind = strings.TrimSpace(kind)
+			if _, ok := gtfs.DefaultKeys[kind]; !ok {
+				return fmt.Errorf("unknown kind of GTFS file %q", kind)
+			}
+			cfg.only = append(cfg.only, kind)
+		}
+		return nil
+	})
+	fs.Func("exclude-file-type", "don't output the given kinds of file, as a comma separated list (e.g. shapes), while outputting the rest", func(s string) error {
+		for _, kind := range strings.Split(s, ",") {
+			kind = strings.TrimSpace(kind)
+			if _, ok := gtfs.DefaultKeys[kind]; !ok {
+				return fmt.Errorf("unknown kind of GTFS file %q", kind)
+			}
+			cfg.exclude = append(cfg.exclude, kind)
+		}
+		return nil
+	})
+	fs.Func("columns", "only output the given columns of a file, as kind:col1,col2 (e.g. stops:stop_id,stop_name); may be repeated", func(s string) error {
+		kind, columns, err := parseColumnList(s)
+		if err != nil {
+			return err
+		}
+		if cfg.columns == nil {
+			cfg.columns = make(map[string][]string)
+		}
+		cfg.columns[kind] = columns
+		return nil
+	})
+	fs.Func("columns-rename", "output columns of a file under different names, as kind:from=to,from=to (e.g. stops:stop_lat=lat,stop_lon=lon); may be repeated", func(s string) error {
+		kind, renames, err := parseRenames(s)
+		if err != nil {
+			return err
+		}
+		if cfg.renames == nil {
+			cfg.renames = make(map[string]map[string]string)
+		}
+		cfg.renames[kind] = renames
+		return nil
+	})
+	fs.Func("min-rows", "fail unless at least n rows are output for a file, as kind:n (e.g. stop_times:1000000); may be repeated", func(s string) error {
+		kind, n, err := parseMinRows(s)
+		if err != nil {
+			return err
+		}
+		if cfg.minRows == nil {
+			cfg.minRows = make(map[string]int)
+		}
+		cfg.minRows[kind] = n
+		return nil
+	})
+	fs.StringVar(&cfg.configPath, "config", "", "JSON file giving the key, columns, rename and min_rows of each kind of file, e.g. {\"stops\": {\"key\": [\"stop_id\"]}}; the equivalent flags take precedence")
+	fs.BoolVar(&cfg.routeTypeNames, "route-type-names", false, "add a route_type_name column to routes, e.g. rail for route_type 2")
+	fs.BoolVar(&cfg.fillRouteColors, "fill-route-colors", false, "fill blank route_color and route_text_color columns with a colour for the route's route_type")
+	fs.BoolVar(&cfg.normalizeTimezone, "timezone-normalize", false, "convert stop times from each agency's agency_timezone to -tz, or to that of the first agency")
+	fs.Func("tz", "IANA timezone to normalize stop times to, e.g. Australia/Melbourne; implies -timezone-normalize", func(s string) error {
+		if _, err := time.LoadLocation(s); err != nil || s == "" {
+			return fmt.Errorf("unknown timezone %q", s)
+		}
+		cfg.timezone = s
+		cfg.normalizeTimezone = true
+		return nil
+	})
+	fs.BoolVar(&cfg.expandFrequencies, "expand-frequencies", false, "replace each trip run to frequencies.txt with a trip for every departure, for consumers which don't understand frequencies")
+	fs.Float64Var(&cfg.walkingTransfers, "walking-transfers", 0, "add a transfer to transfers.txt in each direction between stops within this many meters of one another, or 0 for none")
+	fs.BoolVar(&cfg.sort, "sort", false, "write the rows of each file in key order so that identical feeds produce identical output; holds every row in memory")
+	fs.BoolVar(&cfg.noArchive, "no-archive", false, "leave the consolidated files in -output rather than archiving them to <output>.zip")
+	fs.IntVar(&cfg.sample, "sample", 0, "only output the first n rows of each file, for quickly testing a pipeline, or 0 for every row")
+	fs.BoolVar(&cfg.sampleCoherent, "sample-coherent", false, "with -sample, output the first n trips by trip_id and the records they depend on rather than the first n rows of each file")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "report the rows and duplicates found for each file without writing any output")
+	fs.BoolVar(&cfg.continueOnError, "continue-on-write-error", false, "keep writing the other files when one of them fails to be written")
+	fs.BoolVar(&cfg.strict, "strict", false, "exit with an error if there are any problems with the feed, such as missing optional files or expired services, rather than only warning of them")
+	cfg.logLevel = gtfs.LevelInfo
+	fs.Func("log-level", "minimum level of message to log: debug, info, warn or error (default info)", func(s string) error {
+		var err error
+		cfg.logLevel, err = gtfs.ParseLevel(s)
+		return err
+	})
+	fs.BoolVar(&cfg.quiet, "quiet", false, "only log errors")
+	fs.IntVar(&cfg.concurrency, "concurrency", 0, "maximum number of inner zips to extract, GTFS files to read, and tables to write, at once (default GOMAXPROCS)")
+	fs.BoolVar(&cfg.showVersion, "version", false, "print the version and build details, then exit")
 
-	err := extractPTVData(inputPath)
-	if err != nil {
-		log.Fatal(err)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if cfg.showVersion {
+		return cfg, nil
 	}
 
-	var outputData = map[string][][]string{
-		"agency":         [][]string{{"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang"}},
-		"calendar_dates": [][]string{{"service_id", "date", "exception_type"}},
-		"calendar":       [][]string{{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"}},
-		"routes":         [][]string{{"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color"}},
-		"stop_times":     [][]string{{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "stop_headsign", "pickup_type", "drop_off_type", "shape_dist_traveled"}},
-		"stops":          [][]string{{"stop_id", "stop_name", "stop_lat", "stop_lon"}},
-		"trips":          [][]string{{"route_id", "service_id", "trip_id", "shape_id", "trip_headsign", "direction_id"}},
-		"shapes":         [][]string{{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}},
+	cfg.inputs = append(cfg.inputs, fs.Args()...)
+	if len(cfg.inputs) == 0 && cfg.url == "" {
+		fs.Usage()
+		return cfg, errors.New("input .zip not provided")
+	}
+	if len(cfg.inputs) > 0 && cfg.url != "" {
+		return cfg, errors.New("only one of an input .zip and -url may be provided")
+	}
+	if cfg.sample < 0 {
+		return cfg, fmt.Errorf("-sample must not be negative, not %d", cfg.sample)
+	}
+	if cfg.walkingTransfers < 0 {
+		return cfg, fmt.Errorf("-walking-transfers must not be negative, not %g", cfg.walkingTransfers)
+	}
+	if cfg.sampleCoherent && cfg.sample == 0 {
+		return cfg, errors.New("-sample-coherent requires -sample")
 	}
 
-	for record := range walkPTVData(looseInputFiles) {
-		if !isGTFSRecordExisting(record, outputData[record.Type]) {
-			outputData[record.Type] = append(outputData[record.Type], record.Contents)
+	if cfg.configPath != "" {
+		if err := applyConfigFile(&cfg, cfg.configPath); err != nil {
+			return cfg, err
 		}
 	}
 
-	writeOutput(outputData, consolidatedOutputFiles, "txt")
+	if cfg.output == stdoutOutput {
+		if cfg.format != gtfs.FormatCSV && cfg.format != gtfs.FormatNDJSON {
+			return cfg, fmt.Errorf("only the csv and ndjson formats can be written to stdout, not %s", cfg.format)
+		}
+		if cfg.outputPrefix != "" {
+			return cfg, errors.New("-output-prefix can't be used when writing to stdout")
+		}
+		return cfg, nil
+	}
+	cfg.output = filepath.Clean(cfg.output)
+	return cfg, nil
+}
 
-	cleanup()
+// Parses the value of -delimiter or -output-delimiter, which must be a single character.
+// As a tab is awkward to type on the command line it may also be given as \t.
+func parseDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if s == "" || size != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q: must be a single character other than a quote or line break", s)
+	}
+	return r, nil
 }
 
-// Removes the temporary directories (gtfs_in and gtfs_out) created when
-// the original files were extracted and the consolidated output was produced.
-func cleanup() {
-	err := os.RemoveAll(looseInputFiles)
+// Reads the JSON config file at path, which maps kinds of GTFS file to a fileConfig,
+// into cfg. Settings already given for a kind by the equivalent flags are left as
+// they are.
+func applyConfigFile(cfg *config, path string) error {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Printf("Error when deleting extracted input files: %s\n", err.Error())
+		return err
+	}
+	var files map[string]fileConfig
+	if err := json.Unmarshal(b, &files); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", path, err)
 	}
 
-	err = os.RemoveAll(consolidatedOutputFiles)
-	if err != nil {
-		log.Printf("Error when deleting consolidated output files: %s\n", err.Error())
+	for kind, fc := range files {
+		if _, ok := gtfs.DefaultKeys[kind]; !ok {
+			return fmt.Errorf("%s: unknown kind of GTFS file %q", path, kind)
+		}
+		if len(fc.Key) > 0 {
+			if cfg.keys == nil {
+				cfg.keys = make(map[string][]string)
+			}
+			cfg.keys[kind] = fc.Key
+		}
+		if _, ok := cfg.columns[kind]; len(fc.Columns) > 0 && !ok {
+			if cfg.columns == nil {
+				cfg.columns = make(map[string][]string)
+			}
+			cfg.columns[kind] = fc.Columns
+		}
+		if _, ok := cfg.renames[kind]; len(fc.Rename) > 0 && !ok {
+			if cfg.renames == nil {
+				cfg.renames = make(map[string]map[string]string)
+			}
+			cfg.renames[kind] = fc.Rename
+		}
+		if _, ok := cfg.minRows[kind]; fc.MinRows > 0 && !ok {
+			if cfg.minRows == nil {
+				cfg.minRows = make(map[string]int)
+			}
+			cfg.minRows[kind] = fc.MinRows
+		}
 	}
+	return nil
 }
 
-// Writes each 2D string slice in the supplied map to its own CSV file, where
-// the name of the file is the key of the map.
-func writeOutput(data map[string][][]string, path string, ext string) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		os.MkdirAll(path, os.ModePerm)
+// Parses a comma separated list of integers, such as "2,3".
+func parseIntList(s string) ([]int, error) {
+	var ints []int
+	for _, field := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", field)
+		}
+		ints = append(ints, i)
 	}
+	return ints, nil
+}
 
-	for k, v := range data {
-		writeCSV(v, fmt.Sprintf("%s/%s.%s", path, k, ext))
+// Parses a list of columns for a kind of GTFS file, of the form kind:col1,col2.
+func parseColumnList(s string) (string, []string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, fmt.Errorf("column list %q must be kind:col1,col2", s)
 	}
 
-	archiver.Archive([]string{path}, fmt.Sprintf("%s.zip", path))
+	var columns []string
+	for _, column := range strings.Split(parts[1], ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return parts[0], columns, nil
 }
 
-// Writes a 2D slice of strings to a CSV file.
-func writeCSV(data [][]string, path string) {
-	file, err := os.Create(path)
-
-	if err != nil {
-		log.Fatalf("Unable to create output file %s: %s\n", path, err.Error())
+// Parses a list of column renames for a kind of GTFS file, of the form kind:from=to,from=to.
+func parseRenames(s string) (string, map[string]string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, fmt.Errorf("column renames %q must be kind:from=to,from=to", s)
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	for _, value := range data {
-		err := writer.Write(value)
-		if err != nil {
-			log.Fatalf("Unable to write row to file: %s\n", err.Error())
+	renames := make(map[string]string)
+	for _, pair := range strings.Split(parts[1], ",") {
+		names := strings.SplitN(pair, "=", 2)
+		if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+			return "", nil, fmt.Errorf("column rename %q must be from=to", pair)
 		}
+		renames[strings.TrimSpace(names[0])] = strings.TrimSpace(names[1])
 	}
+	return parts[0], renames, nil
 }
 
-// Returns whether a supplied GTFSRecord exists in a target array.
-func isGTFSRecordExisting(rec GTFSRecord, targetArrays [][]string) bool {
-	for _, arr := range targetArrays {
-		if rec.Contents[0] == arr[0] {
-			return true
-		}
+// Parses a minimum number of rows for a kind of GTFS file, of the form kind:n.
+func parseMinRows(s string) (string, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, fmt.Errorf("minimum rows %q must be kind:n", s)
 	}
-
-	return false
+	n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || n < 0 {
+		return "", 0, fmt.Errorf("invalid number of rows %q", parts[1])
+	}
+	return parts[0], n, nil
 }
 
-// Returns whether a given filename is likely a GTFS file, i.e. if its name
-// matches one of the values in validGTFSFileNames.
-func fileIsGTFSFile(fileName string) bool {
-	for _, str := range validGTFSFileNames {
-		if fileName == fmt.Sprintf("%s.txt", str) {
-			return true
+// Parses a bounding box of the form minLat,minLon,maxLat,maxLon.
+func parseBoundingBox(s string) (*gtfs.BoundingBox, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("bounding box %q must be minLat,minLon,maxLat,maxLon", s)
+	}
+
+	var coords [4]float64
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate %q", field)
 		}
+		coords[i] = f
 	}
 
-	return false
+	b := &gtfs.BoundingBox{MinLat: coords[0], MinLon: coords[1], MaxLat: coords[2], MaxLon: coords[3]}
+	if b.MinLat > b.MaxLat || b.MinLon > b.MaxLon {
+		return nil, fmt.Errorf("bounding box %q has its minimum above its maximum", s)
+	}
+	return b, nil
 }
 
-// Walks the fully extracted PTV GTFS zip and outputs each row of each GTFS CSV through a goroutine
-// channel. Each row is wrapped in a GTFSRecord struct which contains the path of the parent file,
-// the kind of file (stop_times, routes etc.), and the string slice of CSV data itself.
-func walkPTVData(path string) chan GTFSRecord {
-	c := make(chan GTFSRecord)
-	var wg sync.WaitGroup
-
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Fatalf("Failure to access path %s: %s\n", path, err.Error())
+func main() {
+	if len(os.Args) > 1 {
+		if sub, ok := subcommands[os.Args[1]]; ok {
+			if err := sub(os.Args[2:], os.Stdout); err != nil && err != flag.ErrHelp {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
 		}
+	}
 
-		// Check if we've arrived at a GTFS txt file.
-		if !info.IsDir() && fileIsGTFSFile(info.Name()) {
-			// Add a task to the waitgroup and fire off a goroutine.
-			wg.Add(1)
-			go func() {
-				file, err := os.Open(path)
-				if err != nil {
-					log.Fatalf("Unable to open %s: %s\n", path, err.Error())
-				}
-
-				csvFile := csv.NewReader(file)
-				// Skip the header row.
-				csvFile.Read()
-				// Iterate through the records of the current file.
-				for {
-					record, err := csvFile.Read()
+	cfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.showVersion {
+		fmt.Println(versionString())
+		return
+	}
 
-					if err == io.EOF {
-						break
-					}
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
 
-					if err != nil {
-						log.Fatal(err)
-					}
+// Runs the tool with the supplied config.
+func run(cfg config) error {
+	level := cfg.logLevel
+	if cfg.quiet {
+		level = gtfs.LevelError
+	}
+	logger := gtfs.NewLogger(os.Stderr, level)
 
-					recordType := strings.Split(info.Name(), ".")[0]
-					c <- GTFSRecord{Path: path, Type: recordType, Contents: record}
-				}
-				wg.Done()
-			}()
+	if cfg.url != "" {
+		path, err := gtfs.Download(cfg.url, cfg.tmp, cfg.downloadTimeout, logger)
+		if err != nil {
+			return err
 		}
+		defer os.Remove(path)
+		cfg.inputs = []string{path}
+	}
 
-		return err
-	})
+	// The consolidated files are written to stdout in place of -output.
+	var sink gtfs.Sink
+	if cfg.output == stdoutOutput {
+		if cfg.format == gtfs.FormatNDJSON {
+			sink = gtfs.NewNDJSONSink(os.Stdout)
+		} else {
+			sink = gtfs.NewDelimitedStreamSink(os.Stdout, cfg.outputDelimiter)
+		}
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	var progress func(done, total int64)
+	if !cfg.quiet {
+		progress = progressReporter(logger, sink == nil && isTerminal(os.Stdout))
 	}
 
-	// Close the channel after all records from all files have been read.
-	go func() {
-		wg.Wait()
-		close(c)
-	}()
+	// Stop consolidating on Ctrl-C, so that any downloaded feed is still removed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	filter := gtfs.Filter{
+		RouteTypes:      cfg.routeTypes,
+		AgencyIDs:       cfg.agencies,
+		ActiveOn:        cfg.activeOn,
+		BBox:            cfg.bbox,
+		ExcludeRouteIDs: cfg.excludeRoutes,
+		ExcludeStopIDs:  cfg.excludeStops,
+		AccessibleOnly:  cfg.accessibleOnly,
+	}
+	sample := cfg.sample
+	if cfg.sampleCoherent {
+		filter.SampleTrips = sample
+		sample = 0
+	}
 
-	return c
+	return gtfs.ConsolidateFeeds(ctx, cfg.inputs, cfg.output, gtfs.Options{
+		TmpDir:               cfg.tmp,
+		KeepIntermediate:     cfg.keepIntermediate,
+		ExtractRetries:       cfg.extractRetries,
+		MaxMalformedRows:     cfg.maxMalformed,
+		Delimiter:            cfg.delimiter,
+		OutputDelimiter:      cfg.outputDelimiter,
+		Concurrency:          cfg.concurrency,
+		Format:               cfg.format,
+		Sink:                 sink,
+		SkipArchive:          cfg.noArchive,
+		FilePrefix:           cfg.outputPrefix,
+		Only:                 cfg.only,
+		Exclude:              cfg.exclude,
+		Keys:                 cfg.keys,
+		Columns:              cfg.columns,
+		RenameColumns:        cfg.renames,
+		MinRows:              cfg.minRows,
+		CheckpointInterval:   cfg.checkpoint,
+		Resume:               cfg.resume,
+		Sort:                 cfg.sort,
+		AddRouteTypeNames:    cfg.routeTypeNames,
+		FillRouteColors:      cfg.fillRouteColors,
+		NormalizeTimezone:    cfg.normalizeTimezone,
+		Timezone:             cfg.timezone,
+		ExpandFrequencies:    cfg.expandFrequencies,
+		WalkingTransfers:     cfg.walkingTransfers,
+		Build:                &gtfs.BuildInfo{Version: version, Commit: commit, Date: date},
+		DryRun:               cfg.dryRun,
+		ContinueOnWriteError: cfg.continueOnError,
+		Strict:               cfg.strict,
+		Logger:               logger,
+		Progress:             progress,
+		Filter:               filter,
+		Sample:               sample,
+	})
 }
 
-// Extracts the .zip of the GTFS data supplied by PTV into a temporary directory, including
-// subdirectories (1, 2, 3 etc.).
-func extractPTVData(path string) error {
-	log.Printf("Extracting %s...\n", path)
-	// Extract the input zip.
-	err := archiver.Unarchive(path, looseInputFiles)
-	if err != nil {
-		return err
-	}
-	log.Printf("Extracted %s. Walking...\n", path)
+// Returns the version and build details of the tool, as printed by -version.
+func versionString() string {
+	return fmt.Sprintf("prepare-ptv-data %s (commit %s, built %s)", version, commit, date)
+}
 
-	// Walk the contents of the extracted input zip, and extract any inner zip files found.
-	err = filepath.Walk(looseInputFiles, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Fatalf("Failure to access path %s: %s\n", path, err.Error())
+// Returns a callback for gtfs.Options.Progress which reports the percentage of the feed
+// consolidated so far. If tty is set, i.e. stdout is a terminal free to be drawn on,
+// the percentage is redrawn in place; otherwise it's logged every 10%.
+func progressReporter(logger gtfs.Logger, tty bool) func(done, total int64) {
+	last := -1
+	return func(done, total int64) {
+		if total == 0 {
+			return
+		}
+		percent := int(done * 100 / total)
+		if percent == last {
+			return
 		}
 
-		// Check if we've hit an inner zip file.
-		if info.Name() == innerZipFileName {
-			// Extract zip to a directory of the same name in the same path.
-			innerOutputPath := strings.Replace(path, ".zip", "", 1)
-
-			log.Printf("Found %s file in path %s\n", innerZipFileName, path)
-			err := archiver.Unarchive(path, innerOutputPath)
-			if err != nil {
-				log.Fatalf("Unable to unzip %s: %s\n", path, err.Error())
+		if tty {
+			fmt.Printf("\rConsolidating... %3d%%", percent)
+			if percent == 100 {
+				fmt.Println()
 			}
-			log.Printf("Extracted %s\n", path)
+		} else if percent/10 > last/10 || last < 0 {
+			logger.Infof("Consolidated %d%%", percent)
 		}
+		last = percent
+	}
+}
 
-		return nil
-	})
-	return err
+// Returns whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
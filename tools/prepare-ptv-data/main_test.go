@@ -0,0 +1,614 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-input", "in.zip", "-output", "/data/out/", "-tmp", "/mnt/tmpfs", "-keep-intermediate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.inputs, []string{"in.zip"}) {
+		t.Errorf("got inputs %v, want [in.zip]", cfg.inputs)
+	}
+	if cfg.output != "/data/out" {
+		t.Errorf("got output %q, want /data/out", cfg.output)
+	}
+	if cfg.tmp != "/mnt/tmpfs" {
+		t.Errorf("got tmp %q, want /mnt/tmpfs", cfg.tmp)
+	}
+	if !cfg.keepIntermediate {
+		t.Error("-keep-intermediate wasn't set")
+	}
+}
+
+func TestParseFlagsPositionalInput(t *testing.T) {
+	// A lone positional argument keeps the original gtfs_in/gtfs_out layout.
+	cfg, err := parseFlags([]string{"gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.inputs, []string{"gtfs.zip"}) {
+		t.Errorf("got inputs %v, want [gtfs.zip]", cfg.inputs)
+	}
+	if cfg.output != "gtfs_out" {
+		t.Errorf("got output %q, want gtfs_out", cfg.output)
+	}
+	if cfg.tmp != "." {
+		t.Errorf("got tmp %q, want .", cfg.tmp)
+	}
+	if cfg.keepIntermediate {
+		t.Error("-keep-intermediate was set by default")
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-keep-intermediate"},
+		{"-url", "https://example.com/gtfs.zip", "gtfs.zip"},
+		{"-unknown", "gtfs.zip"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("parseFlags(%q) didn't return an error", args)
+		}
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	b, err := parseBoundingBox("-37.9, 144.9,-37.7,145.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (gtfs.BoundingBox{MinLat: -37.9, MinLon: 144.9, MaxLat: -37.7, MaxLon: 145.1}); *b != want {
+		t.Errorf("got %+v, want %+v", *b, want)
+	}
+	for _, s := range []string{"-37.9,144.9,-37.7", "-37.9,144.9,-37.7,east", "-37.7,144.9,-37.9,145.1"} {
+		if _, err := parseBoundingBox(s); err == nil {
+			t.Errorf("parseBoundingBox(%q) didn't return an error", s)
+		}
+	}
+}
+
+func TestParseFlagsLogLevel(t *testing.T) {
+	cfg, err := parseFlags([]string{"gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.logLevel != gtfs.LevelInfo || cfg.quiet {
+		t.Errorf("got log level %s and quiet %t, want info and false", cfg.logLevel, cfg.quiet)
+	}
+
+	cfg, err = parseFlags([]string{"-log-level", "debug", "-quiet", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.logLevel != gtfs.LevelDebug || !cfg.quiet {
+		t.Errorf("got log level %s and quiet %t, want debug and true", cfg.logLevel, cfg.quiet)
+	}
+
+	if _, err := parseFlags([]string{"-log-level", "verbose", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted an unknown log level")
+	}
+}
+
+func TestParseColumnList(t *testing.T) {
+	kind, columns, err := parseColumnList("stops:stop_id, stop_name,wheelchair_boarding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"stop_id", "stop_name", "wheelchair_boarding"}; kind != "stops" || !reflect.DeepEqual(columns, want) {
+		t.Errorf("got %s %v, want stops %v", kind, columns, want)
+	}
+	for _, s := range []string{"stops", "stops:", ":stop_id"} {
+		if _, _, err := parseColumnList(s); err == nil {
+			t.Errorf("parseColumnList(%q) didn't return an error", s)
+		}
+	}
+}
+
+func TestProgressReporterLogsEveryTenPercent(t *testing.T) {
+	var buf bytes.Buffer
+	report := progressReporter(gtfs.NewLogger(&buf, gtfs.LevelInfo), false)
+	for done := int64(0); done <= 1000; done += 7 {
+		report(done, 1000)
+	}
+	report(1000, 1000)
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		got = append(got, line[strings.Index(line, "Consolidated"):])
+	}
+	var want []string
+	for percent := 0; percent <= 100; percent += 10 {
+		want = append(want, fmt.Sprintf("Consolidated %d%%", percent))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseFlagsConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"stops": {"key": ["stop_id", "parent_station"], "columns": ["stop_id", "parent_station"]},
+		"trips": {"columns": ["trip_id"], "min_rows": 10}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -columns takes precedence over the columns of the config file.
+	cfg, err := parseFlags([]string{"-config", path, "-columns", "trips:trip_id,route_id", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"stops": {"stop_id", "parent_station"}}; !reflect.DeepEqual(cfg.keys, want) {
+		t.Errorf("got keys %v, want %v", cfg.keys, want)
+	}
+	want := map[string][]string{"stops": {"stop_id", "parent_station"}, "trips": {"trip_id", "route_id"}}
+	if !reflect.DeepEqual(cfg.columns, want) {
+		t.Errorf("got columns %v, want %v", cfg.columns, want)
+	}
+	if cfg.minRows["trips"] != 10 {
+		t.Errorf("got min rows %v, want 10 trips", cfg.minRows)
+	}
+}
+
+func TestParseFlagsBadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"unknown.json": `{"stations": {"key": ["station_id"]}}`,
+		"invalid.json": `{"stops": `,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseFlags([]string{"-config", path, "gtfs.zip"}); err == nil {
+			t.Errorf("parseFlags accepted %s", name)
+		}
+	}
+	if _, err := parseFlags([]string{"-config", filepath.Join(dir, "missing.json"), "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted a config file which doesn't exist")
+	}
+}
+
+// Runs main with the arguments after "--" when the test binary is re-executed by
+// runMain, as main exits the process.
+func TestMainProcess(t *testing.T) {
+	if os.Getenv("PREPARE_PTV_DATA_MAIN") != "1" {
+		t.Skip("only run by runMain")
+	}
+	version, commit, date = "v1.2.3", "abc123", "2024-06-01T00:00:00Z"
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"prepare-ptv-data"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+// Runs the tool in dir with args, returning its combined output.
+func runMain(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PREPARE_PTV_DATA_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestVersion(t *testing.T) {
+	dir := t.TempDir()
+	out, err := runMain(t, dir, "-version", "gtfs.zip")
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if want := "prepare-ptv-data v1.2.3 (commit abc123, built 2024-06-01T00:00:00Z)\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	// Nothing is consolidated, so nothing is written.
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+		t.Errorf("left %d files in the working directory", len(infos))
+	}
+}
+
+func TestParseFlagsMinRows(t *testing.T) {
+	cfg, err := parseFlags([]string{"-min-rows", "stop_times:1000000", "-min-rows", "stops: 10", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"stop_times": 1000000, "stops": 10}; !reflect.DeepEqual(cfg.minRows, want) {
+		t.Errorf("got %v, want %v", cfg.minRows, want)
+	}
+	for _, s := range []string{"stop_times", ":10", "stop_times:many", "stop_times:-1"} {
+		if _, err := parseFlags([]string{"-min-rows", s, "gtfs.zip"}); err == nil {
+			t.Errorf("parseFlags accepted -min-rows %q", s)
+		}
+	}
+}
+
+func TestParseFlagsSeveralInputs(t *testing.T) {
+	for _, args := range [][]string{
+		{"-input", "metro.zip", "-input", "regional.zip", "-output", "out"},
+		{"-output", "out", "metro.zip", "regional.zip"},
+	} {
+		cfg, err := parseFlags(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"metro.zip", "regional.zip"}; !reflect.DeepEqual(cfg.inputs, want) {
+			t.Errorf("parseFlags(%q) gave inputs %v, want %v", args, cfg.inputs, want)
+		}
+	}
+}
+
+func TestParseFlagsColumnsRename(t *testing.T) {
+	cfg, err := parseFlags([]string{"-columns-rename", "stops:stop_lat=lat, stop_lon = lon", "-columns-rename", "routes:route_color=colour", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"stops":  {"stop_lat": "lat", "stop_lon": "lon"},
+		"routes": {"route_color": "colour"},
+	}
+	if !reflect.DeepEqual(cfg.renames, want) {
+		t.Errorf("got renames %v, want %v", cfg.renames, want)
+	}
+	for _, s := range []string{"stops", "stops:", ":stop_lat=lat", "stops:stop_lat", "stops:stop_lat=", "stops:=lat"} {
+		if _, err := parseFlags([]string{"-columns-rename", s, "gtfs.zip"}); err == nil {
+			t.Errorf("parseFlags accepted -columns-rename %q", s)
+		}
+	}
+}
+
+func TestParseFlagsExclusions(t *testing.T) {
+	cfg, err := parseFlags([]string{"-exclude-route", "R9", "-exclude-stop", "S1", "-exclude-route", "R10", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"R9", "R10"}; !reflect.DeepEqual(cfg.excludeRoutes, want) {
+		t.Errorf("got excluded routes %v, want %v", cfg.excludeRoutes, want)
+	}
+	if want := []string{"S1"}; !reflect.DeepEqual(cfg.excludeStops, want) {
+		t.Errorf("got excluded stops %v, want %v", cfg.excludeStops, want)
+	}
+}
+
+func TestParseFlagsTimezone(t *testing.T) {
+	cfg, err := parseFlags([]string{"-tz", "Australia/Perth", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.timezone != "Australia/Perth" || !cfg.normalizeTimezone {
+		t.Errorf("got timezone %q and normalize %t, want Australia/Perth and true", cfg.timezone, cfg.normalizeTimezone)
+	}
+	for _, tz := range []string{"", "Australia/Gotham"} {
+		if _, err := parseFlags([]string{"-tz", tz, "gtfs.zip"}); err == nil {
+			t.Errorf("parseFlags accepted -tz %q", tz)
+		}
+	}
+}
+
+func TestParseFlagsAccessibleOnly(t *testing.T) {
+	cfg, err := parseFlags([]string{"-accessible-only", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.accessibleOnly {
+		t.Error("-accessible-only wasn't set")
+	}
+}
+
+func TestParseFlagsCheckpoint(t *testing.T) {
+	cfg, err := parseFlags([]string{"-checkpoint-interval", "30s", "-resume", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.checkpoint != 30*time.Second || !cfg.resume {
+		t.Errorf("got checkpoint interval %s and resume %t, want 30s and true", cfg.checkpoint, cfg.resume)
+	}
+}
+
+func TestParseFlagsOnly(t *testing.T) {
+	cfg, err := parseFlags([]string{"-only", "stops, routes", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"stops", "routes"}; !reflect.DeepEqual(cfg.only, want) {
+		t.Errorf("got only %v, want %v", cfg.only, want)
+	}
+	if _, err := parseFlags([]string{"-only", "stops,stations", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted an unknown kind of file")
+	}
+}
+
+func TestParseFlagsExcludeFileType(t *testing.T) {
+	cfg, err := parseFlags([]string{"-exclude-file-type", "shapes, frequencies", "-exclude-file-type", "transfers", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"shapes", "frequencies", "transfers"}; !reflect.DeepEqual(cfg.exclude, want) {
+		t.Errorf("got exclude %v, want %v", cfg.exclude, want)
+	}
+	if _, err := parseFlags([]string{"-exclude-file-type", "shapes,stations", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted an unknown kind of file")
+	}
+}
+
+func TestParseFlagsDelimiter(t *testing.T) {
+	cfg, err := parseFlags([]string{"-delimiter", ";", "-output-delimiter", `\t`, "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.delimiter != ';' || cfg.outputDelimiter != '\t' {
+		t.Errorf("got delimiters %q and %q, want ';' and a tab", cfg.delimiter, cfg.outputDelimiter)
+	}
+	for _, d := range []string{"", ";;", `"`, "\n", "\xff"} {
+		if _, err := parseFlags([]string{"-delimiter", d, "gtfs.zip"}); err == nil {
+			t.Errorf("parseFlags accepted -delimiter %q", d)
+		}
+	}
+}
+
+func TestParseFlagsExpandFrequencies(t *testing.T) {
+	cfg, err := parseFlags([]string{"-expand-frequencies", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.expandFrequencies {
+		t.Error("-expand-frequencies wasn't set")
+	}
+}
+
+func TestParseFlagsSample(t *testing.T) {
+	cfg, err := parseFlags([]string{"-sample", "100", "-sample-coherent", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.sample != 100 || !cfg.sampleCoherent {
+		t.Errorf("got sample %d, coherent %t, want 100 and true", cfg.sample, cfg.sampleCoherent)
+	}
+	for _, args := range [][]string{
+		{"-sample", "-1", "gtfs.zip"},
+		{"-sample-coherent", "gtfs.zip"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("parseFlags accepted %q", args)
+		}
+	}
+}
+
+func TestParseFlagsFillRouteColors(t *testing.T) {
+	cfg, err := parseFlags([]string{"-fill-route-colors", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.fillRouteColors {
+		t.Error("-fill-route-colors wasn't set")
+	}
+}
+
+// Writes a zip laid out as PTV supplies its feeds to path, holding a single small feed.
+func writeFeedZip(t *testing.T, path string) {
+	t.Helper()
+	zipOf := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for name, contents := range files {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(f, contents); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	inner := zipOf(map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nA,\"Flinders St, Stop 1\",-37.8183,144.9671\nB,Southern Cross,-37.8184,144.9525\n",
+		"routes.txt":     "route_id,route_short_name,route_type\nR1,Sandringham,2\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR1,S1,T1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,A,1\nT1,08:05:00,08:05:00,B,2\n",
+	})
+	if err := ioutil.WriteFile(path, zipOf(map[string]string{"1/google_transit.zip": string(inner)}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Runs the tool in dir with args, returning what it writes to stdout and to stderr.
+func runMainStdout(t *testing.T, dir string, args ...string) (string, string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PREPARE_PTV_DATA_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestOutputToStdout(t *testing.T) {
+	dir := t.TempDir()
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+
+	stdout, stderr, err := runMainStdout(t, dir, "-output", "-", "gtfs.zip")
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	r := csv.NewReader(strings.NewReader(stdout))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("stdout isn't a CSV stream: %v\n%s", err, stdout)
+	}
+	files := make(map[string][][]string)
+	for _, rec := range records {
+		files[rec[0]] = append(files[rec[0]], rec[1:])
+	}
+	for name, rows := range map[string]int{"stops.txt": 2, "routes.txt": 1, "trips.txt": 1, "stop_times.txt": 2} {
+		if got := len(files[name]) - 1; got != rows {
+			t.Errorf("got %d rows of %s, want %d: %q", got, name, rows, files[name])
+		}
+	}
+	if stops := files["stops.txt"]; len(stops) < 2 || stops[0][0] != "stop_id" || stops[1][1] != "Flinders St, Stop 1" {
+		t.Errorf("got stops %q, want the header then stop A", stops)
+	}
+
+	// Nothing is written to the output directory, nor archived.
+	for _, name := range []string{"gtfs_out", "gtfs_out.zip", "-", "-.zip"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written: %v", name, err)
+		}
+	}
+}
+
+func TestOutputToStdoutWithDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+
+	stdout, stderr, err := runMainStdout(t, dir, "-output", "-", "-output-delimiter", ";", "gtfs.zip")
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	// Every field of the stream, including the name of each file, is separated by ';'.
+	r := csv.NewReader(strings.NewReader(stdout))
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("stdout isn't a stream delimited by ';': %v\n%s", err, stdout)
+	}
+	var stops [][]string
+	for _, rec := range records {
+		if rec[0] == "stops.txt" {
+			stops = append(stops, rec[1:])
+		}
+	}
+	if len(stops) < 2 || stops[0][0] != "stop_id" || stops[1][1] != "Flinders St, Stop 1" {
+		t.Errorf("got stops %q, want the header then stop A", stops)
+	}
+}
+
+func TestOutputNDJSONToStdout(t *testing.T) {
+	dir := t.TempDir()
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+
+	stdout, stderr, err := runMainStdout(t, dir, "-output", "-", "-format", "ndjson", "gtfs.zip")
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var tagged struct {
+			File   string          `json:"file"`
+			Record json.RawMessage `json:"record"`
+		}
+		if err := json.Unmarshal([]byte(line), &tagged); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		counts[tagged.File]++
+	}
+	if want := map[string]int{"stops.ndjson": 2, "routes.ndjson": 1, "trips.ndjson": 1, "stop_times.ndjson": 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got records %v, want %v", counts, want)
+	}
+}
+
+func TestParseFlagsOutputToStdout(t *testing.T) {
+	if _, err := parseFlags([]string{"-output", "-", "gtfs.zip"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseFlags([]string{"-output", "-", "-format", "sqlite", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted sqlite output to stdout")
+	}
+}
+
+func TestStrictExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	// The feed lacks every optional file, which is only a warning.
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+
+	if out, err := runMain(t, dir, "-output", "lenient", "gtfs.zip"); err != nil {
+		t.Fatalf("got %v without -strict: %s", err, out)
+	}
+	out, err := runMain(t, dir, "-strict", "-output", "strict", "gtfs.zip")
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("got error %v with -strict, want a non-zero exit: %s", err, out)
+	}
+	if !strings.Contains(out, "Optional files missing") || !strings.Contains(out, "strict mode") {
+		t.Errorf("output %q doesn't warn of the missing files and fail in strict mode", out)
+	}
+}
+
+func TestParseFlagsWalkingTransfers(t *testing.T) {
+	cfg, err := parseFlags([]string{"-walking-transfers", "250", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.walkingTransfers != 250 {
+		t.Errorf("got walking transfers within %gm, want 250m", cfg.walkingTransfers)
+	}
+	if _, err := parseFlags([]string{"-walking-transfers", "-1", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted a negative -walking-transfers")
+	}
+}
+
+func TestParseFlagsOutputPrefix(t *testing.T) {
+	cfg, err := parseFlags([]string{"-output-prefix", "metro_", "gtfs.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.outputPrefix != "metro_" {
+		t.Errorf("got output prefix %q, want metro_", cfg.outputPrefix)
+	}
+	if _, err := parseFlags([]string{"-output", "-", "-output-prefix", "metro_", "gtfs.zip"}); err == nil {
+		t.Error("parseFlags accepted -output-prefix writing to stdout")
+	}
+}
+
+func TestOutputPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+	if out, err := runMain(t, dir, "-output-prefix", "metro_", "-output", "gtfs_out", "gtfs.zip"); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	r, err := zip.OpenReader(filepath.Join(dir, "metro_gtfs_out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	names := make(map[string]bool)
+	for _, f := range r.File {
+		names[f.Name] = true
+	}
+	if !names["metro_stops.txt"] || !names["metro_trips.txt"] {
+		t.Errorf("archive holds %v, want the files named with the prefix", names)
+	}
+
+	if out, err := runMain(t, dir, "-output-prefix", "../metro_", "-output", "gtfs_out", "gtfs.zip"); err == nil || !strings.Contains(out, "invalid file prefix") {
+		t.Errorf("got error %v and output %q, want the prefix rejected", err, out)
+	}
+}
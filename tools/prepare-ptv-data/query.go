@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/disposedtrolley/ptv-graph/gtfs"
+)
+
+// subcommands are run in place of consolidating a feed when named by the first argument,
+// e.g. prepare-ptv-data query-stop flinders. Each is given the arguments after its name.
+var subcommands = map[string]func(args []string, w io.Writer) error{
+	"query-stop": queryStop,
+}
+
+// Prints the stops of a consolidated feed whose names contain the query, ignoring case,
+// with their ids and coordinates.
+func queryStop(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("prepare-ptv-data query-stop", flag.ContinueOnError)
+	feed := fs.String("feed", defaultOutput+".zip", "consolidated feed to search, as the archive or the directory of files written by a run")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ./prepare-ptv-data query-stop [-feed path] <name>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a single stop name to search for must be provided")
+	}
+
+	dir := *feed
+	if strings.HasSuffix(dir, ".zip") {
+		tmp, err := ioutil.TempDir("", "prepare-ptv-data")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		if err := gtfs.DefaultArchiver.Unarchive(dir, tmp); err != nil {
+			return err
+		}
+		dir = tmp
+	}
+	stops, err := gtfs.LoadStops(dir)
+	if err != nil {
+		return err
+	}
+
+	found := gtfs.FindStopsByName(stops, fs.Arg(0))
+	if len(found) == 0 {
+		return fmt.Errorf("no stops match %q", fs.Arg(0))
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stop_id\tstop_name\tstop_lat\tstop_lon")
+	for _, s := range found {
+		fmt.Fprintf(tw, "%s\t%s\t%.6f\t%.6f\n", s.ID, s.Name, s.Lat, s.Lon)
+	}
+	return tw.Flush()
+}
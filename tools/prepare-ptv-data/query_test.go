@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueryStop(t *testing.T) {
+	dir := t.TempDir()
+	stops := "stop_id,stop_name,stop_lat,stop_lon\n" +
+		"1071,Flinders Street Railway Station,-37.8183,144.9671\n" +
+		"1181,Southern Cross Railway Station,-37.8184,144.9525\n" +
+		"19843,Flinders St/Elizabeth St,-37.8178,144.9646\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "stops.txt"), []byte(stops), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := queryStop([]string{"-feed", dir, "FLINDERS"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %q, want a header and two stops", out.String())
+	}
+	for i, want := range [][]string{
+		{"stop_id", "stop_name", "stop_lat", "stop_lon"},
+		{"19843", "Flinders St/Elizabeth St", "-37.817800", "144.964600"},
+		{"1071", "Flinders Street Railway Station", "-37.818300", "144.967100"},
+	} {
+		if got := strings.Join(strings.Fields(lines[i]), " "); got != strings.Join(want, " ") {
+			t.Errorf("line %d: got %q, want %q", i, lines[i], want)
+		}
+	}
+
+	if err := queryStop([]string{"-feed", dir, "richmond"}, &out); err == nil || !strings.Contains(err.Error(), "richmond") {
+		t.Errorf("got error %v, want one of no stops matching", err)
+	}
+	if err := queryStop([]string{"-feed", dir}, ioutil.Discard); err == nil {
+		t.Error("queried without a stop name")
+	}
+}
+
+func TestQueryStopInArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeFeedZip(t, filepath.Join(dir, "gtfs.zip"))
+	if out, err := runMain(t, dir, "gtfs.zip"); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	// The feed defaults to the archive written by a run.
+	out, err := runMain(t, dir, "query-stop", "southern")
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if !strings.Contains(out, "Southern Cross") || strings.Contains(out, "Flinders") {
+		t.Errorf("got %q, want Southern Cross alone", out)
+	}
+}